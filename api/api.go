@@ -0,0 +1,144 @@
+// Package api describes the versioned HTTP API under /api/v1: the JSON
+// documents it exchanges, the error codes it answers with and its OpenAPI
+// description. The server and Go clients share these types, so a change to
+// the wire format shows up in both.
+package api
+
+import (
+	_ "embed"
+)
+
+// Version is the API version the server implements
+const Version = "v1"
+
+// Prefix is where every route of this API version lives
+const Prefix = "/api/" + Version + "/"
+
+// OpenAPI is the OpenAPI 3 description of the API, served at
+// Prefix + "openapi.json"
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// ErrorCode is a machine-readable reason for a failed request. Codes are
+// stable; messages are meant for people and may change.
+type ErrorCode string
+
+const (
+	CodeBadRequest        ErrorCode = "bad_request"        // a parameter or the body is missing or malformed
+	CodeUnauthorized      ErrorCode = "unauthorized"       // pair the device or send an API key
+	CodePairingFailed     ErrorCode = "pairing_failed"     // the PIN or QR token was wrong or expired
+	CodeTooManyRequests   ErrorCode = "too_many_requests"  // wait for Retry-After before pairing again
+	CodeDeclined          ErrorCode = "declined"           // the desktop user refused the transfer
+	CodeDownloadsDisabled ErrorCode = "downloads_disabled" // the shared folder isn't shared
+	CodeAccessDenied      ErrorCode = "access_denied"      // the path leads outside of the folder
+	CodeShareNotFound     ErrorCode = "share_not_found"
+	CodeShareReadOnly     ErrorCode = "share_read_only"
+	CodeShareWriteOnly    ErrorCode = "share_write_only"
+	CodeNotFound          ErrorCode = "not_found"
+	CodeMethodNotAllowed  ErrorCode = "method_not_allowed"
+	CodeTooLarge          ErrorCode = "too_large"
+	CodeUnsupportedType   ErrorCode = "unsupported_type"
+	CodeChecksumMismatch  ErrorCode = "checksum_mismatch" // the data doesn't match the sha256 sent with it
+	CodeOffsetMismatch    ErrorCode = "offset_mismatch"   // ask for the upload's offset and resume from there
+	CodeUnavailable       ErrorCode = "unavailable"       // the feature is off or nobody can receive
+	CodeInternal          ErrorCode = "internal_error"
+)
+
+// Error describes why a request failed
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// FileInfo describes a file or folder that can be downloaded
+type FileInfo struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	ModTime      string `json:"mod_time"`
+	IsDirectory  bool   `json:"is_directory"`
+	RelativePath string `json:"relative_path"`
+	MimeType     string `json:"mime_type,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Share        string `json:"share,omitempty"`  // set on the entries for named shares
+	Access       string `json:"access,omitempty"` // what devices may do with the share
+}
+
+// FileList is the content of a folder in the shared folder or a share
+type FileList struct {
+	Path  string     `json:"path"`
+	Share string     `json:"share"`
+	Files []FileInfo `json:"files"`
+}
+
+// Outcomes of an uploaded file
+const (
+	UploadSaved   = "saved"
+	UploadSkipped = "skipped" // the conflict policy kept the existing file
+	UploadCorrupt = "corrupt"
+	UploadFailed  = "failed"
+)
+
+// UploadResult is the outcome for one file of an upload
+type UploadResult struct {
+	Name    string `json:"name"`
+	SavedAs string `json:"saved_as,omitempty"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Status  string `json:"status"` // saved, skipped, corrupt or failed
+	Error   string `json:"error,omitempty"`
+}
+
+// UploadResponse lists the outcome for each file of a multipart upload
+type UploadResponse struct {
+	Files []UploadResult `json:"files"`
+}
+
+// Share is a named folder with its own permissions
+type Share struct {
+	Name     string `json:"name"`
+	Access   string `json:"access"` // read, write or read_write
+	Readable bool   `json:"readable"`
+	Writable bool   `json:"writable"`
+}
+
+// ShareList lists the named shares
+type ShareList struct {
+	Shares []Share `json:"shares"`
+}
+
+// ServerInfo identifies the server and tells a client whether it's paired
+type ServerInfo struct {
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	APIVersion      string `json:"api_version"`
+	HTTPS           bool   `json:"https"`
+	Fingerprint     string `json:"fingerprint,omitempty"` // SHA-256 of the TLS certificate
+	PairingRequired bool   `json:"pairing_required"`
+	Authenticated   bool   `json:"authenticated"`
+}
+
+// Capabilities tells a client which features are turned on
+type Capabilities struct {
+	APIVersion       string   `json:"api_version"`
+	Downloads        bool     `json:"downloads"` // the shared folder can be browsed
+	Shares           bool     `json:"shares"`    // named shares are configured
+	ArchiveFormats   []string `json:"archive_formats"`
+	ResumableUploads string   `json:"resumable_uploads"` // tus endpoint
+	WebDAV           string   `json:"webdav,omitempty"`  // WebDAV endpoint, if enabled
+	WebDAVUploads    bool     `json:"webdav_uploads"`
+	History          bool     `json:"history"`
+	MaxTextSize      int      `json:"max_text_size"`
+	ApprovalRequired bool     `json:"approval_required"` // the desktop user may be asked first
+	ConflictPolicy   string   `json:"conflict_policy"`
+	PairingRequired  bool     `json:"pairing_required"`
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestOpenAPIListsErrorCodes(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas struct {
+				ErrorResponse struct {
+					Properties struct {
+						Error struct {
+							Properties struct {
+								Code struct {
+									Enum []ErrorCode
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatalf("The OpenAPI document is not valid JSON: %v", err)
+	}
+
+	documented := doc.Components.Schemas.ErrorResponse.Properties.Error.Properties.Code.Enum
+	codes := []ErrorCode{
+		CodeBadRequest, CodeUnauthorized, CodePairingFailed, CodeTooManyRequests, CodeDeclined,
+		CodeDownloadsDisabled, CodeAccessDenied, CodeShareNotFound, CodeShareReadOnly,
+		CodeShareWriteOnly, CodeNotFound, CodeMethodNotAllowed, CodeTooLarge,
+		CodeUnsupportedType, CodeChecksumMismatch, CodeOffsetMismatch, CodeUnavailable, CodeInternal,
+	}
+	for _, code := range codes {
+		if !slices.Contains(documented, code) {
+			t.Errorf("Error code %s is not in the OpenAPI document", code)
+		}
+	}
+	if len(documented) != len(codes) {
+		t.Errorf("Expected %d documented codes, got %v", len(codes), documented)
+	}
+}
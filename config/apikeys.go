@@ -0,0 +1,105 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so a key is recognisable in scripts
+// and secret scanners
+const apiKeyPrefix = "ldk_"
+
+// APIKey lets scripts use the API without pairing. Only a hash of the key
+// is saved; the key itself is shown once, when it's created.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"` // hex SHA-256 of the key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewAPIKey creates a key named after what will use it. It returns the key
+// to save in Preferences.APIKeys and the secret to hand to the script.
+func NewAPIKey(name string) (APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIKey{}, "", errors.New("the key needs a name")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashAPIKey(key),
+		CreatedAt: time.Now(),
+	}, key, nil
+}
+
+// FindAPIKey returns the saved key matching the secret a script sent
+func (p Preferences) FindAPIKey(secret string) (APIKey, bool) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return APIKey{}, false
+	}
+	hash := hashAPIKey(secret)
+	for _, key := range p.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// RevokeAPIKey removes the key with the given ID
+func (p *Preferences) RevokeAPIKey(id string) {
+	keys := make([]APIKey, 0, len(p.APIKeys))
+	for _, key := range p.APIKeys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+	p.APIKeys = keys
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeAPIKeys reads the keys saved in preferences, skipping broken ones
+func decodeAPIKeys(data string) []APIKey {
+	var keys []APIKey
+	if data == "" || json.Unmarshal([]byte(data), &keys) != nil {
+		return []APIKey{}
+	}
+	valid := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		if key.ID != "" && len(key.Hash) == hex.EncodedLen(sha256.Size) {
+			valid = append(valid, key)
+		}
+	}
+	return valid
+}
+
+func encodeAPIKeys(keys []APIKey) string {
+	data, err := json.Marshal(keys)
+	if err != nil || len(keys) == 0 {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	if _, _, err := NewAPIKey("  "); err == nil {
+		t.Error("Expected a key without a name to be refused")
+	}
+
+	ci, ciSecret, err := NewAPIKey("CI runner")
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	pi, piSecret, _ := NewAPIKey("Raspberry Pi")
+	if !strings.HasPrefix(ciSecret, apiKeyPrefix) || strings.Contains(ci.Hash, ciSecret) {
+		t.Errorf("Expected a prefixed secret that isn't saved, got %q and %+v", ciSecret, ci)
+	}
+
+	prefs := Preferences{APIKeys: []APIKey{ci, pi}}
+	if key, ok := prefs.FindAPIKey(piSecret); !ok || key.Name != "Raspberry Pi" {
+		t.Errorf("Expected the Pi's key, got %+v (%v)", key, ok)
+	}
+	for _, secret := range []string{"", "ldk_wrong", ci.Hash} {
+		if _, ok := prefs.FindAPIKey(secret); ok {
+			t.Errorf("Expected %q to match no key", secret)
+		}
+	}
+
+	prefs.RevokeAPIKey(ci.ID)
+	if _, ok := prefs.FindAPIKey(ciSecret); ok {
+		t.Error("Expected the revoked key to stop working")
+	}
+	if _, ok := prefs.FindAPIKey(piSecret); !ok {
+		t.Error("Expected the other key to keep working")
+	}
+}
+
+func TestDecodeAPIKeysSkipsInvalid(t *testing.T) {
+	key, _, _ := NewAPIKey("CI runner")
+	keys := decodeAPIKeys(encodeAPIKeys([]APIKey{key, {ID: "x", Name: "broken", Hash: "abc"}}))
+	if len(keys) != 1 || keys[0].ID != key.ID || !keys[0].CreatedAt.Equal(key.CreatedAt) {
+		t.Errorf("Expected only the valid key, got %+v", keys)
+	}
+	if keys := decodeAPIKeys("not json"); len(keys) != 0 {
+		t.Errorf("Expected no keys from invalid data, got %v", keys)
+	}
+}
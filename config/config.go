@@ -15,6 +15,22 @@ type Preferences struct {
 	EnableDownloads     bool
 	SharedDir           string
 	OnboardingCompleted bool
+	RequirePairing      bool
+	AskBeforeReceiving  bool
+	ConsentTimeout      int      // seconds to wait for the desktop user's answer
+	TrustedDevices      []string // devices allowed to send without a prompt
+	EnableHTTPS         bool     // serve over TLS with a self-signed certificate
+	RedirectHTTP        bool     // answer plain HTTP with a redirect to HTTPS
+	ConflictPolicy      string   // what to do when a received file's name is taken
+	RenamePattern       string   // how renamed copies are named, e.g. "{name}_{n}{ext}"
+	WriteSidecar        bool     // write a .landrop.json with the sender's details next to received files
+	FollowSymlinks      bool     // let links in the shared folder point outside of it
+	Shares              []Share  // named folders with their own permissions
+	OpenReceivedURLs    bool     // open links sent as text in the default browser
+	EnableWebDAV        bool     // serve the shared folder to WebDAV clients under /dav/
+	WebDAVUploads       bool     // let WebDAV clients read and write the upload folder too
+	APIKeys             []APIKey // keys scripts use instead of pairing
+	EnableDiscovery     bool     // advertise the server to other LANDrop instances over mDNS
 }
 
 // LoadPreferences loads preferences using Fyne's preferences API
@@ -27,6 +43,12 @@ func LoadPreferences(app fyne.App) Preferences {
 	defaultAutoOpenFiles := true
 	defaultEnableDownloads := true
 	defaultSharedDir := "./shared"
+	defaultRequirePairing := true
+	defaultConsentTimeout := 60
+	defaultRedirectHTTP := true
+	defaultConflictPolicy := "rename"
+	defaultRenamePattern := "{name}_{n}{ext}"
+	defaultEnableDiscovery := true
 
 	// Load from Fyne preferences
 	p := Preferences{
@@ -38,6 +60,22 @@ func LoadPreferences(app fyne.App) Preferences {
 		EnableDownloads:     app.Preferences().BoolWithFallback("enable_downloads", defaultEnableDownloads),
 		SharedDir:           app.Preferences().StringWithFallback("shared_dir", defaultSharedDir),
 		OnboardingCompleted: app.Preferences().BoolWithFallback("onboarding_completed", false),
+		RequirePairing:      app.Preferences().BoolWithFallback("require_pairing", defaultRequirePairing),
+		AskBeforeReceiving:  app.Preferences().BoolWithFallback("ask_before_receiving", false),
+		ConsentTimeout:      app.Preferences().IntWithFallback("consent_timeout", defaultConsentTimeout),
+		TrustedDevices:      app.Preferences().StringListWithFallback("trusted_devices", []string{}),
+		EnableHTTPS:         app.Preferences().BoolWithFallback("enable_https", false),
+		RedirectHTTP:        app.Preferences().BoolWithFallback("redirect_http", defaultRedirectHTTP),
+		ConflictPolicy:      app.Preferences().StringWithFallback("conflict_policy", defaultConflictPolicy),
+		RenamePattern:       app.Preferences().StringWithFallback("rename_pattern", defaultRenamePattern),
+		WriteSidecar:        app.Preferences().BoolWithFallback("write_sidecar", false),
+		FollowSymlinks:      app.Preferences().BoolWithFallback("follow_symlinks", false),
+		Shares:              decodeShares(app.Preferences().String("shares")),
+		OpenReceivedURLs:    app.Preferences().BoolWithFallback("open_received_urls", false),
+		EnableWebDAV:        app.Preferences().BoolWithFallback("enable_webdav", false),
+		WebDAVUploads:       app.Preferences().BoolWithFallback("webdav_uploads", false),
+		APIKeys:             decodeAPIKeys(app.Preferences().String("api_keys")),
+		EnableDiscovery:     app.Preferences().BoolWithFallback("enable_discovery", defaultEnableDiscovery),
 	}
 
 	return p
@@ -53,6 +91,22 @@ func SavePreferences(app fyne.App, p Preferences) {
 	app.Preferences().SetBool("enable_downloads", p.EnableDownloads)
 	app.Preferences().SetString("shared_dir", p.SharedDir)
 	app.Preferences().SetBool("onboarding_completed", p.OnboardingCompleted)
+	app.Preferences().SetBool("require_pairing", p.RequirePairing)
+	app.Preferences().SetBool("ask_before_receiving", p.AskBeforeReceiving)
+	app.Preferences().SetInt("consent_timeout", p.ConsentTimeout)
+	app.Preferences().SetStringList("trusted_devices", p.TrustedDevices)
+	app.Preferences().SetBool("enable_https", p.EnableHTTPS)
+	app.Preferences().SetBool("redirect_http", p.RedirectHTTP)
+	app.Preferences().SetString("conflict_policy", p.ConflictPolicy)
+	app.Preferences().SetString("rename_pattern", p.RenamePattern)
+	app.Preferences().SetBool("write_sidecar", p.WriteSidecar)
+	app.Preferences().SetBool("follow_symlinks", p.FollowSymlinks)
+	app.Preferences().SetString("shares", encodeShares(p.Shares))
+	app.Preferences().SetBool("open_received_urls", p.OpenReceivedURLs)
+	app.Preferences().SetBool("enable_webdav", p.EnableWebDAV)
+	app.Preferences().SetBool("webdav_uploads", p.WebDAVUploads)
+	app.Preferences().SetString("api_keys", encodeAPIKeys(p.APIKeys))
+	app.Preferences().SetBool("enable_discovery", p.EnableDiscovery)
 }
 
 // MarkOnboardingCompleted marks the onboarding as completed
@@ -48,6 +48,51 @@ func TestPreferencesDefaults(t *testing.T) {
 	if prefs.OnboardingCompleted {
 		t.Errorf("Expected default OnboardingCompleted to be false, got %v", prefs.OnboardingCompleted)
 	}
+
+	if !prefs.RequirePairing {
+		t.Errorf("Expected default RequirePairing to be true, got %v", prefs.RequirePairing)
+	}
+
+	if prefs.AskBeforeReceiving {
+		t.Errorf("Expected default AskBeforeReceiving to be false, got %v", prefs.AskBeforeReceiving)
+	}
+
+	if prefs.ConsentTimeout != 60 {
+		t.Errorf("Expected default ConsentTimeout to be 60, got %d", prefs.ConsentTimeout)
+	}
+
+	if len(prefs.TrustedDevices) != 0 {
+		t.Errorf("Expected no trusted devices by default, got %v", prefs.TrustedDevices)
+	}
+
+	if prefs.EnableHTTPS {
+		t.Errorf("Expected default EnableHTTPS to be false, got %v", prefs.EnableHTTPS)
+	}
+
+	if !prefs.RedirectHTTP {
+		t.Errorf("Expected default RedirectHTTP to be true, got %v", prefs.RedirectHTTP)
+	}
+	if prefs.ConflictPolicy != "rename" {
+		t.Errorf("Expected default ConflictPolicy 'rename', got '%s'", prefs.ConflictPolicy)
+	}
+	if prefs.RenamePattern != "{name}_{n}{ext}" {
+		t.Errorf("Expected default RenamePattern '{name}_{n}{ext}', got '%s'", prefs.RenamePattern)
+	}
+	if prefs.WriteSidecar {
+		t.Errorf("Expected default WriteSidecar to be false, got %v", prefs.WriteSidecar)
+	}
+	if prefs.FollowSymlinks {
+		t.Errorf("Expected default FollowSymlinks to be false, got %v", prefs.FollowSymlinks)
+	}
+	if prefs.OpenReceivedURLs {
+		t.Errorf("Expected default OpenReceivedURLs to be false, got %v", prefs.OpenReceivedURLs)
+	}
+	if prefs.EnableWebDAV || prefs.WebDAVUploads {
+		t.Errorf("Expected WebDAV to be off by default, got %v/%v", prefs.EnableWebDAV, prefs.WebDAVUploads)
+	}
+	if !prefs.EnableDiscovery {
+		t.Errorf("Expected default EnableDiscovery to be true, got %v", prefs.EnableDiscovery)
+	}
 }
 
 func TestSaveAndLoadPreferences(t *testing.T) {
@@ -64,6 +109,24 @@ func TestSaveAndLoadPreferences(t *testing.T) {
 		AutoOpenFiles:       false,
 		SharedDir:           "/tmp/test-shared",
 		OnboardingCompleted: true,
+		RequirePairing:      false,
+		AskBeforeReceiving:  true,
+		ConsentTimeout:      30,
+		TrustedDevices:      []string{"device-a", "ip:10.0.0.2"},
+		EnableHTTPS:         true,
+		RedirectHTTP:        false,
+		ConflictPolicy:      "skip_identical",
+		RenamePattern:       "{name} ({n}){ext}",
+		WriteSidecar:        true,
+		FollowSymlinks:      true,
+		Shares: []Share{
+			{Name: "Design assets", Dir: "/tmp/design", Access: ShareReadOnly},
+			{Name: "Inbox", Dir: "/tmp/inbox", Access: ShareWriteOnly},
+		},
+		OpenReceivedURLs: true,
+		EnableWebDAV:     true,
+		WebDAVUploads:    true,
+		EnableDiscovery:  false,
 	}
 
 	// Save preferences
@@ -99,6 +162,54 @@ func TestSaveAndLoadPreferences(t *testing.T) {
 	if loadedPrefs.OnboardingCompleted != testPrefs.OnboardingCompleted {
 		t.Errorf("Expected OnboardingCompleted %v, got %v", testPrefs.OnboardingCompleted, loadedPrefs.OnboardingCompleted)
 	}
+
+	if loadedPrefs.RequirePairing != testPrefs.RequirePairing {
+		t.Errorf("Expected RequirePairing %v, got %v", testPrefs.RequirePairing, loadedPrefs.RequirePairing)
+	}
+
+	if loadedPrefs.AskBeforeReceiving != testPrefs.AskBeforeReceiving {
+		t.Errorf("Expected AskBeforeReceiving %v, got %v", testPrefs.AskBeforeReceiving, loadedPrefs.AskBeforeReceiving)
+	}
+
+	if loadedPrefs.EnableHTTPS != testPrefs.EnableHTTPS {
+		t.Errorf("Expected EnableHTTPS %v, got %v", testPrefs.EnableHTTPS, loadedPrefs.EnableHTTPS)
+	}
+
+	if loadedPrefs.RedirectHTTP != testPrefs.RedirectHTTP {
+		t.Errorf("Expected RedirectHTTP %v, got %v", testPrefs.RedirectHTTP, loadedPrefs.RedirectHTTP)
+	}
+	if loadedPrefs.ConflictPolicy != testPrefs.ConflictPolicy {
+		t.Errorf("Expected ConflictPolicy '%s', got '%s'", testPrefs.ConflictPolicy, loadedPrefs.ConflictPolicy)
+	}
+	if loadedPrefs.RenamePattern != testPrefs.RenamePattern {
+		t.Errorf("Expected RenamePattern '%s', got '%s'", testPrefs.RenamePattern, loadedPrefs.RenamePattern)
+	}
+	if loadedPrefs.WriteSidecar != testPrefs.WriteSidecar {
+		t.Errorf("Expected WriteSidecar %v, got %v", testPrefs.WriteSidecar, loadedPrefs.WriteSidecar)
+	}
+	if loadedPrefs.FollowSymlinks != testPrefs.FollowSymlinks {
+		t.Errorf("Expected FollowSymlinks %v, got %v", testPrefs.FollowSymlinks, loadedPrefs.FollowSymlinks)
+	}
+	if loadedPrefs.OpenReceivedURLs != testPrefs.OpenReceivedURLs {
+		t.Errorf("Expected OpenReceivedURLs %v, got %v", testPrefs.OpenReceivedURLs, loadedPrefs.OpenReceivedURLs)
+	}
+	if loadedPrefs.EnableWebDAV != testPrefs.EnableWebDAV || loadedPrefs.WebDAVUploads != testPrefs.WebDAVUploads {
+		t.Errorf("Expected WebDAV %v/%v, got %v/%v", testPrefs.EnableWebDAV, testPrefs.WebDAVUploads, loadedPrefs.EnableWebDAV, loadedPrefs.WebDAVUploads)
+	}
+	if loadedPrefs.EnableDiscovery != testPrefs.EnableDiscovery {
+		t.Errorf("Expected EnableDiscovery %v, got %v", testPrefs.EnableDiscovery, loadedPrefs.EnableDiscovery)
+	}
+	if len(loadedPrefs.Shares) != 2 || loadedPrefs.Shares[1] != testPrefs.Shares[1] {
+		t.Errorf("Expected Shares %v, got %v", testPrefs.Shares, loadedPrefs.Shares)
+	}
+
+	if loadedPrefs.ConsentTimeout != testPrefs.ConsentTimeout {
+		t.Errorf("Expected ConsentTimeout %d, got %d", testPrefs.ConsentTimeout, loadedPrefs.ConsentTimeout)
+	}
+
+	if len(loadedPrefs.TrustedDevices) != 2 || loadedPrefs.TrustedDevices[1] != "ip:10.0.0.2" {
+		t.Errorf("Expected TrustedDevices %v, got %v", testPrefs.TrustedDevices, loadedPrefs.TrustedDevices)
+	}
 }
 
 func TestEnsureUploadDir(t *testing.T) {
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ShareAccess says what other devices may do with a share
+type ShareAccess string
+
+const (
+	// ShareReadOnly lets devices browse and download
+	ShareReadOnly ShareAccess = "read"
+	// ShareWriteOnly lets devices upload without seeing what's there
+	ShareWriteOnly ShareAccess = "write"
+	// ShareReadWrite lets devices do both
+	ShareReadWrite ShareAccess = "read_write"
+)
+
+// ShareAccessLevels lists every access level in the order the settings show them
+var ShareAccessLevels = []ShareAccess{ShareReadOnly, ShareWriteOnly, ShareReadWrite}
+
+// Label returns the description shown in the settings window
+func (a ShareAccess) Label() string {
+	switch a {
+	case ShareReadOnly:
+		return "Read only"
+	case ShareWriteOnly:
+		return "Write only (drop box)"
+	default:
+		return "Read and write"
+	}
+}
+
+// CanRead reports whether devices may list and download files
+func (a ShareAccess) CanRead() bool {
+	return a == ShareReadOnly || a == ShareReadWrite
+}
+
+// CanWrite reports whether devices may upload files
+func (a ShareAccess) CanWrite() bool {
+	return a == ShareWriteOnly || a == ShareReadWrite
+}
+
+// CanDelete reports whether devices may delete files. A drop box doesn't
+// let one uploader remove, or even probe for, what others dropped.
+func (a ShareAccess) CanDelete() bool {
+	return a == ShareReadWrite
+}
+
+// Share is a named folder offered to other devices, next to the shared and
+// upload folders
+type Share struct {
+	Name   string      `json:"name"`
+	Dir    string      `json:"dir"`
+	Access ShareAccess `json:"access"`
+}
+
+var (
+	// ErrShareNotFound is returned for a share name that isn't configured
+	ErrShareNotFound = errors.New("share not found")
+	// ErrShareReadOnly is returned when writing to a read-only share
+	ErrShareReadOnly = errors.New("share is read-only")
+	// ErrShareWriteOnly is returned when reading from a write-only share
+	ErrShareWriteOnly = errors.New("share is write-only")
+)
+
+// FindShare returns the share with the given name, ignoring case
+func (p Preferences) FindShare(name string) (Share, bool) {
+	for _, share := range p.Shares {
+		if strings.EqualFold(share.Name, name) {
+			return share, true
+		}
+	}
+	return Share{}, false
+}
+
+// ReadDir returns the folder devices read from: the named share, or the
+// shared folder when name is empty
+func (p Preferences) ReadDir(name string) (string, error) {
+	if name == "" {
+		return p.SharedDir, nil
+	}
+	share, ok := p.FindShare(name)
+	if !ok {
+		return "", ErrShareNotFound
+	}
+	if !share.Access.CanRead() {
+		return "", ErrShareWriteOnly
+	}
+	return share.Dir, nil
+}
+
+// WriteDir returns the folder devices write to: the named share, or the
+// upload folder when name is empty
+func (p Preferences) WriteDir(name string) (string, error) {
+	if name == "" {
+		return p.UploadDir, nil
+	}
+	share, ok := p.FindShare(name)
+	if !ok {
+		return "", ErrShareNotFound
+	}
+	if !share.Access.CanWrite() {
+		return "", ErrShareReadOnly
+	}
+	return share.Dir, nil
+}
+
+// DeleteDir returns the folder devices delete uploaded files from: the named
+// share, or the upload folder when name is empty
+func (p Preferences) DeleteDir(name string) (string, error) {
+	if name == "" {
+		return p.UploadDir, nil
+	}
+	share, ok := p.FindShare(name)
+	if !ok {
+		return "", ErrShareNotFound
+	}
+	if !share.Access.CanWrite() {
+		return "", ErrShareReadOnly
+	}
+	if !share.Access.CanDelete() {
+		return "", ErrShareWriteOnly
+	}
+	return share.Dir, nil
+}
+
+// ValidateShare checks a share before it's saved alongside others, which
+// must not include the share itself
+func ValidateShare(share Share, others []Share) error {
+	name := strings.TrimSpace(share.Name)
+	if name == "" {
+		return errors.New("the share needs a name")
+	}
+	if name != share.Name || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("the name %q must not contain slashes or surrounding spaces", share.Name)
+	}
+	for _, other := range others {
+		if strings.EqualFold(other.Name, name) {
+			return fmt.Errorf("a share named %q already exists", other.Name)
+		}
+	}
+	if share.Dir == "" {
+		return errors.New("the share needs a folder")
+	}
+	switch share.Access {
+	case ShareReadOnly, ShareWriteOnly, ShareReadWrite:
+		return nil
+	default:
+		return fmt.Errorf("unknown access %q", share.Access)
+	}
+}
+
+// decodeShares reads the shares saved in preferences, skipping invalid ones
+func decodeShares(data string) []Share {
+	var shares []Share
+	if data == "" || json.Unmarshal([]byte(data), &shares) != nil {
+		return []Share{}
+	}
+	valid := make([]Share, 0, len(shares))
+	for _, share := range shares {
+		if ValidateShare(share, valid) == nil {
+			valid = append(valid, share)
+		}
+	}
+	return valid
+}
+
+func encodeShares(shares []Share) string {
+	data, err := json.Marshal(shares)
+	if err != nil || len(shares) == 0 {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShareDirs(t *testing.T) {
+	prefs := Preferences{
+		UploadDir: "/uploads",
+		SharedDir: "/shared",
+		Shares: []Share{
+			{Name: "Design assets", Dir: "/design", Access: ShareReadOnly},
+			{Name: "Inbox", Dir: "/inbox", Access: ShareWriteOnly},
+			{Name: "Scratch", Dir: "/scratch", Access: ShareReadWrite},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		share    string
+		write    bool
+		delete   bool
+		expected string
+		err      error
+	}{
+		{"default read", "", false, false, "/shared", nil},
+		{"default write", "", true, false, "/uploads", nil},
+		{"default delete", "", false, true, "/uploads", nil},
+		{"read-only read", "Design assets", false, false, "/design", nil},
+		{"read-only write", "Design assets", true, false, "", ErrShareReadOnly},
+		{"read-only delete", "Design assets", false, true, "", ErrShareReadOnly},
+		{"write-only read", "Inbox", false, false, "", ErrShareWriteOnly},
+		{"write-only write", "Inbox", true, false, "/inbox", nil},
+		{"write-only delete", "Inbox", false, true, "", ErrShareWriteOnly},
+		{"read-write read", "Scratch", false, false, "/scratch", nil},
+		{"read-write write", "Scratch", true, false, "/scratch", nil},
+		{"read-write delete", "Scratch", false, true, "/scratch", nil},
+		{"case insensitive", "scratch", true, false, "/scratch", nil},
+		{"unknown", "Archive", false, false, "", ErrShareNotFound},
+		{"unknown delete", "Archive", false, true, "", ErrShareNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := prefs.ReadDir(tc.share)
+			if tc.write {
+				dir, err = prefs.WriteDir(tc.share)
+			}
+			if tc.delete {
+				dir, err = prefs.DeleteDir(tc.share)
+			}
+			if !errors.Is(err, tc.err) || dir != tc.expected {
+				t.Errorf("Expected %q (%v), got %q (%v)", tc.expected, tc.err, dir, err)
+			}
+		})
+	}
+}
+
+func TestValidateShare(t *testing.T) {
+	others := []Share{{Name: "Inbox", Dir: "/inbox", Access: ShareWriteOnly}}
+
+	testCases := []struct {
+		name  string
+		share Share
+		valid bool
+	}{
+		{"valid", Share{Name: "Scratch", Dir: "/scratch", Access: ShareReadWrite}, true},
+		{"no name", Share{Dir: "/scratch", Access: ShareReadWrite}, false},
+		{"spaces around name", Share{Name: " Scratch", Dir: "/scratch", Access: ShareReadWrite}, false},
+		{"slash in name", Share{Name: "a/b", Dir: "/scratch", Access: ShareReadWrite}, false},
+		{"duplicate name", Share{Name: "inbox", Dir: "/other", Access: ShareReadOnly}, false},
+		{"no folder", Share{Name: "Scratch", Access: ShareReadWrite}, false},
+		{"unknown access", Share{Name: "Scratch", Dir: "/scratch", Access: "admin"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateShare(tc.share, others); (err == nil) != tc.valid {
+				t.Errorf("Expected valid=%v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestDecodeSharesSkipsInvalid(t *testing.T) {
+	shares := decodeShares(`[{"name":"Inbox","dir":"/inbox","access":"write"},{"name":"INBOX","dir":"/x","access":"read"},{"name":"","dir":"/y","access":"read"}]`)
+	if len(shares) != 1 || shares[0].Name != "Inbox" {
+		t.Errorf("Expected only the first share, got %v", shares)
+	}
+	if shares := decodeShares("not json"); len(shares) != 0 {
+		t.Errorf("Expected no shares from invalid data, got %v", shares)
+	}
+}
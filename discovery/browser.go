@@ -0,0 +1,204 @@
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultBrowseTimeout is how long Browse waits for answers
+const defaultBrowseTimeout = 2 * time.Second
+
+// Browser asks for LANDrop instances
+type Browser struct {
+	// Addr is where questions are sent, the multicast DNS group if nil
+	Addr net.Addr
+	// Timeout is how long to wait for answers, 2 seconds if zero
+	Timeout time.Duration
+}
+
+// Browse lists the LANDrop instances on the local network
+func Browse(ctx context.Context) ([]Instance, error) {
+	return (&Browser{}).Browse(ctx)
+}
+
+// Browse sends a one-shot query for the LANDrop service and returns the
+// instances that answered before the timeout, sorted by name
+func (b *Browser) Browse(ctx context.Context) ([]Instance, error) {
+	addr := b.Addr
+	if addr == nil {
+		addr = &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}
+	}
+	timeout := b.Timeout
+	if timeout == 0 {
+		timeout = defaultBrowseTimeout
+	}
+
+	// Any port but the mDNS one gets the answers sent straight back
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query, err := browseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(query, addr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	answers := newAnswerSet()
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		answers.add(buf[:n])
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+	return answers.instances(), nil
+}
+
+// browseQuery asks for every instance of the LANDrop service
+func browseQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:])})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET | unicastResponse})
+	return b.Finish()
+}
+
+// answerSet collects the records of every answer to a query
+type answerSet struct {
+	names []string // instance names, in the order they were announced
+	srv   map[string]dnsmessage.SRVResource
+	txt   map[string][]string
+	addrs map[string][]net.IP
+}
+
+func newAnswerSet() *answerSet {
+	return &answerSet{
+		srv:   make(map[string]dnsmessage.SRVResource),
+		txt:   make(map[string][]string),
+		addrs: make(map[string][]net.IP),
+	}
+}
+
+// add reads the records of a response, skipping anything malformed
+func (s *answerSet) add(data []byte) {
+	var p dnsmessage.Parser
+	header, err := p.Start(data)
+	if err != nil || !header.Response {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	var resources []dnsmessage.Resource
+	if answers, err := p.AllAnswers(); err == nil {
+		resources = append(resources, answers...)
+	}
+	if p.SkipAllAuthorities() == nil {
+		if additionals, err := p.AllAdditionals(); err == nil {
+			resources = append(resources, additionals...)
+		}
+	}
+
+	for _, res := range resources {
+		name := strings.ToLower(res.Header.Name.String())
+		switch body := res.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == serviceName && res.Header.TTL > 0 {
+				instance := strings.ToLower(body.PTR.String())
+				if !slices.Contains(s.names, instance) {
+					s.names = append(s.names, instance)
+				}
+			}
+		case *dnsmessage.SRVResource:
+			s.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			s.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			ip := net.IP(body.A[:])
+			if !slices.ContainsFunc(s.addrs[name], ip.Equal) {
+				s.addrs[name] = append(s.addrs[name], ip)
+			}
+		}
+	}
+}
+
+// instances assembles the instances that sent all of their records
+func (s *answerSet) instances() []Instance {
+	found := []Instance{}
+	for _, name := range s.names {
+		srv, ok := s.srv[name]
+		txt, hasTXT := s.txt[name]
+		if !ok || !hasTXT {
+			continue
+		}
+		target := strings.ToLower(srv.Target.String())
+		inst := Instance{
+			Host:  strings.TrimSuffix(target, "."+domain),
+			Port:  int(srv.Port),
+			Addrs: s.addrs[target],
+		}
+		for _, entry := range txt {
+			key, value, _ := strings.Cut(entry, "=")
+			switch key {
+			case "id":
+				inst.ID = value
+			case "name":
+				inst.Name = value
+			case "version":
+				inst.Version = value
+			case "fp":
+				inst.Fingerprint = value
+			case "port":
+				if inst.Port == 0 {
+					inst.Port, _ = strconv.Atoi(value)
+				}
+			}
+		}
+		if inst.Name == "" {
+			inst.Name = strings.TrimSuffix(name, "."+serviceName)
+		}
+		found = append(found, inst)
+	}
+	slices.SortFunc(found, func(a, b Instance) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return found
+}
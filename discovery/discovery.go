@@ -0,0 +1,176 @@
+// Package discovery finds LANDrop instances on the local network. A running
+// server is advertised as a _landrop._tcp DNS-SD service over multicast DNS,
+// and a browser asks the network for the services and reads back the name,
+// version, port and TLS fingerprint of every instance that answers.
+package discovery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// ServiceType is the DNS-SD service LANDrop instances register
+	ServiceType = "_landrop._tcp"
+
+	domain      = "local."
+	serviceName = ServiceType + "." + domain
+	// enumerationName lists every service type on the network
+	enumerationName = "_services._dns-sd._udp." + domain
+
+	mdnsPort = 5353
+	// recordTTL is how long answers sent to the multicast group are cached
+	recordTTL = 120
+	// legacyTTL caps the TTL of answers sent straight to a one-shot query
+	legacyTTL = 10
+
+	// cacheFlush marks a record as the only one with its name and type
+	cacheFlush = 1 << 15
+	// unicastResponse marks a question whose answer may go straight back
+	unicastResponse = 1 << 15
+)
+
+// mdnsGroup is the IPv4 multicast group of multicast DNS
+var mdnsGroup = net.IPv4(224, 0, 0, 251)
+
+// Instance is a LANDrop server on the network
+type Instance struct {
+	ID          string // random, so a browser can tell its own server apart
+	Name        string // shown to people, usually the host name
+	Host        string // host name without the .local domain
+	Port        int
+	Version     string   // LANDrop version
+	Fingerprint string   // SHA-256 fingerprint of the TLS certificate, empty over plain HTTP
+	Addrs       []net.IP // IPv4 addresses the server can be reached at
+}
+
+// URL returns the address of the instance's web page
+func (inst Instance) URL() string {
+	scheme := "http"
+	if inst.Fingerprint != "" {
+		scheme = "https"
+	}
+	host := inst.Host + ".local"
+	if len(inst.Addrs) > 0 {
+		host = inst.Addrs[0].String()
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(inst.Port)))
+}
+
+// NewID returns a random instance ID
+func NewID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// label turns a name into a single DNS label: no dots, at most 63 bytes
+func label(name string) string {
+	name = strings.ReplaceAll(strings.TrimSpace(name), ".", "-")
+	if name == "" {
+		name = "landrop"
+	}
+	for len(name) > 63 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// instanceName is the DNS name of the instance's SRV and TXT records
+func (inst Instance) instanceName() string {
+	return label(inst.Name) + "." + serviceName
+}
+
+// hostName is the DNS name of the instance's address records
+func (inst Instance) hostName() string {
+	return label(inst.Host) + "." + domain
+}
+
+// txt encodes what a browser needs to know about the instance
+func (inst Instance) txt() []string {
+	txt := []string{
+		"txtvers=1",
+		"id=" + inst.ID,
+		"name=" + inst.Name,
+		"version=" + inst.Version,
+		"port=" + strconv.Itoa(inst.Port),
+	}
+	if inst.Fingerprint != "" {
+		txt = append(txt, "fp="+inst.Fingerprint)
+	}
+	return txt
+}
+
+// record is a resource record of an advertised instance
+type record struct {
+	header dnsmessage.ResourceHeader
+	body   dnsmessage.ResourceBody
+}
+
+// matches reports whether the record answers a question
+func (rec record) matches(q dnsmessage.Question) bool {
+	return strings.EqualFold(q.Name.String(), rec.header.Name.String()) &&
+		(q.Type == rec.header.Type || q.Type == dnsmessage.TypeALL)
+}
+
+// records returns the PTR, SRV, TXT and A records describing the instance.
+// Unique records get the cache-flush bit unless the answer goes to a
+// one-shot query, which must not see it.
+func (inst Instance) records(ttl uint32, unique bool) ([]record, error) {
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(inst.instanceName())
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(inst.hostName())
+	if err != nil {
+		return nil, err
+	}
+
+	uniqueClass := dnsmessage.ClassINET
+	if unique {
+		uniqueClass |= cacheFlush
+	}
+	header := func(name dnsmessage.Name, typ dnsmessage.Type, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl}
+	}
+
+	records := []record{
+		{header(service, dnsmessage.TypePTR, dnsmessage.ClassINET), &dnsmessage.PTRResource{PTR: instance}},
+		{header(instance, dnsmessage.TypeSRV, uniqueClass), &dnsmessage.SRVResource{Port: uint16(inst.Port), Target: host}},
+		{header(instance, dnsmessage.TypeTXT, uniqueClass), &dnsmessage.TXTResource{TXT: inst.txt()}},
+	}
+	for _, ip := range inst.Addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			records = append(records, record{header(host, dnsmessage.TypeA, uniqueClass), &dnsmessage.AResource{A: [4]byte(ip4)}})
+		}
+	}
+	return records, nil
+}
+
+// addRecord appends a record to the current section of a message
+func addRecord(b *dnsmessage.Builder, rec record) error {
+	switch body := rec.body.(type) {
+	case *dnsmessage.PTRResource:
+		return b.PTRResource(rec.header, *body)
+	case *dnsmessage.SRVResource:
+		return b.SRVResource(rec.header, *body)
+	case *dnsmessage.TXTResource:
+		return b.TXTResource(rec.header, *body)
+	case *dnsmessage.AResource:
+		return b.AResource(rec.header, *body)
+	default:
+		return fmt.Errorf("unexpected record %T", rec.body)
+	}
+}
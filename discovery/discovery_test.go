@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startResponder serves an instance on loopback and returns its address
+func startResponder(t *testing.T, inst Instance) net.Addr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %v", err)
+	}
+	r := NewResponder(conn, inst)
+	go r.Serve()
+	t.Cleanup(func() { r.Close() })
+	return conn.LocalAddr()
+}
+
+func TestBrowseFindsResponder(t *testing.T) {
+	inst := Instance{
+		ID:          NewID(),
+		Name:        "Paolo's MacBook Pro",
+		Host:        "paolos-macbook",
+		Port:        8443,
+		Version:     "1.4.0",
+		Fingerprint: strings.Repeat("ab", 32),
+		Addrs:       []net.IP{net.IPv4(192, 168, 1, 20), net.IPv4(10, 0, 0, 5)},
+	}
+	addr := startResponder(t, inst)
+
+	browser := &Browser{Addr: addr, Timeout: 300 * time.Millisecond}
+	found, err := browser.Browse(context.Background())
+	if err != nil {
+		t.Fatalf("Browse failed: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("Expected one instance, got %+v", found)
+	}
+
+	got := found[0]
+	if got.ID != inst.ID || got.Name != inst.Name || got.Host != inst.Host || got.Port != inst.Port ||
+		got.Version != inst.Version || got.Fingerprint != inst.Fingerprint {
+		t.Errorf("Expected %+v, got %+v", inst, got)
+	}
+	if len(got.Addrs) != 2 || !got.Addrs[0].Equal(inst.Addrs[0]) || !got.Addrs[1].Equal(inst.Addrs[1]) {
+		t.Errorf("Expected addresses %v, got %v", inst.Addrs, got.Addrs)
+	}
+	if url := got.URL(); url != "https://192.168.1.20:8443" {
+		t.Errorf("Unexpected URL %s", url)
+	}
+}
+
+func TestBrowseCancelled(t *testing.T) {
+	addr := startResponder(t, Instance{Name: "desk", Host: "desk", Port: 8080})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	browser := &Browser{Addr: addr, Timeout: 5 * time.Second}
+	start := time.Now()
+	if _, err := browser.Browse(ctx); err != context.Canceled {
+		t.Errorf("Expected the browse to be cancelled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected a cancelled browse to return right away, took %s", time.Since(start))
+	}
+}
+
+// ask sends a one-shot query and returns the records of the answer, or nil
+// if nothing answered
+func ask(t *testing.T, addr net.Addr, name string, typ dnsmessage.Type) []dnsmessage.Resource {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET})
+	query, _ := b.Finish()
+	conn.WriteTo(query, addr)
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, 9000)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		t.Fatalf("Malformed answer: %v", err)
+	}
+	if msg.Header.ID != 42 || len(msg.Questions) != 1 {
+		t.Errorf("Expected the one-shot query's ID and question back, got %+v", msg.Header)
+	}
+	for _, res := range append(msg.Answers, msg.Additionals...) {
+		if res.Header.TTL > legacyTTL || res.Header.Class != dnsmessage.ClassINET {
+			t.Errorf("Expected a plain DNS record in a one-shot answer, got %+v", res.Header)
+		}
+	}
+	return msg.Answers
+}
+
+func TestResponderAnswers(t *testing.T) {
+	addr := startResponder(t, Instance{Name: "desk.example", Host: "desk", Port: 8080, Addrs: []net.IP{net.IPv4(192, 168, 1, 20)}})
+
+	testCases := []struct {
+		name     string
+		question string
+		typ      dnsmessage.Type
+		expected []dnsmessage.Type // answer records, nil if nothing may answer
+	}{
+		{"service", "_landrop._tcp.local.", dnsmessage.TypePTR, []dnsmessage.Type{dnsmessage.TypePTR}},
+		{"enumeration", "_services._dns-sd._udp.local.", dnsmessage.TypePTR, []dnsmessage.Type{dnsmessage.TypePTR}},
+		{"instance", "desk-example._landrop._tcp.local.", dnsmessage.TypeALL, []dnsmessage.Type{dnsmessage.TypeSRV, dnsmessage.TypeTXT}},
+		{"host", "DESK.local.", dnsmessage.TypeA, []dnsmessage.Type{dnsmessage.TypeA}},
+		{"other service", "_http._tcp.local.", dnsmessage.TypePTR, nil},
+		{"other host", "laptop.local.", dnsmessage.TypeA, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			answers := ask(t, addr, tc.question, tc.typ)
+			var types []dnsmessage.Type
+			for _, res := range answers {
+				types = append(types, res.Header.Type)
+			}
+			if !slices.Equal(types, tc.expected) {
+				t.Errorf("Expected answers %v, got %v", tc.expected, types)
+			}
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"Paolo's MacBook", "Paolo's MacBook"},
+		{"desk.example.com", "desk-example-com"},
+		{"  ", "landrop"},
+		{strings.Repeat("é", 40), strings.Repeat("é", 31)},
+	}
+
+	for _, tc := range testCases {
+		if got := label(tc.name); got != tc.expected {
+			t.Errorf("label(%q) = %q, expected %q", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestInstanceURL(t *testing.T) {
+	testCases := []struct {
+		inst     Instance
+		expected string
+	}{
+		{Instance{Host: "desk", Port: 8080, Addrs: []net.IP{net.IPv4(192, 168, 1, 20)}}, "http://192.168.1.20:8080"},
+		{Instance{Host: "desk", Port: 8443, Fingerprint: "ab"}, "https://desk.local:8443"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.inst.URL(); got != tc.expected {
+			t.Errorf("Expected %s, got %s", tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package discovery
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// Responder answers multicast DNS questions about one instance
+type Responder struct {
+	conn     net.PacketConn
+	group    net.Addr // where multicast answers go, nil to only answer directly
+	instance Instance
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewResponder answers the questions arriving on conn. Call Serve to start
+// answering. Answers go straight back to whoever asked, which is enough for
+// the one-shot queries Browse sends, e.g. to a responder on loopback in
+// tests.
+func NewResponder(conn net.PacketConn, instance Instance) *Responder {
+	return &Responder{conn: conn, instance: instance, closed: make(chan struct{})}
+}
+
+// Advertise announces the instance on the multicast DNS group of every
+// network interface and keeps answering questions about it until Close.
+// Loopback addresses are left out, other devices can't use them.
+func Advertise(instance Instance) (*Responder, error) {
+	group := &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	// ListenMulticastUDP only joins on the default interface
+	pc := ipv4.NewPacketConn(conn)
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+				pc.JoinGroup(&iface, group) // fails where already joined
+			}
+		}
+	}
+	pc.SetMulticastLoopback(true) // other instances on this computer ask too
+
+	var addrs []net.IP
+	for _, ip := range instance.Addrs {
+		if !ip.IsLoopback() {
+			addrs = append(addrs, ip)
+		}
+	}
+	instance.Addrs = addrs
+
+	r := NewResponder(conn, instance)
+	r.group = group
+	go r.Serve()
+	r.announce(recordTTL)
+	return r, nil
+}
+
+// Serve answers questions until the responder is closed
+func (r *Responder) Serve() error {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+		r.handle(buf[:n], from)
+	}
+}
+
+// Close says goodbye, so browsers forget the instance, and stops answering
+func (r *Responder) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		r.announce(0)
+		close(r.closed)
+		err = r.conn.Close()
+	})
+	return err
+}
+
+// announce sends every record to the multicast group unasked. A TTL of 0
+// withdraws them.
+func (r *Responder) announce(ttl uint32) {
+	if r.group == nil {
+		return
+	}
+	records, err := r.instance.records(ttl, true)
+	if err != nil {
+		return
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	b.StartAnswers()
+	for _, rec := range records {
+		if addRecord(&b, rec) != nil {
+			return
+		}
+	}
+	if msg, err := b.Finish(); err == nil {
+		r.conn.WriteTo(msg, r.group)
+	}
+}
+
+// handle answers a message if it asks about the instance
+func (r *Responder) handle(data []byte, from net.Addr) {
+	var p dnsmessage.Parser
+	header, err := p.Start(data)
+	if err != nil || header.Response || header.OpCode != 0 {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	// Queries that don't come from the mDNS port are one-shot queries, which
+	// get a plain DNS answer sent straight back
+	udp, _ := from.(*net.UDPAddr)
+	oneShot := udp == nil || udp.Port != mdnsPort
+	ttl := uint32(recordTTL)
+	if oneShot {
+		ttl = legacyTTL
+	}
+	records, err := r.instance.records(ttl, !oneShot)
+	if err != nil {
+		return
+	}
+	enumeration, err := enumerationRecord(ttl)
+	if err != nil {
+		return
+	}
+
+	candidates := append(records[:len(records):len(records)], enumeration)
+	direct := oneShot || r.group == nil
+	var answers []record
+	for _, q := range questions {
+		if q.Class&^unicastResponse != dnsmessage.ClassINET && q.Class&^unicastResponse != dnsmessage.ClassANY {
+			continue
+		}
+		matched := false
+		for _, rec := range candidates {
+			if rec.matches(q) && !containsRecord(answers, rec) {
+				answers = append(answers, rec)
+				matched = true
+			}
+		}
+		if matched && q.Class&unicastResponse != 0 {
+			direct = true
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	responseHeader := dnsmessage.Header{Response: true, Authoritative: true}
+	if oneShot {
+		responseHeader.ID = header.ID
+	}
+	b := dnsmessage.NewBuilder(nil, responseHeader)
+	b.EnableCompression()
+	if oneShot {
+		// A plain DNS resolver expects its questions back
+		b.StartQuestions()
+		for _, q := range questions {
+			b.Question(q)
+		}
+	}
+	b.StartAnswers()
+	for _, rec := range answers {
+		if addRecord(&b, rec) != nil {
+			return
+		}
+	}
+	// The rest of the instance's records save the asker more questions
+	b.StartAdditionals()
+	for _, rec := range records {
+		if !containsRecord(answers, rec) && addRecord(&b, rec) != nil {
+			return
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return
+	}
+
+	to := from
+	if !direct {
+		to = r.group
+	}
+	r.conn.WriteTo(msg, to)
+}
+
+// enumerationRecord points DNS-SD service enumeration at LANDrop
+func enumerationRecord(ttl uint32) (record, error) {
+	name, err := dnsmessage.NewName(enumerationName)
+	if err != nil {
+		return record{}, err
+	}
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return record{}, err
+	}
+	return record{
+		dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		&dnsmessage.PTRResource{PTR: service},
+	}, nil
+}
+
+func containsRecord(records []record, rec record) bool {
+	for _, r := range records {
+		if r.header.Type == rec.header.Type && strings.EqualFold(r.header.Name.String(), rec.header.Name.String()) && r.body == rec.body {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// Package events tells the rest of the application what the server and the
+// transfers are doing. Producers publish typed events on a Bus and any number
+// of subscribers (the status label, notifications, logs) receive them without
+// ever slowing the producer down.
+package events
+
+import (
+	"sync"
+)
+
+// Event is something that happened. Status returns the line shown in the
+// desktop window's status label, or "" for events that don't update it.
+type Event interface {
+	Status() string
+}
+
+// defaultBuffer is how many events a subscriber can fall behind by before
+// new ones are dropped for it
+const defaultBuffer = 256
+
+// Bus delivers published events to every subscriber
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates a Bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Default is the bus the server, the transfers and the desktop window share
+var Default = NewBus()
+
+// Publish sends e to every subscriber. It never blocks: a subscriber whose
+// buffer is full misses the event.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// in order, and a function that ends the subscription and closes the channel
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, defaultBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			close(ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Handle calls fn for every event published from now on, one at a time on a
+// goroutine of its own. It returns a function that stops the calls.
+func (b *Bus) Handle(fn func(Event)) func() {
+	ch, cancel := b.Subscribe()
+	go func() {
+		for e := range ch {
+			fn(e)
+		}
+	}()
+	return cancel
+}
+
+// HandleStatus calls fn with the status line of every event that has one.
+// It keeps callbacks written for plain status strings working.
+func (b *Bus) HandleStatus(fn func(status string)) func() {
+	return b.Handle(func(e Event) {
+		if status := e.Status(); status != "" {
+			fn(status)
+		}
+	})
+}
+
+// Publish sends e on the Default bus
+func Publish(e Event) {
+	Default.Publish(e)
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBusDeliversToEverySubscriber(t *testing.T) {
+	bus := NewBus()
+	first, cancelFirst := bus.Subscribe()
+	second, cancelSecond := bus.Subscribe()
+	defer cancelFirst()
+	defer cancelSecond()
+
+	bus.Publish(Notice{Text: "one"})
+	bus.Publish(DevicePaired{Device: "Phone"})
+
+	for _, ch := range []<-chan Event{first, second} {
+		if e := <-ch; e != (Notice{Text: "one"}) {
+			t.Errorf("Expected the notice first, got %#v", e)
+		}
+		if e := <-ch; e != (DevicePaired{Device: "Phone"}) {
+			t.Errorf("Expected the pairing second, got %#v", e)
+		}
+	}
+}
+
+func TestBusNeverBlocks(t *testing.T) {
+	bus := NewBus()
+	slow, cancel := bus.Subscribe()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3*defaultBuffer; i++ {
+			bus.Publish(Notice{Text: "event"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a subscriber that doesn't read")
+	}
+	if len(slow) != defaultBuffer {
+		t.Errorf("Expected the subscriber to keep %d events, got %d", defaultBuffer, len(slow))
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	ch, cancel := bus.Subscribe()
+	cancel()
+	cancel()
+
+	bus.Publish(Notice{Text: "late"})
+	if _, ok := <-ch; ok {
+		t.Error("Expected the channel to be closed")
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	bus := NewBus()
+	statuses := make(chan string, 10)
+	stop := bus.HandleStatus(func(status string) {
+		statuses <- status
+	})
+	defer stop()
+
+	bus.Publish(FileReceived{Name: "a.jpg", Path: "/uploads/a_1.jpg"})
+	bus.Publish(Notice{}) // no status line
+	bus.Publish(ServerStopped{Err: errors.New("port in use")})
+
+	for _, expected := range []string{"Received: a_1.jpg", "Server stopped: port in use"} {
+		select {
+		case got := <-statuses:
+			if got != expected {
+				t.Errorf("Expected %q, got %q", expected, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+}
+
+func TestStatusLines(t *testing.T) {
+	testCases := []struct {
+		event    Event
+		expected string
+	}{
+		{ServerStarted{Port: 8080, Scheme: "HTTPS"}, "Server listening on port 8080 (HTTPS)"},
+		{PeerConnected{Via: ViaSignaling}, "WebRTC client connected"},
+		{PeerConnected{Via: ViaWebRTC}, "WebRTC peer connected"},
+		{PeerDisconnected{Via: ViaSignaling, Failed: true}, "WebRTC connection error"},
+		{PeerDisconnected{Via: ViaWebRTC, Failed: true}, "WebRTC connection failed"},
+		{TransferProgress{Name: "video.mp4", Bytes: 25, Size: 100}, "Receiving: video.mp4 (25%)"},
+		{TransferProgress{Name: "video.mp4", Bytes: 25}, "Receiving: video.mp4"},
+		{FileReceived{Path: "/uploads/a.jpg", Skipped: true}, "Kept existing file: a.jpg"},
+		{FilesReceived{Paths: []string{"a", "b"}}, "Received 2 files"},
+		{TransferFailed{Name: "a.jpg", Reason: Corrupt}, "Corrupt, deleted: a.jpg"},
+		{TransferFailed{Name: "a.jpg", Reason: Rejected}, "Rejected: a.jpg"},
+		{DownloadServed{Name: "photos.zip", Archive: true}, "Downloaded archive: photos.zip"},
+		{DownloadStarted{Name: "a.jpg"}, "Downloading: a.jpg"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.event.Status(); got != tc.expected {
+			t.Errorf("%#v: expected %q, got %q", tc.event, tc.expected, got)
+		}
+	}
+}
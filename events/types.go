@@ -0,0 +1,232 @@
+package events
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Notice is a message with nothing more specific to it
+type Notice struct {
+	Text string
+}
+
+func (e Notice) Status() string { return e.Text }
+
+// ServerStarted is published once the server accepts connections
+type ServerStarted struct {
+	Port   int
+	Scheme string // HTTP or HTTPS
+}
+
+func (e ServerStarted) Status() string {
+	return fmt.Sprintf("Server listening on port %d (%s)", e.Port, e.Scheme)
+}
+
+// ServerStopped is published when the server can't start or stops on an error
+type ServerStopped struct {
+	Err error
+}
+
+func (e ServerStopped) Status() string {
+	return fmt.Sprintf("Server stopped: %s", e.Err)
+}
+
+// Ways a peer connects
+const (
+	ViaSignaling = "signaling" // the WebSocket used to set up WebRTC
+	ViaWebRTC    = "webrtc"    // the peer connection itself
+)
+
+// PeerConnected is published when a browser opens a connection
+type PeerConnected struct {
+	Addr string
+	Via  string // ViaSignaling or ViaWebRTC
+}
+
+func (e PeerConnected) Status() string {
+	if e.Via == ViaWebRTC {
+		return "WebRTC peer connected"
+	}
+	return "WebRTC client connected"
+}
+
+// PeerDisconnected is published when a browser's connection ends
+type PeerDisconnected struct {
+	Addr   string
+	Via    string // ViaSignaling or ViaWebRTC
+	Failed bool   // the connection broke rather than being closed
+}
+
+func (e PeerDisconnected) Status() string {
+	switch {
+	case e.Via == ViaWebRTC && e.Failed:
+		return "WebRTC connection failed"
+	case e.Via == ViaWebRTC:
+		return "WebRTC peer disconnected"
+	case e.Failed:
+		return "WebRTC connection error"
+	default:
+		return "WebRTC client disconnected"
+	}
+}
+
+// DevicePaired is published when a device completes pairing
+type DevicePaired struct {
+	Device string
+}
+
+func (e DevicePaired) Status() string {
+	return fmt.Sprintf("Paired new device: %s", e.Device)
+}
+
+// TransferStarted is published when a file starts arriving
+type TransferStarted struct {
+	ID        string
+	SessionID string
+	Name      string
+	Method    string // upload, tus, webrtc
+	Device    string
+	Size      int64 // 0 when unknown
+}
+
+func (e TransferStarted) Status() string {
+	return fmt.Sprintf("Receiving: %s", e.Name)
+}
+
+// TransferProgress is published while a file arrives
+type TransferProgress struct {
+	ID        string
+	SessionID string
+	Name      string
+	Method    string
+	Bytes     int64
+	Size      int64   // 0 when unknown
+	Rate      float64 // bytes per second
+	ETA       float64 // seconds left, 0 when unknown
+}
+
+func (e TransferProgress) Status() string {
+	if e.Size <= 0 {
+		return fmt.Sprintf("Receiving: %s", e.Name)
+	}
+	return fmt.Sprintf("Receiving: %s (%d%%)", e.Name, e.Bytes*100/e.Size)
+}
+
+// TransferDeclined is published when the desktop user turns a transfer down
+type TransferDeclined struct {
+	Device string
+	Addr   string
+	Reason string
+}
+
+func (e TransferDeclined) Status() string {
+	return fmt.Sprintf("Declined transfer from %s", e.Device)
+}
+
+// FileReceived is published once a received file is in place
+type FileReceived struct {
+	Name    string // as sent
+	Path    string // where it was saved
+	Method  string
+	Device  string
+	Size    int64
+	Skipped bool // the conflict policy kept the existing file instead
+}
+
+func (e FileReceived) Status() string {
+	if e.Skipped {
+		return fmt.Sprintf("Kept existing file: %s", filepath.Base(e.Path))
+	}
+	return fmt.Sprintf("Received: %s", filepath.Base(e.Path))
+}
+
+// FilesReceived is published when a batch of files has been received
+type FilesReceived struct {
+	Paths  []string
+	Dir    string // folder they were saved in
+	Method string
+}
+
+func (e FilesReceived) Status() string {
+	if len(e.Paths) == 1 {
+		return "File received"
+	}
+	return fmt.Sprintf("Received %d files", len(e.Paths))
+}
+
+// FailureReason tells why a file wasn't received
+type FailureReason string
+
+const (
+	Rejected   FailureReason = "rejected"    // refused before any data was written
+	Corrupt    FailureReason = "corrupt"     // didn't match its size or checksum
+	SaveFailed FailureReason = "save_failed" // couldn't be put in place
+)
+
+// TransferFailed is published when a file doesn't make it
+type TransferFailed struct {
+	Name   string
+	Method string
+	Reason FailureReason
+}
+
+func (e TransferFailed) Status() string {
+	switch e.Reason {
+	case Corrupt:
+		return fmt.Sprintf("Corrupt, deleted: %s", e.Name)
+	case SaveFailed:
+		return fmt.Sprintf("Failed to save: %s", e.Name)
+	default:
+		return fmt.Sprintf("Rejected: %s", e.Name)
+	}
+}
+
+// DownloadStarted is published when a device starts downloading
+type DownloadStarted struct {
+	Name    string
+	Archive bool
+}
+
+func (e DownloadStarted) Status() string {
+	if e.Archive {
+		return fmt.Sprintf("Downloading archive: %s", e.Name)
+	}
+	return fmt.Sprintf("Downloading: %s", e.Name)
+}
+
+// DownloadServed is published when a download has been sent completely
+type DownloadServed struct {
+	Name     string
+	Archive  bool
+	Size     int64
+	PeerAddr string
+}
+
+func (e DownloadServed) Status() string {
+	if e.Archive {
+		return fmt.Sprintf("Downloaded archive: %s", e.Name)
+	}
+	return fmt.Sprintf("Downloaded: %s", e.Name)
+}
+
+// DownloadFailed is published when a download breaks off
+type DownloadFailed struct {
+	Name    string
+	Archive bool
+}
+
+func (e DownloadFailed) Status() string {
+	if e.Archive {
+		return fmt.Sprintf("Archive download failed: %s", e.Name)
+	}
+	return fmt.Sprintf("Download failed: %s", e.Name)
+}
+
+// TextReceived is published when a device sends text to the desktop
+type TextReceived struct {
+	Device string
+}
+
+func (e TextReceived) Status() string {
+	return "Text received"
+}
@@ -0,0 +1,89 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/config"
+	"lan-drop/transfer"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showAPIKeysDialog lists the API keys scripts use to upload without pairing
+// and lets the user create and revoke them. Changes are saved right away.
+func showAPIKeysDialog(w fyne.Window, a fyne.App, prefs *config.Preferences) {
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.RemoveAll()
+		if len(prefs.APIKeys) == 0 {
+			list.Add(widget.NewLabel("No API keys yet. Scripts have to pair like any other device."))
+		}
+		for _, k := range prefs.APIKeys {
+			key := k
+			label := widget.NewLabel(fmt.Sprintf("%s\nCreated %s", key.Name, key.CreatedAt.Format("2006-01-02 15:04")))
+			revokeBtn := widget.NewButton("Revoke", func() {
+				dialog.ShowConfirm("Revoke API key?",
+					fmt.Sprintf("Scripts using %q will no longer be able to upload.", key.Name),
+					func(ok bool) {
+						if ok {
+							prefs.RevokeAPIKey(key.ID)
+							config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+							rebuild()
+						}
+					}, w)
+			})
+			list.Add(container.NewBorder(nil, nil, nil, revokeBtn, label))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	createBtn := widget.NewButton("Create Key", func() {
+		nameEntry := widget.NewEntry()
+		nameEntry.SetPlaceHolder("CI runner")
+		dialog.ShowForm("Create API Key", "Create", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Used by", nameEntry),
+		}, func(ok bool) {
+			if !ok {
+				return
+			}
+			key, secret, err := config.NewAPIKey(nameEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			prefs.APIKeys = append(prefs.APIKeys, key)
+			config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+			rebuild()
+			showNewAPIKey(w, a, key.Name, secret)
+		}, w)
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(460, 250))
+
+	dialog.NewCustom("API Keys", "Close", container.NewBorder(nil, createBtn, nil, nil, scroll), w).Show()
+}
+
+// showNewAPIKey shows a key that was just created. Only its hash is kept, so
+// this is the one chance to copy it.
+func showNewAPIKey(w fyne.Window, a fyne.App, name, secret string) {
+	secretEntry := widget.NewEntry()
+	secretEntry.SetText(secret)
+	copyBtn := widget.NewButton("Copy", func() {
+		a.Clipboard().SetContent(secret)
+	})
+
+	hint := widget.NewLabel("Copy the key now, it won't be shown again. Send it as a bearer token:\n" +
+		"curl -H \"Authorization: Bearer <key>\" -T file.txt http://<address>:<port>/api/v1/files/")
+	hint.Wrapping = fyne.TextWrapWord
+
+	content := container.NewVBox(hint, container.NewBorder(nil, nil, nil, copyBtn, secretEntry))
+	d := dialog.NewCustom(fmt.Sprintf("API key for %s", name), "Done", content, w)
+	d.Resize(fyne.NewSize(520, 200))
+	d.Show()
+}
@@ -0,0 +1,69 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"lan-drop/transfer"
+	"os"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newConflictPrompter returns a transfer.ConflictPrompter that asks the user
+// in a dialog on w whether to keep both files, replace the existing one or
+// skip the new one. The dialog is dismissed when the question times out.
+func newConflictPrompter(w fyne.Window) transfer.ConflictPrompter {
+	return func(ctx context.Context, existing string, in transfer.IncomingFile) transfer.ConflictChoice {
+		answer := make(chan transfer.ConflictChoice, 1)
+		var d *dialog.CustomDialog
+
+		fyne.DoAndWait(func() {
+			existingInfo := "Existing file"
+			if stat, err := os.Stat(existing); err == nil {
+				existingInfo = fmt.Sprintf("Existing: %s, modified %s", formatSize(stat.Size()), stat.ModTime().Format("Jan 2 15:04"))
+			}
+			incomingInfo := fmt.Sprintf("New: %s", formatSize(in.Size))
+			if !in.ModTime.IsZero() {
+				incomingInfo += ", modified " + in.ModTime.Format("Jan 2 15:04")
+			}
+
+			content := container.NewVBox(
+				widget.NewLabelWithStyle(filepath.Base(existing), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+				widget.NewLabel(fmt.Sprintf("already exists in %s.", filepath.Dir(existing))),
+				widget.NewLabel(existingInfo),
+				widget.NewLabel(incomingInfo),
+			)
+
+			choose := func(choice transfer.ConflictChoice) func() {
+				return func() {
+					select {
+					case answer <- choice:
+					default: // already answered
+					}
+					d.Hide()
+				}
+			}
+
+			d = dialog.NewCustomWithoutButtons("File Already Exists", content, w)
+			d.SetButtons([]fyne.CanvasObject{
+				widget.NewButton("Keep Both", choose(transfer.ChoiceRename)),
+				widget.NewButton("Replace", choose(transfer.ChoiceOverwrite)),
+				widget.NewButton("Skip", choose(transfer.ChoiceSkip)),
+			})
+			d.Show()
+			w.RequestFocus()
+		})
+
+		select {
+		case choice := <-answer:
+			return choice
+		case <-ctx.Done():
+			fyne.Do(d.Hide)
+			return transfer.ChoiceRename
+		}
+	}
+}
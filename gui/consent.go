@@ -0,0 +1,87 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"lan-drop/config"
+	"lan-drop/transfer"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// maxListedFiles limits how many file names the consent dialog shows
+const maxListedFiles = 10
+
+// formatSize renders a byte count with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// newConsentPrompter returns a transfer.Prompter that asks the user to
+// accept or decline an incoming transfer in a dialog on w. The dialog is
+// dismissed automatically when the request times out.
+func newConsentPrompter(a fyne.App, w fyne.Window, prefs *config.Preferences) transfer.Prompter {
+	return func(ctx context.Context, req transfer.Request) transfer.Decision {
+		answer := make(chan transfer.Decision, 1)
+		var d dialog.Dialog
+
+		fyne.DoAndWait(func() {
+			device := req.DeviceName
+			if device == "" {
+				device = "Unknown device"
+			}
+
+			files := container.NewVBox()
+			for i, f := range req.Files {
+				if i == maxListedFiles {
+					files.Add(widget.NewLabel(fmt.Sprintf("…and %d more", len(req.Files)-maxListedFiles)))
+					break
+				}
+				files.Add(widget.NewLabel(fmt.Sprintf("%s (%s)", f.Name, formatSize(f.Size))))
+			}
+
+			alwaysAllow := widget.NewCheck("Always allow this device", nil)
+
+			content := container.NewVBox(
+				widget.NewLabelWithStyle(device, fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+				widget.NewLabel(req.RemoteAddr),
+				widget.NewLabel(fmt.Sprintf("wants to send %d file(s), %s in total:", len(req.Files), formatSize(req.TotalSize()))),
+				files,
+			)
+			// Only devices that paired or use an API key can be told apart
+			if req.DeviceID != "" {
+				content.Add(alwaysAllow)
+			}
+
+			d = dialog.NewCustomConfirm("Incoming Transfer", "Accept", "Decline", content, func(accepted bool) {
+				answer <- transfer.Decision{Accepted: accepted, AlwaysAllow: accepted && alwaysAllow.Checked}
+			}, w)
+			d.Show()
+			w.RequestFocus()
+		})
+
+		select {
+		case decision := <-answer:
+			if decision.AlwaysAllow {
+				transfer.Trust(prefs, req.DeviceID)
+				config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+			}
+			return decision
+		case <-ctx.Done():
+			fyne.Do(d.Hide)
+			return transfer.Decision{}
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package gui
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"image/color"
 	"io"
 	"lan-drop/config"
+	"lan-drop/events"
 	"lan-drop/qrcode"
 	"lan-drop/server"
+	"lan-drop/transfer"
 	"lan-drop/update"
 	"lan-drop/utils"
 	"log"
@@ -21,65 +25,70 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-// copyFileToShared copies a file to the shared directory
-func copyFileToShared(sourcePath string, sharedDir string, statusLabel *widget.Label) error {
+// copyFileToShared copies a file to the shared directory, applying the
+// conflict policy if a file with the same name is already shared
+func copyFileToShared(sourcePath string, prefs *config.Preferences, statusLabel *widget.Label) (transfer.Resolution, error) {
 	// Open source file
 	sourceFile, err := os.Open(sourcePath)
 	if err != nil {
-		return fmt.Errorf("cannot open source file: %w", err)
+		return transfer.Resolution{}, fmt.Errorf("cannot open source file: %w", err)
 	}
 	defer sourceFile.Close()
 
 	// Get file info
 	fileInfo, err := sourceFile.Stat()
 	if err != nil {
-		return fmt.Errorf("cannot get file info: %w", err)
+		return transfer.Resolution{}, fmt.Errorf("cannot get file info: %w", err)
 	}
 
 	// Skip directories
 	if fileInfo.IsDir() {
-		return fmt.Errorf("cannot copy directories")
+		return transfer.Resolution{}, fmt.Errorf("cannot copy directories")
 	}
 
-	// Create destination path
-	destPath := filepath.Join(sharedDir, filepath.Base(sourcePath))
-
-	// Check if file exists
-	if _, err := os.Stat(destPath); err == nil {
-		// File exists, add number suffix
-		ext := filepath.Ext(filepath.Base(sourcePath))
-		name := filepath.Base(sourcePath)
-		name = name[:len(name)-len(ext)]
-
-		for i := 1; ; i++ {
-			destPath = filepath.Join(sharedDir, fmt.Sprintf("%s_%d%s", name, i, ext))
-			if _, err := os.Stat(destPath); os.IsNotExist(err) {
-				break
-			}
-		}
+	// Copy into a hidden part file so a half-copied file is never shared
+	partFile, err := transfer.CreatePartFile(prefs.SharedDir)
+	if err != nil {
+		return transfer.Resolution{}, fmt.Errorf("cannot create destination file: %w", err)
 	}
+	defer os.Remove(partFile.Name())
 
-	// Create destination file
-	destFile, err := os.Create(destPath)
+	// Copy data, hashing it for the skip-if-identical policy
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(partFile, hash), sourceFile)
+	if closeErr := partFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return fmt.Errorf("cannot create destination file: %w", err)
+		return transfer.Resolution{}, fmt.Errorf("cannot copy file data: %w", err)
 	}
-	defer destFile.Close()
 
-	// Copy data
-	_, err = io.Copy(destFile, sourceFile)
+	incoming := transfer.IncomingFile{
+		Name:    filepath.Base(sourcePath),
+		Size:    fileInfo.Size(),
+		SHA256:  hash.Sum(nil),
+		ModTime: fileInfo.ModTime(),
+	}
+	res, err := transfer.ResolveConflict(context.Background(), prefs, prefs.SharedDir, incoming)
 	if err != nil {
-		return fmt.Errorf("cannot copy file data: %w", err)
+		return res, fmt.Errorf("cannot check for an existing file: %w", err)
+	}
+	if res, err = transfer.PlaceFile(partFile.Name(), res); err != nil {
+		return res, fmt.Errorf("cannot save file: %w", err)
 	}
 
 	// Update status
 	if statusLabel != nil {
-		fyne.DoAndWait(func() {
-			statusLabel.SetText(fmt.Sprintf("Shared: %s", filepath.Base(destPath)))
+		status := fmt.Sprintf("Shared: %s", filepath.Base(res.Path))
+		if res.Skip {
+			status = fmt.Sprintf("Already shared: %s", filepath.Base(res.Path))
+		}
+		fyne.Do(func() {
+			statusLabel.SetText(status)
 		})
 	}
 
-	return nil
+	return res, nil
 }
 
 func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerController, version string) {
@@ -99,7 +108,14 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 
 	w := a.NewWindow("LAN Drop v" + version)
 
-	url := fmt.Sprintf("http://%s:%d", utils.GetLocalIP(), prefs.Port)
+	// Incoming transfers are confirmed in a dialog when "ask before receiving" is on
+	transfer.SetPrompter(newConsentPrompter(a, w, prefs))
+	// and name clashes are settled there when the conflict policy is "ask"
+	transfer.SetConflictPrompter(newConflictPrompter(w))
+	// Text sent by devices lands in the clipboard
+	transfer.SetTextReceiver(newTextReceiver(a, prefs))
+
+	url := controller.URL()
 
 	// Header section with title and version
 	titleLabel := widget.NewLabelWithStyle("LANDrop", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
@@ -115,17 +131,42 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 	})
 	copyableURL.Importance = widget.LowImportance
 
-	// QR Code
-	qrImg := canvas.NewImageFromImage(qrcode.GenerateQRImage(url))
+	// QR Code (carries the one-time pairing token when pairing is required)
+	qrImg := canvas.NewImageFromImage(qrcode.GenerateQRImage(pairingURL(url, controller.Fingerprint(), prefs, controller.Auth)))
 	qrImg.FillMode = canvas.ImageFillContain
 	qrImg.SetMinSize(fyne.NewSize(200, 200))
 	qrContainer := container.NewCenter(qrImg)
 
+	refreshQR := func() {
+		qrImg.Image = qrcode.GenerateQRImage(pairingURL(url, controller.Fingerprint(), prefs, controller.Auth))
+		qrImg.Refresh()
+	}
+
+	// Certificate fingerprint, so users can verify the self-signed certificate
+	fingerprintLabel := widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{Monospace: true})
+	fingerprintLabel.Wrapping = fyne.TextWrapWord
+	refreshFingerprint := func() {
+		fingerprint := controller.Fingerprint()
+		if fingerprint == "" {
+			fingerprintLabel.Hide()
+			return
+		}
+		fingerprintLabel.SetText("Certificate fingerprint (SHA-256):\n" + fingerprint)
+		fingerprintLabel.Show()
+	}
+	refreshFingerprint()
+
+	// A scanned QR token is single use, so show a fresh code after pairing
+	controller.Auth.OnChange = func() {
+		fyne.Do(refreshQR)
+	}
+
 	// Status label (updated dynamically)
 	statusLabel := widget.NewLabel("Ready to receive files")
 	statusLabel.Wrapping = fyne.TextWrapWord
 
-	controller.OnStatus = func(msg string) {
+	// The label shows the status line of every event the server publishes
+	events.Default.HandleStatus(func(msg string) {
 		fyne.DoAndWait(func() {
 			// Limit status message length to avoid UI overflow
 			const maxStatusLength = 150
@@ -135,7 +176,7 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 			}
 			statusLabel.SetText(safeMsg)
 		})
-	}
+	})
 
 	// Share Files Section (to shared folder for download by peers)
 	shareLabel := widget.NewLabelWithStyle("📤 Share Files with Connected Peers", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
@@ -152,22 +193,30 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 			if reader == nil {
 				return
 			}
-			defer reader.Close()
+			sourcePath := reader.URI().Path()
+			reader.Close()
 
 			// Ensure shared directory exists
 			config.EnsureSharedDir(*prefs)
 
-			// Copy file to shared directory
-			sourcePath := reader.URI().Path()
-			err = copyFileToShared(sourcePath, prefs.SharedDir, statusLabel)
-			if err != nil {
-				log.Printf("Error sharing file: %v", err)
-				dialog.ShowError(fmt.Errorf("failed to share file: %v", err), w)
-				return
-			}
-
-			dialog.ShowInformation("File Shared",
-				fmt.Sprintf("File '%s' is now available for download by connected peers", filepath.Base(sourcePath)), w)
+			// Copy off the UI thread, the conflict policy may ask the user
+			go func() {
+				res, err := copyFileToShared(sourcePath, prefs, statusLabel)
+				fyne.Do(func() {
+					if err != nil {
+						log.Printf("Error sharing file: %v", err)
+						dialog.ShowError(fmt.Errorf("failed to share file: %v", err), w)
+						return
+					}
+					if res.Skip {
+						dialog.ShowInformation("File Not Copied",
+							fmt.Sprintf("'%s' is already in the shared folder and was kept", filepath.Base(res.Path)), w)
+						return
+					}
+					dialog.ShowInformation("File Shared",
+						fmt.Sprintf("File '%s' is now available for download by connected peers", filepath.Base(res.Path)), w)
+				})
+			}()
 		}, w)
 		fd.Show()
 	})
@@ -198,6 +247,10 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 		}()
 	})
 
+	sendBtn := widget.NewButton("📨 Send to Device…", func() {
+		showSendDialog(w, controller, nil)
+	})
+
 	var openSharedBtn *widget.Button
 	if prefs.EnableDownloads {
 		openSharedBtn = widget.NewButton("📁 Open Shared Folder", func() {
@@ -216,10 +269,10 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 	settingsBtn := widget.NewButton("⚙️ Settings", func() {
 		showSettingsWindow(a, prefs, func(port int, folder string) {
 			controller.Update(port, folder)
-			url = fmt.Sprintf("http://%s:%d", utils.GetLocalIP(), port)
+			url = controller.URL()
 			copyableURL.SetText(url)
-			qrImg.Image = qrcode.GenerateQRImage(url)
-			qrImg.Refresh()
+			refreshQR()
+			refreshFingerprint()
 			statusLabel.SetText("Settings saved. Server updated.")
 			w.SetTitle("LAN Drop v" + version)
 			dialog.ShowInformation("Settings Updated",
@@ -247,6 +300,14 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 		qrContainer,
 		container.NewCenter(urlLabel),
 		container.NewCenter(copyableURL),
+		fingerprintLabel,
+	)
+
+	pairingSection := container.NewVBox(
+		widget.NewLabelWithStyle("Pairing", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabel("Scan the QR code, or enter this PIN in the browser:"),
+		newPairingSection(w, prefs, controller.Auth),
+		widget.NewSeparator(),
 	)
 
 	shareSection := container.NewVBox(
@@ -255,12 +316,24 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 		widget.NewSeparator(),
 	)
 
+	textSection := container.NewVBox(
+		widget.NewLabelWithStyle("Send Text", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		newTextSection(a),
+		widget.NewSeparator(),
+	)
+
 	statusSection := container.NewVBox(
 		widget.NewLabelWithStyle("Status", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		statusLabel,
 		widget.NewSeparator(),
 	)
 
+	transfersSection := container.NewVBox(
+		widget.NewLabelWithStyle("Transfers", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		newProgressSection(),
+		widget.NewSeparator(),
+	)
+
 	buttonsSection := container.NewVBox(
 		widget.NewLabelWithStyle("Quick Actions", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		openBtn,
@@ -270,6 +343,8 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 		buttonsSection.Add(openSharedBtn)
 	}
 
+	buttonsSection.Add(sendBtn)
+
 	buttonsSection.Add(widget.NewSeparator())
 	buttonsSection.Add(settingsBtn)
 	buttonsSection.Add(updateBtn)
@@ -283,8 +358,11 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 	content := container.NewVBox(
 		topSection,
 		qrSection,
+		pairingSection,
 		shareSection,
+		textSection,
 		statusSection,
+		transfersSection,
 		buttonsSection,
 		footerSection,
 	)
@@ -292,7 +370,13 @@ func Start(a fyne.App, prefs *config.Preferences, controller *server.ServerContr
 	scrollContent := container.NewVScroll(content)
 	scrollContent.SetMinSize(fyne.NewSize(450, 700))
 
-	w.SetContent(scrollContent)
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Home", scrollContent),
+		container.NewTabItem("History", newHistoryTab(w, controller.History)),
+		container.NewTabItem("Nearby", newNearbyTab(w, a, controller)),
+	)
+
+	w.SetContent(tabs)
 	w.Resize(fyne.NewSize(480, 750))
 
 	// Perform automatic update check on startup (if enabled)
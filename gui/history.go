@@ -0,0 +1,160 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/history"
+	"lan-drop/utils"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// historyLimit caps how many entries the history tab shows at once
+const historyLimit = 500
+
+// describeEntry renders a history entry as two lines of text
+func describeEntry(e history.Entry) string {
+	names := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		names = append(names, f.Name)
+	}
+	files := strings.Join(names, ", ")
+	if len(files) > 60 {
+		files = files[:57] + "..."
+	}
+
+	arrow := "↓"
+	if e.Direction == history.Sent {
+		arrow = "↑"
+	}
+
+	peer := e.PeerAddr
+	if e.Device != "" {
+		peer = fmt.Sprintf("%s (%s)", e.Device, e.PeerAddr)
+	}
+
+	result := string(e.Result)
+	if e.Error != "" {
+		result += ": " + e.Error
+	}
+
+	return fmt.Sprintf("%s %s\n%s · %s · %s · %s · %s",
+		arrow, files,
+		e.Time.Format("Jan 2 15:04"), peer, formatSize(e.TotalSize()), e.Duration().Round(100*time.Millisecond), result)
+}
+
+// firstExistingPath returns the first file of the entry still on disk
+func firstExistingPath(e history.Entry) string {
+	for _, f := range e.Files {
+		if f.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.Path); err == nil {
+			return f.Path
+		}
+	}
+	return ""
+}
+
+// newHistoryTab builds the searchable transfer history view
+func newHistoryTab(w fyne.Window, store *history.Store) fyne.CanvasObject {
+	if store == nil {
+		return container.NewCenter(widget.NewLabel("Transfer history is not available"))
+	}
+
+	var entries []history.Entry
+	search := widget.NewEntry()
+	search.SetPlaceHolder("Search by file name, device or address")
+
+	list := widget.NewList(
+		func() int { return len(entries) },
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Wrapping = fyne.TextWrapWord
+			openBtn := widget.NewButton("Open", nil)
+			showBtn := widget.NewButton("Show in Folder", nil)
+			return container.NewBorder(nil, nil, nil, container.NewHBox(openBtn, showBtn), label)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			entry := entries[id]
+			row := item.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(describeEntry(entry))
+
+			buttons := row.Objects[1].(*fyne.Container)
+			openBtn := buttons.Objects[0].(*widget.Button)
+			showBtn := buttons.Objects[1].(*widget.Button)
+
+			path := firstExistingPath(entry)
+			if path == "" {
+				openBtn.Disable()
+				showBtn.Disable()
+				return
+			}
+			openBtn.Enable()
+			showBtn.Enable()
+			openBtn.OnTapped = func() {
+				if err := utils.OpenFile(path); err != nil {
+					dialog.ShowError(fmt.Errorf("could not open %s: %v", path, err), w)
+				}
+			}
+			showBtn.OnTapped = func() {
+				if err := utils.ShowInFileManager(path); err != nil {
+					dialog.ShowError(fmt.Errorf("could not show %s: %v", path, err), w)
+				}
+			}
+		},
+	)
+
+	reload := func() {
+		entries = store.Search(search.Text, historyLimit)
+		list.Refresh()
+	}
+	search.OnChanged = func(string) { reload() }
+	reload()
+
+	// New transfers are recorded from server goroutines
+	store.OnAdd = func(history.Entry) {
+		fyne.Do(reload)
+	}
+
+	exportBtn := widget.NewButton("Export CSV…", func() {
+		save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+			if err := history.WriteCSV(writer, store.Search(search.Text, 0)); err != nil {
+				log.Printf("Failed to export history: %v", err)
+				dialog.ShowError(fmt.Errorf("could not export history: %v", err), w)
+			}
+		}, w)
+		save.SetFileName("landrop-history.csv")
+		save.Show()
+	})
+
+	clearBtn := widget.NewButton("Clear History", func() {
+		dialog.ShowConfirm("Clear history?", "The record of past transfers will be deleted. Received files are kept.",
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := store.Clear(); err != nil {
+					dialog.ShowError(fmt.Errorf("could not clear history: %v", err), w)
+				}
+				reload()
+			}, w)
+	})
+
+	return container.NewBorder(
+		search,
+		container.NewGridWithColumns(2, exportBtn, clearBtn),
+		nil, nil,
+		list,
+	)
+}
@@ -0,0 +1,95 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"lan-drop/discovery"
+	"lan-drop/server"
+	"net/url"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// describeInstance renders a LANDrop instance found on the network as two
+// lines of text
+func describeInstance(inst discovery.Instance) string {
+	details := inst.URL()
+	if inst.Version != "" {
+		details += " · " + inst.Version
+	}
+	if inst.Fingerprint != "" {
+		details += " · HTTPS"
+	}
+	return fmt.Sprintf("%s\n%s", inst.Name, details)
+}
+
+// newNearbyTab lists the other LANDrop instances on the local network, to
+// open their page or send them files
+func newNearbyTab(w fyne.Window, a fyne.App, controller *server.ServerController) fyne.CanvasObject {
+	var instances []discovery.Instance
+	statusLabel := widget.NewLabel("")
+	statusLabel.Wrapping = fyne.TextWrapWord
+
+	list := widget.NewList(
+		func() int { return len(instances) },
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Wrapping = fyne.TextWrapWord
+			buttons := container.NewHBox(widget.NewButton("Send…", nil), widget.NewButton("Open", nil))
+			return container.NewBorder(nil, nil, nil, buttons, label)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			inst := instances[id]
+			row := item.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(describeInstance(inst))
+			buttons := row.Objects[1].(*fyne.Container)
+			buttons.Objects[0].(*widget.Button).OnTapped = func() {
+				showSendDialog(w, controller, &inst)
+			}
+			buttons.Objects[1].(*widget.Button).OnTapped = func() {
+				link, err := url.Parse(inst.URL())
+				if err == nil {
+					err = a.OpenURL(link)
+				}
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("could not open %s: %v", inst.URL(), err), w)
+				}
+			}
+		},
+	)
+
+	var refreshBtn *widget.Button
+	refresh := func() {
+		refreshBtn.Disable()
+		statusLabel.SetText("Looking for other LANDrop devices…")
+		go func() {
+			found, err := discovery.Browse(context.Background())
+			fyne.Do(func() {
+				refreshBtn.Enable()
+				if err != nil {
+					statusLabel.SetText(fmt.Sprintf("Could not search the network: %s", err))
+					return
+				}
+				instances = instances[:0]
+				for _, inst := range found {
+					if inst.ID != controller.DiscoveryID() {
+						instances = append(instances, inst)
+					}
+				}
+				list.Refresh()
+				if len(instances) == 0 {
+					statusLabel.SetText("No other LANDrop devices found. They show up here while LANDrop runs on them.")
+				} else {
+					statusLabel.SetText(fmt.Sprintf("Found %d device(s)", len(instances)))
+				}
+			})
+		}()
+	}
+	refreshBtn = widget.NewButton("Refresh", refresh)
+	refresh()
+
+	return container.NewBorder(container.NewVBox(refreshBtn, statusLabel), nil, nil, nil, list)
+}
@@ -3,6 +3,7 @@ package gui
 import (
 	"fmt"
 	"lan-drop/config"
+	"lan-drop/transfer"
 	"lan-drop/utils"
 	"strconv"
 
@@ -247,7 +248,7 @@ func ShowOnboardingWizard(a fyne.App, prefs *config.Preferences, onComplete func
 			prefs.AutoUpdateCheck = tempAutoUpdateCheck
 			prefs.OnboardingCompleted = true
 
-			config.SavePreferences(a, *prefs)
+			config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
 			config.MarkOnboardingCompleted(a)
 
 			wizardWindow.Close()
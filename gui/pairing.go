@@ -0,0 +1,138 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/config"
+	"lan-drop/server"
+	"net/url"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// pairingURL returns the URL to encode in the QR code. When pairing is
+// required it carries the one-time token, so scanning the code pairs the
+// phone without typing the PIN. Over HTTPS it also carries the certificate
+// fingerprint, which the page shows so it can be checked against the one
+// the browser received.
+func pairingURL(baseURL, fingerprint string, prefs *config.Preferences, auth *server.AuthManager) string {
+	query := url.Values{}
+	if prefs.RequirePairing {
+		query.Set("pair", auth.QRToken())
+	}
+	if fingerprint != "" {
+		query.Set("fp", strings.ReplaceAll(fingerprint, ":", ""))
+	}
+	if len(query) == 0 {
+		return baseURL
+	}
+	return baseURL + "/?" + query.Encode()
+}
+
+// formatPIN groups a 6 digit PIN for readability
+func formatPIN(pin string) string {
+	if len(pin) != 6 {
+		return pin
+	}
+	return pin[:3] + " " + pin[3:]
+}
+
+// newPairingSection shows the current pairing PIN with its countdown and the
+// buttons to rotate it or manage paired devices.
+func newPairingSection(w fyne.Window, prefs *config.Preferences, auth *server.AuthManager) *fyne.Container {
+	pinLabel := widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{Bold: true, Monospace: true})
+	expiryLabel := widget.NewLabel("")
+	expiryLabel.Alignment = fyne.TextAlignCenter
+
+	refresh := func() {
+		if !prefs.RequirePairing {
+			pinLabel.SetText("Pairing disabled")
+			expiryLabel.SetText("Anyone on your network can connect")
+			return
+		}
+		pin, expires := auth.PIN()
+		remaining := time.Until(expires).Round(time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		pinLabel.SetText("PIN: " + formatPIN(pin))
+		expiryLabel.SetText(fmt.Sprintf("Expires in %d:%02d", int(remaining.Minutes()), int(remaining.Seconds())%60))
+	}
+	refresh()
+
+	// Keep the countdown ticking; PIN() rotates the PIN once it expires
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			fyne.Do(refresh)
+		}
+	}()
+
+	newPINBtn := widget.NewButton("New PIN", func() {
+		auth.RotatePIN()
+		refresh()
+	})
+
+	devicesBtn := widget.NewButton("Paired Devices…", func() {
+		showPairedDevicesDialog(w, auth)
+	})
+
+	return container.NewVBox(
+		container.NewCenter(pinLabel),
+		container.NewCenter(expiryLabel),
+		container.NewGridWithColumns(2, newPINBtn, devicesBtn),
+	)
+}
+
+// showPairedDevicesDialog lists the active sessions and lets the user revoke them
+func showPairedDevicesDialog(w fyne.Window, auth *server.AuthManager) {
+	var d dialog.Dialog
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.RemoveAll()
+		sessions := auth.Sessions()
+		if len(sessions) == 0 {
+			list.Add(widget.NewLabel("No paired devices"))
+		}
+		for _, s := range sessions {
+			session := s
+			label := widget.NewLabel(fmt.Sprintf("%s\n%s · paired %s · last seen %s",
+				session.Device,
+				session.RemoteAddr,
+				session.CreatedAt.Format("Jan 2 15:04"),
+				session.LastSeen.Format("15:04:05")))
+			label.Wrapping = fyne.TextWrapWord
+			revokeBtn := widget.NewButton("Revoke", func() {
+				auth.Revoke(session.ID)
+				rebuild()
+			})
+			list.Add(container.NewBorder(nil, nil, nil, revokeBtn, label))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	revokeAllBtn := widget.NewButton("Revoke All", func() {
+		dialog.ShowConfirm("Revoke all devices?",
+			"Every paired browser will have to enter a new PIN.",
+			func(ok bool) {
+				if ok {
+					auth.RevokeAll()
+					rebuild()
+				}
+			}, w)
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(420, 250))
+
+	d = dialog.NewCustom("Paired Devices", "Close", container.NewBorder(nil, revokeAllBtn, nil, nil, scroll), w)
+	d.Show()
+}
@@ -0,0 +1,104 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/progress"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// finishedRowDelay is how long a finished file stays in the transfers list
+const finishedRowDelay = 5 * time.Second
+
+// progressRow shows the progress of one file
+type progressRow struct {
+	box      *fyne.Container
+	label    *widget.Label
+	bar      *widget.ProgressBar
+	infinite *widget.ProgressBarInfinite // used while the file's size is unknown
+}
+
+// progressText describes a file's progress for its row
+func progressText(e progress.Event) string {
+	switch {
+	case e.Done && e.Result == progress.Completed:
+		return fmt.Sprintf("%s: received %s", e.Name, formatSize(e.Bytes))
+	case e.Done && e.Result == progress.Skipped:
+		return fmt.Sprintf("%s: kept the existing file", e.Name)
+	case e.Done:
+		return fmt.Sprintf("%s: failed", e.Name)
+	}
+
+	text := fmt.Sprintf("%s: %s", e.Name, formatSize(e.Bytes))
+	if e.Size > 0 {
+		text += " of " + formatSize(e.Size)
+	}
+	if e.Rate > 0 {
+		text += fmt.Sprintf(" · %s/s", formatSize(int64(e.Rate)))
+	}
+	if e.ETA > 0 {
+		text += fmt.Sprintf(" · %s left", time.Duration(e.ETA*float64(time.Second)).Round(time.Second))
+	}
+	return text
+}
+
+// newProgressSection lists the files being received, with a progress bar
+// each, for as long as the window is open
+func newProgressSection() fyne.CanvasObject {
+	idleLabel := widget.NewLabel("No transfers in progress")
+	list := container.NewVBox(idleLabel)
+	rows := make(map[string]*progressRow)
+
+	update := func(e progress.Event) {
+		row := rows[e.ID]
+		if row == nil {
+			if e.Done {
+				return
+			}
+			row = &progressRow{label: widget.NewLabel(""), bar: widget.NewProgressBar(), infinite: widget.NewProgressBarInfinite()}
+			row.label.Truncation = fyne.TextTruncateEllipsis
+			row.box = container.NewVBox(row.label, row.bar, row.infinite)
+			rows[e.ID] = row
+			list.Remove(idleLabel)
+			list.Add(row.box)
+		}
+
+		row.label.SetText(progressText(e))
+		if e.Size > 0 || e.Done {
+			row.infinite.Stop()
+			row.infinite.Hide()
+			row.bar.Show()
+			if e.Done {
+				row.bar.SetValue(1)
+			} else {
+				row.bar.SetValue(float64(e.Bytes) / float64(e.Size))
+			}
+		} else {
+			row.bar.Hide()
+		}
+
+		if e.Done {
+			time.AfterFunc(finishedRowDelay, func() {
+				fyne.Do(func() {
+					list.Remove(row.box)
+					delete(rows, e.ID)
+					if len(rows) == 0 {
+						list.Add(idleLabel)
+					}
+				})
+			})
+		}
+	}
+
+	events, _ := progress.Default.Subscribe()
+	go func() {
+		for e := range events {
+			fyne.Do(func() { update(e) })
+		}
+	}()
+
+	return list
+}
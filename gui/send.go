@@ -0,0 +1,226 @@
+package gui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lan-drop/discovery"
+	"lan-drop/p2p"
+	"lan-drop/server"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	// sendTokensMu guards sendTokens
+	sendTokensMu sync.Mutex
+	// sendTokens keeps the session tokens other devices handed out, keyed by
+	// their URL, so the PIN is only asked for once while the app runs
+	sendTokens = make(map[string]string)
+)
+
+// sendTarget describes a device files are sent to
+func sendTarget(inst discovery.Instance) string {
+	return fmt.Sprintf("%s (%s)", inst.Name, inst.URL())
+}
+
+// describeSendResults summarizes what the receiving device did with the files
+func describeSendResults(results []p2p.SendResult) string {
+	saved, kept := 0, 0
+	var failed []string
+	for _, r := range results {
+		switch {
+		case r.OK && r.Skipped:
+			kept++
+		case r.OK:
+			saved++
+		default:
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Message))
+		}
+	}
+
+	text := fmt.Sprintf("Sent %d file(s).", saved)
+	if kept > 0 {
+		text += fmt.Sprintf(" %d already existed on the device and were kept.", kept)
+	}
+	if len(failed) > 0 {
+		text += fmt.Sprintf("\n%d file(s) were not saved:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return text
+}
+
+// showSendDialog sends files and folders to another LANDrop device, picked
+// from the ones found on the network or entered by address. target
+// preselects a device, e.g. from the Nearby tab.
+func showSendDialog(w fyne.Window, controller *server.ServerController, target *discovery.Instance) {
+	urlEntry := widget.NewEntry()
+	urlEntry.SetPlaceHolder("https://192.168.1.20:8443")
+	fingerprintEntry := widget.NewEntry()
+	fingerprintEntry.SetPlaceHolder("Shown under the other device's QR code")
+	pinEntry := widget.NewEntry()
+	pinEntry.SetPlaceHolder("Only if the device asks for pairing")
+
+	pick := func(inst discovery.Instance) {
+		urlEntry.SetText(inst.URL())
+		fingerprintEntry.SetText(inst.Fingerprint)
+	}
+
+	var nearby []discovery.Instance
+	deviceSelect := widget.NewSelect(nil, func(choice string) {
+		for _, inst := range nearby {
+			if sendTarget(inst) == choice {
+				pick(inst)
+			}
+		}
+	})
+	deviceSelect.PlaceHolder = "Looking for devices…"
+	go func() {
+		found, err := discovery.Browse(context.Background())
+		fyne.Do(func() {
+			var options []string
+			for _, inst := range found {
+				if inst.ID != controller.DiscoveryID() {
+					nearby = append(nearby, inst)
+					options = append(options, sendTarget(inst))
+				}
+			}
+			deviceSelect.SetOptions(options)
+			switch {
+			case err != nil || len(options) == 0:
+				deviceSelect.PlaceHolder = "No devices found, enter an address"
+			default:
+				deviceSelect.PlaceHolder = "Choose a device"
+			}
+			deviceSelect.Refresh()
+		})
+	}()
+	if target != nil {
+		pick(*target)
+	}
+
+	var paths []string
+	filesLabel := widget.NewLabel("No files chosen")
+	filesLabel.Wrapping = fyne.TextWrapWord
+	addPath := func(path string) {
+		paths = append(paths, path)
+		names := make([]string, len(paths))
+		for i, p := range paths {
+			names[i] = filepath.Base(p)
+		}
+		filesLabel.SetText(strings.Join(names, ", "))
+	}
+	addFileBtn := widget.NewButton("Add File…", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			addPath(reader.URI().Path())
+			reader.Close()
+		}, w)
+	})
+	addFolderBtn := widget.NewButton("Add Folder…", func() {
+		dialog.ShowFolderOpen(func(folder fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if folder != nil {
+				addPath(folder.Path())
+			}
+		}, w)
+	})
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Nearby", deviceSelect),
+		widget.NewFormItem("Address", urlEntry),
+		widget.NewFormItem("Fingerprint", fingerprintEntry),
+		widget.NewFormItem("PIN", pinEntry),
+		widget.NewFormItem("Files", container.NewVBox(filesLabel, container.NewGridWithColumns(2, addFileBtn, addFolderBtn))),
+	}
+	d := dialog.NewForm("Send to Device", "Send", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		address := strings.TrimSuffix(strings.TrimSpace(urlEntry.Text), "/")
+		if address == "" || len(paths) == 0 {
+			dialog.ShowError(errors.New("choose a device and at least one file to send"), w)
+			return
+		}
+
+		sendTokensMu.Lock()
+		token := sendTokens[address]
+		sendTokensMu.Unlock()
+		sender := &p2p.Sender{
+			URL:         address,
+			Fingerprint: strings.TrimSpace(fingerprintEntry.Text),
+			Token:       token,
+			PIN:         strings.TrimSpace(pinEntry.Text),
+		}
+		sendFiles(w, sender, paths)
+	}, w)
+	d.Resize(fyne.NewSize(520, 420))
+	d.Show()
+}
+
+// sendFiles sends the files in the background, showing their progress
+func sendFiles(w fyne.Window, sender *p2p.Sender, paths []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	statusLabel := widget.NewLabel("Connecting…")
+	statusLabel.Wrapping = fyne.TextWrapWord
+	bar := widget.NewProgressBar()
+	progressDialog := dialog.NewCustom("Sending", "Cancel", container.NewVBox(statusLabel, bar), w)
+	progressDialog.SetOnClosed(cancel)
+	progressDialog.Resize(fyne.NewSize(420, 150))
+	progressDialog.Show()
+
+	sender.OnProgress = func(p p2p.SendProgress) {
+		fyne.Do(func() {
+			statusLabel.SetText(fmt.Sprintf("Sending %s (%d of %d)", p.Name, p.File+1, p.Files))
+			if p.Size > 0 {
+				bar.SetValue(float64(p.Bytes) / float64(p.Size))
+			} else {
+				bar.SetValue(1)
+			}
+		})
+	}
+
+	go func() {
+		results, err := sender.Send(ctx, paths)
+		if sender.Token != "" {
+			sendTokensMu.Lock()
+			sendTokens[sender.URL] = sender.Token
+			sendTokensMu.Unlock()
+		}
+
+		fyne.Do(func() {
+			canceled := ctx.Err() != nil
+			progressDialog.SetOnClosed(nil)
+			progressDialog.Hide()
+			cancel()
+
+			switch {
+			case canceled:
+				return
+			case errors.Is(err, p2p.ErrPairingRequired):
+				dialog.ShowError(errors.New("the device requires pairing: enter the PIN it shows and send again"), w)
+			case errors.Is(err, p2p.ErrFingerprintMismatch):
+				dialog.ShowError(errors.New("the device's certificate doesn't match the fingerprint, check it on the other device"), w)
+			case err != nil:
+				dialog.ShowError(fmt.Errorf("could not send the files: %v", err), w)
+			default:
+				dialog.ShowInformation("Files Sent", describeSendResults(results), w)
+			}
+		})
+	}()
+}
@@ -3,6 +3,7 @@ package gui
 import (
 	"fmt"
 	"lan-drop/config"
+	"lan-drop/transfer"
 	"strconv"
 
 	"fyne.io/fyne/v2"
@@ -17,6 +18,57 @@ func showSettingsWindow(a fyne.App, prefs *config.Preferences, onSave func(port
 	portEntry := widget.NewEntry()
 	portEntry.SetText(strconv.Itoa(prefs.Port))
 
+	// HTTPS settings take effect when the server restarts on Save
+	redirectHTTPCheckbox := widget.NewCheck("Redirect plain HTTP to HTTPS", func(checked bool) {
+		prefs.RedirectHTTP = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	redirectHTTPCheckbox.SetChecked(prefs.RedirectHTTP)
+
+	enableHTTPSCheckbox := widget.NewCheck("Use HTTPS (self-signed certificate)", func(checked bool) {
+		prefs.EnableHTTPS = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+		if checked {
+			redirectHTTPCheckbox.Enable()
+		} else {
+			redirectHTTPCheckbox.Disable()
+		}
+	})
+	enableHTTPSCheckbox.SetChecked(prefs.EnableHTTPS)
+	if !prefs.EnableHTTPS {
+		redirectHTTPCheckbox.Disable()
+	}
+
+	// Like HTTPS, discovery changes take effect when the server restarts on Save
+	enableDiscoveryCheckbox := widget.NewCheck("Let other LANDrop devices on the network find this computer", func(checked bool) {
+		prefs.EnableDiscovery = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	enableDiscoveryCheckbox.SetChecked(prefs.EnableDiscovery)
+
+	// Consent timeout is applied on Save like the port
+	consentTimeoutEntry := widget.NewEntry()
+	consentTimeoutEntry.SetText(strconv.Itoa(prefs.ConsentTimeout))
+
+	// Rename pattern is applied on Save like the port
+	renamePatternEntry := widget.NewEntry()
+	renamePatternEntry.SetText(prefs.RenamePattern)
+	renamePatternEntry.SetPlaceHolder(transfer.DefaultRenamePattern)
+
+	conflictOptions := make([]string, 0, len(transfer.ConflictPolicies))
+	for _, policy := range transfer.ConflictPolicies {
+		conflictOptions = append(conflictOptions, policy.Label())
+	}
+	conflictSelect := widget.NewSelect(conflictOptions, func(selected string) {
+		for _, policy := range transfer.ConflictPolicies {
+			if policy.Label() == selected {
+				prefs.ConflictPolicy = string(policy)
+			}
+		}
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	conflictSelect.SetSelected(transfer.ConflictPolicy(prefs.ConflictPolicy).Label())
+
 	folderLabel := widget.NewLabel(prefs.UploadDir)
 	selectFolderBtn := widget.NewButton("Choose Upload Folder", func() {
 		dialog.ShowFolderOpen(func(u fyne.ListableURI, err error) {
@@ -38,11 +90,25 @@ func showSettingsWindow(a fyne.App, prefs *config.Preferences, onSave func(port
 
 	saveBtn := widget.NewButton("Save", func() {
 		port, err := strconv.Atoi(portEntry.Text)
+		timeout, timeoutErr := strconv.Atoi(consentTimeoutEntry.Text)
+		if timeoutErr != nil || timeout <= 0 {
+			dialog.ShowError(fmt.Errorf("invalid approval timeout"), w)
+			return
+		}
+		if renamePatternEntry.Text == "" {
+			renamePatternEntry.SetText(transfer.DefaultRenamePattern)
+		}
+		if err := transfer.ValidateRenamePattern(renamePatternEntry.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("invalid rename pattern: %v", err), w)
+			return
+		}
 		if err == nil && port > 0 && port < 65536 {
+			prefs.ConsentTimeout = timeout
+			prefs.RenamePattern = renamePatternEntry.Text
 			prefs.Port = port
 			prefs.UploadDir = folderLabel.Text
 			prefs.SharedDir = sharedFolderLabel.Text
-			config.SavePreferences(a, *prefs)
+			config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
 			onSave(prefs.Port, folderLabel.Text)
 			w.Close()
 		} else {
@@ -52,26 +118,26 @@ func showSettingsWindow(a fyne.App, prefs *config.Preferences, onSave func(port
 
 	showNotifCheckbox := widget.NewCheck("Show upload notifications", func(checked bool) {
 		prefs.ShowNotifications = checked
-		config.SavePreferences(a, *prefs) // persist change
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
 	})
 	showNotifCheckbox.SetChecked(prefs.ShowNotifications)
 
 	autoUpdateCheckbox := widget.NewCheck("Check for updates automatically", func(checked bool) {
 		prefs.AutoUpdateCheck = checked
-		config.SavePreferences(a, *prefs) // persist change
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
 	})
 	autoUpdateCheckbox.SetChecked(prefs.AutoUpdateCheck)
 
 	autoOpenCheckbox := widget.NewCheck("Automatically open uploaded files", func(checked bool) {
 		prefs.AutoOpenFiles = checked
-		config.SavePreferences(a, *prefs) // persist change
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
 	})
 	autoOpenCheckbox.SetChecked(prefs.AutoOpenFiles)
 
 	// Enable downloads checkbox
 	enableDownloadsCheckbox := widget.NewCheck("Enable bidirectional transfers (downloads)", func(checked bool) {
 		prefs.EnableDownloads = checked
-		config.SavePreferences(a, *prefs) // persist change
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
 		// Enable/disable shared folder selection based on this setting
 		if checked {
 			selectSharedFolderBtn.Enable()
@@ -86,6 +152,83 @@ func showSettingsWindow(a fyne.App, prefs *config.Preferences, onSave func(port
 		selectSharedFolderBtn.Disable()
 	}
 
+	followSymlinksCheckbox := widget.NewCheck("Follow links that point outside the shared folder", func(checked bool) {
+		prefs.FollowSymlinks = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	followSymlinksCheckbox.SetChecked(prefs.FollowSymlinks)
+
+	webDAVUploadsCheckbox := widget.NewCheck("Let WebDAV clients read and write the upload folder", func(checked bool) {
+		prefs.WebDAVUploads = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	webDAVUploadsCheckbox.SetChecked(prefs.WebDAVUploads)
+
+	enableWebDAVCheckbox := widget.NewCheck("Serve the shared folder over WebDAV (/dav/)", func(checked bool) {
+		prefs.EnableWebDAV = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+		if checked {
+			webDAVUploadsCheckbox.Enable()
+		} else {
+			webDAVUploadsCheckbox.Disable()
+		}
+	})
+	enableWebDAVCheckbox.SetChecked(prefs.EnableWebDAV)
+	if !prefs.EnableWebDAV {
+		webDAVUploadsCheckbox.Disable()
+	}
+
+	manageSharesBtn := widget.NewButton("Manage Shares", func() {
+		showSharesDialog(w, a, prefs)
+	})
+
+	manageAPIKeysBtn := widget.NewButton("Manage API Keys", func() {
+		showAPIKeysDialog(w, a, prefs)
+	})
+
+	writeSidecarCheckbox := widget.NewCheck("Save sender details in a .landrop.json file next to received files", func(checked bool) {
+		prefs.WriteSidecar = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	writeSidecarCheckbox.SetChecked(prefs.WriteSidecar)
+
+	openReceivedURLsCheckbox := widget.NewCheck("Open links received as text in the default browser", func(checked bool) {
+		prefs.OpenReceivedURLs = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	openReceivedURLsCheckbox.SetChecked(prefs.OpenReceivedURLs)
+
+	requirePairingCheckbox := widget.NewCheck("Require pairing PIN for new devices", func(checked bool) {
+		prefs.RequirePairing = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+	})
+	requirePairingCheckbox.SetChecked(prefs.RequirePairing)
+
+	askBeforeReceivingCheckbox := widget.NewCheck("Ask before receiving files", func(checked bool) {
+		prefs.AskBeforeReceiving = checked
+		config.SavePreferences(a, transfer.PreferencesSnapshot(prefs)) // persist change
+		if checked {
+			consentTimeoutEntry.Enable()
+		} else {
+			consentTimeoutEntry.Disable()
+		}
+	})
+	askBeforeReceivingCheckbox.SetChecked(prefs.AskBeforeReceiving)
+	if !prefs.AskBeforeReceiving {
+		consentTimeoutEntry.Disable()
+	}
+
+	forgetTrustedBtn := widget.NewButton("Forget Trusted Devices", func() {
+		dialog.ShowConfirm("Forget trusted devices?",
+			"Every device will be asked for approval again.",
+			func(ok bool) {
+				if ok {
+					transfer.ForgetTrustedDevices(prefs)
+					config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+				}
+			}, w)
+	})
+
 	// Restart onboarding button
 	restartOnboardingBtn := widget.NewButton("Restart Setup Wizard", func() {
 		dialog.ShowConfirm("Restart Setup Wizard?",
@@ -108,21 +251,44 @@ func showSettingsWindow(a fyne.App, prefs *config.Preferences, onSave func(port
 		widget.NewLabelWithStyle("Server Configuration", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		widget.NewLabel("HTTP Port:"),
 		portEntry,
+		enableDiscoveryCheckbox,
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Upload Settings", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		widget.NewLabel("Upload Folder (where files are saved):"),
 		folderLabel,
 		selectFolderBtn,
+		widget.NewLabel("When a file with the same name exists:"),
+		conflictSelect,
+		widget.NewLabel("Name for kept copies ({name}, {n} and {ext}):"),
+		renamePatternEntry,
+		writeSidecarCheckbox,
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Download Settings", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		enableDownloadsCheckbox,
 		widget.NewLabel("Shared Folder (files available for download):"),
 		sharedFolderLabel,
 		selectSharedFolderBtn,
+		followSymlinksCheckbox,
+		widget.NewLabel("Named shares (extra folders with their own permissions):"),
+		manageSharesBtn,
+		enableWebDAVCheckbox,
+		webDAVUploadsCheckbox,
+		widget.NewSeparator(),
+		widget.NewLabelWithStyle("Security", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		requirePairingCheckbox,
+		enableHTTPSCheckbox,
+		redirectHTTPCheckbox,
+		askBeforeReceivingCheckbox,
+		widget.NewLabel("Approval timeout (seconds):"),
+		consentTimeoutEntry,
+		forgetTrustedBtn,
+		widget.NewLabel("API keys (let scripts upload without pairing):"),
+		manageAPIKeysBtn,
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Notifications", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		showNotifCheckbox,
 		autoOpenCheckbox,
+		openReceivedURLsCheckbox,
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Updates", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		autoUpdateCheckbox,
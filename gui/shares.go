@@ -0,0 +1,132 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/config"
+	"lan-drop/transfer"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showSharesDialog lists the named shares and lets the user add, edit and
+// remove them. Changes are saved right away.
+func showSharesDialog(w fyne.Window, a fyne.App, prefs *config.Preferences) {
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.RemoveAll()
+		if len(prefs.Shares) == 0 {
+			list.Add(widget.NewLabel("No shares yet. Devices only see the shared and upload folders."))
+		}
+		for i, s := range prefs.Shares {
+			index, share := i, s
+			label := widget.NewLabel(fmt.Sprintf("%s\n%s · %s", share.Name, share.Dir, share.Access.Label()))
+			label.Wrapping = fyne.TextWrapWord
+			editBtn := widget.NewButton("Edit", func() {
+				showShareForm(w, share, func(edited config.Share) error {
+					others := append(append([]config.Share{}, prefs.Shares[:index]...), prefs.Shares[index+1:]...)
+					if err := config.ValidateShare(edited, others); err != nil {
+						return err
+					}
+					prefs.Shares[index] = edited
+					config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+					rebuild()
+					return nil
+				})
+			})
+			removeBtn := widget.NewButton("Remove", func() {
+				dialog.ShowConfirm("Remove share?",
+					fmt.Sprintf("Devices will no longer see %q. The folder and its files are kept.", share.Name),
+					func(ok bool) {
+						if ok {
+							prefs.Shares = append(prefs.Shares[:index:index], prefs.Shares[index+1:]...)
+							config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+							rebuild()
+						}
+					}, w)
+			})
+			list.Add(container.NewBorder(nil, nil, nil, container.NewHBox(editBtn, removeBtn), label))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	addBtn := widget.NewButton("Add Share", func() {
+		showShareForm(w, config.Share{Access: config.ShareReadOnly}, func(share config.Share) error {
+			if err := config.ValidateShare(share, prefs.Shares); err != nil {
+				return err
+			}
+			prefs.Shares = append(prefs.Shares, share)
+			config.SavePreferences(a, transfer.PreferencesSnapshot(prefs))
+			rebuild()
+			return nil
+		})
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(460, 250))
+
+	dialog.NewCustom("Shares", "Close", container.NewBorder(nil, addBtn, nil, nil, scroll), w).Show()
+}
+
+// showShareForm asks for a share's name, folder and access. onSubmit returns
+// an error to keep the form open.
+func showShareForm(w fyne.Window, share config.Share, onSubmit func(config.Share) error) {
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(share.Name)
+	nameEntry.SetPlaceHolder("Design assets")
+
+	folderLabel := widget.NewLabel(share.Dir)
+	if share.Dir == "" {
+		folderLabel.SetText("No folder chosen")
+	}
+	dir := share.Dir
+	chooseBtn := widget.NewButton("Choose Folder", func() {
+		dialog.ShowFolderOpen(func(u fyne.ListableURI, err error) {
+			if u != nil {
+				dir = u.Path()
+				folderLabel.SetText(dir)
+			}
+		}, w)
+	})
+
+	accessOptions := make([]string, 0, len(config.ShareAccessLevels))
+	for _, access := range config.ShareAccessLevels {
+		accessOptions = append(accessOptions, access.Label())
+	}
+	accessSelect := widget.NewSelect(accessOptions, nil)
+	accessSelect.SetSelected(share.Access.Label())
+
+	title := "Edit Share"
+	if share.Name == "" {
+		title = "Add Share"
+	}
+
+	var form dialog.Dialog
+	form = dialog.NewForm(title, "Save", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Folder", container.NewBorder(nil, nil, nil, chooseBtn, folderLabel)),
+		widget.NewFormItem("Devices can", accessSelect),
+	}, func(ok bool) {
+		if !ok {
+			return
+		}
+		edited := config.Share{Name: nameEntry.Text, Dir: dir}
+		for _, access := range config.ShareAccessLevels {
+			if access.Label() == accessSelect.Selected {
+				edited.Access = access
+			}
+		}
+		if err := onSubmit(edited); err != nil {
+			// The form closes on Save, so show it again with what was typed
+			dialog.ShowError(err, w)
+			showShareForm(w, edited, onSubmit)
+		}
+	}, w)
+	form.Resize(fyne.NewSize(460, 240))
+	form.Show()
+}
@@ -0,0 +1,89 @@
+package gui
+
+import (
+	"fmt"
+	"lan-drop/config"
+	"lan-drop/p2p"
+	"lan-drop/transfer"
+	"lan-drop/utils"
+	"log"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// maxPreviewLength limits how much of a received text the notification shows
+const maxPreviewLength = 80
+
+// textPreview shortens text to a single line for notifications and labels
+func textPreview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxPreviewLength {
+		return string(runes[:maxPreviewLength-1]) + "…"
+	}
+	return text
+}
+
+// newTextReceiver returns a transfer.TextReceiver that copies received text
+// to the clipboard and, when enabled, opens received links
+func newTextReceiver(a fyne.App, prefs *config.Preferences) transfer.TextReceiver {
+	return func(msg transfer.TextMessage) {
+		fyne.Do(func() {
+			a.Clipboard().SetContent(msg.Text)
+		})
+
+		device := msg.DeviceName
+		if device == "" {
+			device = msg.RemoteAddr
+		}
+		if prefs.ShowNotifications {
+			utils.SendNotificationWithAction(a, utils.NotificationConfig{
+				Title:   "LAN-Drop",
+				Content: fmt.Sprintf("Copied text from %s: %s", device, textPreview(msg.Text)),
+			})
+		}
+
+		if link, ok := transfer.WebURL(msg.Text); ok && prefs.OpenReceivedURLs {
+			if err := utils.OpenFile(link); err != nil {
+				log.Printf("Failed to open received link: %v", err)
+			}
+		}
+	}
+}
+
+// newTextSection lets the user send typed text or the clipboard to the
+// connected browsers
+func newTextSection(a fyne.App) fyne.CanvasObject {
+	textEntry := widget.NewMultiLineEntry()
+	textEntry.SetPlaceHolder("Text or link to send to connected devices")
+	textEntry.SetMinRowsVisible(3)
+	resultLabel := widget.NewLabel("")
+	resultLabel.Wrapping = fyne.TextWrapWord
+
+	send := func(text string) {
+		reached, err := p2p.SendText(text)
+		switch {
+		case err != nil:
+			resultLabel.SetText(fmt.Sprintf("Not sent: %s", err))
+		case reached == 0:
+			resultLabel.SetText("No device is connected. Devices will find it when they open the page.")
+		default:
+			resultLabel.SetText(fmt.Sprintf("Sent to %d device(s): %s", reached, textPreview(text)))
+		}
+	}
+
+	sendBtn := widget.NewButton("Send Text", func() {
+		send(textEntry.Text)
+	})
+	sendClipboardBtn := widget.NewButton("Send Clipboard", func() {
+		send(a.Clipboard().Content())
+	})
+
+	return container.NewVBox(
+		textEntry,
+		container.NewGridWithColumns(2, sendBtn, sendClipboardBtn),
+		resultLabel,
+	)
+}
@@ -0,0 +1,261 @@
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction tells whether a transfer came into or went out of this computer
+type Direction string
+
+const (
+	Received Direction = "received"
+	Sent     Direction = "sent"
+)
+
+// Result is the outcome of a transfer
+type Result string
+
+const (
+	Completed Result = "completed"
+	Failed    Result = "failed"
+	Declined  Result = "declined"
+)
+
+// File is one file of a transfer
+type File struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"` // where the file lives on this computer
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Entry records a single transfer
+type Entry struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Direction  Direction `json:"direction"`
+	Method     string    `json:"method"` // upload, tus, api, webrtc, webdav, download, archive
+	PeerAddr   string    `json:"peer_addr"`
+	Device     string    `json:"device,omitempty"`
+	Files      []File    `json:"files"`
+	DurationMS int64     `json:"duration_ms"`
+	Result     Result    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// TotalSize returns the combined size of the entry's files
+func (e Entry) TotalSize() int64 {
+	var total int64
+	for _, f := range e.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Duration returns how long the transfer took
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+// matches reports whether the entry contains text in a file name, the
+// device name or the peer address. The comparison ignores case.
+func (e Entry) matches(text string) bool {
+	if text == "" {
+		return true
+	}
+	text = strings.ToLower(text)
+	if strings.Contains(strings.ToLower(e.Device), text) || strings.Contains(e.PeerAddr, text) {
+		return true
+	}
+	for _, f := range e.Files {
+		if strings.Contains(strings.ToLower(f.Name), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the transfer history in memory and appends every new entry to
+// a JSON Lines file, so the record survives restarts.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+
+	// OnAdd is called after an entry has been recorded, e.g. to refresh the GUI
+	OnAdd func(Entry)
+}
+
+// DefaultPath returns the history file under the user's config directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "landrop", "history.jsonl")
+}
+
+// Open loads the history stored at path. A missing file is an empty history.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip lines torn by a crash instead of losing the whole history
+			log.Printf("Skipping corrupt history entry: %v", err)
+			continue
+		}
+		s.entries = append(s.entries, entry)
+	}
+	return s, scanner.Err()
+}
+
+// Add records an entry, filling in its ID and time if they are missing
+func (s *Store) Add(entry Entry) error {
+	if entry.ID == "" {
+		entry.ID = newID()
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err = s.appendLocked(data)
+	s.entries = append(s.entries, entry)
+	onAdd := s.OnAdd
+	s.mu.Unlock()
+
+	if onAdd != nil {
+		onAdd(entry)
+	}
+	return err
+}
+
+func (s *Store) appendLocked(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Search returns the entries matching text, newest first. An empty text
+// matches everything; limit <= 0 means no limit.
+func (s *Store) Search(text string, limit int) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Entry
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if !s.entries[i].matches(text) {
+			continue
+		}
+		result = append(result, s.entries[i])
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+// Clear deletes the whole history
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// WriteCSV writes entries as CSV with one row per file
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "direction", "method", "peer_addr", "device", "file", "path", "size", "sha256", "duration_ms", "result", "error"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		files := e.Files
+		if len(files) == 0 {
+			files = []File{{}}
+		}
+		for _, f := range files {
+			row := []string{
+				e.Time.Format(time.RFC3339),
+				string(e.Direction),
+				e.Method,
+				e.PeerAddr,
+				csvSafe(e.Device),
+				csvSafe(f.Name),
+				csvSafe(f.Path),
+				strconv.FormatInt(f.Size, 10),
+				f.SHA256,
+				strconv.FormatInt(e.DurationMS, 10),
+				string(e.Result),
+				csvSafe(e.Error),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvSafe stops spreadsheet applications from evaluating a cell that comes
+// from the remote device, such as a file name, as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorePersistsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	var added []Entry
+	store.OnAdd = func(e Entry) { added = append(added, e) }
+
+	entry := Entry{
+		Direction:  Received,
+		Method:     "upload",
+		PeerAddr:   "192.168.1.10",
+		Device:     "Android (Chrome)",
+		Files:      []File{{Name: "photo.jpg", Size: 1234, SHA256: "abc"}},
+		DurationMS: 1500,
+		Result:     Completed,
+	}
+	if err := store.Add(entry); err != nil {
+		t.Fatalf("Failed to add entry: %v", err)
+	}
+	if len(added) != 1 || added[0].ID == "" || added[0].Time.IsZero() {
+		t.Fatalf("Expected OnAdd with an ID and time, got %+v", added)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	entries := reopened.Search("", 0)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry after reopening, got %d", len(entries))
+	}
+	got := entries[0]
+	if got.Device != entry.Device || got.Files[0].SHA256 != "abc" || got.Duration() != 1500*time.Millisecond {
+		t.Errorf("Entry did not round-trip: %+v", got)
+	}
+}
+
+func TestStoreSkipsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	content := `{"id":"1","direction":"sent","files":[{"name":"a.txt"}]}
+{"id":"2","direc
+{"id":"3","direction":"received","files":[{"name":"b.txt"}]}
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if entries := store.Search("", 0); len(entries) != 2 {
+		t.Errorf("Expected 2 valid entries, got %d", len(entries))
+	}
+}
+
+func TestStoreSearch(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(Entry{Device: "iPhone", PeerAddr: "10.0.0.2", Files: []File{{Name: "Holiday.png"}}})
+	store.Add(Entry{Device: "Android", PeerAddr: "10.0.0.3", Files: []File{{Name: "report.pdf"}}})
+	store.Add(Entry{Device: "iPhone", PeerAddr: "10.0.0.2", Files: []File{{Name: "notes.txt"}}})
+
+	if got := store.Search("holiday", 0); len(got) != 1 || got[0].Files[0].Name != "Holiday.png" {
+		t.Errorf("File name search failed: %+v", got)
+	}
+	if got := store.Search("10.0.0.3", 0); len(got) != 1 {
+		t.Errorf("Address search failed: %+v", got)
+	}
+
+	// Newest first, limited
+	got := store.Search("iphone", 1)
+	if len(got) != 1 || got[0].Files[0].Name != "notes.txt" {
+		t.Errorf("Expected the newest iPhone entry, got %+v", got)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Failed to clear: %v", err)
+	}
+	if got := store.Search("", 0); len(got) != 0 {
+		t.Errorf("Expected empty history after Clear, got %d entries", len(got))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	entries := []Entry{
+		{
+			Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Direction: Received,
+			Method:    "upload",
+			PeerAddr:  "10.0.0.2",
+			Files:     []File{{Name: "a.txt", Size: 1}, {Name: "=HYPERLINK()", Size: 2}},
+			Result:    Completed,
+		},
+		{Direction: Received, Method: "upload", Result: Declined},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	// Header, one row per file, and one row for the entry without files
+	if len(rows) != 4 {
+		t.Fatalf("Expected 4 rows, got %d", len(rows))
+	}
+	if rows[1][0] != "2024-05-01T12:00:00Z" || rows[1][5] != "a.txt" {
+		t.Errorf("Unexpected first row: %v", rows[1])
+	}
+	if rows[2][5] != "'=HYPERLINK()" {
+		t.Errorf("Expected formula to be escaped, got %q", rows[2][5])
+	}
+	if rows[3][10] != "declined" {
+		t.Errorf("Unexpected result column: %v", rows[3])
+	}
+}
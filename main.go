@@ -4,7 +4,9 @@ import (
 	"embed"
 	"lan-drop/config"
 	"lan-drop/gui"
+	"lan-drop/history"
 	"lan-drop/server"
+	"log"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -44,6 +46,15 @@ func main() {
 	config.EnsureUploadDir(prefs)
 	config.EnsureSharedDir(prefs)
 	controller := server.NewServerController(prefs.Port, prefs.UploadDir, &prefs, embeddedFiles, version)
+
+	// Transfers are still possible without a history, so only log failures
+	historyStore, err := history.Open(history.DefaultPath())
+	if err != nil {
+		log.Printf("Failed to open transfer history: %v", err)
+	} else {
+		controller.History = historyStore
+	}
+
 	controller.Start()
 	gui.Start(a, &prefs, controller, version)
 }
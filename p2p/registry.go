@@ -0,0 +1,79 @@
+package p2p
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"lan-drop/transfer"
+)
+
+// SentText is a text the desktop sent to the connected browsers
+type SentText struct {
+	ID   int64     `json:"id"`
+	Text string    `json:"text"`
+	From string    `json:"from"`
+	Time time.Time `json:"time"`
+}
+
+var (
+	sessionsMu sync.Mutex // guards sessions and lastText
+	sessions   = make(map[*Session]struct{})
+	lastText   SentText
+)
+
+// register adds a session to the ones SendText reaches
+func register(s *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions[s] = struct{}{}
+}
+
+// unregister removes a session added with register
+func unregister(s *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions, s)
+}
+
+// SendText pushes text to every connected browser and returns how many were
+// reached. The text is also kept for LastText, so browsers that aren't
+// connected can fetch it later.
+func SendText(text string) (int, error) {
+	if err := transfer.CheckText(text); err != nil {
+		return 0, err
+	}
+	from, _ := os.Hostname()
+
+	sessionsMu.Lock()
+	lastText = SentText{ID: lastText.ID + 1, Text: text, From: from, Time: time.Now()}
+	msg := lastText
+	connected := make([]*Session, 0, len(sessions))
+	for s := range sessions {
+		connected = append(connected, s)
+	}
+	sessionsMu.Unlock()
+
+	reached := 0
+	for _, s := range connected {
+		s.mu.Lock()
+		if !s.closed && len(s.dataChannels) > 0 {
+			s.sendJSONLocked(map[string]interface{}{
+				"type": "text",
+				"id":   msg.ID,
+				"text": msg.Text,
+				"from": msg.From,
+			})
+			reached++
+		}
+		s.mu.Unlock()
+	}
+	return reached, nil
+}
+
+// LastText returns the last text sent with SendText, if any
+func LastText() (SentText, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return lastText, lastText.ID != 0
+}
@@ -0,0 +1,33 @@
+package p2p
+
+import (
+	"testing"
+
+	"lan-drop/config"
+)
+
+func TestSendText(t *testing.T) {
+	session := NewSession(nil, &config.Preferences{})
+	register(session)
+	defer unregister(session)
+	defer session.Close()
+
+	if _, err := SendText(" "); err == nil {
+		t.Error("Expected empty text to be refused")
+	}
+
+	before, _ := LastText()
+	// The session has no data channel yet, so nobody is reached
+	reached, err := SendText("123 456")
+	if err != nil {
+		t.Fatalf("SendText failed: %v", err)
+	}
+	if reached != 0 {
+		t.Errorf("Expected no browser to be reached, got %d", reached)
+	}
+
+	last, ok := LastText()
+	if !ok || last.Text != "123 456" || last.ID != before.ID+1 {
+		t.Errorf("Expected the text to be kept with the next ID, got %+v", last)
+	}
+}
@@ -0,0 +1,671 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/history"
+	"lan-drop/transfer"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// chunkSize is the size of the binary messages a file is sent in, the
+	// same the browser page uses
+	chunkSize = 16 << 10
+	// maxBufferedAmount is how much data may wait in the data channel before
+	// sending pauses
+	maxBufferedAmount = 1 << 20
+)
+
+var (
+	// ErrPairingRequired is returned when the receiving device only accepts
+	// paired clients and no valid PIN or token was given
+	ErrPairingRequired = errors.New("the device requires pairing, enter the PIN it shows")
+	// ErrDeclined is returned when the receiving desktop turns the transfer down
+	ErrDeclined = errors.New("the transfer was declined")
+	// ErrFingerprintMismatch is returned when the receiving device's
+	// certificate isn't the one the sender expected
+	ErrFingerprintMismatch = errors.New("the device's certificate doesn't match its fingerprint")
+)
+
+// Sender pushes files to another LANDrop instance the way the browser page
+// does: it offers a WebRTC connection over the instance's signaling socket
+// and sends the files over a data channel.
+type Sender struct {
+	// URL is the address of the receiving instance, e.g. https://192.168.1.20:8443
+	URL string
+	// Fingerprint is the SHA-256 fingerprint of the receiver's TLS
+	// certificate. When set, it is trusted instead of the system roots, which
+	// is how self-signed certificates are checked.
+	Fingerprint string
+	// Token is a session token from an earlier pairing, if any. Send sets it
+	// once the PIN has been exchanged, so later sends can reuse it.
+	Token string
+	// PIN is the pairing PIN shown on the receiving desktop, used when no
+	// token is set
+	PIN string
+	// DeviceName names the sender on the receiving desktop. It defaults to
+	// the host name.
+	DeviceName string
+	// Share is the writable share on the receiver to save into, the upload
+	// folder if empty
+	Share string
+	// OnProgress, if set, is called as the files are sent
+	OnProgress func(SendProgress)
+}
+
+// SendProgress reports how far a Send has come
+type SendProgress struct {
+	Name  string // relative path of the file being sent
+	File  int    // index of that file
+	Files int
+	Bytes int64 // bytes of the file sent so far
+	Size  int64
+}
+
+// SendResult is what the receiver did with one file
+type SendResult struct {
+	Name    string // relative path the file was sent under
+	Size    int64
+	SHA256  string
+	OK      bool
+	Skipped bool   // the receiver kept an identical file it already had
+	Message string // why the file wasn't saved
+}
+
+// outgoingFile is a local file and the name it is sent under
+type outgoingFile struct {
+	path    string
+	name    string // relative path with forward slashes
+	size    int64
+	modTime time.Time
+}
+
+// controlMessage is a JSON message the receiver sends on the data channel
+type controlMessage struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Skipped  bool   `json:"skipped"`
+	SHA256   string `json:"sha256"`
+	Message  string `json:"message"`
+	Accepted bool   `json:"accepted"`
+}
+
+// Send sends the files and folders at paths to the receiver. Folders are sent
+// with everything in them and recreated on the other side. It returns the
+// outcome of every file; files the receiver refused don't make it fail.
+func (s *Sender) Send(ctx context.Context, paths []string) ([]SendResult, error) {
+	files, err := collectFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("nothing to send")
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(s.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid device address %q", s.URL)
+	}
+
+	start := time.Now()
+	results, err := s.send(ctx, base, files)
+	s.recordHistory(base, files, results, err, start)
+	return results, err
+}
+
+func (s *Sender) send(ctx context.Context, base *url.URL, files []outgoingFile) ([]SendResult, error) {
+	tlsConfig := s.tlsConfig()
+	if s.Token == "" && s.PIN != "" {
+		token, err := s.pair(ctx, base, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.Token = token
+	}
+
+	ws, err := s.dialSignaling(ctx, base, tlsConfig, s.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+
+	conn, err := offer(ctx, ws)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	// The receiver asks its user first if it's set up to
+	summaries := make([]transfer.FileSummary, len(files))
+	for i, f := range files {
+		summaries[i] = transfer.FileSummary{Name: f.name, Size: f.size}
+	}
+	if err := conn.sendJSON(map[string]interface{}{
+		"type":        "transfer_request",
+		"device_name": s.deviceName(),
+		"files":       summaries,
+	}); err != nil {
+		return nil, err
+	}
+	response, err := conn.await(ctx, "transfer_response")
+	if err != nil {
+		return nil, err
+	}
+	if !response.Accepted {
+		if response.Message != "" {
+			return nil, fmt.Errorf("%w: %s", ErrDeclined, response.Message)
+		}
+		return nil, ErrDeclined
+	}
+
+	sessionID := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := conn.sendJSON(map[string]interface{}{
+		"type":        "session_start",
+		"session_id":  sessionID,
+		"total_files": len(files),
+	}); err != nil {
+		return nil, err
+	}
+
+	results := make([]SendResult, 0, len(files))
+	for i, f := range files {
+		result, err := s.sendFile(ctx, conn, f, i, len(files), sessionID)
+		if err != nil {
+			return results, fmt.Errorf("sending %s: %w", f.name, err)
+		}
+		results = append(results, result)
+	}
+
+	if err := conn.sendJSON(map[string]string{"type": "session_end"}); err != nil {
+		return results, err
+	}
+	conn.flush(ctx)
+	return results, nil
+}
+
+// sendFile sends the metadata and chunks of one file and waits for the
+// receiver to save it
+func (s *Sender) sendFile(ctx context.Context, conn *offerConn, f outgoingFile, index, total int, sessionID string) (SendResult, error) {
+	result := SendResult{Name: f.name, Size: f.size}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	// The receiver verifies the file against a digest announced up front
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return result, err
+	}
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return result, err
+	}
+
+	metadata := map[string]interface{}{
+		"name":         path.Base(f.name),
+		"size":         f.size,
+		"sha256":       result.SHA256,
+		"lastModified": f.modTime.UnixMilli(),
+		"sessionId":    sessionID,
+	}
+	if f.name != path.Base(f.name) {
+		metadata["relativePath"] = f.name
+	}
+	if mimeType := mime.TypeByExtension(filepath.Ext(f.path)); mimeType != "" {
+		metadata["mimeType"] = mimeType
+	}
+	if s.Share != "" {
+		metadata["share"] = s.Share
+	}
+	if err := conn.sendJSON(metadata); err != nil {
+		return result, err
+	}
+
+	report := func(sent int64) {
+		if s.OnProgress != nil {
+			s.OnProgress(SendProgress{Name: f.name, File: index, Files: total, Bytes: sent, Size: f.size})
+		}
+	}
+	report(0)
+
+	buf := make([]byte, chunkSize)
+	var sent int64
+	for sent < f.size {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err := conn.sendChunk(ctx, buf[:n]); err != nil {
+				return result, err
+			}
+			sent += int64(n)
+			report(sent)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	if sent != f.size {
+		return result, errors.New("the file changed while it was being sent")
+	}
+
+	reply, err := conn.await(ctx, "file_result", "file_rejected")
+	if err != nil {
+		return result, err
+	}
+	result.OK = reply.Type == "file_result" && reply.OK
+	result.Skipped = reply.Skipped
+	result.Message = reply.Message
+	return result, nil
+}
+
+// deviceName is how the sender introduces itself
+func (s *Sender) deviceName() string {
+	if s.DeviceName != "" {
+		return s.DeviceName
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// tlsConfig pins the receiver's certificate when a fingerprint is known
+func (s *Sender) tlsConfig() *tls.Config {
+	if s.Fingerprint == "" {
+		return nil
+	}
+	expected := normalizeFingerprint(s.Fingerprint)
+	return &tls.Config{
+		// The certificate is checked against the fingerprint instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrFingerprintMismatch
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if hex.EncodeToString(sum[:]) != expected {
+				return ErrFingerprintMismatch
+			}
+			return nil
+		},
+	}
+}
+
+// normalizeFingerprint turns a fingerprint into lowercase hex without
+// separators, so "AB:CD" and "abcd" compare equal
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// pair exchanges the PIN for a session token
+func (s *Sender) pair(ctx context.Context, base *url.URL, tlsConfig *tls.Config) (string, error) {
+	body, _ := json.Marshal(map[string]string{"pin": s.PIN, "device": s.deviceName()})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.JoinPath(api.Prefix, "pair").String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", unwrapFingerprintError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr api.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			return "", fmt.Errorf("pairing failed: %s", apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("pairing failed: %s", resp.Status)
+	}
+	var paired struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&paired); err != nil || paired.Token == "" {
+		return "", errors.New("pairing failed: the device sent no token")
+	}
+	return paired.Token, nil
+}
+
+// dialSignaling opens the receiver's signaling socket
+func (s *Sender) dialSignaling(ctx context.Context, base *url.URL, tlsConfig *tls.Config, token string) (*websocket.Conn, error) {
+	target := *base.JoinPath("signaling")
+	target.Scheme = "ws"
+	if base.Scheme == "https" {
+		target.Scheme = "wss"
+	}
+
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: 30 * time.Second,
+		Proxy:            http.ProxyFromEnvironment,
+	}
+	ws, resp, err := dialer.DialContext(ctx, target.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, ErrPairingRequired
+		}
+		if resp != nil {
+			return nil, fmt.Errorf("could not connect to the device: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("could not connect to the device: %w", unwrapFingerprintError(err))
+	}
+	return ws, nil
+}
+
+// unwrapFingerprintError reports a pinned certificate mismatch as such
+// rather than as the TLS handshake error that carries it
+func unwrapFingerprintError(err error) error {
+	if errors.Is(err, ErrFingerprintMismatch) {
+		return ErrFingerprintMismatch
+	}
+	return err
+}
+
+// collectFiles lists the files to send. A folder contributes the files in it
+// under the folder's name.
+func collectFiles(paths []string) ([]outgoingFile, error) {
+	var files []outgoingFile
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, outgoingFile{path: p, name: filepath.Base(p), size: info.Size(), modTime: info.ModTime()})
+			continue
+		}
+
+		root := filepath.Dir(filepath.Clean(p))
+		err = filepath.WalkDir(p, func(walked string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, walked)
+			if err != nil {
+				return err
+			}
+			files = append(files, outgoingFile{path: walked, name: filepath.ToSlash(rel), size: info.Size(), modTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// recordHistory adds the transfer to the history as a sent entry
+func (s *Sender) recordHistory(base *url.URL, files []outgoingFile, results []SendResult, err error, start time.Time) {
+	entry := history.Entry{
+		Direction:  history.Sent,
+		Method:     "webrtc",
+		PeerAddr:   base.Hostname(),
+		DurationMS: time.Since(start).Milliseconds(),
+		Result:     history.Completed,
+	}
+	for i, f := range files {
+		file := history.File{Name: f.name, Path: f.path, Size: f.size}
+		if i < len(results) && results[i].OK {
+			file.SHA256 = results[i].SHA256
+		}
+		entry.Files = append(entry.Files, file)
+	}
+
+	switch {
+	case errors.Is(err, ErrDeclined):
+		entry.Result, entry.Error = history.Declined, err.Error()
+	case err != nil:
+		entry.Result, entry.Error = history.Failed, err.Error()
+	default:
+		for _, r := range results {
+			if !r.OK {
+				entry.Result, entry.Error = history.Failed, fmt.Sprintf("%s: %s", r.Name, r.Message)
+				break
+			}
+		}
+	}
+	recordTransfer(entry)
+}
+
+// offerConn is a WebRTC connection offered over a signaling socket, with
+// the data channel files are sent on
+type offerConn struct {
+	ws *websocket.Conn
+	pc *webrtc.PeerConnection
+	dc *webrtc.DataChannel
+
+	writeMu  sync.Mutex // serializes writes on ws
+	messages chan controlMessage
+	drained  chan struct{} // signalled when the data channel's buffer runs low
+	failed   chan struct{} // closed when the connection is lost
+	failOnce sync.Once
+}
+
+// offer creates a PeerConnection, sends its offer and waits for the data
+// channel to open
+func offer(ctx context.Context, ws *websocket.Conn) (*offerConn, error) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	c := &offerConn{
+		ws:       ws,
+		pc:       pc,
+		messages: make(chan controlMessage, 16),
+		drained:  make(chan struct{}, 1),
+		failed:   make(chan struct{}),
+	}
+
+	c.dc, err = pc.CreateDataChannel("file", nil)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	opened := make(chan struct{})
+	c.dc.OnOpen(func() { close(opened) })
+	c.dc.OnClose(c.fail)
+	c.dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var control controlMessage
+		if !msg.IsString || json.Unmarshal(msg.Data, &control) != nil || control.Type == "" {
+			return // e.g. the greeting sent when the channel opens
+		}
+		select {
+		case c.messages <- control:
+		case <-c.failed:
+		}
+	})
+	c.dc.SetBufferedAmountLowThreshold(maxBufferedAmount / 2)
+	c.dc.OnBufferedAmountLow(func() {
+		select {
+		case c.drained <- struct{}{}:
+		default:
+		}
+	})
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			c.sendSignal(SignalMessage{Type: "candidate", Candidate: candidate.ToJSON().Candidate})
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			c.fail()
+		}
+	})
+
+	description, err := pc.CreateOffer(nil)
+	if err == nil {
+		err = pc.SetLocalDescription(description)
+	}
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	go c.readSignals()
+	c.sendSignal(SignalMessage{Type: "offer", SDP: description.SDP})
+
+	select {
+	case <-opened:
+		return c, nil
+	case <-c.failed:
+		c.close()
+		return nil, errors.New("could not connect to the device")
+	case <-ctx.Done():
+		c.close()
+		return nil, ctx.Err()
+	}
+}
+
+// readSignals applies the answer and candidates the receiver sends until the
+// socket closes
+func (c *offerConn) readSignals() {
+	var pending []string // candidates that arrived before the answer
+	answered := false
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			// The socket is only needed until the data channel is open
+			if c.pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+				c.fail()
+			}
+			return
+		}
+		var signal SignalMessage
+		if err := json.Unmarshal(data, &signal); err != nil {
+			continue
+		}
+		switch signal.Type {
+		case "answer":
+			if err := c.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: signal.SDP}); err != nil {
+				log.Println("Failed to set remote description:", err)
+				c.fail()
+				return
+			}
+			answered = true
+			for _, candidate := range pending {
+				c.pc.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate})
+			}
+			pending = nil
+		case "candidate":
+			if !answered {
+				pending = append(pending, signal.Candidate)
+				continue
+			}
+			c.pc.AddICECandidate(webrtc.ICECandidateInit{Candidate: signal.Candidate})
+		}
+	}
+}
+
+// sendSignal writes a signaling message to the socket
+func (c *offerConn) sendSignal(msg SignalMessage) {
+	data, _ := json.Marshal(msg)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// fail marks the connection as lost
+func (c *offerConn) fail() {
+	c.failOnce.Do(func() { close(c.failed) })
+}
+
+// sendJSON sends a control message on the data channel
+func (c *offerConn) sendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.dc.SendText(string(data))
+}
+
+// sendChunk sends file data, waiting while too much of it is still buffered
+func (c *offerConn) sendChunk(ctx context.Context, chunk []byte) error {
+	for c.dc.BufferedAmount() > maxBufferedAmount {
+		select {
+		case <-c.drained:
+		case <-c.failed:
+			return errors.New("the connection to the device was lost")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.dc.Send(chunk)
+}
+
+// await waits for the next control message of one of the given types,
+// dropping any other
+func (c *offerConn) await(ctx context.Context, types ...string) (controlMessage, error) {
+	for {
+		select {
+		case msg := <-c.messages:
+			for _, t := range types {
+				if msg.Type == t {
+					return msg, nil
+				}
+			}
+		case <-c.failed:
+			return controlMessage{}, errors.New("the connection to the device was lost")
+		case <-ctx.Done():
+			return controlMessage{}, ctx.Err()
+		}
+	}
+}
+
+// flush waits until the data channel has handed everything to the network,
+// so closing the connection doesn't cut off the last messages
+func (c *offerConn) flush(ctx context.Context) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.dc.BufferedAmount() > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// close tears the connection down
+func (c *offerConn) close() {
+	c.fail()
+	c.pc.Close()
+}
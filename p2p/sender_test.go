@@ -0,0 +1,222 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/history"
+)
+
+const testPIN = "123456"
+
+// startReceiver serves the signaling socket the way the server does. When
+// pairing is on, the socket needs the token handed out for testPIN.
+func startReceiver(t *testing.T, prefs *config.Preferences, pairing, useTLS bool) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/pair", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PIN string `json:"pin"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.PIN != testPIN {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":"pairing_failed","message":"invalid or expired PIN"}}`))
+			return
+		}
+		w.Write([]byte(`{"token":"session-token"}`))
+	})
+	mux.HandleFunc("/signaling", func(w http.ResponseWriter, r *http.Request) {
+		if pairing && r.Header.Get("Authorization") != "Bearer session-token" {
+			http.Error(w, "Pairing required", http.StatusUnauthorized)
+			return
+		}
+		SignalingHandler(w, r, prefs, "")
+	})
+
+	var ts *httptest.Server
+	if useTLS {
+		ts = httptest.NewTLSServer(mux)
+	} else {
+		ts = httptest.NewServer(mux)
+	}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestSenderSendsFilesAndFolders(t *testing.T) {
+	uploadDir := t.TempDir()
+	ts := startReceiver(t, &config.Preferences{UploadDir: uploadDir}, false, false)
+
+	src := t.TempDir()
+	big := strings.Repeat("0123456789abcdef", 10000) // spans many chunks
+	files := map[string]string{
+		"notes.txt":            "hello",
+		"empty.txt":            "",
+		"album/cover.jpg":      big,
+		"album/disc 2/one.mp3": "la la la",
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	var sentBytes int64
+	sender := &Sender{
+		URL: ts.URL,
+		OnProgress: func(p SendProgress) {
+			if p.Name == "album/cover.jpg" {
+				sentBytes = p.Bytes
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	results, err := sender.Send(ctx, []string{
+		filepath.Join(src, "notes.txt"),
+		filepath.Join(src, "empty.txt"),
+		filepath.Join(src, "album"),
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if len(results) != len(files) {
+		t.Fatalf("Expected %d results, got %+v", len(files), results)
+	}
+	for _, r := range results {
+		if !r.OK {
+			t.Errorf("Expected %s to be saved, got %+v", r.Name, r)
+		}
+		sum := sha256.Sum256([]byte(files[r.Name]))
+		if r.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: unexpected digest %s", r.Name, r.SHA256)
+		}
+	}
+	for name, expected := range files {
+		content, err := os.ReadFile(filepath.Join(uploadDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Failed to read received %s: %v", name, err)
+			continue
+		}
+		if string(content) != expected {
+			t.Errorf("%s: received %d bytes, expected %d", name, len(content), len(expected))
+		}
+	}
+	if sentBytes != int64(len(big)) {
+		t.Errorf("Expected progress up to %d bytes, got %d", len(big), sentBytes)
+	}
+}
+
+func TestSenderErrors(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(src, []byte("jpeg"), 0644)
+
+	testCases := []struct {
+		name     string
+		prefs    config.Preferences
+		pairing  bool
+		useTLS   bool
+		sender   Sender
+		expected error  // nil when the file must arrive
+		message  string // part of the error, when it isn't a sentinel
+	}{
+		{name: "pairing required", pairing: true, expected: ErrPairingRequired},
+		{name: "wrong PIN", pairing: true, sender: Sender{PIN: "000000"}, message: "invalid or expired PIN"},
+		{name: "paired with PIN", pairing: true, sender: Sender{PIN: testPIN}},
+		{name: "paired with token", pairing: true, sender: Sender{Token: "session-token"}},
+		{name: "declined", prefs: config.Preferences{AskBeforeReceiving: true}, expected: ErrDeclined},
+		{name: "pinned certificate", useTLS: true},
+		{name: "other certificate", useTLS: true, sender: Sender{Fingerprint: strings.Repeat("AB:", 31) + "AB"}, expected: ErrFingerprintMismatch},
+		{name: "unknown certificate", useTLS: true, sender: Sender{}, message: "certificate"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prefs := tc.prefs
+			prefs.UploadDir = t.TempDir()
+			ts := startReceiver(t, &prefs, tc.pairing, tc.useTLS)
+
+			sender := tc.sender
+			sender.URL = ts.URL
+			if tc.name == "pinned certificate" {
+				sum := sha256.Sum256(ts.Certificate().Raw)
+				sender.Fingerprint = strings.ToUpper(hex.EncodeToString(sum[:]))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			_, err := sender.Send(ctx, []string{src})
+
+			switch {
+			case tc.expected != nil:
+				if !errors.Is(err, tc.expected) {
+					t.Errorf("Expected %v, got %v", tc.expected, err)
+				}
+			case tc.message != "":
+				if err == nil || !strings.Contains(err.Error(), tc.message) {
+					t.Errorf("Expected an error mentioning %q, got %v", tc.message, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Send failed: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(prefs.UploadDir, "photo.jpg")); err != nil {
+					t.Errorf("Expected the file to arrive: %v", err)
+				}
+				if tc.pairing && sender.Token != "session-token" {
+					t.Errorf("Expected the session token to be kept, got %q", sender.Token)
+				}
+			}
+		})
+	}
+}
+
+func TestSenderRecordsHistory(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	SetHistory(store)
+	defer SetHistory(nil)
+
+	ts := startReceiver(t, &config.Preferences{UploadDir: t.TempDir()}, false, false)
+	src := filepath.Join(t.TempDir(), "report.pdf")
+	os.WriteFile(src, []byte("%PDF"), 0644)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if _, err := (&Sender{URL: ts.URL}).Send(ctx, []string{src}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var sent []history.Entry
+	for _, e := range store.Search("", 0) {
+		if e.Direction == history.Sent {
+			sent = append(sent, e)
+		}
+	}
+	if len(sent) != 1 {
+		t.Fatalf("Expected one sent entry, got %+v", sent)
+	}
+	e := sent[0]
+	if e.Method != "webrtc" || e.Result != history.Completed || e.PeerAddr != "127.0.0.1" ||
+		len(e.Files) != 1 || e.Files[0].Name != "report.pdf" || e.Files[0].Path != src || e.Files[0].SHA256 == "" {
+		t.Errorf("Unexpected history entry %+v", e)
+	}
+}
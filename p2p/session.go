@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"hash"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/progress"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// Session owns the WebRTC state of a single signaling WebSocket: its
+// PeerConnection, data channels and the file currently being received.
+// Every connected browser gets its own Session so concurrent transfers
+// don't interfere with each other.
+type Session struct {
+	conn       *websocket.Conn
+	prefs      *config.Preferences
+	remoteAddr string
+	deviceID   string // identity the socket was authenticated with, see transfer.Request
+
+	// ctx is cancelled when the session closes, aborting pending prompts
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex // serializes writes on conn
+
+	mu               sync.Mutex // guards everything below
+	closed           bool
+	peerConnection   *webrtc.PeerConnection
+	dataChannels     []*webrtc.DataChannel
+	currentFile      *os.File
+	currentFileName  string // relative path the browser sent, with forward slashes
+	currentFileDir   string // upload folder or share the file goes to
+	currentFileRel   string // where the file goes under that folder
+	currentFilePath  string // part file the data is received into
+	currentModTime   time.Time
+	currentMIMEType  string
+	currentSessionID string
+	expectedFileSize int64
+	receivedBytes    int64
+	progress         *progress.Transfer // progress of the current file
+	transferSession  *TransferSession
+	fileHash         hash.Hash      // SHA-256 of the chunks received so far
+	expectedDigest   []byte         // SHA-256 announced by the browser, if any
+	fileStart        time.Time      // when the current file's metadata arrived
+	deviceName       string         // name the browser announced, if any
+	approved         map[string]int // file names the desktop user accepted
+	skipBytes        int64          // bytes left of a rejected file
+}
+
+// NewSession creates a session bound to a signaling WebSocket
+func NewSession(conn *websocket.Conn, prefs *config.Preferences) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{
+		conn:     conn,
+		prefs:    prefs,
+		ctx:      ctx,
+		cancel:   cancel,
+		approved: make(map[string]int),
+	}
+	if conn != nil {
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			s.remoteAddr = host
+		}
+	}
+	return s
+}
+
+// setPeerConnection installs pc as the session's PeerConnection, closing the
+// one it replaces. It returns false if the session has already been closed.
+func (s *Session) setPeerConnection(pc *webrtc.PeerConnection) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	previous := s.peerConnection
+	s.peerConnection = pc
+	s.dataChannels = nil
+	s.abortCurrentFileLocked()
+	s.transferSession = nil
+	s.mu.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			log.Println("Failed to close previous PeerConnection:", err)
+		}
+	}
+	return true
+}
+
+// addDataChannel records a data channel opened by the remote peer
+func (s *Session) addDataChannel(dc *webrtc.DataChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dataChannels = append(s.dataChannels, dc)
+}
+
+// Close tears down the PeerConnection and discards any partially received
+// file. It is safe to call more than once.
+func (s *Session) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.cancel()
+	pc := s.peerConnection
+	s.peerConnection = nil
+	s.dataChannels = nil
+	s.abortCurrentFileLocked()
+	s.transferSession = nil
+	s.mu.Unlock()
+
+	if pc != nil {
+		if err := pc.Close(); err != nil {
+			log.Println("Failed to close PeerConnection:", err)
+		}
+	}
+}
+
+// sendJSONLocked sends a control message to the browser on every data
+// channel of the session. The caller must hold s.mu.
+func (s *Session) sendJSONLocked(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Failed to encode data channel message:", err)
+		return
+	}
+	for _, dc := range s.dataChannels {
+		if err := dc.SendText(string(data)); err != nil {
+			log.Println("Failed to send data channel message:", err)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/history"
+	"lan-drop/progress"
+	"lan-drop/transfer"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func metadataMessage(t *testing.T, name string, size int) webrtc.DataChannelMessage {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"name": name, "size": size})
+	if err != nil {
+		t.Fatalf("Failed to marshal metadata: %v", err)
+	}
+	return webrtc.DataChannelMessage{IsString: true, Data: data}
+}
+
+func chunkMessage(data string) webrtc.DataChannelMessage {
+	return webrtc.DataChannelMessage{Data: []byte(data)}
+}
+
+func TestSessionsReceiveIndependently(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	first := NewSession(nil, prefs)
+	second := NewSession(nil, prefs)
+	defer first.Close()
+	defer second.Close()
+
+	// Interleave two transfers the way two phones would
+	first.onDataChannelMessage(metadataMessage(t, "first.txt", 10))
+	second.onDataChannelMessage(metadataMessage(t, "second.txt", 6))
+	first.onDataChannelMessage(chunkMessage("hello"))
+	second.onDataChannelMessage(chunkMessage("abc"))
+	first.onDataChannelMessage(chunkMessage("world"))
+	second.onDataChannelMessage(chunkMessage("def"))
+
+	for name, expected := range map[string]string{"first.txt": "helloworld", "second.txt": "abcdef"} {
+		content, err := os.ReadFile(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(content) != expected {
+			t.Errorf("%s: expected '%s', got '%s'", name, expected, string(content))
+		}
+	}
+}
+
+func TestSessionConcurrentMessages(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := NewSession(nil, prefs)
+			defer session.Close()
+
+			session.onDataChannelMessage(metadataMessage(t, fmt.Sprintf("file%d.bin", i), 100))
+			for j := 0; j < 10; j++ {
+				session.onDataChannelMessage(chunkMessage("0123456789"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 4; i++ {
+		stat, err := os.Stat(filepath.Join(tempDir, fmt.Sprintf("file%d.bin", i)))
+		if err != nil {
+			t.Fatalf("File %d was not received: %v", i, err)
+		}
+		if stat.Size() != 100 {
+			t.Errorf("File %d: expected 100 bytes, got %d", i, stat.Size())
+		}
+	}
+}
+
+func TestSessionCloseDiscardsPartialFile(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	session := NewSession(nil, prefs)
+	session.onDataChannelMessage(metadataMessage(t, "partial.txt", 100))
+	session.onDataChannelMessage(chunkMessage("only a few bytes"))
+	session.Close()
+
+	if _, err := os.Stat(filepath.Join(tempDir, "partial.txt")); !os.IsNotExist(err) {
+		t.Error("Expected partial file to be removed when the session closes")
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("Expected no leftover part files, found %d entries", len(entries))
+	}
+
+	// Closing twice must be harmless
+	session.Close()
+}
+
+func TestSessionTracksSavedPath(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "dup.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"type":"session_start","session_id":"1","total_files":1}`)})
+	session.onDataChannelMessage(metadataMessage(t, "dup.txt", 3))
+	session.onDataChannelMessage(chunkMessage("new"))
+
+	if len(session.transferSession.Files) != 1 {
+		t.Fatalf("Expected 1 tracked file, got %d", len(session.transferSession.Files))
+	}
+	expected := filepath.Join(tempDir, "dup_1.txt")
+	if session.transferSession.Files[0] != expected {
+		t.Errorf("Expected tracked path %s, got %s", expected, session.transferSession.Files[0])
+	}
+}
+
+func TestSessionSkipsUnapprovedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	// Nothing was approved, so the file and its chunks must be dropped
+	session.onDataChannelMessage(metadataMessage(t, "secret.txt", 6))
+	session.onDataChannelMessage(chunkMessage("abcdef"))
+
+	if _, err := os.Stat(filepath.Join(tempDir, "secret.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected unapproved file not to be written, got err=%v", err)
+	}
+
+	// An approved file sent afterwards is received normally
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		return transfer.Decision{Accepted: true}
+	})
+	defer transfer.SetPrompter(nil)
+
+	session.handleTransferRequest(transfer.Request{
+		DeviceID: "phone",
+		Files:    []transfer.FileSummary{{Name: "ok.txt", Size: 2}},
+	})
+	session.onDataChannelMessage(metadataMessage(t, "ok.txt", 2))
+	session.onDataChannelMessage(chunkMessage("hi"))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "ok.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read approved file: %v", err)
+	}
+	if string(content) != "hi" {
+		t.Errorf("Expected 'hi', got '%s'", string(content))
+	}
+}
+
+func TestSessionDeclinedRequestApprovesNothing(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		return transfer.Decision{Accepted: false}
+	})
+	defer transfer.SetPrompter(nil)
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.handleTransferRequest(transfer.Request{
+		DeviceID: "phone",
+		Files:    []transfer.FileSummary{{Name: "photo.jpg", Size: 3}},
+	})
+	session.onDataChannelMessage(metadataMessage(t, "photo.jpg", 3))
+	session.onDataChannelMessage(chunkMessage("abc"))
+
+	if _, err := os.Stat(filepath.Join(tempDir, "photo.jpg")); !os.IsNotExist(err) {
+		t.Fatalf("Expected declined file not to be written, got err=%v", err)
+	}
+}
+
+func TestSessionRecordsHistory(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	SetHistory(store)
+	defer SetHistory(nil)
+
+	prefs := &config.Preferences{UploadDir: t.TempDir()}
+	session := NewSession(nil, prefs)
+
+	session.onDataChannelMessage(metadataMessage(t, "done.txt", 4))
+	session.onDataChannelMessage(chunkMessage("abcd"))
+	session.onDataChannelMessage(metadataMessage(t, "partial.txt", 10))
+	session.onDataChannelMessage(chunkMessage("abc"))
+	session.Close()
+
+	entries := store.Search("", 0)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(entries))
+	}
+
+	// Newest first: the interrupted file, then the completed one
+	if entries[0].Result != history.Failed || entries[0].Files[0].Name != "partial.txt" {
+		t.Errorf("Unexpected entry for the interrupted file: %+v", entries[0])
+	}
+	done := entries[1]
+	sum := sha256.Sum256([]byte("abcd"))
+	if done.Result != history.Completed || done.Files[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected entry for the completed file: %+v", done)
+	}
+}
+
+func TestSessionVerifiesDigest(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	send := func(name, content, digest string) {
+		data, _ := json.Marshal(map[string]interface{}{"name": name, "size": len(content), "sha256": digest})
+		session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: data})
+		session.onDataChannelMessage(chunkMessage(content))
+	}
+
+	good := sha256.Sum256([]byte("intact"))
+	send("good.txt", "intact", hex.EncodeToString(good[:]))
+	send("bad.txt", "tampered", hex.EncodeToString(good[:]))
+
+	if content, err := os.ReadFile(filepath.Join(tempDir, "good.txt")); err != nil || string(content) != "intact" {
+		t.Errorf("Expected verified file to be kept, got %q (err=%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "bad.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected corrupt file to be deleted, got err=%v", err)
+	}
+}
+
+func TestSessionRejectsOversizedFile(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	// More data than announced means the stream got out of sync
+	session.onDataChannelMessage(metadataMessage(t, "short.txt", 3))
+	session.onDataChannelMessage(chunkMessage("abcdef"))
+
+	if _, err := os.Stat(filepath.Join(tempDir, "short.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected oversized file to be deleted, got err=%v", err)
+	}
+}
+
+func TestSessionAppliesConflictPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, ConflictPolicy: string(transfer.ConflictOverwrite)}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "dup.txt"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.onDataChannelMessage(metadataMessage(t, "dup.txt", 3))
+	session.onDataChannelMessage(chunkMessage("new"))
+
+	if content, err := os.ReadFile(filepath.Join(tempDir, "dup.txt")); err != nil || string(content) != "new" {
+		t.Errorf("Expected dup.txt to be overwritten, got '%s' (%v)", content, err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 1 {
+		t.Errorf("Expected only dup.txt in the upload folder, found %d entries", len(entries))
+	}
+}
+
+func TestSessionRecreatesFolders(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"name":"b.txt","relativePath":"Docs/notes/b.txt","size":4}`)})
+	session.onDataChannelMessage(chunkMessage("note"))
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"name":"x.txt","relativePath":"../x.txt","size":4}`)})
+	session.onDataChannelMessage(chunkMessage("evil"))
+
+	if content, err := os.ReadFile(filepath.Join(tempDir, "Docs", "notes", "b.txt")); err != nil || string(content) != "note" {
+		t.Errorf("Expected Docs/notes/b.txt, got '%s' (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tempDir), "x.txt")); !os.IsNotExist(err) {
+		t.Error("Expected a path leaving the upload folder to be rejected")
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 1 {
+		t.Errorf("Expected only the Docs folder in the upload folder, found %d entries", len(entries))
+	}
+}
+
+func TestSessionSanitizesFileName(t *testing.T) {
+	tempDir := t.TempDir()
+	uploadDir := filepath.Join(tempDir, "uploads")
+	if err := os.Mkdir(uploadDir, 0755); err != nil {
+		t.Fatalf("Failed to create upload folder: %v", err)
+	}
+	prefs := &config.Preferences{UploadDir: uploadDir}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.onDataChannelMessage(metadataMessage(t, "../../x.txt", 4))
+	session.onDataChannelMessage(chunkMessage("evil"))
+	session.onDataChannelMessage(metadataMessage(t, "cafe\u0301\u202e.txt", 4))
+	session.onDataChannelMessage(chunkMessage("cafe"))
+
+	if _, err := os.Stat(filepath.Join(tempDir, "x.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the file not to leave the upload folder")
+	}
+	if content, err := os.ReadFile(filepath.Join(uploadDir, ".._.._x.txt")); err != nil || string(content) != "evil" {
+		t.Errorf("Expected the file to be saved as .._.._x.txt, got '%s' (%v)", content, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(uploadDir, "caf\u00e9.txt")); err != nil || string(content) != "cafe" {
+		t.Errorf("Expected the name to be normalized, got '%s' (%v)", content, err)
+	}
+}
+
+func TestSessionKeepsModificationTime(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	modTime := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	data, _ := json.Marshal(map[string]interface{}{"name": "photo.jpg", "size": 4, "lastModified": modTime.UnixMilli()})
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: data})
+	session.onDataChannelMessage(chunkMessage("jpeg"))
+
+	stat, err := os.Stat(filepath.Join(tempDir, "photo.jpg"))
+	if err != nil {
+		t.Fatalf("Received file missing: %v", err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, stat.ModTime())
+	}
+}
+
+func TestSessionSavesIntoShare(t *testing.T) {
+	tempDir := t.TempDir()
+	inbox, design := t.TempDir(), t.TempDir()
+	prefs := &config.Preferences{
+		UploadDir: tempDir,
+		Shares: []config.Share{
+			{Name: "Inbox", Dir: inbox, Access: config.ShareWriteOnly},
+			{Name: "Design assets", Dir: design, Access: config.ShareReadOnly},
+		},
+	}
+
+	session := NewSession(nil, prefs)
+	defer session.Close()
+
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"name":"a.txt","size":4,"share":"Inbox"}`)})
+	session.onDataChannelMessage(chunkMessage("into"))
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"name":"b.txt","size":4,"share":"Design assets"}`)})
+	session.onDataChannelMessage(chunkMessage("deny"))
+	session.onDataChannelMessage(metadataMessage(t, "c.txt", 4))
+	session.onDataChannelMessage(chunkMessage("home"))
+
+	if content, err := os.ReadFile(filepath.Join(inbox, "a.txt")); err != nil || string(content) != "into" {
+		t.Errorf("Expected a.txt in the Inbox share, got '%s' (%v)", content, err)
+	}
+	if entries, _ := os.ReadDir(design); len(entries) != 0 {
+		t.Errorf("Expected nothing written to the read-only share, found %d entries", len(entries))
+	}
+	if content, err := os.ReadFile(filepath.Join(tempDir, "c.txt")); err != nil || string(content) != "home" {
+		t.Errorf("Expected c.txt in the upload folder, got '%s' (%v)", content, err)
+	}
+}
+
+func TestSessionReceivesText(t *testing.T) {
+	var got []transfer.TextMessage
+	transfer.SetTextReceiver(func(msg transfer.TextMessage) {
+		got = append(got, msg)
+	})
+	defer transfer.SetTextReceiver(nil)
+
+	session := NewSession(nil, &config.Preferences{UploadDir: t.TempDir()})
+	defer session.Close()
+	session.remoteAddr = "192.168.1.20"
+
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"type":"text","text":"https://example.com","device_name":"Phone"}`)})
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"type":"text","text":"  "}`)})
+
+	if len(got) != 1 {
+		t.Fatalf("Expected one text to be delivered, got %+v", got)
+	}
+	expected := transfer.TextMessage{Text: "https://example.com", DeviceName: "Phone", RemoteAddr: "192.168.1.20"}
+	if got[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got[0])
+	}
+}
+
+func TestSessionReportsProgress(t *testing.T) {
+	events, cancel := progress.Default.Subscribe()
+	defer cancel()
+
+	session := NewSession(nil, &config.Preferences{UploadDir: t.TempDir()})
+	defer session.Close()
+
+	meta, _ := json.Marshal(map[string]interface{}{"name": "clip.mov", "size": 10, "sessionId": "p2p-session"})
+	session.onDataChannelMessage(webrtc.DataChannelMessage{IsString: true, Data: meta})
+	session.onDataChannelMessage(chunkMessage("hello"))
+	session.onDataChannelMessage(chunkMessage("world"))
+
+	var got []progress.Event
+	for len(events) > 0 {
+		if e := <-events; e.Name == "clip.mov" {
+			got = append(got, e)
+		}
+	}
+	if len(got) < 2 {
+		t.Fatalf("Expected a start and a done event, got %+v", got)
+	}
+	first, last := got[0], got[len(got)-1]
+	if first.Bytes != 0 || first.Size != 10 || first.SessionID != "p2p-session" || first.Method != "webrtc" {
+		t.Errorf("Unexpected start event: %+v", first)
+	}
+	if !last.Done || last.Result != progress.Completed || last.Bytes != 10 {
+		t.Errorf("Expected the file to complete with 10 bytes, got %+v", last)
+	}
+}
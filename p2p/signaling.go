@@ -2,44 +2,68 @@ package p2p
 
 import (
 	"lan-drop/config"
+	"lan-drop/events"
 	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // allow all for now
-	},
+	CheckOrigin: sameOrigin,
 }
 
-func SignalingHandler(w http.ResponseWriter, r *http.Request, prefs *config.Preferences) {
+// sameOrigin only lets browsers open the signaling socket from pages served
+// by this server. Non-browser clients don't send an Origin header; they are
+// authenticated by their session token like any other request.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// SignalingHandler serves a signaling WebSocket. deviceID is the identity
+// the caller was authenticated with, remembered if the desktop user always
+// allows its transfers; empty if it has none.
+func SignalingHandler(w http.ResponseWriter, r *http.Request, prefs *config.Preferences, deviceID string) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade failed:", err)
-		reportStatus("WebRTC connection failed")
+		events.Publish(events.PeerDisconnected{Via: events.ViaSignaling, Failed: true})
 		return
 	}
 	defer ws.Close()
 
+	// Each WebSocket gets its own PeerConnection and transfer state
+	session := NewSession(ws, prefs)
+	session.deviceID = deviceID
+	defer session.Close()
+
+	// Open sessions are reachable by SendText
+	register(session)
+	defer unregister(session)
+
 	log.Println("WebSocket connection established")
-	reportStatus("WebRTC client connected")
+	events.Publish(events.PeerConnected{Addr: session.remoteAddr, Via: events.ViaSignaling})
 
 	for {
 		_, msg, err := ws.ReadMessage()
 		if err != nil {
 			log.Println("WebSocket read error:", err)
 			// Check if it's a normal close (client disconnected)
-			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				reportStatus("WebRTC client disconnected")
-			} else {
-				reportStatus("WebRTC connection error")
-			}
+			closed := websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure)
+			events.Publish(events.PeerDisconnected{Addr: session.remoteAddr, Via: events.ViaSignaling, Failed: !closed})
 			break
 		}
 		log.Printf("Received signaling message: %s\n", msg)
 
-		HandleSignalMessage(msg, ws, prefs)
+		session.HandleSignalMessage(msg)
 	}
 }
@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		origin   string
+		expected bool
+	}{
+		{"no origin (native client)", "", true},
+		{"same origin", "http://192.168.1.10:8080", true},
+		{"other host", "http://evil.example", false},
+		{"other port", "http://192.168.1.10:9090", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://192.168.1.10:8080/signaling", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if result := sameOrigin(req); result != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
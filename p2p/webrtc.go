@@ -1,16 +1,22 @@
 package p2p
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"lan-drop/config"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/progress"
+	"lan-drop/transfer"
 	"lan-drop/utils"
 
 	"fyne.io/fyne/v2"
@@ -19,25 +25,39 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
-var peerConnection *webrtc.PeerConnection
+// Global transfer history, nil when history is disabled
+var historyStore *history.Store
 
-// StatusReporter interface for decentralized status updates
-type StatusReporter interface {
-	ReportStatus(message string)
+// SetHistory sets the store received files are recorded in
+func SetHistory(store *history.Store) {
+	historyStore = store
 }
 
-// Global status reporter instance
-var statusReporter StatusReporter
-
-// SetStatusReporter sets the global status reporter
-func SetStatusReporter(reporter StatusReporter) {
-	statusReporter = reporter
+// recordTransfer adds an entry to the transfer history if one is set
+func recordTransfer(entry history.Entry) {
+	if historyStore == nil {
+		return
+	}
+	if err := historyStore.Add(entry); err != nil {
+		log.Println("Failed to record transfer history:", err)
+	}
 }
 
-// reportStatus safely reports status if a reporter is set
-func reportStatus(message string) {
-	if statusReporter != nil {
-		statusReporter.ReportStatus(message)
+// fileEntryLocked builds the history entry for the file being received. The
+// caller must hold s.mu.
+func (s *Session) fileEntryLocked(result history.Result) history.Entry {
+	file := history.File{Name: s.currentFileName, Path: s.currentFilePath, Size: s.receivedBytes}
+	if result == history.Completed {
+		file.SHA256 = hex.EncodeToString(s.fileHash.Sum(nil))
+	}
+	return history.Entry{
+		Direction:  history.Received,
+		Method:     "webrtc",
+		PeerAddr:   s.remoteAddr,
+		Device:     s.deviceName,
+		Files:      []history.File{file},
+		DurationMS: time.Since(s.fileStart).Milliseconds(),
+		Result:     result,
 	}
 }
 
@@ -47,8 +67,8 @@ type SignalMessage struct {
 	Candidate string `json:"candidate,omitempty"`
 }
 
-// Called when we get an offer from the browser
-func HandleSignalMessage(msg []byte, conn *websocket.Conn, prefs *config.Preferences) {
+// HandleSignalMessage is called for every message received on the session's WebSocket
+func (s *Session) HandleSignalMessage(msg []byte) {
 	var signal SignalMessage
 	if err := json.Unmarshal(msg, &signal); err != nil {
 		dialog.ShowError(errors.New("invalid signaling message"), nil)
@@ -58,65 +78,70 @@ func HandleSignalMessage(msg []byte, conn *websocket.Conn, prefs *config.Prefere
 
 	switch signal.Type {
 	case "offer":
-		handleOffer(signal.SDP, conn, prefs)
+		s.handleOffer(signal.SDP)
 	case "candidate":
-		handleRemoteCandidate(signal.Candidate)
+		s.handleRemoteCandidate(signal.Candidate)
 	}
 }
 
-func handleOffer(sdp string, conn *websocket.Conn, prefs *config.Preferences) {
+// Called when we get an offer from the browser
+func (s *Session) handleOffer(sdp string) {
 	// Create the WebRTC config
 	config := webrtc.Configuration{}
 
-	var err error
-	peerConnection, err = webrtc.NewPeerConnection(config)
+	pc, err := webrtc.NewPeerConnection(config)
 	if err != nil {
 		dialog.ShowError(errors.New("failed to create PeerConnection"), nil)
 		// log.Println("Failed to create PeerConnection:", err)
 		return
 	}
 
+	// A new offer replaces whatever connection the peer had before
+	if !s.setPeerConnection(pc) {
+		pc.Close()
+		return
+	}
+
 	// Setup ICE candidate callback
-	peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
 		if c == nil {
 			return
 		}
-		candidateJSON, _ := json.Marshal(SignalMessage{
+		s.sendSignal(SignalMessage{
 			Type:      "candidate",
 			Candidate: c.ToJSON().Candidate,
 		})
-		conn.WriteMessage(websocket.TextMessage, candidateJSON)
 	})
 
 	// Monitor connection state changes
-	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		log.Printf("Peer connection state changed: %s\n", s.String())
-		switch s {
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("Peer connection state changed: %s\n", state.String())
+		switch state {
 		case webrtc.PeerConnectionStateConnected:
-			reportStatus("WebRTC peer connected")
-		case webrtc.PeerConnectionStateDisconnected:
-			reportStatus("WebRTC peer disconnected")
+			events.Publish(events.PeerConnected{Addr: s.remoteAddr, Via: events.ViaWebRTC})
+		case webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+			events.Publish(events.PeerDisconnected{Addr: s.remoteAddr, Via: events.ViaWebRTC})
 		case webrtc.PeerConnectionStateFailed:
-			reportStatus("WebRTC connection failed")
-		case webrtc.PeerConnectionStateClosed:
-			reportStatus("WebRTC connection closed")
+			events.Publish(events.PeerDisconnected{Addr: s.remoteAddr, Via: events.ViaWebRTC, Failed: true})
 		}
 	})
 
 	// Create a data channel
-	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		s.addDataChannel(dc)
+
 		dc.OnOpen(func() {
-			reportStatus("Data channel opened")
+			events.Publish(events.Notice{Text: "Data channel opened"})
 			log.Println("Data channel opened")
 			dc.SendText("Data channel established")
 		})
 
 		dc.OnClose(func() {
-			reportStatus("Data channel closed")
+			events.Publish(events.Notice{Text: "Data channel closed"})
 			log.Println("Data channel closed")
 		})
 
-		dc.OnMessage(dcOnMessage(prefs))
+		dc.OnMessage(s.onDataChannelMessage)
 	})
 
 	// Set the remote offer
@@ -124,46 +149,53 @@ func handleOffer(sdp string, conn *websocket.Conn, prefs *config.Preferences) {
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdp,
 	}
-	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+	if err := pc.SetRemoteDescription(offer); err != nil {
 		dialog.ShowError(errors.New("failed to set remote description"), nil)
 		// log.Println("Failed to set remote description:", err)
 		return
 	}
 
 	// Create and send the answer
-	answer, err := peerConnection.CreateAnswer(nil)
+	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		log.Println("Failed to create answer:", err)
 		return
 	}
 
-	if err := peerConnection.SetLocalDescription(answer); err != nil {
+	if err := pc.SetLocalDescription(answer); err != nil {
 		log.Println("Failed to set local description:", err)
 		return
 	}
 
-	answerJSON, _ := json.Marshal(SignalMessage{
+	s.sendSignal(SignalMessage{
 		Type: "answer",
 		SDP:  answer.SDP,
 	})
-	conn.WriteMessage(websocket.TextMessage, answerJSON)
 }
 
-func handleRemoteCandidate(candidateStr string) {
-	if peerConnection == nil {
+func (s *Session) handleRemoteCandidate(candidateStr string) {
+	s.mu.Lock()
+	pc := s.peerConnection
+	s.mu.Unlock()
+
+	if pc == nil {
 		return
 	}
 	candidate := webrtc.ICECandidateInit{Candidate: candidateStr}
-	peerConnection.AddICECandidate(candidate)
+	pc.AddICECandidate(candidate)
 }
 
-var (
-	currentFile      *os.File
-	currentFileName  string
-	expectedFileSize int64
-	receivedBytes    int64
-	transferSession  *TransferSession
-)
+// sendSignal writes a signaling message to the WebSocket. Pion invokes
+// callbacks from its own goroutines, so writes have to be serialized.
+func (s *Session) sendSignal(msg SignalMessage) {
+	data, _ := json.Marshal(msg)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.conn != nil {
+		s.conn.WriteMessage(websocket.TextMessage, data)
+	}
+}
 
 // TransferSession tracks a batch of file transfers
 type TransferSession struct {
@@ -171,177 +203,493 @@ type TransferSession struct {
 	TotalFiles    int
 	ReceivedFiles int
 	Files         []string // Track received file paths
+	Dir           string   // folder the last file was saved in
 	StartTime     time.Time
 }
 
-// safeSavePath generates a unique file path to avoid overwriting existing files
-func safeSavePath(folder, filename string) string {
-	base := strings.TrimSuffix(filename, filepath.Ext(filename))
-	ext := filepath.Ext(filename)
-	savePath := filepath.Join(folder, filename)
-
-	i := 1
-	for {
-		if _, err := os.Stat(savePath); os.IsNotExist(err) {
-			break
-		}
-		savePath = filepath.Join(folder, fmt.Sprintf("%s_%d%s", base, i, ext))
-		i++
-	}
+// onDataChannelMessage handles session control messages, file metadata and
+// file chunks sent by the browser over any of the session's data channels.
+func (s *Session) onDataChannelMessage(msg webrtc.DataChannelMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return savePath
-}
+	prefs := s.prefs
 
-func dcOnMessage(prefs *config.Preferences) func(msg webrtc.DataChannelMessage) {
-	return func(msg webrtc.DataChannelMessage) {
-		if msg.IsString {
-			// Parse message type first
-			var msgType struct {
-				Type string `json:"type,omitempty"`
-			}
-			if err := json.Unmarshal(msg.Data, &msgType); err == nil && msgType.Type != "" {
-				// Handle session messages
-				switch msgType.Type {
-				case "session_start":
-					var sessionMsg struct {
-						Type       string `json:"type"`
-						SessionID  string `json:"session_id"`
-						TotalFiles int    `json:"total_files"`
-					}
-					if err := json.Unmarshal(msg.Data, &sessionMsg); err == nil {
-						transferSession = &TransferSession{
-							ID:            sessionMsg.SessionID,
-							TotalFiles:    sessionMsg.TotalFiles,
-							ReceivedFiles: 0,
-							Files:         make([]string, 0, sessionMsg.TotalFiles),
-							StartTime:     time.Now(),
-						}
-						// Silent session start - no status reporting during auto-upload
+	if msg.IsString {
+		// Parse message type first
+		var msgType struct {
+			Type string `json:"type,omitempty"`
+		}
+		if err := json.Unmarshal(msg.Data, &msgType); err == nil && msgType.Type != "" {
+			// Handle session messages
+			switch msgType.Type {
+			case "session_start":
+				var sessionMsg struct {
+					Type       string `json:"type"`
+					SessionID  string `json:"session_id"`
+					TotalFiles int    `json:"total_files"`
+				}
+				if err := json.Unmarshal(msg.Data, &sessionMsg); err == nil {
+					s.transferSession = &TransferSession{
+						ID:            sessionMsg.SessionID,
+						TotalFiles:    sessionMsg.TotalFiles,
+						ReceivedFiles: 0,
+						Files:         make([]string, 0, sessionMsg.TotalFiles),
+						StartTime:     time.Now(),
 					}
+					// Silent session start - no status reporting during auto-upload
+				}
+				return
+			case "transfer_request":
+				var req struct {
+					DeviceName string                 `json:"device_name"`
+					Files      []transfer.FileSummary `json:"files"`
+				}
+				if err := json.Unmarshal(msg.Data, &req); err != nil {
+					log.Println("Failed to parse transfer request:", err)
 					return
-				case "session_end":
-					if transferSession != nil {
-						fileCount := transferSession.TotalFiles
-
-						// Show notification when user confirms upload (clicks Upload button)
-						if prefs.ShowNotifications {
-							if fileCount == 1 && len(transferSession.Files) > 0 {
-								// Single file - show specific file notification and open file
-								filePath := transferSession.Files[0]
-								action := utils.GetBestActionForFile(filePath)
-								utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
-									Title:    "LAN-Drop",
-									Content:  fmt.Sprintf("Received file: %s", filepath.Base(filePath)),
-									FilePath: filePath,
-									Action:   action,
-								})
-
-								// Auto-open single file if enabled
-								if prefs.AutoOpenFiles {
-									utils.HandleFileAction(filePath, action)
-								}
-							} else {
-								// Multiple files or no files tracked yet - show generic notification and open folder
-								utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
-									Title:    "LAN-Drop",
-									Content:  fmt.Sprintf("Received %d files", fileCount),
-									FilePath: prefs.UploadDir,
-									Action:   "show",
-								})
-
-								// Auto-open upload folder if enabled
-								if prefs.AutoOpenFiles {
-									if err := utils.OpenFolder(prefs.UploadDir); err != nil {
-										log.Printf("Failed to auto-open upload folder: %v", err)
-									}
+				}
+				// Waiting for the desktop user must not block the data channel
+				go s.handleTransferRequest(transfer.Request{
+					DeviceID:   s.deviceID,
+					DeviceName: req.DeviceName,
+					RemoteAddr: s.remoteAddr,
+					Files:      req.Files,
+				})
+				return
+			case "session_end":
+				if s.transferSession != nil {
+					transferSession := s.transferSession
+					fileCount := transferSession.TotalFiles
+					dir := transferSession.Dir
+					if dir == "" {
+						dir = prefs.UploadDir
+					}
+
+					// Show notification when user confirms upload (clicks Upload button)
+					if prefs.ShowNotifications {
+						if fileCount == 1 && len(transferSession.Files) > 0 {
+							// Single file - show specific file notification and open file
+							filePath := transferSession.Files[0]
+							action := utils.GetBestActionForFile(filePath)
+							utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
+								Title:    "LAN-Drop",
+								Content:  fmt.Sprintf("Received file: %s", filepath.Base(filePath)),
+								FilePath: filePath,
+								Action:   action,
+							})
+
+							// Auto-open single file if enabled
+							if prefs.AutoOpenFiles {
+								utils.HandleFileAction(filePath, action)
+							}
+						} else if folder := transfer.RootFolder(dir, transferSession.Files); folder != "" {
+							// A dropped folder - show notification and open that folder
+							utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
+								Title:    "LAN-Drop",
+								Content:  fmt.Sprintf("Received folder: %s (%d files)", filepath.Base(folder), len(transferSession.Files)),
+								FilePath: folder,
+								Action:   "show",
+							})
+
+							if prefs.AutoOpenFiles {
+								if err := utils.OpenFolder(folder); err != nil {
+									log.Printf("Failed to auto-open received folder: %v", err)
 								}
 							}
-						}
-
-						transferSession = nil
-						if fileCount == 1 {
-							reportStatus("File received")
 						} else {
-							reportStatus(fmt.Sprintf("Received %d files", fileCount))
+							// Multiple files or no files tracked yet - show generic notification and open folder
+							utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
+								Title:    "LAN-Drop",
+								Content:  fmt.Sprintf("Received %d files", fileCount),
+								FilePath: dir,
+								Action:   "show",
+							})
+
+							// Auto-open upload folder if enabled
+							if prefs.AutoOpenFiles {
+								if err := utils.OpenFolder(dir); err != nil {
+									log.Printf("Failed to auto-open upload folder: %v", err)
+								}
+							}
 						}
 					}
+
+					s.transferSession = nil
+					events.Publish(events.FilesReceived{Paths: transferSession.Files, Dir: dir, Method: "webrtc"})
+				}
+				return
+			case "text":
+				var textMsg struct {
+					Text       string `json:"text"`
+					DeviceName string `json:"device_name"`
+				}
+				if err := json.Unmarshal(msg.Data, &textMsg); err != nil {
+					log.Println("Failed to parse text message:", err)
 					return
 				}
-			}
-
-			// Handle file metadata (existing logic, but track in session)
-			var meta struct {
-				Name string `json:"name"`
-				Size int64  `json:"size"`
-			}
-			if err := json.Unmarshal(msg.Data, &meta); err != nil {
-				log.Println("Failed to parse file metadata:", err)
+				s.receiveTextLocked(textMsg.Text, textMsg.DeviceName)
 				return
 			}
+		}
 
-			// Create file
-			savePath := safeSavePath(prefs.UploadDir, meta.Name)
-			file, err := os.Create(savePath)
-			if err != nil {
-				dialog.ShowError(errors.New("failed to create file"), nil)
-				// log.Println("Failed to create file:", err)
-				return
-			}
-			currentFile = file
-			currentFileName = meta.Name
-			expectedFileSize = meta.Size
-			receivedBytes = 0
-
-			// Trim filename if longer than 10 characters
-			displayName := meta.Name
-			if len(displayName) > 10 {
-				displayName = displayName[:7] + "..."
-			}
+		// Handle file metadata (existing logic, but track in session)
+		var meta struct {
+			Name         string      `json:"name"`
+			RelativePath string      `json:"relativePath,omitempty"` // set for files of a dropped folder
+			Size         int64       `json:"size"`
+			SHA256       string      `json:"sha256,omitempty"`
+			LastModified json.Number `json:"lastModified,omitempty"` // milliseconds since the epoch
+			MIMEType     string      `json:"mimeType,omitempty"`
+			SessionID    string      `json:"sessionId,omitempty"`
+			Share        string      `json:"share,omitempty"` // writable named share to save into
+		}
+		if err := json.Unmarshal(msg.Data, &meta); err != nil {
+			log.Println("Failed to parse file metadata:", err)
+			return
+		}
+		digest, err := transfer.ParseDigest(meta.SHA256)
+		if err != nil {
+			log.Println("Ignoring invalid file digest:", err)
+		}
+		modTime, err := transfer.ParseLastModified(meta.LastModified.String())
+		if err != nil {
+			log.Println("Ignoring invalid modification time:", err)
+		}
 
-			reportStatus(fmt.Sprintf("Receiving: %s", displayName))
-		} else {
-			// Append chunk to file
-			if currentFile == nil {
-				dialog.ShowError(errors.New("error retrieving file"), nil)
-				// log.Println("Received data before metadata!")
-				return
-			}
+		// A new file replaces any transfer the peer abandoned half-way
+		s.abortCurrentFileLocked()
+		s.skipBytes = 0
 
-			_, err := currentFile.Write(msg.Data)
-			if err != nil {
-				dialog.ShowError(errors.New("error retrieving file"), nil)
-				// log.Println("Error writing chunk:", err)
-				return
+		rel, err := transfer.ReceivedPath(meta.Name, meta.RelativePath)
+		if err != nil {
+			if meta.RelativePath != "" {
+				meta.Name = meta.RelativePath
 			}
-			receivedBytes += int64(len(msg.Data))
+			s.skipBytes = meta.Size
+			s.sendJSONLocked(map[string]interface{}{
+				"type":    "file_rejected",
+				"name":    meta.Name,
+				"message": "The file name is not allowed",
+			})
+			events.Publish(events.TransferFailed{Name: meta.Name, Method: "webrtc", Reason: events.Rejected})
+			return
+		}
+		meta.Name = filepath.ToSlash(rel)
+
+		// In "ask before receiving" mode only approved files are written
+		if prefs.AskBeforeReceiving && !s.consumeApprovalLocked(meta.Name) {
+			s.skipBytes = meta.Size
+			s.sendJSONLocked(map[string]interface{}{
+				"type":    "file_rejected",
+				"name":    meta.Name,
+				"message": "The transfer was not approved on the desktop",
+			})
+			events.Publish(events.TransferFailed{Name: meta.Name, Method: "webrtc", Reason: events.Rejected})
+			return
+		}
 
-			if receivedBytes >= expectedFileSize {
-				// Trim filename if longer than 10 characters
-				displayName := currentFileName
-				if len(displayName) > 10 {
-					displayName = displayName[:7] + "..."
-				}
+		// Files go to the upload folder unless a writable share is named
+		dir, err := prefs.WriteDir(meta.Share)
+		if err == nil {
+			err = os.MkdirAll(dir, os.ModePerm)
+		}
+		if err != nil {
+			s.skipBytes = meta.Size
+			s.sendJSONLocked(map[string]interface{}{
+				"type":    "file_rejected",
+				"name":    meta.Name,
+				"message": shareRejection(err),
+			})
+			events.Publish(events.TransferFailed{Name: meta.Name, Method: "webrtc", Reason: events.Rejected})
+			return
+		}
+
+		// Receive into a hidden part file; the name is settled once it's complete
+		file, err := transfer.CreatePartFile(dir)
+		if err != nil {
+			dialog.ShowError(errors.New("failed to create file"), nil)
+			// log.Println("Failed to create file:", err)
+			return
+		}
+		s.currentFile = file
+		s.currentFileName = meta.Name
+		s.currentFileRel = rel
+		s.currentFileDir = dir
+		s.currentModTime = modTime
+		s.currentMIMEType = meta.MIMEType
+		s.currentSessionID = meta.SessionID
+		s.currentFilePath = file.Name()
+		s.expectedFileSize = meta.Size
+		s.receivedBytes = 0
+		s.fileHash = sha256.New()
+		s.fileStart = time.Now()
+		s.expectedDigest = digest
+		s.progress = progress.Default.Start(progress.Info{
+			SessionID: meta.SessionID,
+			Name:      meta.Name,
+			Method:    "webrtc",
+			Device:    s.deviceName,
+			Size:      meta.Size,
+		})
 
-				filePath := filepath.Join(prefs.UploadDir, currentFileName)
+		// Empty files have no chunks to wait for
+		if meta.Size == 0 {
+			s.finishCurrentFileLocked()
+		}
+	} else {
+		// Drop the chunks of a rejected file
+		if s.currentFile == nil && s.skipBytes > 0 {
+			s.skipBytes -= int64(len(msg.Data))
+			return
+		}
 
-				// log.Printf("✅ File %s received completely (%d bytes)\n", currentFileName, receivedBytes)
-				reportStatus(fmt.Sprintf("Received: %s", displayName))
+		// Append chunk to file
+		if s.currentFile == nil {
+			dialog.ShowError(errors.New("error retrieving file"), nil)
+			// log.Println("Received data before metadata!")
+			return
+		}
 
-				// Track file in transfer session
-				if transferSession != nil {
-					transferSession.Files = append(transferSession.Files, filePath)
-					transferSession.ReceivedFiles++
+		_, err := s.currentFile.Write(msg.Data)
+		if err != nil {
+			dialog.ShowError(errors.New("error retrieving file"), nil)
+			// log.Println("Error writing chunk:", err)
+			return
+		}
+		s.fileHash.Write(msg.Data)
+		s.receivedBytes += int64(len(msg.Data))
+		s.progress.Add(int64(len(msg.Data)))
 
-					// NO individual file notifications during auto-upload
-					// Notifications only happen on session_end when user clicks upload
-				} else {
-					// Legacy mode - single file without session (also no notification during auto-upload)
-					// User will get notification only when they click upload button
-				}
+		if s.receivedBytes >= s.expectedFileSize {
+			s.finishCurrentFileLocked()
+		}
+	}
+}
 
-				currentFile.Close()
-				currentFile = nil
-			}
+// handleTransferRequest asks the desktop user whether to accept the files
+// announced by the browser and sends the answer back.
+func (s *Session) handleTransferRequest(req transfer.Request) {
+	decision := transfer.RequestConsent(s.ctx, s.prefs, req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deviceName = req.DeviceName
+	if decision.Accepted {
+		for _, f := range req.Files {
+			s.approved[approvalKey(f.Name)]++
+		}
+	} else {
+		events.Publish(events.TransferDeclined{Device: req.DeviceName, Addr: s.remoteAddr, Reason: decision.Message})
+
+		entry := history.Entry{
+			Direction: history.Received,
+			Method:    "webrtc",
+			PeerAddr:  s.remoteAddr,
+			Device:    req.DeviceName,
+			Result:    history.Declined,
+			Error:     decision.Message,
 		}
+		for _, f := range req.Files {
+			entry.Files = append(entry.Files, history.File{Name: f.Name, Size: f.Size})
+		}
+		recordTransfer(entry)
+	}
+
+	s.sendJSONLocked(map[string]interface{}{
+		"type":     "transfer_response",
+		"accepted": decision.Accepted,
+		"message":  decision.Message,
+	})
+}
+
+// receiveTextLocked hands a text sent by the browser to the desktop and
+// tells the browser whether it arrived. The caller must hold s.mu.
+func (s *Session) receiveTextLocked(text, deviceName string) {
+	if deviceName == "" {
+		deviceName = s.deviceName
+	}
+	err := transfer.ReceiveText(transfer.TextMessage{
+		Text:       text,
+		DeviceName: deviceName,
+		RemoteAddr: s.remoteAddr,
+	})
+	if err != nil {
+		s.sendJSONLocked(map[string]string{"type": "text_rejected", "message": err.Error()})
+		return
+	}
+	s.sendJSONLocked(map[string]string{"type": "text_received"})
+	events.Publish(events.TextReceived{Device: deviceName})
+}
+
+// shareRejection explains to the browser why a file can't go to its share
+func shareRejection(err error) string {
+	switch {
+	case errors.Is(err, config.ErrShareNotFound):
+		return "The share doesn't exist on the desktop"
+	case errors.Is(err, config.ErrShareReadOnly):
+		return "The share is read-only"
+	default:
+		return "The share can't be written to"
+	}
+}
+
+// approvalKey returns the name an announced file will be received under, so
+// that approvals match the sanitized names of the files that follow
+func approvalKey(name string) string {
+	if rel, err := transfer.CleanRelativePath(name); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return name
+}
+
+// consumeApprovalLocked uses up one approval for the named file. The caller
+// must hold s.mu.
+func (s *Session) consumeApprovalLocked(name string) bool {
+	if s.approved[name] == 0 {
+		return false
+	}
+	s.approved[name]--
+	if s.approved[name] == 0 {
+		delete(s.approved, name)
+	}
+	return true
+}
+
+// finishCurrentFileLocked closes the file being received, verifies it, puts
+// it in place according to the conflict policy and records it in the transfer
+// session. A file that doesn't match the size or digest the browser announced
+// is deleted. The caller must hold s.mu, which is released while the conflict
+// is resolved since that may wait for the desktop user.
+func (s *Session) finishCurrentFileLocked() {
+	sum := s.fileHash.Sum(nil)
+	if s.receivedBytes != s.expectedFileSize || (s.expectedDigest != nil && !bytes.Equal(sum, s.expectedDigest)) {
+		s.rejectCorruptFileLocked()
+		return
+	}
+
+	s.currentFile.Close()
+	s.currentFile = nil
+	name, dir, rel, partPath := s.currentFileName, s.currentFileDir, s.currentFileRel, s.currentFilePath
+	tracked := s.progress
+	s.progress = nil
+	entry := s.fileEntryLocked(history.Completed)
+
+	in := transfer.IncomingFile{
+		Size:      entry.Files[0].Size,
+		SHA256:    sum,
+		ModTime:   s.currentModTime,
+		MIMEType:  s.currentMIMEType,
+		Device:    s.deviceName,
+		PeerAddr:  s.remoteAddr,
+		SessionID: s.currentSessionID,
+	}
+
+	s.mu.Unlock()
+	res, err := transfer.SaveFile(s.ctx, s.prefs, dir, rel, partPath, in)
+	s.mu.Lock()
+
+	if err != nil {
+		log.Printf("Failed to save %s: %v", name, err)
+		os.Remove(partPath)
+		events.Publish(events.TransferFailed{Name: name, Method: "webrtc", Reason: events.SaveFailed})
+		tracked.Finish(progress.Failed)
+		s.sendJSONLocked(map[string]interface{}{
+			"type":    "file_result",
+			"name":    name,
+			"ok":      false,
+			"message": "The file could not be saved on the desktop",
+		})
+		entry.Files[0].Path = ""
+		entry.Result, entry.Error = history.Failed, "Failed to save file"
+		recordTransfer(entry)
+		return
+	}
+
+	// log.Printf("✅ File %s received completely (%d bytes)\n", name, s.receivedBytes)
+	if res.Skip {
+		tracked.Finish(progress.Skipped)
+	} else {
+		tracked.Finish(progress.Completed)
+	}
+	events.Publish(events.FileReceived{
+		Name:    name,
+		Path:    res.Path,
+		Method:  "webrtc",
+		Device:  s.deviceName,
+		Size:    in.Size,
+		Skipped: res.Skip,
+	})
+	s.sendJSONLocked(map[string]interface{}{
+		"type":    "file_result",
+		"name":    name,
+		"ok":      true,
+		"skipped": res.Skip,
+		"sha256":  hex.EncodeToString(sum),
+	})
+
+	// Track file in transfer session
+	if s.transferSession != nil {
+		s.transferSession.Files = append(s.transferSession.Files, res.Path)
+		s.transferSession.Dir = dir
+		s.transferSession.ReceivedFiles++
+
+		// NO individual file notifications during auto-upload
+		// Notifications only happen on session_end when user clicks upload
+	} else {
+		// Legacy mode - single file without session (also no notification during auto-upload)
+		// User will get notification only when they click upload button
+	}
+
+	entry.Files[0].Path = res.Path
+	recordTransfer(entry)
+}
+
+// rejectCorruptFileLocked deletes a received file that failed verification
+// and tells the browser. The caller must hold s.mu.
+func (s *Session) rejectCorruptFileLocked() {
+	s.currentFile.Close()
+	s.currentFile = nil
+	if err := os.Remove(s.currentFilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove corrupt file %s: %v", s.currentFilePath, err)
+	}
+	log.Printf("Deleted corrupt file %s (%d/%d bytes)", s.currentFileName, s.receivedBytes, s.expectedFileSize)
+
+	events.Publish(events.TransferFailed{Name: s.currentFileName, Method: "webrtc", Reason: events.Corrupt})
+	s.progress.Finish(progress.Failed)
+	s.progress = nil
+	s.sendJSONLocked(map[string]interface{}{
+		"type":    "file_result",
+		"name":    s.currentFileName,
+		"ok":      false,
+		"message": "The file was corrupted in transit and has been deleted, please send it again",
+	})
+
+	entry := s.fileEntryLocked(history.Failed)
+	entry.Files[0].Path = ""
+	entry.Error = transfer.ErrChecksumMismatch.Error()
+	recordTransfer(entry)
+}
+
+// abortCurrentFileLocked discards a partially received file. The caller must
+// hold s.mu.
+func (s *Session) abortCurrentFileLocked() {
+	if s.currentFile == nil {
+		return
+	}
+
+	s.currentFile.Close()
+	if err := os.Remove(s.currentFilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove partial file %s: %v", s.currentFilePath, err)
 	}
+	log.Printf("Discarded incomplete file %s (%d/%d bytes)", s.currentFileName, s.receivedBytes, s.expectedFileSize)
+	s.currentFile = nil
+	s.progress.Finish(progress.Failed)
+	s.progress = nil
+
+	entry := s.fileEntryLocked(history.Failed)
+	entry.Files[0].Path = ""
+	entry.Error = "Transfer interrupted"
+	recordTransfer(entry)
 }
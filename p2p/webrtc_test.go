@@ -1,154 +1,47 @@
 package p2p
 
 import (
-	"os"
 	"path/filepath"
 	"testing"
-)
-
-// Mock status reporter for testing
-type mockStatusReporter struct {
-	messages []string
-}
-
-func (m *mockStatusReporter) ReportStatus(message string) {
-	m.messages = append(m.messages, message)
-}
-
-func (m *mockStatusReporter) getMessages() []string {
-	return m.messages
-}
-
-func (m *mockStatusReporter) reset() {
-	m.messages = nil
-}
-
-func TestSetStatusReporter(t *testing.T) {
-	// Reset any existing status reporter
-	statusReporter = nil
-
-	mock := &mockStatusReporter{}
-	SetStatusReporter(mock)
-
-	if statusReporter == nil {
-		t.Error("SetStatusReporter did not set the status reporter")
-	}
-
-	if statusReporter != mock {
-		t.Error("SetStatusReporter did not set the correct status reporter")
-	}
-}
-
-func TestReportStatusWithReporter(t *testing.T) {
-	mock := &mockStatusReporter{}
-	SetStatusReporter(mock)
-
-	testMessage := "test status message"
-	reportStatus(testMessage)
-
-	messages := mock.getMessages()
-	if len(messages) != 1 {
-		t.Errorf("Expected 1 message, got %d", len(messages))
-	}
-
-	if messages[0] != testMessage {
-		t.Errorf("Expected '%s', got '%s'", testMessage, messages[0])
-	}
-}
-
-func TestReportStatusWithoutReporter(t *testing.T) {
-	// Set status reporter to nil
-	statusReporter = nil
-
-	// This should not panic
-	reportStatus("test message")
-}
 
-func TestReportStatusMultipleMessages(t *testing.T) {
-	mock := &mockStatusReporter{}
-	SetStatusReporter(mock)
-
-	messages := []string{"message 1", "message 2", "message 3"}
-	for _, msg := range messages {
-		reportStatus(msg)
-	}
-
-	receivedMessages := mock.getMessages()
-	if len(receivedMessages) != len(messages) {
-		t.Errorf("Expected %d messages, got %d", len(messages), len(receivedMessages))
-	}
-
-	for i, msg := range messages {
-		if receivedMessages[i] != msg {
-			t.Errorf("Message %d: expected '%s', got '%s'", i, msg, receivedMessages[i])
-		}
-	}
-}
-
-func TestSafeSavePath(t *testing.T) {
-	tempDir := t.TempDir()
-
-	testCases := []struct {
-		name     string
-		filename string
-		expected string
-	}{
-		{"simple filename", "test.txt", "test.txt"},
-		{"no extension", "test", "test"},
-		{"multiple dots", "test.backup.txt", "test.backup.txt"},
-		{"hidden file", ".hidden", ".hidden"},
-		{"complex filename", "My Document (1).pdf", "My Document (1).pdf"},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			result := safeSavePath(tempDir, tc.filename)
-			expectedPath := filepath.Join(tempDir, tc.expected)
+	"lan-drop/config"
+	"lan-drop/events"
+)
 
-			if result != expectedPath {
-				t.Errorf("Expected %s, got %s", expectedPath, result)
-			}
-		})
-	}
-}
+func TestSessionPublishesEvents(t *testing.T) {
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
 
-func TestSafeSevePathWithConflicts(t *testing.T) {
 	tempDir := t.TempDir()
-
-	// Create a test file
-	originalFile := filepath.Join(tempDir, "test.txt")
-	if err := os.WriteFile(originalFile, []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
+	session := NewSession(nil, &config.Preferences{UploadDir: tempDir})
+	defer session.Close()
+
+	session.onDataChannelMessage(metadataMessage(t, "kept.txt", 5))
+	session.onDataChannelMessage(chunkMessage("hello"))
+	session.onDataChannelMessage(metadataMessage(t, "broken.txt", 3))
+	session.onDataChannelMessage(chunkMessage("abcdef"))
+
+	var got []events.Event
+	for len(received) > 0 {
+		switch e := (<-received).(type) {
+		case events.FileReceived, events.TransferFailed:
+			got = append(got, e)
+		}
 	}
 
-	// First call should return the _1 version
-	result1 := safeSavePath(tempDir, "test.txt")
-	expected1 := filepath.Join(tempDir, "test_1.txt")
-	if result1 != expected1 {
-		t.Errorf("First conflict: expected %s, got %s", expected1, result1)
+	expected := []events.Event{
+		events.FileReceived{Name: "kept.txt", Path: filepath.Join(tempDir, "kept.txt"), Method: "webrtc", Size: 5},
+		events.TransferFailed{Name: "broken.txt", Method: "webrtc", Reason: events.Corrupt},
 	}
-
-	// Create the _1 file
-	if err := os.WriteFile(expected1, []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create _1 file: %v", err)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, got %#v", len(expected), got)
 	}
-
-	// Second call should return the _2 version
-	result2 := safeSavePath(tempDir, "test.txt")
-	expected2 := filepath.Join(tempDir, "test_2.txt")
-	if result2 != expected2 {
-		t.Errorf("Second conflict: expected %s, got %s", expected2, result2)
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Event %d: expected %#v, got %#v", i, expected[i], got[i])
+		}
 	}
-}
-
-func TestSafeSavePathNoDir(t *testing.T) {
-	// Test with a non-existing directory
-	nonExistentDir := filepath.Join(os.TempDir(), "nonexistentdir")
-
-	result := safeSavePath(nonExistentDir, "file.txt")
-	expected := filepath.Join(nonExistentDir, "file.txt")
-
-	if result != expected {
-		t.Errorf("Expected %s, got %s", expected, result)
+	if status := got[0].Status(); status != "Received: kept.txt" {
+		t.Errorf("Expected the status label to read 'Received: kept.txt', got '%s'", status)
 	}
 }
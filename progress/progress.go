@@ -0,0 +1,327 @@
+// Package progress follows files while they are being transferred and
+// streams their progress to subscribers, such as the browser and the desktop
+// window.
+package progress
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"lan-drop/events"
+)
+
+// emitInterval limits how often a file's progress is published
+const emitInterval = 250 * time.Millisecond
+
+// sessionIdle is how long a session's totals are kept after its last file
+const sessionIdle = time.Minute
+
+// Result is how a transfer ended
+type Result string
+
+const (
+	Completed Result = "completed"
+	Failed    Result = "failed"
+	Skipped   Result = "skipped" // the file was identical to one already there
+)
+
+// Totals describes the files of a transfer session
+type Totals struct {
+	Files     int     `json:"files"`
+	DoneFiles int     `json:"done_files"`
+	Bytes     int64   `json:"bytes"`
+	Size      int64   `json:"size"`
+	Rate      float64 `json:"rate"`        // bytes per second
+	ETA       float64 `json:"eta_seconds"` // 0 when unknown
+}
+
+// Event is the progress of one file
+type Event struct {
+	ID        string  `json:"id"`
+	SessionID string  `json:"session_id,omitempty"`
+	Name      string  `json:"name"`
+	Method    string  `json:"method"` // upload, webrtc
+	Device    string  `json:"device,omitempty"`
+	Bytes     int64   `json:"bytes"`
+	Size      int64   `json:"size"`
+	Rate      float64 `json:"rate"`        // bytes per second
+	ETA       float64 `json:"eta_seconds"` // 0 when unknown
+	Done      bool    `json:"done"`
+	Result    Result  `json:"result,omitempty"`
+	Session   *Totals `json:"session,omitempty"`
+}
+
+// Info describes a file when its transfer starts
+type Info struct {
+	SessionID   string
+	Name        string
+	Method      string
+	Device      string
+	Size        int64 // 0 when unknown
+	SessionSize int64 // size of the whole session when known up front
+	Offset      int64 // bytes already transferred, when resuming a file
+}
+
+// Hub keeps the files being transferred and the subscribers to their progress
+type Hub struct {
+	mu          sync.Mutex
+	bus         *events.Bus // also told when files start and progress, if set
+	now         func() time.Time
+	nextID      int64
+	active      map[string]*Transfer
+	sessions    map[string]*session
+	subscribers map[chan Event]struct{}
+}
+
+type session struct {
+	totals    Totals
+	start     time.Time
+	lastSeen  time.Time
+	fixedSize bool // totals.Size came from Info.SessionSize
+}
+
+// NewHub creates an empty Hub that also publishes on bus, which may be nil
+func NewHub(bus *events.Bus) *Hub {
+	return &Hub{
+		bus:         bus,
+		now:         time.Now,
+		active:      make(map[string]*Transfer),
+		sessions:    make(map[string]*session),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Default is the hub the server and the desktop window share
+var Default = NewHub(events.Default)
+
+// Transfer is one file being transferred. A nil *Transfer ignores every
+// call, so callers needn't check whether progress is tracked.
+type Transfer struct {
+	hub      *Hub
+	info     Info
+	id       string
+	bytes    int64
+	start    time.Time
+	lastEmit time.Time
+	done     bool
+}
+
+// Start begins following a file and publishes its first event
+func (h *Hub) Start(info Info) *Transfer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	h.nextID++
+	t := &Transfer{hub: h, info: info, id: strconv.FormatInt(h.nextID, 10), bytes: info.Offset, start: now, lastEmit: now}
+	h.active[t.id] = t
+
+	if info.SessionID != "" {
+		for id, s := range h.sessions {
+			if now.Sub(s.lastSeen) > sessionIdle {
+				delete(h.sessions, id)
+			}
+		}
+		s := h.sessions[info.SessionID]
+		if s == nil {
+			s = &session{start: now}
+			if info.SessionSize > 0 {
+				s.totals.Size, s.fixedSize = info.SessionSize, true
+			}
+			h.sessions[info.SessionID] = s
+		}
+		s.totals.Files++
+		s.totals.Bytes += info.Offset
+		if !s.fixedSize {
+			s.totals.Size += info.Size
+		}
+		s.lastSeen = now
+	}
+
+	h.publishLocked(t.eventLocked(now))
+	if h.bus != nil {
+		h.bus.Publish(events.TransferStarted{
+			ID:        t.id,
+			SessionID: info.SessionID,
+			Name:      info.Name,
+			Method:    info.Method,
+			Device:    info.Device,
+			Size:      info.Size,
+		})
+	}
+	return t
+}
+
+// Add records n more bytes transferred
+func (t *Transfer) Add(n int64) {
+	if t == nil {
+		return
+	}
+	h := t.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t.done {
+		return
+	}
+
+	t.bytes += n
+	now := h.now()
+	if s := h.sessions[t.info.SessionID]; s != nil {
+		s.totals.Bytes += n
+		s.lastSeen = now
+	}
+	if now.Sub(t.lastEmit) >= emitInterval {
+		t.lastEmit = now
+		e := t.eventLocked(now)
+		h.publishLocked(e)
+		if h.bus != nil {
+			h.bus.Publish(events.TransferProgress{
+				ID:        e.ID,
+				SessionID: e.SessionID,
+				Name:      e.Name,
+				Method:    e.Method,
+				Bytes:     e.Bytes,
+				Size:      e.Size,
+				Rate:      e.Rate,
+				ETA:       e.ETA,
+			})
+		}
+	}
+}
+
+// Finish publishes the file's last event and stops following it. Calling it
+// again has no effect.
+func (t *Transfer) Finish(result Result) {
+	if t == nil {
+		return
+	}
+	h := t.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t.done {
+		return
+	}
+
+	t.done = true
+	delete(h.active, t.id)
+	now := h.now()
+	if s := h.sessions[t.info.SessionID]; s != nil {
+		s.totals.DoneFiles++
+		if result != Completed && !s.fixedSize && t.info.Size > t.bytes {
+			// The bytes won't arrive, so the session is smaller than announced
+			s.totals.Size -= t.info.Size - t.bytes
+		}
+		s.lastSeen = now
+	}
+
+	e := t.eventLocked(now)
+	e.Done = true
+	e.Result = result
+	e.ETA = 0
+	h.publishLocked(e)
+}
+
+// Reader returns a reader that records the bytes read from r
+func (t *Transfer) Reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, t: t}
+}
+
+type countingReader struct {
+	r io.Reader
+	t *Transfer
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.t.Add(int64(n))
+	return n, err
+}
+
+// NewSessionID returns a session ID for transfers whose client sent none
+func (h *Hub) NewSessionID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	return "session-" + strconv.FormatInt(h.nextID, 10)
+}
+
+// Active returns the latest progress of the files being transferred
+func (h *Hub) Active() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	events := make([]Event, 0, len(h.active))
+	for _, t := range h.active {
+		events = append(events, t.eventLocked(now))
+	}
+	return events
+}
+
+// Subscribe returns a channel receiving every event published from now on
+// and a function that ends the subscription. Events are dropped for a
+// subscriber that doesn't keep up.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, ch)
+			h.mu.Unlock()
+		})
+	}
+}
+
+func (h *Hub) publishLocked(e Event) {
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// eventLocked describes the transfer at now. The caller must hold the hub's
+// lock.
+func (t *Transfer) eventLocked(now time.Time) Event {
+	// A resumed file's rate only counts the bytes sent since
+	rate, eta := estimate(t.bytes-t.info.Offset, t.info.Size-t.info.Offset, now.Sub(t.start))
+	e := Event{
+		ID:        t.id,
+		SessionID: t.info.SessionID,
+		Name:      t.info.Name,
+		Method:    t.info.Method,
+		Device:    t.info.Device,
+		Bytes:     t.bytes,
+		Size:      t.info.Size,
+		Rate:      rate,
+		ETA:       eta,
+	}
+	if s := t.hub.sessions[t.info.SessionID]; s != nil {
+		totals := s.totals
+		totals.Rate, totals.ETA = estimate(totals.Bytes, totals.Size, now.Sub(s.start))
+		e.Session = &totals
+	}
+	return e
+}
+
+// estimate returns the average rate in bytes per second and the seconds
+// left at that rate, both 0 until there is something to go by
+func estimate(bytes, size int64, elapsed time.Duration) (float64, float64) {
+	if bytes <= 0 || elapsed <= 0 {
+		return 0, 0
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	if size <= bytes {
+		return rate, 0
+	}
+	return rate, float64(size-bytes) / rate
+}
@@ -0,0 +1,188 @@
+package progress
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-drop/events"
+)
+
+// newTestHub returns a hub whose clock only moves when advance is called
+func newTestHub() (*Hub, func(time.Duration)) {
+	h := NewHub(nil)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+	return h, func(d time.Duration) { now = now.Add(d) }
+}
+
+// drain returns the events waiting on ch
+func drain(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestTransferEvents(t *testing.T) {
+	h, advance := newTestHub()
+	events, cancel := h.Subscribe()
+	defer cancel()
+
+	tr := h.Start(Info{SessionID: "s1", Name: "video.mp4", Method: "webrtc", Size: 1000})
+	advance(100 * time.Millisecond)
+	tr.Add(100) // too soon to publish
+	advance(400 * time.Millisecond)
+	tr.Add(400)
+
+	got := drain(events)
+	if len(got) != 2 {
+		t.Fatalf("Expected a start and a progress event, got %+v", got)
+	}
+	last := got[1]
+	if last.Bytes != 500 || last.Size != 1000 || last.Done {
+		t.Errorf("Expected 500 of 1000 bytes, got %+v", last)
+	}
+	if last.Rate != 1000 || last.ETA != 0.5 {
+		t.Errorf("Expected 1000 B/s and 0.5s left, got %v B/s and %vs", last.Rate, last.ETA)
+	}
+	if last.Session == nil || last.Session.Files != 1 || last.Session.Bytes != 500 {
+		t.Errorf("Expected the session totals, got %+v", last.Session)
+	}
+	if active := h.Active(); len(active) != 1 || active[0].Bytes != 500 {
+		t.Errorf("Expected the file to be active, got %+v", active)
+	}
+
+	tr.Add(500)
+	tr.Finish(Completed)
+	tr.Finish(Failed) // ignored
+	got = drain(events)
+	if len(got) != 1 || !got[0].Done || got[0].Result != Completed || got[0].Bytes != 1000 {
+		t.Errorf("Expected a single completed event, got %+v", got)
+	}
+	if active := h.Active(); len(active) != 0 {
+		t.Errorf("Expected no active files, got %+v", active)
+	}
+}
+
+func TestSessionTotals(t *testing.T) {
+	h, advance := newTestHub()
+
+	first := h.Start(Info{SessionID: "s1", Name: "a.txt", Size: 100})
+	second := h.Start(Info{SessionID: "s1", Name: "b.txt", Size: 300})
+	other := h.Start(Info{SessionID: "s2", Name: "c.txt", Size: 50})
+	advance(time.Second)
+	first.Add(100)
+	first.Finish(Completed)
+	second.Add(100)
+	second.Finish(Failed) // the 200 missing bytes leave the session
+	other.Add(10)
+
+	events, cancel := h.Subscribe()
+	defer cancel()
+	third := h.Start(Info{SessionID: "s1", Name: "d.txt", Size: 50})
+
+	got := drain(events)
+	if len(got) != 1 {
+		t.Fatalf("Expected one event, got %+v", got)
+	}
+	totals := got[0].Session
+	expected := Totals{Files: 3, DoneFiles: 2, Bytes: 200, Size: 250, Rate: 200, ETA: 0.25}
+	if totals == nil || *totals != expected {
+		t.Errorf("Expected %+v, got %+v", expected, totals)
+	}
+
+	// A session idle for long enough starts over
+	third.Finish(Completed)
+	advance(2 * sessionIdle)
+	h.Start(Info{SessionID: "s1", Name: "e.txt", Size: 10})
+	got = drain(events)
+	if last := got[len(got)-1]; last.Session.Files != 1 || last.Session.Bytes != 0 {
+		t.Errorf("Expected a fresh session, got %+v", last.Session)
+	}
+}
+
+func TestNilTransfer(t *testing.T) {
+	var tr *Transfer
+	tr.Add(10)
+	tr.Finish(Completed)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h, _ := newTestHub()
+	events, cancel := h.Subscribe()
+	cancel()
+	cancel()
+
+	h.Start(Info{Name: "a.txt"})
+	if got := drain(events); len(got) != 0 {
+		t.Errorf("Expected no events after unsubscribing, got %+v", got)
+	}
+}
+
+func TestReaderAndSessionSize(t *testing.T) {
+	h, advance := newTestHub()
+	id := h.NewSessionID()
+
+	// The size of an uploaded file is only known once it has been read
+	tr := h.Start(Info{SessionID: id, Name: "a.txt", SessionSize: 10})
+	advance(time.Second)
+	data, err := io.ReadAll(tr.Reader(strings.NewReader("hello")))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Expected the data to pass through, got %q (%v)", data, err)
+	}
+
+	active := h.Active()
+	if len(active) != 1 || active[0].Bytes != 5 || active[0].ETA != 0 {
+		t.Fatalf("Expected 5 bytes with no file ETA, got %+v", active)
+	}
+	expected := Totals{Files: 1, Bytes: 5, Size: 10, Rate: 5, ETA: 1}
+	if totals := active[0].Session; totals == nil || *totals != expected {
+		t.Errorf("Expected %+v, got %+v", expected, totals)
+	}
+}
+
+func TestResumedTransfer(t *testing.T) {
+	h, advance := newTestHub()
+
+	// Half of the file arrived before, so only the rest counts toward the rate
+	tr := h.Start(Info{Name: "movie.mp4", Size: 100, Offset: 50})
+	advance(time.Second)
+	tr.Add(10)
+
+	active := h.Active()
+	if len(active) != 1 || active[0].Bytes != 60 || active[0].Rate != 10 || active[0].ETA != 4 {
+		t.Errorf("Expected 60 bytes at 10 B/s with 4s left, got %+v", active)
+	}
+}
+
+func TestHubPublishesOnBus(t *testing.T) {
+	bus := events.NewBus()
+	published, cancel := bus.Subscribe()
+	defer cancel()
+
+	h, advance := newTestHub()
+	h.bus = bus
+	tr := h.Start(Info{Name: "a.txt", Method: "webrtc", Size: 10})
+	advance(time.Second)
+	tr.Add(5)
+	tr.Finish(Completed)
+
+	started, ok := (<-published).(events.TransferStarted)
+	if !ok || started.Name != "a.txt" || started.Size != 10 {
+		t.Errorf("Expected TransferStarted, got %#v", started)
+	}
+	moved, ok := (<-published).(events.TransferProgress)
+	if !ok || moved.Bytes != 5 || moved.Rate != 5 {
+		t.Errorf("Expected TransferProgress, got %#v", moved)
+	}
+	if len(published) != 0 {
+		t.Errorf("Expected the end to be left to the code saving the file, got %d more events", len(published))
+	}
+}
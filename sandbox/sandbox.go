@@ -0,0 +1,288 @@
+// Package sandbox confines file access to a directory. Every path handed to
+// a Root is relative to its directory, and neither "..", absolute paths nor
+// symbolic links can reach anything outside of it.
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrOutside is returned for a path that leads out of the directory
+var ErrOutside = errors.New("path is outside the folder")
+
+// Root gives access to the files under a directory
+type Root struct {
+	dir  string
+	root *os.Root // nil when links may lead out of dir
+}
+
+// Open opens dir as a Root. With followLinks, symbolic links are followed
+// wherever they point; otherwise links are only followed while they stay
+// inside dir.
+func Open(dir string, followLinks bool) (*Root, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if followLinks {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+		return &Root{dir: dir}, nil
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Root{dir: dir, root: root}, nil
+}
+
+// Close releases the directory
+func (r *Root) Close() error {
+	if r.root == nil {
+		return nil
+	}
+	return r.root.Close()
+}
+
+// Dir returns the absolute path of the directory
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// Clean checks that name, using "/" or the OS separator, stays inside the
+// directory and returns it with the OS separator. The directory itself is ".".
+func Clean(name string) (string, error) {
+	name = filepath.Clean(filepath.FromSlash(name))
+	if !filepath.IsLocal(name) {
+		return "", ErrOutside
+	}
+	return name, nil
+}
+
+// FullPath returns the path of name on disk, for display and logging. It
+// doesn't follow links; use the other methods to access the file.
+func (r *Root) FullPath(name string) (string, error) {
+	name, err := Clean(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(r.dir, name), nil
+}
+
+// Name returns the name under the directory of path, a path on disk such as
+// one FullPath returned. It doesn't follow links.
+func (r *Root) Name(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	name, err := filepath.Rel(r.dir, path)
+	if err != nil || !filepath.IsLocal(name) {
+		return "", ErrOutside
+	}
+	return name, nil
+}
+
+// Open opens the named file for reading
+func (r *Root) Open(name string) (*os.File, error) {
+	name, err := Clean(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.root == nil {
+		return os.Open(filepath.Join(r.dir, name))
+	}
+	f, err := r.root.Open(name)
+	return f, r.checkEscape(name, err)
+}
+
+// Stat returns information about the named file, following links
+func (r *Root) Stat(name string) (fs.FileInfo, error) {
+	name, err := Clean(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.root == nil {
+		return os.Stat(filepath.Join(r.dir, name))
+	}
+	info, err := r.root.Stat(name)
+	return info, r.checkEscape(name, err)
+}
+
+// ReadDir lists the named directory sorted by name
+func (r *Root) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// Remove removes the named file or empty directory. A link is removed
+// itself, never what it points to.
+func (r *Root) Remove(name string) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return ErrOutside
+	}
+	if r.root == nil {
+		return os.Remove(filepath.Join(r.dir, name))
+	}
+	return r.checkEscape(name, r.root.Remove(name))
+}
+
+// OpenFile opens the named file with the given flags, like os.OpenFile
+func (r *Root) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	name, err := Clean(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.root == nil {
+		return os.OpenFile(filepath.Join(r.dir, name), flag, perm)
+	}
+	f, err := r.root.OpenFile(name, flag, perm)
+	return f, r.checkEscape(name, err)
+}
+
+// Mkdir creates the named directory
+func (r *Root) Mkdir(name string, perm fs.FileMode) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if r.root == nil {
+		return os.Mkdir(filepath.Join(r.dir, name), perm)
+	}
+	return r.checkEscape(name, r.root.Mkdir(name, perm))
+}
+
+// MkdirAll creates the named directory and any missing parents
+func (r *Root) MkdirAll(name string, perm fs.FileMode) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if r.root == nil {
+		return os.MkdirAll(filepath.Join(r.dir, name), perm)
+	}
+	return r.checkEscape(name, r.root.MkdirAll(name, perm))
+}
+
+// WriteFile writes data to the named file, creating it if needed
+func (r *Root) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if r.root == nil {
+		return os.WriteFile(filepath.Join(r.dir, name), data, perm)
+	}
+	return r.checkEscape(name, r.root.WriteFile(name, data, perm))
+}
+
+// Chtimes changes the access and modification times of the named file
+func (r *Root) Chtimes(name string, atime, mtime time.Time) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if r.root == nil {
+		return os.Chtimes(filepath.Join(r.dir, name), atime, mtime)
+	}
+	return r.checkEscape(name, r.root.Chtimes(name, atime, mtime))
+}
+
+// RemoveAll removes the named file, or the directory and everything in it.
+// Links are removed themselves, never what they point to.
+func (r *Root) RemoveAll(name string) error {
+	name, err := Clean(name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return ErrOutside
+	}
+	if r.root == nil {
+		return os.RemoveAll(filepath.Join(r.dir, name))
+	}
+	return r.checkEscape(name, r.root.RemoveAll(name))
+}
+
+// Rename moves oldname to newname, replacing a file already there
+func (r *Root) Rename(oldname, newname string) error {
+	oldname, err := Clean(oldname)
+	if err != nil {
+		return err
+	}
+	newname, err = Clean(newname)
+	if err != nil {
+		return err
+	}
+	if oldname == "." || newname == "." {
+		return ErrOutside
+	}
+	if r.root == nil {
+		return os.Rename(filepath.Join(r.dir, oldname), filepath.Join(r.dir, newname))
+	}
+	if err := r.root.Rename(oldname, newname); err != nil {
+		// Either side may be the one leading out of the directory
+		if escaped := r.checkEscape(oldname, err); escaped != err {
+			return escaped
+		}
+		return r.checkEscape(newname, err)
+	}
+	return nil
+}
+
+// FS returns the directory as an fs.FS, with the same confinement
+func (r *Root) FS() fs.FS {
+	if r.root == nil {
+		return os.DirFS(r.dir)
+	}
+	return r.root.FS()
+}
+
+// checkEscape turns the error os.Root gives for a link out of the directory
+// into ErrOutside, so callers can tell it from a missing or unreadable file.
+// The deepest part of name that exists is resolved, since a link may lead to
+// a missing file outside.
+func (r *Root) checkEscape(name string, err error) error {
+	if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	realDir, dirErr := filepath.EvalSymlinks(r.dir)
+	if dirErr != nil {
+		return err
+	}
+	for ; name != "."; name = filepath.Dir(name) {
+		realPath, pathErr := filepath.EvalSymlinks(filepath.Join(r.dir, name))
+		if pathErr != nil {
+			continue
+		}
+		if rel, relErr := filepath.Rel(realDir, realPath); relErr != nil || !filepath.IsLocal(rel) {
+			return ErrOutside
+		}
+		break
+	}
+	return err
+}
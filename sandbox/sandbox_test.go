@@ -0,0 +1,314 @@
+package sandbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestTree creates a "shared" folder next to a "shared-private" sibling,
+// with links out of and inside the shared folder
+func newTestTree(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	shared := filepath.Join(base, "shared")
+	private := filepath.Join(base, "shared-private")
+
+	for _, dir := range []string{filepath.Join(shared, "docs"), private} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(shared, "docs", "readme.txt"): "public",
+		filepath.Join(private, "secret.txt"):        "secret",
+		filepath.Join(base, "outside.txt"):          "outside",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	links := map[string]string{
+		filepath.Join(shared, "private"):     private,
+		filepath.Join(shared, "outside.txt"): filepath.Join(base, "outside.txt"),
+		filepath.Join(shared, "relative"):    filepath.Join("..", "shared-private"),
+		filepath.Join(shared, "inside.txt"):  filepath.Join("docs", "readme.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+	}
+	return shared
+}
+
+func TestRootConfinesPaths(t *testing.T) {
+	shared := newTestTree(t)
+	root, err := Open(shared, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected string // content, "" if the path must be refused
+	}{
+		{"plain file", "docs/readme.txt", "public"},
+		{"OS separator", filepath.Join("docs", "readme.txt"), "public"},
+		{"dot dot inside", "docs/../docs/readme.txt", "public"},
+		{"link inside", "inside.txt", "public"},
+		{"prefix sibling", "../shared-private/secret.txt", ""},
+		{"parent", "../outside.txt", ""},
+		{"dot dot past root", "docs/../../outside.txt", ""},
+		{"absolute", filepath.Join(filepath.Dir(shared), "outside.txt"), ""},
+		{"absolute inside", filepath.Join(shared, "docs", "readme.txt"), ""},
+		{"link to sibling", "private/secret.txt", ""},
+		{"link to file outside", "outside.txt", ""},
+		{"link to missing file outside", "private/missing.txt", ""},
+		{"relative link to sibling", "relative/secret.txt", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := root.Open(tc.path)
+			if tc.expected == "" {
+				if err == nil {
+					f.Close()
+					t.Fatalf("Expected %q to be refused", tc.path)
+				}
+				if !errors.Is(err, ErrOutside) {
+					t.Errorf("Expected ErrOutside, got %v", err)
+				}
+				if _, err := root.Stat(tc.path); !errors.Is(err, ErrOutside) {
+					t.Errorf("Expected Stat to be refused with ErrOutside, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer f.Close()
+			if content, _ := io.ReadAll(f); string(content) != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, content)
+			}
+		})
+	}
+}
+
+func TestRootFollowsLinksWhenAllowed(t *testing.T) {
+	shared := newTestTree(t)
+	root, err := Open(shared, true)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	f, err := root.Open("private/secret.txt")
+	if err != nil {
+		t.Fatalf("Expected the link to be followed, got %v", err)
+	}
+	f.Close()
+
+	// Following links never allows ".." or absolute paths
+	for _, path := range []string{"../shared-private/secret.txt", filepath.Join(filepath.Dir(shared), "outside.txt")} {
+		if _, err := root.Open(path); !errors.Is(err, ErrOutside) {
+			t.Errorf("Expected %q to be refused, got %v", path, err)
+		}
+	}
+}
+
+func TestRootRemove(t *testing.T) {
+	shared := newTestTree(t)
+	root, err := Open(shared, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	if err := root.Remove("../shared-private/secret.txt"); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected removing from a sibling to be refused, got %v", err)
+	}
+	if err := root.Remove("private/secret.txt"); err == nil {
+		t.Error("Expected removing through a link to be refused")
+	}
+	if err := root.Remove("."); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected removing the folder itself to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(shared), "shared-private", "secret.txt")); err != nil {
+		t.Errorf("Expected the file outside to be untouched: %v", err)
+	}
+
+	// Removing a link removes the link, not its target
+	if err := root.Remove("outside.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(shared), "outside.txt")); err != nil {
+		t.Errorf("Expected the link target to be untouched: %v", err)
+	}
+	if err := root.Remove("docs/readme.txt"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+}
+
+func TestRootReadDirAndFS(t *testing.T) {
+	shared := newTestTree(t)
+	root, err := Open(shared, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	entries, err := root.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"docs", "inside.txt", "outside.txt", "private", "relative"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, names)
+			break
+		}
+	}
+
+	if _, err := root.ReadDir("private"); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected listing a linked sibling to be refused, got %v", err)
+	}
+	if _, err := fs.ReadFile(root.FS(), "private/secret.txt"); err == nil {
+		t.Error("Expected the FS to refuse links out of the folder")
+	}
+	if content, err := fs.ReadFile(root.FS(), "docs/readme.txt"); err != nil || string(content) != "public" {
+		t.Errorf("Expected 'public', got %q (%v)", content, err)
+	}
+}
+
+func TestRootWrites(t *testing.T) {
+	shared := newTestTree(t)
+	private := filepath.Join(filepath.Dir(shared), "shared-private")
+	root, err := Open(shared, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	f, err := root.OpenFile("docs/new.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Write([]byte("new"))
+	f.Close()
+	if err := root.Mkdir("docs/sub", 0755); err != nil {
+		t.Errorf("Mkdir failed: %v", err)
+	}
+	if err := root.Rename("docs/new.txt", "docs/sub/moved.txt"); err != nil {
+		t.Errorf("Rename failed: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(shared, "docs", "sub", "moved.txt")); err != nil || string(content) != "new" {
+		t.Errorf("Expected the file to be moved, got %q (%v)", content, err)
+	}
+	if err := root.MkdirAll("docs/a/b", 0755); err != nil {
+		t.Errorf("MkdirAll failed: %v", err)
+	}
+	if err := root.WriteFile("docs/a/b/note.txt", []byte("note"), 0644); err != nil {
+		t.Errorf("WriteFile failed: %v", err)
+	}
+	modTime := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	if err := root.Chtimes("docs/a/b/note.txt", modTime, modTime); err != nil {
+		t.Errorf("Chtimes failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(shared, "docs", "a", "b", "note.txt")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected the note with its modification time, got %v", err)
+	}
+
+	// Nothing may be written through a link out of the folder
+	if _, err := root.OpenFile("private/new.txt", os.O_WRONLY|os.O_CREATE, 0644); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected creating through a link to be refused, got %v", err)
+	}
+	if err := root.Mkdir("private/sub", 0755); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected Mkdir through a link to be refused, got %v", err)
+	}
+	if err := root.MkdirAll("private/a/b", 0755); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected MkdirAll through a link to be refused, got %v", err)
+	}
+	if err := root.WriteFile("private/new.txt", []byte("new"), 0644); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected WriteFile through a link to be refused, got %v", err)
+	}
+	if err := root.Chtimes("outside.txt", modTime, modTime); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected Chtimes through a link to be refused, got %v", err)
+	}
+	if err := root.Rename("docs/sub/moved.txt", "private/moved.txt"); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected moving out through a link to be refused, got %v", err)
+	}
+	if err := root.Rename("private/secret.txt", "stolen.txt"); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected moving in through a link to be refused, got %v", err)
+	}
+	if err := root.Rename("docs", "."); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected replacing the folder itself to be refused, got %v", err)
+	}
+	if err := root.RemoveAll("."); !errors.Is(err, ErrOutside) {
+		t.Errorf("Expected removing the folder itself to be refused, got %v", err)
+	}
+
+	// RemoveAll removes a link, not what it points to
+	if err := root.RemoveAll("private"); err != nil {
+		t.Errorf("RemoveAll failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(private, "secret.txt")); err != nil {
+		t.Errorf("Expected the linked folder to be untouched: %v", err)
+	}
+	if err := root.RemoveAll("docs"); err != nil {
+		t.Errorf("RemoveAll failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(shared, "docs")); !os.IsNotExist(err) {
+		t.Errorf("Expected docs to be removed, got %v", err)
+	}
+}
+
+func TestRootName(t *testing.T) {
+	shared := newTestTree(t)
+	root, err := Open(shared, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected string // "" if the path must be refused
+	}{
+		{"file", filepath.Join(shared, "docs", "readme.txt"), filepath.Join("docs", "readme.txt")},
+		{"folder itself", shared, "."},
+		{"sibling", filepath.Join(filepath.Dir(shared), "shared-private", "secret.txt"), ""},
+		{"parent", filepath.Dir(shared), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := root.Name(tc.path)
+			if tc.expected == "" {
+				if !errors.Is(err, ErrOutside) {
+					t.Errorf("Expected ErrOutside, got %q (%v)", name, err)
+				}
+				return
+			}
+			if err != nil || name != tc.expected {
+				t.Errorf("Expected %q, got %q (%v)", tc.expected, name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/progress"
+	"lan-drop/transfer"
+)
+
+// apiFilesPath is where scripts list, download and upload files
+const apiFilesPath = api.Prefix + "files"
+
+// fileNameHeader names the file of a raw POST upload, percent-encoded if it
+// isn't plain ASCII
+const fileNameHeader = "X-LANDrop-File-Name"
+
+// requireAPIAuth is requireAuth for the upload routes of the scripting API,
+// which also accept the API keys created in the settings window as bearer
+// tokens. Keys only ever upload: any other method needs a paired session.
+func (sc *ServerController) requireAPIAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			sc.requireAuth(next)(w, r)
+			return
+		}
+		if key, ok := sc.prefs.FindAPIKey(requestToken(r)); ok {
+			// History and approval prompts name the key unless the script
+			// names itself
+			if r.Header.Get(deviceNameHeader) == "" {
+				r.Header.Set(deviceNameHeader, key.Name)
+			}
+			next(w, r)
+			return
+		}
+		sc.requireAuth(next)(w, r)
+	}
+}
+
+// apiFileName returns the name a raw upload is saved under: the rest of the
+// path for PUT, or for POST the X-LANDrop-File-Name header, the filename of
+// a Content-Disposition header or a ?name= parameter
+func apiFileName(r *http.Request) string {
+	if r.Method == http.MethodPut {
+		return strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, apiFilesPath), "/")
+	}
+	if name := r.Header.Get(fileNameHeader); name != "" {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			return unescaped
+		}
+		return name
+	}
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return r.URL.Query().Get("name")
+}
+
+// handleAPIUpload receives one file as the raw request body, for scripts
+// and tools like curl that don't do multipart forms:
+//
+//	PUT /api/v1/files/{name}
+//	POST /api/v1/files with the name in an X-LANDrop-File-Name header
+//
+// The name may include folders, which are created under the upload folder.
+// ?share= uploads into a writable named share instead, and ?sha256= is
+// checked against the received data. The body is streamed into a hidden
+// .part file, so nothing is buffered in memory or the temp dir. The response
+// is the same JSON object /upload returns for each file.
+func (sc *ServerController) handleAPIUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r, "Only PUT and POST allowed")
+		return
+	}
+
+	name := apiFileName(r)
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "File name required")
+		return
+	}
+	rel, err := transfer.CleanRelativePath(name)
+	// The resumable upload state lives in the upload folder too
+	if err != nil || strings.SplitN(filepath.ToSlash(rel), "/", 2)[0] == tusStateDir {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid file name")
+		return
+	}
+	name = filepath.ToSlash(rel)
+
+	query := r.URL.Query()
+	digest, err := transfer.ParseDigest(query.Get("sha256"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid sha256")
+		return
+	}
+	dir, err := sc.writeDir(query.Get("share"))
+	if err != nil {
+		writePathError(w, r, err, "Share not found", "Failed to save file")
+		return
+	}
+
+	// The name and length are known up front, so the desktop user is asked
+	// before anything is written
+	size := max(r.ContentLength, 0)
+	if decision := sc.requestConsent(r, []transfer.FileSummary{{Name: name, Size: size}}); !decision.Accepted {
+		writeError(w, r, http.StatusForbidden, api.CodeDeclined, decision.Message)
+		return
+	}
+
+	start := time.Now()
+	entry := history.Entry{Direction: history.Received, Method: "api"}
+	fail := func(status int, code api.ErrorCode, message string) {
+		entry.DurationMS = sinceMS(start)
+		entry.Result, entry.Error = history.Failed, message
+		sc.recordTransfer(r, entry)
+		writeError(w, r, status, code, message)
+	}
+
+	out, err := transfer.CreatePartFile(dir)
+	if err != nil {
+		fail(http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+		return
+	}
+	partPath := out.Name()
+	defer func() {
+		if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove partial upload %s: %v", partPath, err)
+		}
+	}()
+
+	tracked := progress.Default.Start(progress.Info{
+		Name:   name,
+		Method: "api",
+		Device: requestDeviceName(r),
+		Size:   size,
+	})
+	defer tracked.Finish(progress.Failed)
+
+	// Hash while writing so the file is only read once
+	hash := sha256.New()
+	written, copyErr := io.Copy(io.MultiWriter(out, hash), tracked.Reader(r.Body))
+	closeErr := out.Close()
+	if copyErr != nil {
+		log.Printf("Upload of %s interrupted after %d bytes: %v", name, written, copyErr)
+		fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+		return
+	}
+	if closeErr != nil {
+		fail(http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+		return
+	}
+
+	sum := hash.Sum(nil)
+	result := api.UploadResult{Name: name, Size: written, SHA256: hex.EncodeToString(sum)}
+	entry.Files = []history.File{{Name: name, Size: written, SHA256: result.SHA256}}
+	if digest != nil && !bytes.Equal(sum, digest) {
+		events.Publish(events.TransferFailed{Name: name, Method: "api", Reason: events.Corrupt})
+		entry.DurationMS = sinceMS(start)
+		entry.Result, entry.Error = history.Failed, transfer.ErrChecksumMismatch.Error()
+		sc.recordTransfer(r, entry)
+		writeError(w, r, http.StatusUnprocessableEntity, api.CodeChecksumMismatch, "The file doesn't match its sha256")
+		return
+	}
+
+	res, err := transfer.SaveFile(r.Context(), sc.prefs, dir, rel, partPath, transfer.IncomingFile{
+		Size:     written,
+		SHA256:   sum,
+		MIMEType: r.Header.Get("Content-Type"),
+		Device:   requestDeviceName(r),
+		PeerAddr: remoteIP(r),
+	})
+	if err != nil {
+		log.Printf("Failed to save %s: %v", name, err)
+		events.Publish(events.TransferFailed{Name: name, Method: "api", Reason: events.SaveFailed})
+		fail(http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+		return
+	}
+
+	entry.Files[0].Path = res.Path
+	entry.DurationMS = sinceMS(start)
+	entry.Result = history.Completed
+	sc.recordTransfer(r, entry)
+	events.Publish(events.FileReceived{
+		Name:    name,
+		Path:    res.Path,
+		Method:  "api",
+		Device:  requestDeviceName(r),
+		Size:    written,
+		Skipped: res.Skip,
+	})
+
+	result.SavedAs = savedName(dir, res.Path)
+	if res.Skip {
+		// The conflict policy kept the existing file
+		tracked.Finish(progress.Skipped)
+		result.Status = api.UploadSkipped
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	tracked.Finish(progress.Completed)
+	sc.notifyReceived(dir, []string{res.Path})
+	result.Status = api.UploadSaved
+	writeJSON(w, http.StatusCreated, result)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lan-drop/api"
+	"lan-drop/config"
+)
+
+// newAPITestController requires pairing and has one API key, returned with it
+func newAPITestController(t *testing.T) (*ServerController, string) {
+	t.Helper()
+	key, secret, err := config.NewAPIKey("CI runner")
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	prefs := &config.Preferences{UploadDir: t.TempDir(), RequirePairing: true, APIKeys: []config.APIKey{key}}
+	return NewServerController(8080, prefs.UploadDir, prefs, testEmbeddedFiles, "test-version"), secret
+}
+
+func apiUpload(controller *ServerController, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	controller.requireAPIAuth(controller.handleAPIUpload)(w, req)
+	return w
+}
+
+func TestAPIUploadPut(t *testing.T) {
+	controller, secret := newAPITestController(t)
+	auth := map[string]string{"Authorization": "Bearer " + secret}
+
+	w := apiUpload(controller, http.MethodPut, "/api/v1/files/builds/app.zip", "build output", auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var result api.UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Expected a JSON response, got %q", w.Body.String())
+	}
+	sum := sha256.Sum256([]byte("build output"))
+	expected := api.UploadResult{Name: "builds/app.zip", SavedAs: "builds/app.zip", Size: 12, SHA256: hex.EncodeToString(sum[:]), Status: "saved"}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+	if content, err := os.ReadFile(filepath.Join(controller.folder, "builds", "app.zip")); err != nil || string(content) != "build output" {
+		t.Errorf("Expected the file in a builds folder, got %q (%v)", content, err)
+	}
+
+	// The default conflict policy keeps both files
+	w = apiUpload(controller, http.MethodPut, "/api/v1/files/builds/app.zip", "second build", auth)
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusCreated || result.SavedAs != "builds/app_1.zip" {
+		t.Errorf("Expected a renamed copy, got %d %+v", w.Code, result)
+	}
+}
+
+func TestAPIUploadPost(t *testing.T) {
+	controller, secret := newAPITestController(t)
+
+	testCases := []struct {
+		name     string
+		target   string
+		header   map[string]string
+		expected string // saved name, "" if the upload must be refused
+	}{
+		{"name header", "/api/v1/files", map[string]string{fileNameHeader: "r%C3%A9sum%C3%A9.pdf"}, "résumé.pdf"},
+		{"content disposition", "/api/v1/files", map[string]string{"Content-Disposition": `attachment; filename="notes.txt"`}, "notes.txt"},
+		{"query", "/api/v1/files?name=photo.jpg", nil, "photo.jpg"},
+		{"no name", "/api/v1/files", nil, ""},
+		{"parent folder", "/api/v1/files", map[string]string{fileNameHeader: "../escape.txt"}, ""},
+		{"upload state", "/api/v1/files", map[string]string{fileNameHeader: ".landrop-tus/x.info"}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := map[string]string{"Authorization": "Bearer " + secret}
+			for k, v := range tc.header {
+				header[k] = v
+			}
+			w := apiUpload(controller, http.MethodPost, tc.target, "content", header)
+			if tc.expected == "" {
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("Expected 400, got %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			var result api.UploadResult
+			json.Unmarshal(w.Body.Bytes(), &result)
+			if w.Code != http.StatusCreated || result.SavedAs != tc.expected {
+				t.Errorf("Expected %q to be saved, got %d %s", tc.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIUploadAuth(t *testing.T) {
+	controller, secret := newAPITestController(t)
+
+	for _, header := range []map[string]string{
+		nil,
+		{"Authorization": "Bearer ldk_not-a-key"},
+	} {
+		if w := apiUpload(controller, http.MethodPut, "/api/v1/files/a.txt", "x", header); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %v, got %d", header, w.Code)
+		}
+	}
+
+	controller.prefs.RevokeAPIKey(controller.prefs.APIKeys[0].ID)
+	if w := apiUpload(controller, http.MethodPut, "/api/v1/files/a.txt", "x", map[string]string{"Authorization": "Bearer " + secret}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(controller.folder, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be saved, got %v", err)
+	}
+}
+
+func TestAPIUploadChecksDigest(t *testing.T) {
+	controller, secret := newAPITestController(t)
+	sum := sha256.Sum256([]byte("expected"))
+
+	w := apiUpload(controller, http.MethodPut, "/api/v1/files/data.bin?sha256="+hex.EncodeToString(sum[:]), "tampered", map[string]string{"Authorization": "Bearer " + secret})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422, got %d: %s", w.Code, w.Body.String())
+	}
+	entries, _ := os.ReadDir(controller.folder)
+	if len(entries) != 0 {
+		t.Errorf("Expected the corrupt upload to leave nothing behind, got %v", entries)
+	}
+}
+
+func TestAPIKeysOnlyUpload(t *testing.T) {
+	controller, secret := newAPITestController(t)
+	controller.prefs.EnableDownloads = true
+	controller.prefs.SharedDir = t.TempDir()
+	os.WriteFile(filepath.Join(controller.folder, "someone-elses.txt"), []byte("x"), 0644)
+	auth := map[string]string{"Authorization": "Bearer " + secret}
+
+	testCases := []struct {
+		method   string
+		target   string
+		expected int
+	}{
+		{http.MethodPut, "/api/v1/files/build.zip", http.StatusCreated},
+		{http.MethodPost, "/api/v1/files?name=log.txt", http.StatusCreated},
+		{http.MethodGet, "/api/v1/files", http.StatusUnauthorized},
+		{http.MethodDelete, "/api/v1/files/someone-elses.txt", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/shares", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/archive", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/thumbnail?file=a.jpg", http.StatusUnauthorized},
+		{http.MethodPost, "/api/v1/text", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/progress", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/history", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.target, func(t *testing.T) {
+			if w := serveAPI(controller, tc.method, tc.target, auth); w.Code != tc.expected {
+				t.Errorf("Expected %d, got %d: %s", tc.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(controller.folder, "someone-elses.txt")); err != nil {
+		t.Errorf("Expected the key not to delete files: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/sandbox"
+)
+
+// archiveFormats lists the archive formats that can be downloaded
+var archiveFormats = []string{"zip", "tar.gz"}
+
+// archiveWriter abstracts over the zip and tar.gz formats
+type archiveWriter interface {
+	addFile(name string, info fs.FileInfo, r io.Reader) error
+	addDir(name string, info fs.FileInfo) error
+	Close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addFile(name string, info fs.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipArchive) addDir(name string, info fs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	_, err = a.zw.CreateHeader(header)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) addFile(name string, info fs.FileInfo, r io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// Never write more than the header announced, even if the file grows
+	_, err = io.Copy(a.tw, io.LimitReader(r, info.Size()))
+	return err
+}
+
+func (a *tarGzArchive) addDir(name string, info fs.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	return a.tw.WriteHeader(header)
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// handleArchiveDownload streams a ZIP (default) or tar.gz archive of either
+// a directory (?path=) or an explicit selection (?file=, repeatable) from the
+// shared folder or the share named by ?share=. Nothing is buffered to disk.
+func (sc *ServerController) handleArchiveDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+
+	query := r.URL.Query()
+
+	// Check if downloads are enabled
+	share := query.Get("share")
+	if !sc.canDownload(share) {
+		writeError(w, r, http.StatusForbidden, api.CodeDownloadsDisabled, "Downloads not enabled")
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format == "tgz" {
+		format = "tar.gz"
+	}
+	if !slices.Contains(archiveFormats, format) {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Unsupported archive format")
+		return
+	}
+
+	selection := query["file"]
+	archiveName := "landrop-files"
+	if len(selection) == 0 {
+		dir := query.Get("path")
+		if dir == "" {
+			dir = "."
+		}
+		selection = []string{dir}
+		if base := filepath.Base(filepath.Clean(dir)); dir != "." && base != "." {
+			archiveName = base
+		} else if share != "" {
+			archiveName = share
+		} else {
+			archiveName = "shared"
+		}
+	}
+
+	root, err := sc.readRoot(share)
+	if err != nil {
+		writePathError(w, r, err, "Share not found", "Internal server error")
+		return
+	}
+	defer root.Close()
+
+	// Validate every entry before sending anything
+	var roots []string
+	for _, entry := range selection {
+		if _, err := root.Stat(entry); err != nil {
+			writePathError(w, r, err, "File not found", "Cannot access file")
+			return
+		}
+		name, _ := sandbox.Clean(entry)
+		roots = append(roots, filepath.ToSlash(name))
+	}
+
+	var archive archiveWriter
+	filename := archiveName + "." + format
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		archive = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		archive = &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	events.Publish(events.DownloadStarted{Name: filename, Archive: true})
+
+	start := time.Now()
+	entry := history.Entry{Direction: history.Sent, Method: "archive"}
+	for _, name := range roots {
+		fullPath, _ := root.FullPath(name)
+		entry.Files = append(entry.Files, history.File{Name: filepath.Base(fullPath), Path: fullPath})
+	}
+
+	// A directory archive contains the directory's contents; a selection
+	// contains each selected entry under its own name.
+	for _, name := range roots {
+		prefix := path.Base(name)
+		if name == "." {
+			prefix = filepath.Base(root.Dir())
+		}
+		if len(query["file"]) == 0 {
+			prefix = ""
+		}
+		if err := addToArchive(archive, root.FS(), name, prefix); err != nil {
+			// Headers are already sent, so the best we can do is stop the
+			// stream and leave a truncated archive the client will reject.
+			log.Printf("Archive download failed: %v", err)
+			entry.DurationMS = sinceMS(start)
+			entry.Result, entry.Error = history.Failed, "Archive download failed"
+			sc.recordTransfer(r, entry)
+			events.Publish(events.DownloadFailed{Name: filename, Archive: true})
+			return
+		}
+	}
+
+	entry.DurationMS = sinceMS(start)
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish archive: %v", err)
+		entry.Result, entry.Error = history.Failed, "Archive download failed"
+		sc.recordTransfer(r, entry)
+		events.Publish(events.DownloadFailed{Name: filename, Archive: true})
+		return
+	}
+
+	entry.Result = history.Completed
+	sc.recordTransfer(r, entry)
+
+	events.Publish(events.DownloadServed{Name: filename, Archive: true, PeerAddr: remoteIP(r)})
+}
+
+// addToArchive walks dir in fsys and adds every regular file and directory
+// under prefix. Symlinks and special files are skipped so the archive can
+// never reach outside the shared folder.
+func addToArchive(archive archiveWriter, fsys fs.FS, dir, prefix string) error {
+	return fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if isHiddenEntry(d.Name()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+		if dir == "." {
+			rel = p
+		}
+		name := path.Join(prefix, rel)
+		if name == "." || name == "" {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return archive.addDir(name, info)
+		case info.Mode().IsRegular():
+			file, err := fsys.Open(p)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return archive.addFile(name, info, file)
+		default:
+			return nil
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func createArchiveTestTree(t *testing.T, sharedDir string) {
+	t.Helper()
+	files := map[string]string{
+		"album/a.jpg":        "aaa",
+		"album/b.jpg":        "bbbb",
+		"album/nested/c.txt": "c",
+		"readme.txt":         "hello",
+	}
+	for name, content := range files {
+		p := filepath.Join(sharedDir, name)
+		os.MkdirAll(filepath.Dir(p), os.ModePerm)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+}
+
+func zipEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Invalid zip archive: %v", err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func TestArchiveDirectoryAsZip(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	createArchiveTestTree(t, sharedDir)
+
+	req := httptest.NewRequest("GET", "/archive?path=album", nil)
+	w := httptest.NewRecorder()
+	controller.handleArchiveDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("Expected application/zip, got %s", w.Header().Get("Content-Type"))
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "attachment; filename=album.zip" {
+		t.Errorf("Unexpected Content-Disposition: %s", cd)
+	}
+
+	entries := zipEntries(t, w.Body.Bytes())
+	expected := map[string]string{
+		"a.jpg":        "aaa",
+		"b.jpg":        "bbbb",
+		"nested/":      "",
+		"nested/c.txt": "c",
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Expected %d entries, got %v", len(expected), entries)
+	}
+	for name, content := range expected {
+		if entries[name] != content {
+			t.Errorf("Entry %s: expected '%s', got '%s'", name, content, entries[name])
+		}
+	}
+}
+
+func TestArchiveSelectionAsTarGz(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	createArchiveTestTree(t, sharedDir)
+
+	req := httptest.NewRequest("GET", "/archive?file=readme.txt&file=album/nested&format=tar.gz", nil)
+	w := httptest.NewRecorder()
+	controller.handleArchiveDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip stream: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid tar stream: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	expected := []string{"nested/", "nested/c.txt", "readme.txt"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, names)
+			break
+		}
+	}
+}
+
+func TestArchiveRejectsTraversal(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+
+	req := httptest.NewRequest("GET", "/archive?file=../../etc", nil)
+	w := httptest.NewRecorder()
+	controller.handleArchiveDownload(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestArchiveDownloadsDisabled(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	controller.prefs.EnableDownloads = false
+
+	req := httptest.NewRequest("GET", "/archive?path=.", nil)
+	w := httptest.NewRecorder()
+	controller.handleArchiveDownload(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestArchiveUnknownFormat(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+
+	req := httptest.NewRequest("GET", "/archive?path=.&format=rar", nil)
+	w := httptest.NewRecorder()
+	controller.handleArchiveDownload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
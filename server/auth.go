@@ -0,0 +1,494 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/events"
+)
+
+const (
+	sessionCookieName = "landrop_session"
+	defaultPINTTL     = 5 * time.Minute
+	maxPINAttempts    = 5
+
+	// pairLockout is how long an address that failed to pair maxPINAttempts
+	// times in a row has to wait. It doubles with every further lockout, up
+	// to maxPairLockout, and the address is forgotten once it has been quiet
+	// for that long.
+	pairLockout    = 30 * time.Second
+	maxPairLockout = 15 * time.Minute
+)
+
+var (
+	errInvalidPIN   = errors.New("invalid or expired PIN")
+	errInvalidToken = errors.New("invalid or already used pairing token")
+)
+
+// lockoutError is returned by Pair while the caller's address is locked out
+type lockoutError struct {
+	retryAfter time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return "too many wrong attempts, try again later"
+}
+
+// pairFailures counts the failed pairing attempts of one address
+type pairFailures struct {
+	count    int       // failures since the last lockout
+	lockouts int       // lockouts so far, each one longer than the last
+	until    time.Time // end of the current lockout
+	last     time.Time // time of the last failure
+}
+
+// AuthSession is a paired browser or client
+type AuthSession struct {
+	ID         string
+	Device     string
+	RemoteAddr string
+	CreatedAt  time.Time
+	LastSeen   time.Time
+}
+
+// AuthManager issues the short-lived pairing PIN shown on the desktop and
+// the one-time token embedded in the QR code, and keeps track of the session
+// tokens handed out in exchange. Sessions live in memory only, so restarting
+// the app revokes every token.
+type AuthManager struct {
+	mu         sync.Mutex
+	pin        string
+	pinExpires time.Time
+	pinTTL     time.Duration
+	attempts   int
+	failures   map[string]*pairFailures // keyed by remote address
+	qrToken    string
+	sessions   map[string]*AuthSession // keyed by session token
+	logins     map[string]string       // session tokens keyed by the hash of the Basic credentials that paired them
+
+	// OnChange is called (without the lock held) whenever the PIN, the QR
+	// token or the list of sessions changes
+	OnChange func()
+
+	now func() time.Time
+}
+
+// NewAuthManager creates an AuthManager whose PINs expire after pinTTL
+func NewAuthManager(pinTTL time.Duration) *AuthManager {
+	return &AuthManager{
+		pinTTL:   pinTTL,
+		failures: make(map[string]*pairFailures),
+		sessions: make(map[string]*AuthSession),
+		logins:   make(map[string]string),
+		now:      time.Now,
+	}
+}
+
+// PIN returns the current pairing PIN and when it expires, generating a new
+// one if the previous PIN has expired.
+func (am *AuthManager) PIN() (string, time.Time) {
+	am.mu.Lock()
+	rotated := am.ensurePINLocked()
+	pin, expires := am.pin, am.pinExpires
+	am.mu.Unlock()
+
+	if rotated {
+		am.changed()
+	}
+	return pin, expires
+}
+
+// RotatePIN discards the current PIN and generates a new one
+func (am *AuthManager) RotatePIN() {
+	am.mu.Lock()
+	am.pin = ""
+	am.ensurePINLocked()
+	am.mu.Unlock()
+	am.changed()
+}
+
+// QRToken returns the one-time pairing token to embed in the QR code
+func (am *AuthManager) QRToken() string {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if am.qrToken == "" {
+		am.qrToken = randomToken(24)
+	}
+	return am.qrToken
+}
+
+// Pair exchanges a PIN or a QR token for a new session token. An address
+// that keeps guessing wrong is locked out for a while, so the PIN can't be
+// brute-forced by trying again after every rotation.
+func (am *AuthManager) Pair(pin, qrToken, device, remoteAddr string) (string, error) {
+	return am.pair(pin, qrToken, device, remoteAddr, true)
+}
+
+// pair implements Pair. Wrong PINs always count toward locking remoteAddr
+// out, but only burn the PIN shown on the desktop when burnPIN is set.
+func (am *AuthManager) pair(pin, qrToken, device, remoteAddr string, burnPIN bool) (string, error) {
+	am.mu.Lock()
+
+	if f := am.failures[remoteAddr]; f != nil && am.now().Before(f.until) {
+		am.mu.Unlock()
+		return "", &lockoutError{retryAfter: f.until.Sub(am.now())}
+	}
+
+	switch {
+	case qrToken != "":
+		if am.qrToken == "" || subtle.ConstantTimeCompare([]byte(qrToken), []byte(am.qrToken)) != 1 {
+			am.recordFailureLocked(remoteAddr)
+			am.mu.Unlock()
+			return "", errInvalidToken
+		}
+		// The QR token is single use: the next scan needs a fresh code
+		am.qrToken = randomToken(24)
+	default:
+		am.ensurePINLocked()
+		if subtle.ConstantTimeCompare([]byte(normalizePIN(pin)), []byte(am.pin)) != 1 {
+			am.recordFailureLocked(remoteAddr)
+			if !burnPIN {
+				am.mu.Unlock()
+				return "", errInvalidPIN
+			}
+			am.attempts++
+			// Too many wrong guesses burn the PIN
+			if am.attempts >= maxPINAttempts {
+				am.pin = ""
+				am.ensurePINLocked()
+			}
+			am.mu.Unlock()
+			am.changed()
+			return "", errInvalidPIN
+		}
+		// A PIN can only pair one device
+		am.pin = ""
+		am.ensurePINLocked()
+	}
+	delete(am.failures, remoteAddr)
+
+	token := randomToken(32)
+	now := am.now()
+	am.sessions[token] = &AuthSession{
+		ID:         randomHex(8),
+		Device:     device,
+		RemoteAddr: remoteAddr,
+		CreatedAt:  now,
+		LastSeen:   now,
+	}
+	am.mu.Unlock()
+
+	am.changed()
+	return token, nil
+}
+
+// Validate reports whether token belongs to an active session
+func (am *AuthManager) Validate(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	session, ok := am.sessions[token]
+	if ok {
+		session.LastSeen = am.now()
+	}
+	return ok
+}
+
+// ValidateBasic authenticates HTTP Basic credentials, which WebDAV clients
+// send with every request. The password is a session token or the pairing
+// PIN; a PIN pairs the client the first time it is used, and the same
+// credentials keep working afterwards for as long as that session does.
+// paired reports whether a new session was created.
+//
+// WebDAV clients retry stale passwords on their own, so wrong ones never
+// rotate the PIN shown on the desktop. They still lock the address out like
+// wrong guesses on the pairing endpoint do.
+func (am *AuthManager) ValidateBasic(user, password, device, remoteAddr string) (paired bool, err error) {
+	if am.Validate(password) {
+		return false, nil
+	}
+
+	sum := sha256.Sum256([]byte(user + ":" + password))
+	key := hex.EncodeToString(sum[:])
+	am.mu.Lock()
+	token, known := am.logins[key]
+	am.mu.Unlock()
+	if known && am.Validate(token) {
+		return false, nil
+	}
+
+	token, err = am.pair(password, "", device, remoteAddr, false)
+	if err != nil {
+		return false, err
+	}
+	am.mu.Lock()
+	am.logins[key] = token
+	am.mu.Unlock()
+	return true, nil
+}
+
+// SessionID returns the ID of the session a token or Basic credentials
+// belong to
+func (am *AuthManager) SessionID(token, user, password string) (string, bool) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if token == "" && password != "" {
+		token = password
+		if _, ok := am.sessions[token]; !ok {
+			sum := sha256.Sum256([]byte(user + ":" + password))
+			token = am.logins[hex.EncodeToString(sum[:])]
+		}
+	}
+	session, ok := am.sessions[token]
+	if !ok {
+		return "", false
+	}
+	return session.ID, true
+}
+
+// Sessions returns a snapshot of the active sessions, oldest first
+func (am *AuthManager) Sessions() []AuthSession {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	sessions := make([]AuthSession, 0, len(am.sessions))
+	for _, s := range am.sessions {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// Revoke invalidates the session with the given ID
+func (am *AuthManager) Revoke(id string) {
+	am.mu.Lock()
+	for token, s := range am.sessions {
+		if s.ID == id {
+			delete(am.sessions, token)
+		}
+	}
+	for key, token := range am.logins {
+		if _, ok := am.sessions[token]; !ok {
+			delete(am.logins, key)
+		}
+	}
+	am.mu.Unlock()
+	am.changed()
+}
+
+// RevokeAll invalidates every session
+func (am *AuthManager) RevokeAll() {
+	am.mu.Lock()
+	am.sessions = make(map[string]*AuthSession)
+	am.logins = make(map[string]string)
+	am.mu.Unlock()
+	am.changed()
+}
+
+// recordFailureLocked counts a failed pairing attempt from remoteAddr and
+// locks the address out once it has failed maxPINAttempts times in a row.
+// The caller must hold am.mu.
+func (am *AuthManager) recordFailureLocked(remoteAddr string) {
+	now := am.now()
+	for addr, f := range am.failures {
+		if now.Sub(f.last) > maxPairLockout && now.After(f.until) {
+			delete(am.failures, addr)
+		}
+	}
+
+	f := am.failures[remoteAddr]
+	if f == nil {
+		f = &pairFailures{}
+		am.failures[remoteAddr] = f
+	}
+	f.last = now
+	f.count++
+	if f.count < maxPINAttempts {
+		return
+	}
+	f.count = 0
+	f.lockouts++
+	lockout := maxPairLockout
+	if f.lockouts <= 10 {
+		lockout = min(pairLockout<<(f.lockouts-1), maxPairLockout)
+	}
+	f.until = now.Add(lockout)
+}
+
+func (am *AuthManager) ensurePINLocked() bool {
+	if am.pin != "" && am.now().Before(am.pinExpires) {
+		return false
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		panic(err)
+	}
+	am.pin = fmt.Sprintf("%06d", n.Int64())
+	am.pinExpires = am.now().Add(am.pinTTL)
+	am.attempts = 0
+	return true
+}
+
+func (am *AuthManager) changed() {
+	if am.OnChange != nil {
+		am.OnChange()
+	}
+}
+
+// normalizePIN drops the spaces and dashes people type when copying a PIN
+func normalizePIN(pin string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(pin)
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestToken extracts a session token from the cookie or a bearer header
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// isAuthenticated reports whether the request may access protected endpoints
+func (sc *ServerController) isAuthenticated(r *http.Request) bool {
+	if !sc.prefs.RequirePairing {
+		return true
+	}
+	return sc.Auth.Validate(requestToken(r))
+}
+
+// requireAuth wraps a handler so it rejects requests from unpaired clients
+func (sc *ServerController) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !sc.isAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="LANDrop"`)
+			writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Pairing required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handlePair exchanges a pairing PIN or QR token for a session token.
+// GET reports whether the caller is already paired.
+func (sc *ServerController) handlePair(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{
+			"required": sc.prefs.RequirePairing,
+			"paired":   sc.isAuthenticated(r),
+		})
+		return
+	case http.MethodPost:
+	default:
+		writeMethodNotAllowed(w, r, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		PIN    string `json:"pin"`
+		Token  string `json:"token"`
+		Device string `json:"device"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid request")
+		return
+	}
+
+	device := req.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+
+	token, err := sc.Auth.Pair(req.PIN, req.Token, device, remoteIP(r))
+	if writeLockout(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusForbidden, api.CodePairingFailed, err.Error())
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	events.Publish(events.DevicePaired{Device: shortDeviceName(device)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// remoteIP returns the client IP of a request without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// shortDeviceName trims long user agent strings for display
+func shortDeviceName(device string) string {
+	if len(device) > 40 {
+		return device[:37] + "..."
+	}
+	return device
+}
+
+// writeLockout answers 429 if err says the address is locked out of pairing,
+// and reports whether it did
+func writeLockout(w http.ResponseWriter, r *http.Request, err error) bool {
+	var locked *lockoutError
+	if !errors.As(err, &locked) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.retryAfter.Seconds()))))
+	writeError(w, r, http.StatusTooManyRequests, api.CodeTooManyRequests, err.Error())
+	return true
+}
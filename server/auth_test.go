@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"lan-drop/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAuthTestController(t *testing.T) *ServerController {
+	t.Helper()
+	prefs := &config.Preferences{
+		UploadDir:       t.TempDir(),
+		SharedDir:       t.TempDir(),
+		Port:            8080,
+		EnableDownloads: true,
+		RequirePairing:  true,
+	}
+	return NewServerController(8080, prefs.UploadDir, prefs, testEmbeddedFiles, "test-version")
+}
+
+func pairRequest(controller *ServerController, body map[string]string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/pair", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	controller.handlePair(w, req)
+	return w
+}
+
+func TestPairWithPIN(t *testing.T) {
+	controller := newAuthTestController(t)
+	pin, _ := controller.Auth.PIN()
+
+	w := pairRequest(controller, map[string]string{"pin": pin, "device": "Test Phone"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response map[string]string
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if !controller.Auth.Validate(response["token"]) {
+		t.Error("Returned token is not valid")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly {
+		t.Errorf("Expected an HttpOnly session cookie, got %v", cookies)
+	}
+
+	// The PIN is consumed by a successful pairing
+	if newPIN, _ := controller.Auth.PIN(); newPIN == pin {
+		t.Error("Expected PIN to rotate after use")
+	}
+
+	sessions := controller.Auth.Sessions()
+	if len(sessions) != 1 || sessions[0].Device != "Test Phone" {
+		t.Errorf("Unexpected sessions: %+v", sessions)
+	}
+}
+
+func TestPairWithWrongPIN(t *testing.T) {
+	controller := newAuthTestController(t)
+	pin, _ := controller.Auth.PIN()
+
+	wrong := "000000"
+	if pin == wrong {
+		wrong = "111111"
+	}
+
+	w := pairRequest(controller, map[string]string{"pin": wrong})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestPINRotatesAfterTooManyAttempts(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	pin, _ := am.PIN()
+
+	wrong := "000000"
+	if pin == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < maxPINAttempts; i++ {
+		am.Pair(wrong, "", "attacker", "10.0.0.66")
+	}
+
+	if _, err := am.Pair(pin, "", "late", "10.0.0.2"); err == nil {
+		t.Error("Expected the PIN to be burned after too many failed attempts")
+	}
+}
+
+func TestPairLockout(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	current := time.Now()
+	am.now = func() time.Time { return current }
+
+	guess := func(addr string) error {
+		pin, _ := am.PIN()
+		wrong := "000000"
+		if pin == wrong {
+			wrong = "111111"
+		}
+		_, err := am.Pair(wrong, "", "attacker", addr)
+		return err
+	}
+	lockedOut := func(addr string) time.Duration {
+		pin, _ := am.PIN()
+		_, err := am.Pair(pin, "", "attacker", addr)
+		var locked *lockoutError
+		if !errors.As(err, &locked) {
+			return 0
+		}
+		return locked.retryAfter
+	}
+
+	for i := 0; i < maxPINAttempts; i++ {
+		if err := guess("10.0.0.66"); err != errInvalidPIN {
+			t.Fatalf("Guess %d: expected a wrong PIN, got %v", i+1, err)
+		}
+	}
+	if wait := lockedOut("10.0.0.66"); wait != pairLockout {
+		t.Fatalf("Expected even the right PIN to be refused for %s, got %s", pairLockout, wait)
+	}
+
+	// Other devices can still pair
+	if _, err := am.Pair("", am.QRToken(), "phone", "10.0.0.2"); err != nil {
+		t.Errorf("Expected another address to pair, got %v", err)
+	}
+
+	// Every further lockout lasts twice as long
+	current = current.Add(pairLockout)
+	for i := 0; i < maxPINAttempts; i++ {
+		guess("10.0.0.66")
+	}
+	if wait := lockedOut("10.0.0.66"); wait != 2*pairLockout {
+		t.Errorf("Expected the second lockout to last %s, got %s", 2*pairLockout, wait)
+	}
+
+	// Pairing successfully clears the record
+	current = current.Add(2 * pairLockout)
+	guess("10.0.0.66")
+	if wait := lockedOut("10.0.0.66"); wait != 0 {
+		t.Fatalf("Expected the address to pair once the lockout is over, still locked for %s", wait)
+	}
+	for i := 0; i < maxPINAttempts-1; i++ {
+		guess("10.0.0.66")
+	}
+	if wait := lockedOut("10.0.0.66"); wait != 0 {
+		t.Errorf("Expected failures to start over after pairing, locked for %s", wait)
+	}
+}
+
+func TestPairLockoutResponse(t *testing.T) {
+	controller := newAuthTestController(t)
+	pin, _ := controller.Auth.PIN()
+	wrong := "000000"
+	if pin == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < maxPINAttempts; i++ {
+		pairRequest(controller, map[string]string{"pin": wrong})
+	}
+	pin, _ = controller.Auth.PIN()
+	w := pairRequest(controller, map[string]string{"pin": pin})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d: %s", w.Code, w.Body.String())
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "30" {
+		t.Errorf("Expected Retry-After: 30, got %q", retry)
+	}
+}
+
+func TestPINExpires(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	current := time.Now()
+	am.now = func() time.Time { return current }
+
+	pin, expires := am.PIN()
+	if !expires.Equal(current.Add(time.Minute)) {
+		t.Errorf("Unexpected expiry: %v", expires)
+	}
+
+	current = current.Add(2 * time.Minute)
+	if _, err := am.Pair(pin, "", "phone", "10.0.0.2"); err == nil {
+		t.Error("Expected expired PIN to be rejected")
+	}
+}
+
+func TestQRTokenIsSingleUse(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	token := am.QRToken()
+
+	changed := 0
+	am.OnChange = func() { changed++ }
+
+	if _, err := am.Pair("", token, "phone", "10.0.0.2"); err != nil {
+		t.Fatalf("Expected QR token to pair: %v", err)
+	}
+	if _, err := am.Pair("", token, "phone", "10.0.0.3"); err == nil {
+		t.Error("Expected QR token to be rejected on second use")
+	}
+	if am.QRToken() == token {
+		t.Error("Expected a new QR token after use")
+	}
+	if changed == 0 {
+		t.Error("Expected OnChange to fire so the QR code can be refreshed")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	first, _ := am.Pair("", am.QRToken(), "phone", "10.0.0.2")
+	second, _ := am.Pair("", am.QRToken(), "tablet", "10.0.0.3")
+
+	for _, s := range am.Sessions() {
+		if s.Device == "phone" {
+			am.Revoke(s.ID)
+		}
+	}
+
+	if am.Validate(first) {
+		t.Error("Revoked token is still valid")
+	}
+	if !am.Validate(second) {
+		t.Error("Unrelated token was revoked")
+	}
+
+	am.RevokeAll()
+	if am.Validate(second) {
+		t.Error("Token survived RevokeAll")
+	}
+}
+
+func TestValidateBasic(t *testing.T) {
+	am := NewAuthManager(time.Minute)
+	pin, _ := am.PIN()
+
+	if _, err := am.ValidateBasic("dev", "000000x", "davfs", "10.0.0.2"); err == nil {
+		t.Error("Expected a wrong PIN to be refused")
+	}
+	if paired, err := am.ValidateBasic("dev", pin, "davfs", "10.0.0.2"); err != nil || !paired {
+		t.Fatalf("Expected the PIN to pair, got paired=%v err=%v", paired, err)
+	}
+
+	// The mount keeps sending the same PIN after it has rotated
+	if newPIN, _ := am.PIN(); newPIN == pin {
+		t.Fatal("Expected PIN to rotate after use")
+	}
+	if paired, err := am.ValidateBasic("dev", pin, "davfs", "10.0.0.2"); err != nil || paired {
+		t.Errorf("Expected the credentials to stay valid without pairing again, got paired=%v err=%v", paired, err)
+	}
+	if _, err := am.ValidateBasic("someone-else", pin, "davfs", "10.0.0.3"); err == nil {
+		t.Error("Expected the used PIN to be refused for other credentials")
+	}
+
+	sessions := am.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("Expected one session, got %+v", sessions)
+	}
+	am.Revoke(sessions[0].ID)
+	if _, err := am.ValidateBasic("dev", pin, "davfs", "10.0.0.2"); err == nil {
+		t.Error("Expected the credentials to stop working once the session is revoked")
+	}
+
+	// A session token works as the password too
+	token, _ := am.Pair("", am.QRToken(), "phone", "10.0.0.2")
+	if paired, err := am.ValidateBasic("", token, "davfs", "10.0.0.2"); err != nil || paired {
+		t.Errorf("Expected a session token to be accepted, got paired=%v err=%v", paired, err)
+	}
+}
+
+func TestValidateBasicThrottlesWithoutRotatingPIN(t *testing.T) {
+	am := NewAuthManager(time.Hour)
+	now := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	am.now = func() time.Time { return now }
+	pin, _ := am.PIN()
+
+	// A client retrying a stale password doesn't burn the PIN on the desktop
+	for i := 0; i < maxPINAttempts; i++ {
+		if _, err := am.ValidateBasic("dev", "stale", "davfs", "10.0.0.2"); !errors.Is(err, errInvalidPIN) {
+			t.Fatalf("Attempt %d: expected errInvalidPIN, got %v", i+1, err)
+		}
+	}
+	if current, _ := am.PIN(); current != pin {
+		t.Error("Expected wrong Basic passwords to leave the PIN alone")
+	}
+
+	// but it is locked out like a guesser on the pairing endpoint, there too
+	var locked *lockoutError
+	if _, err := am.ValidateBasic("dev", pin, "davfs", "10.0.0.2"); !errors.As(err, &locked) || locked.retryAfter != pairLockout {
+		t.Fatalf("Expected a %v lockout, got %v", pairLockout, err)
+	}
+	if _, err := am.Pair(pin, "", "phone", "10.0.0.2"); !errors.As(err, &locked) {
+		t.Errorf("Expected the lockout to cover pairing too, got %v", err)
+	}
+
+	now = now.Add(pairLockout)
+	if paired, err := am.ValidateBasic("dev", pin, "davfs", "10.0.0.2"); err != nil || !paired {
+		t.Errorf("Expected the PIN to pair once the lockout is over, got paired=%v err=%v", paired, err)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	controller := newAuthTestController(t)
+	handler := controller.requireAuth(controller.handleFileBrowse)
+
+	// No credentials
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/files", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+
+	token, _ := controller.Auth.Pair("", controller.Auth.QRToken(), "phone", "10.0.0.2")
+
+	// Cookie
+	req := httptest.NewRequest("GET", "/files", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with cookie, got %d", w.Code)
+	}
+
+	// Bearer token
+	req = httptest.NewRequest("GET", "/files", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with bearer token, got %d", w.Code)
+	}
+
+	// Pairing disabled
+	controller.prefs.RequirePairing = false
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/files", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 when pairing is disabled, got %d", w.Code)
+	}
+}
+
+func TestPairStatus(t *testing.T) {
+	controller := newAuthTestController(t)
+
+	w := httptest.NewRecorder()
+	controller.handlePair(w, httptest.NewRequest("GET", "/pair", nil))
+
+	var status map[string]bool
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if !status["required"] || status["paired"] {
+		t.Errorf("Unexpected pairing status: %v", status)
+	}
+}
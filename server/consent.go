@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"lan-drop/transfer"
+)
+
+// deviceNameHeader is a header a client can send to name itself in the
+// consent prompt
+const deviceNameHeader = "X-LANDrop-Device-Name"
+
+// Prefixes of the trusted device IDs, naming what identified the device
+const (
+	trustedSessionPrefix = "session:"
+	trustedAPIKeyPrefix  = "key:"
+)
+
+// trustedDeviceID returns what "Always allow this device" remembers for a
+// request: the API key or paired session it was authenticated with. Anything
+// a client says about itself can be repeated by another host on the network,
+// so a request with neither can't be trusted.
+func (sc *ServerController) trustedDeviceID(r *http.Request) string {
+	token := requestToken(r)
+	if key, ok := sc.prefs.FindAPIKey(token); ok {
+		return trustedAPIKeyPrefix + key.ID
+	}
+	user, password, _ := r.BasicAuth()
+	if id, ok := sc.Auth.SessionID(token, user, password); ok {
+		return trustedSessionPrefix + id
+	}
+	return ""
+}
+
+// forgetTrustedSessions forgets the devices trusted by their paired session.
+// Sessions don't outlive the app, so those IDs can never match again.
+func (sc *ServerController) forgetTrustedSessions() {
+	transfer.ForgetTrustedDevicesFunc(sc.prefs, func(deviceID string) bool {
+		return strings.HasPrefix(deviceID, trustedSessionPrefix)
+	})
+}
+
+// requestConsent asks the desktop user whether the files of an HTTP upload
+// may be written. It returns the decision once the user answered, the
+// client went away or the prompt timed out.
+func (sc *ServerController) requestConsent(r *http.Request, files []transfer.FileSummary) transfer.Decision {
+	addr := remoteIP(r)
+	return transfer.RequestConsent(r.Context(), sc.prefs, transfer.Request{
+		DeviceID:   sc.trustedDeviceID(r),
+		DeviceName: requestDeviceName(r),
+		RemoteAddr: addr,
+		Files:      files,
+	})
+}
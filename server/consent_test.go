@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lan-drop/config"
+	"lan-drop/transfer"
+)
+
+// newConsentUploadRequest uploads test.txt, announced in a manifest field
+// first unless manifest is empty
+func newConsentUploadRequest(t *testing.T, manifest string, files ...string) *http.Request {
+	t.Helper()
+	if len(files) == 0 {
+		files = []string{"Hello, World!"}
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if manifest != "" {
+		writer.WriteField("manifest", manifest)
+	}
+	for _, content := range files {
+		fileWriter, err := writer.CreateFormFile("file", "test.txt")
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		fileWriter.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(deviceNameHeader, "Test Phone")
+	return req
+}
+
+func TestHandleUploadDeclined(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	var prompted transfer.Request
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		prompted = req
+		return transfer.Decision{Message: "Not now"}
+	})
+	defer transfer.SetPrompter(nil)
+
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, newConsentUploadRequest(t, `[{"name":"test.txt","size":13}]`))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Not now") {
+		t.Errorf("Expected decline message in response, got %q", w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "test.txt")); !os.IsNotExist(err) {
+		t.Error("Declined upload must not be written")
+	}
+
+	if prompted.DeviceID != "" || prompted.DeviceName != "Test Phone" {
+		t.Errorf("Unexpected device in prompt: %+v", prompted)
+	}
+	if len(prompted.Files) != 1 || prompted.Files[0].Name != "test.txt" || prompted.Files[0].Size != 13 {
+		t.Errorf("Unexpected files in prompt: %+v", prompted.Files)
+	}
+}
+
+func TestTrustedDeviceID(t *testing.T) {
+	tempDir := t.TempDir()
+	key, secret, err := config.NewAPIKey("backup script")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	prefs := &config.Preferences{UploadDir: tempDir, APIKeys: []config.APIKey{key}}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	pin, _ := controller.Auth.PIN()
+	token, err := controller.Auth.Pair(pin, "", "phone", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Pair failed: %v", err)
+	}
+	session := trustedSessionPrefix + controller.Auth.Sessions()[0].ID
+
+	testCases := []struct {
+		name     string
+		prepare  func(r *http.Request)
+		expected string
+	}{
+		{"anonymous", func(r *http.Request) {}, ""},
+		{"claimed id", func(r *http.Request) { r.Header.Set("X-LANDrop-Device-Id", session) }, ""},
+		{"unknown token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, ""},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, session},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token}) }, session},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("me", token) }, session},
+		{"api key", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret) }, trustedAPIKeyPrefix + key.ID},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/upload", nil)
+			tc.prepare(r)
+			if got := controller.trustedDeviceID(r); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestAlwaysAllowFollowsTheSession(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true, TrustedDevices: []string{trustedSessionPrefix + "stale"}}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+	if len(prefs.TrustedDevices) != 0 {
+		t.Errorf("Expected sessions from an earlier run to be forgotten, got %v", prefs.TrustedDevices)
+	}
+
+	pin, _ := controller.Auth.PIN()
+	token, err := controller.Auth.Pair(pin, "", "phone", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Pair failed: %v", err)
+	}
+
+	prompts := 0
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		prompts++
+		return transfer.Decision{Accepted: true, AlwaysAllow: true}
+	})
+	defer transfer.SetPrompter(nil)
+
+	upload := func(token string) {
+		t.Helper()
+		req := newConsentUploadRequest(t, "")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		controller.handleUpload(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	upload(token)
+	upload(token)
+	if prompts != 1 {
+		t.Errorf("Expected the paired device to be asked about once, got %d prompts", prompts)
+	}
+	// Another client can't borrow the trust without the session
+	upload("")
+	upload("")
+	if prompts != 3 {
+		t.Errorf("Expected an unpaired client to be asked every time, got %d prompts", prompts)
+	}
+}
+
+func TestHandleUploadAccepted(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		return transfer.Decision{Accepted: true}
+	})
+	defer transfer.SetPrompter(nil)
+
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, newConsentUploadRequest(t, ""))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "test.txt")); err != nil {
+		t.Errorf("Accepted upload was not written: %v", err)
+	}
+}
+
+func TestHandleUploadAsksBeforeWriting(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	var prompted transfer.Request
+	var written []os.DirEntry
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		prompted = req
+		written, _ = os.ReadDir(tempDir)
+		return transfer.Decision{Message: "Not now"}
+	})
+	defer transfer.SetPrompter(nil)
+
+	// Without a manifest the prompt shows the first file and the request size
+	req := newConsentUploadRequest(t, "")
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", w.Code)
+	}
+	if len(written) != 0 {
+		t.Errorf("Expected nothing to be written before the prompt, found %v", written)
+	}
+	if len(prompted.Files) != 1 || prompted.Files[0].Name != "test.txt" || prompted.Files[0].Size != req.ContentLength {
+		t.Errorf("Unexpected files in prompt: %+v", prompted.Files)
+	}
+}
+
+func TestHandleUploadAsksForEachFileWithoutManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	prompts := 0
+	transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+		prompts++
+		// Only the first file the user was shown is approved
+		return transfer.Decision{Accepted: prompts == 1, Message: "Not now"}
+	})
+	defer transfer.SetPrompter(nil)
+
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, newConsentUploadRequest(t, "", "hello", "abc"))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if prompts != 2 {
+		t.Errorf("Expected a prompt for each file, got %d", prompts)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("Expected a declined upload to leave nothing behind, found %v", entries)
+	}
+}
+
+func TestHandleUploadEnforcesManifest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		manifest string
+		files    []string
+		expected int
+	}{
+		{"matches", `[{"name":"test.txt","size":5},{"name":"test.txt","size":3}]`, []string{"hello", "abc"}, http.StatusOK},
+		{"more files", `[{"name":"test.txt","size":8}]`, []string{"hello", "abc"}, http.StatusBadRequest},
+		{"more bytes", `[{"name":"test.txt","size":4}]`, []string{"hello"}, http.StatusRequestEntityTooLarge},
+		{"malformed", `{"name":"test.txt"}`, []string{"hello"}, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			prefs := &config.Preferences{UploadDir: tempDir, AskBeforeReceiving: true}
+			controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+			transfer.SetPrompter(func(ctx context.Context, req transfer.Request) transfer.Decision {
+				return transfer.Decision{Accepted: true}
+			})
+			defer transfer.SetPrompter(nil)
+
+			w := httptest.NewRecorder()
+			controller.handleUpload(w, newConsentUploadRequest(t, tc.manifest, tc.files...))
+
+			if w.Code != tc.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expected, w.Code, w.Body.String())
+			}
+			if tc.expected != http.StatusOK {
+				if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+					t.Errorf("Expected a refused upload to leave nothing behind, found %v", entries)
+				}
+			}
+		})
+	}
+}
@@ -2,22 +2,33 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"embed"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/fs"
+	"lan-drop/api"
 	"lan-drop/config"
+	"lan-drop/discovery"
+	"lan-drop/events"
+	"lan-drop/history"
 	"lan-drop/p2p"
+	"lan-drop/sandbox"
+	"lan-drop/transfer"
 	"lan-drop/utils"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"sync"
+	"time"
 
 	"fyne.io/fyne/v2"
+	"golang.org/x/net/webdav"
 )
 
 type ServerController struct {
@@ -28,17 +39,33 @@ type ServerController struct {
 	prefs         *config.Preferences // Add preferences to the controller
 	embeddedFiles embed.FS            // Embedded filesystem for static files
 	version       string              // Version of the application
-	OnStatus      func(string)        // GUI callback
+	Auth          *AuthManager        // Pairing PINs and session tokens
+	History       *history.Store      // Transfer history, nil to disable
+
+	thumbnailCacheDir string               // Where generated thumbnails are cached
+	certDir           string               // Where the self-signed certificate is kept
+	certificate       *x509.Certificate    // Certificate being served, nil over plain HTTP
+	davLocks          webdav.LockSystem    // Locks WebDAV clients hold in the upload folder
+	discoveryID       string               // Identifies this server in mDNS answers
+	advertiser        *discovery.Responder // Advertises the server on the network, nil if not
 }
 
 func NewServerController(port int, folder string, prefs *config.Preferences, embeddedFiles embed.FS, version string) *ServerController {
-	return &ServerController{
+	sc := &ServerController{
 		port:          port,
 		folder:        folder,
 		prefs:         prefs,
 		embeddedFiles: embeddedFiles,
 		version:       version,
+		Auth:          NewAuthManager(defaultPINTTL),
+
+		thumbnailCacheDir: defaultThumbnailCacheDir(),
+		certDir:           defaultCertDir(),
+		davLocks:          webdav.NewMemLS(),
+		discoveryID:       discovery.NewID(),
 	}
+	sc.forgetTrustedSessions()
+	return sc
 }
 
 func (sc *ServerController) Start() {
@@ -49,8 +76,8 @@ func (sc *ServerController) Start() {
 		sc.stopLocked()
 	}
 
-	// Set up the status reporter for P2P
-	p2p.SetStatusReporter(sc)
+	// Set up the history for P2P
+	p2p.SetHistory(sc.History)
 
 	// Create a new HTTP server
 	mux := http.NewServeMux()
@@ -66,7 +93,7 @@ func (sc *ServerController) Start() {
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		data, err := fs.ReadFile(content, "index.html")
 		if err != nil {
-			http.Error(w, "File not found", http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, api.CodeNotFound, "File not found")
 			return
 		}
 		w.Header().Set("Content-Type", "text/html")
@@ -77,15 +104,22 @@ func (sc *ServerController) Start() {
 	mux.Handle("/static/", http.FileServer(http.FS(content)))
 
 	// Upload endpoint
-	mux.HandleFunc("/upload", sc.handleUpload)
+	mux.HandleFunc("/upload", sc.requireAuth(sc.handleUpload))
+
+	// Resumable upload endpoint (tus protocol)
+	mux.HandleFunc("/tus", sc.requireAuth(sc.handleTus))
+	mux.HandleFunc(tusBasePath, sc.requireAuth(sc.handleTus))
 
 	// Delete endpoint for file cleanup
-	mux.HandleFunc("/delete", sc.handleDelete)
+	mux.HandleFunc("/delete", sc.requireAuth(sc.handleDelete))
 
 	// Signaling endpoint
-	mux.HandleFunc("/signaling", func(w http.ResponseWriter, r *http.Request) {
-		p2p.SignalingHandler(w, r, sc.prefs)
-	})
+	mux.HandleFunc("/signaling", sc.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		p2p.SignalingHandler(w, r, sc.prefs, sc.trustedDeviceID(r))
+	}))
+
+	// Pairing endpoint: exchanges the desktop PIN or QR token for a session
+	mux.HandleFunc("/pair", sc.handlePair)
 
 	// Version endpoint
 	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
@@ -93,23 +127,164 @@ func (sc *ServerController) Start() {
 	})
 
 	// File browsing and download endpoints (for bidirectional transfers)
-	mux.HandleFunc("/files", sc.handleFileBrowse)
-	mux.HandleFunc("/download", sc.handleFileDownload)
+	mux.HandleFunc("/files", sc.requireAuth(sc.handleFileBrowse))
+	mux.HandleFunc("/download", sc.requireAuth(sc.handleFileDownload))
+	mux.HandleFunc("/archive", sc.requireAuth(sc.handleArchiveDownload))
+	mux.HandleFunc("/thumbnail", sc.requireAuth(sc.handleThumbnail))
+
+	// Live transfer progress as server-sent events
+	mux.HandleFunc("/progress", sc.requireAuth(sc.handleProgress))
+
+	// Text and clipboard exchange for devices without a data channel
+	mux.HandleFunc("/text", sc.requireAuth(sc.handleText))
+
+	// Transfer history as JSON or CSV
+	mux.HandleFunc("/history", sc.requireAuth(sc.handleHistory))
+
+	// The versioned API for scripts and other tools, which may use an API
+	// key instead of pairing
+	sc.registerAPI(mux)
+
+	// WebDAV access to the shared and upload folders
+	mux.HandleFunc(davPrefix, sc.requireDAVAuth(sc.handleDAV))
 
 	addr := fmt.Sprintf(":%d", sc.port)
-	sc.server = &http.Server{Addr: addr, Handler: mux}
+	var handler http.Handler = mux
+
+	// HTTPS uses a persisted self-signed certificate covering the LAN addresses
+	var tlsConfig *tls.Config
+	sc.certificate = nil
+	if sc.prefs.EnableHTTPS {
+		cert, err := loadOrCreateCertificate(sc.certDir, utils.GetLocalIPs(), time.Now())
+		if err != nil {
+			log.Printf("Failed to load TLS certificate: %v", err)
+			events.Publish(events.Notice{Text: fmt.Sprintf("HTTPS unavailable, falling back to HTTP: %s", err)})
+		} else {
+			sc.certificate = cert.Leaf
+			tlsConfig = &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"http/1.1"},
+			}
+			if sc.prefs.RedirectHTTP {
+				handler = redirectToHTTPS(mux)
+			}
+		}
+	}
+
+	// Shutdown waits for requests to finish, so long-lived responses such as
+	// the progress stream watch a context that is cancelled when it starts
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancelBase)
+	sc.server = server
+
+	// Abandoned resumable uploads are removed while the server runs
+	go sc.sweepTusUploadsUntil(baseCtx)
+
+	redirect := sc.prefs.RedirectHTTP
+	advertise := sc.prefs.EnableDiscovery
+	instance := sc.discoveryInstance()
 
 	go func() {
-		if sc.OnStatus != nil {
-			sc.OnStatus(fmt.Sprintf("Server listening on port %d", sc.port))
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			events.Publish(events.ServerStopped{Err: err})
+			return
+		}
+		// With port 0 the system picked one, so report the one bound
+		port := ln.Addr().(*net.TCPAddr).Port
+		if advertise {
+			instance.Port = port
+			sc.advertise(server, instance)
+		}
+
+		scheme := "HTTP"
+		if tlsConfig != nil {
+			scheme = "HTTPS"
+			if redirect {
+				// Plain HTTP on the same port is answered with a redirect
+				ln = newSniffListener(ln, tlsConfig)
+			} else {
+				ln = tls.NewListener(ln, tlsConfig)
+			}
 		}
-		err := sc.server.ListenAndServe()
-		if err != nil && sc.OnStatus != nil {
-			sc.OnStatus(fmt.Sprintf("Server stopped: %s", err))
+
+		events.Publish(events.ServerStarted{Port: port, Scheme: scheme})
+		err = server.Serve(ln)
+		if err != nil {
+			events.Publish(events.ServerStopped{Err: err})
 		}
 	}()
 }
 
+// URL returns the address phones should open to reach the server
+func (sc *ServerController) URL() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	scheme := "http"
+	if sc.certificate != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, utils.GetLocalIP(), sc.port)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the TLS certificate, or an
+// empty string when the server is not serving HTTPS.
+func (sc *ServerController) Fingerprint() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.certificate == nil {
+		return ""
+	}
+	return certificateFingerprint(sc.certificate)
+}
+
+// DiscoveryID returns the ID the server is advertised with, so a browser
+// can leave it out of the instances it found
+func (sc *ServerController) DiscoveryID() string {
+	return sc.discoveryID
+}
+
+// discoveryInstance describes the server to other LANDrop instances
+func (sc *ServerController) discoveryInstance() discovery.Instance {
+	hostname, _ := os.Hostname()
+	instance := discovery.Instance{
+		ID:      sc.discoveryID,
+		Name:    hostname,
+		Host:    hostname,
+		Port:    sc.port,
+		Version: sc.version,
+		Addrs:   utils.GetLocalIPs(),
+	}
+	if sc.certificate != nil {
+		instance.Fingerprint = certificateFingerprint(sc.certificate)
+	}
+	return instance
+}
+
+// advertise starts answering mDNS questions about the server, unless it was
+// stopped or restarted in the meantime
+func (sc *ServerController) advertise(server *http.Server, instance discovery.Instance) {
+	advertiser, err := discovery.Advertise(instance)
+	if err != nil {
+		log.Printf("Failed to advertise the server on the local network: %v", err)
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.server != server {
+		advertiser.Close()
+		return
+	}
+	sc.advertiser = advertiser
+}
+
 func (sc *ServerController) Stop() {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
@@ -117,6 +292,10 @@ func (sc *ServerController) Stop() {
 }
 
 func (sc *ServerController) stopLocked() {
+	if sc.advertiser != nil {
+		sc.advertiser.Close()
+		sc.advertiser = nil
+	}
 	if sc.server != nil {
 		_ = sc.server.Shutdown(context.Background())
 		sc.server = nil
@@ -134,78 +313,9 @@ func (sc *ServerController) Update(port int, folder string) {
 	sc.Start()
 }
 
-// ReportStatus implements the p2p.StatusReporter interface
-func (sc *ServerController) ReportStatus(message string) {
-	if sc.OnStatus != nil {
-		sc.OnStatus(message)
-	}
-}
-
-func (sc *ServerController) safeSavePath(filename string) string {
-	dir := sc.folder
-	base := strings.TrimSuffix(filename, filepath.Ext(filename))
-	ext := filepath.Ext(filename)
-	savePath := filepath.Join(dir, filename)
-
-	i := 1
-	for {
-		if _, err := os.Stat(savePath); os.IsNotExist(err) {
-			break
-		}
-		savePath = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
-		i++
-	}
-
-	return savePath
-}
-func (sc *ServerController) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	err := r.ParseMultipartForm(32 << 20) // 32 MB
-	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
-		return
-	}
-
-	files := r.MultipartForm.File["file"]
-	if len(files) == 0 {
-		http.Error(w, "No files uploaded", http.StatusBadRequest)
-		return
-	}
-
-	noErrCount := 0
-	var savedFiles []string
-
-	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, "Failed to open file", http.StatusInternalServerError)
-			return
-		}
-		defer file.Close()
-
-		savePath := sc.safeSavePath(fileHeader.Filename)
-
-		out, err := os.Create(savePath)
-		if err != nil {
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
-
-		io.Copy(out, file)
-
-		if sc.OnStatus != nil {
-			noErrCount++
-			sc.OnStatus("Received: " + filepath.Base(savePath))
-		}
-		savedFiles = append(savedFiles, savePath)
-	}
-	sc.OnStatus(fmt.Sprintf("Received %d file(s)", noErrCount))
-
+// notifyReceived shows the desktop notification for a completed upload into
+// dir and, if enabled, opens the received file or the folder.
+func (sc *ServerController) notifyReceived(dir string, savedFiles []string) {
 	if sc.prefs.ShowNotifications {
 		if len(savedFiles) == 1 {
 			// Single file - use enhanced notification with file action
@@ -223,71 +333,81 @@ func (sc *ServerController) handleUpload(w http.ResponseWriter, r *http.Request)
 			if sc.prefs.AutoOpenFiles {
 				utils.HandleFileAction(filePath, action)
 			}
+		} else if folder := transfer.RootFolder(dir, savedFiles); folder != "" {
+			// A dropped folder - show notification and open that folder
+			utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
+				Title:    "LAN-Drop",
+				Content:  fmt.Sprintf("Received folder: %s (%d files)", filepath.Base(folder), len(savedFiles)),
+				FilePath: folder,
+				Action:   "show",
+			})
+
+			if sc.prefs.AutoOpenFiles {
+				if err := utils.OpenFolder(folder); err != nil {
+					log.Printf("Failed to auto-open received folder: %v", err)
+				}
+			}
 		} else {
 			// Multiple files - show notification and open upload folder
 			utils.SendNotificationWithAction(fyne.CurrentApp(), utils.NotificationConfig{
 				Title:    "LAN-Drop",
 				Content:  fmt.Sprintf("Received %d files", len(savedFiles)),
-				FilePath: sc.prefs.UploadDir,
+				FilePath: dir,
 				Action:   "show",
 			})
 
 			// Open the upload folder to show all files only if enabled
 			if sc.prefs.AutoOpenFiles {
-				if err := utils.OpenFolder(sc.prefs.UploadDir); err != nil {
+				if err := utils.OpenFolder(dir); err != nil {
 					log.Printf("Failed to auto-open upload folder: %v", err)
 				}
 			}
 		}
 	}
-
-	w.Write([]byte("Upload successful"))
 }
 
-// handleDelete removes uploaded files that were not confirmed
+// handleDelete removes uploaded files that were not confirmed. An optional
+// "share" field names the share they were uploaded to.
 func (sc *ServerController) handleDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, "Method not allowed")
 		return
 	}
 
 	filename := r.FormValue("filename")
 	if filename == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Filename required")
 		return
 	}
+	sc.deleteFile(w, r, r.FormValue("share"), filename)
+}
 
-	// Construct file path
-	filePath := filepath.Join(sc.prefs.UploadDir, filename)
-
-	// Security check: ensure the file is within the upload directory
-	uploadDir, err := filepath.Abs(sc.prefs.UploadDir)
+// deleteFile removes a file from the upload directory or the read-write share
+// it was uploaded to. Drop boxes refuse every delete, whether the file exists
+// or not.
+func (sc *ServerController) deleteFile(w http.ResponseWriter, r *http.Request, share, filename string) {
+	dir, err := sc.prefs.DeleteDir(share)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePathError(w, r, err, "Share not found", "Internal server error")
 		return
 	}
-
-	targetFile, err := filepath.Abs(filePath)
+	root, err := sandbox.Open(dir, false)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Internal server error")
 		return
 	}
+	defer root.Close()
 
-	if !strings.HasPrefix(targetFile, uploadDir) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Delete the file
+	if err := root.Remove(filename); err != nil {
+		writePathError(w, r, err, "File not found", "Failed to delete file")
 		return
 	}
 
-	// Delete the file
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to delete file", http.StatusInternalServerError)
-		}
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-
 	w.Write([]byte("File deleted successfully"))
 }
 
@@ -298,201 +418,297 @@ func (c *ServerController) handleVersion(w http.ResponseWriter, _ *http.Request,
 	})
 }
 
-// FileInfo represents a file available for download
-type FileInfo struct {
-	Name         string `json:"name"`
-	Size         int64  `json:"size"`
-	ModTime      string `json:"modTime"`
-	IsDirectory  bool   `json:"isDirectory"`
-	RelativePath string `json:"relativePath"`
-}
-
-// handleFileBrowse lists files available for download
+// handleFileBrowse lists files available for download. ?share= browses a
+// named share; the top level of the shared folder also lists every share.
 func (sc *ServerController) handleFileBrowse(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, "Only GET allowed")
 		return
 	}
 
-	// Check if downloads are enabled
-	if !sc.prefs.EnableDownloads {
-		http.Error(w, "Downloads not enabled", http.StatusForbidden)
-		return
-	}
+	// Get the requested share and path (subdirectory within it)
+	sc.browse(w, r, r.URL.Query().Get("share"), r.URL.Query().Get("path"))
+}
 
-	// Get the requested path (subdirectory within shared folder)
-	requestedPath := r.URL.Query().Get("path")
+// browse answers with the content of a folder in the named share
+func (sc *ServerController) browse(w http.ResponseWriter, r *http.Request, share, requestedPath string) {
 	if requestedPath == "" {
 		requestedPath = "."
 	}
+	listShares := share == "" && requestedPath == "." && len(sc.prefs.Shares) > 0
 
-	// Ensure shared directory exists
-	config.EnsureSharedDir(*sc.prefs)
-
-	// Construct the full path and validate it's within shared directory
-	fullPath := filepath.Join(sc.prefs.SharedDir, requestedPath)
-	sharedDirAbs, err := filepath.Abs(sc.prefs.SharedDir)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Check if downloads are enabled
+	if !sc.canDownload(share) && !listShares {
+		writeError(w, r, http.StatusForbidden, api.CodeDownloadsDisabled, "Downloads not enabled")
 		return
 	}
 
-	fullPathAbs, err := filepath.Abs(fullPath)
-	if err != nil {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
-		return
+	var files []api.FileInfo
+	if sc.canDownload(share) {
+		listed, err := sc.listFiles(share, requestedPath)
+		if err != nil {
+			writePathError(w, r, err, "Path not found", "Cannot access path")
+			return
+		}
+		files = listed
 	}
 
-	// Security check: ensure we're within the shared directory
-	if !strings.HasPrefix(fullPathAbs, sharedDirAbs) {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
+	if listShares {
+		for _, s := range sc.prefs.Shares {
+			files = append(files, api.FileInfo{
+				Name:         s.Name,
+				IsDirectory:  true,
+				RelativePath: ".",
+				Share:        s.Name,
+				Access:       string(s.Access),
+			})
+		}
 	}
 
-	// Check if path exists and is a directory
-	stat, err := os.Stat(fullPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "Path not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Cannot access path", http.StatusInternalServerError)
-		}
+	if !isAPIRequest(r) {
+		writeJSON(w, http.StatusOK, legacyFileList(requestedPath, share, files))
 		return
 	}
+	writeJSON(w, http.StatusOK, api.FileList{Path: requestedPath, Share: share, Files: files})
+}
 
-	var files []FileInfo
-
-	if stat.IsDir() {
-		// List directory contents
-		entries, err := os.ReadDir(fullPath)
-		if err != nil {
-			http.Error(w, "Cannot read directory", http.StatusInternalServerError)
-			return
-		}
+// FileInfo is how the older /files route describes a file, with the
+// camelCase names the web page was written against. The versioned API
+// uses api.FileInfo.
+type FileInfo struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	ModTime      string `json:"modTime"`
+	IsDirectory  bool   `json:"isDirectory"`
+	RelativePath string `json:"relativePath"`
+	MimeType     string `json:"mimeType,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	Share        string `json:"share,omitempty"`
+	Access       string `json:"access,omitempty"`
+}
 
-		for _, entry := range entries {
-			// Skip .DS_Store files
-			if entry.Name() == ".DS_Store" {
-				continue
-			}
+// legacyFileList is the answer of the older /files route
+func legacyFileList(path, share string, files []api.FileInfo) map[string]any {
+	legacy := make([]FileInfo, len(files))
+	for i, f := range files {
+		legacy[i] = FileInfo(f)
+	}
+	return map[string]any{"path": path, "share": share, "files": legacy}
+}
 
-			info, err := entry.Info()
-			if err != nil {
-				continue // Skip files we can't read
-			}
+// listFiles describes the file or the directory contents at requestedPath
+// in the named share
+func (sc *ServerController) listFiles(share, requestedPath string) ([]api.FileInfo, error) {
+	root, err := sc.readRoot(share)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
 
-			relativePath := requestedPath
-			if relativePath != "." {
-				relativePath = filepath.Join(requestedPath, entry.Name())
-			} else {
-				relativePath = entry.Name()
-			}
+	// Check if path exists and is a directory
+	stat, err := root.Stat(requestedPath)
+	if err != nil {
+		return nil, err
+	}
 
-			files = append(files, FileInfo{
-				Name:         entry.Name(),
-				Size:         info.Size(),
-				ModTime:      info.ModTime().Format("2006-01-02 15:04:05"),
-				IsDirectory:  entry.IsDir(),
-				RelativePath: relativePath,
-			})
-		}
-	} else {
+	if !stat.IsDir() {
 		// Single file info
-		files = append(files, FileInfo{
+		return []api.FileInfo{{
 			Name:         stat.Name(),
 			Size:         stat.Size(),
 			ModTime:      stat.ModTime().Format("2006-01-02 15:04:05"),
 			IsDirectory:  false,
 			RelativePath: requestedPath,
-		})
+			MimeType:     mimeTypeFor(stat.Name()),
+			ThumbnailURL: thumbnailURL(share, requestedPath),
+		}}, nil
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":  requestedPath,
-		"files": files,
-	})
+	// List directory contents
+	entries, err := root.ReadDir(requestedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []api.FileInfo{}
+	for _, entry := range entries {
+		// Skip .DS_Store files and partial uploads
+		if isHiddenEntry(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue // Skip files we can't read
+		}
+
+		relativePath := requestedPath
+		if relativePath != "." {
+			relativePath = filepath.Join(requestedPath, entry.Name())
+		} else {
+			relativePath = entry.Name()
+		}
+
+		fileInfo := api.FileInfo{
+			Name:         entry.Name(),
+			Size:         info.Size(),
+			ModTime:      info.ModTime().Format("2006-01-02 15:04:05"),
+			IsDirectory:  entry.IsDir(),
+			RelativePath: relativePath,
+		}
+		if !entry.IsDir() {
+			fileInfo.MimeType = mimeTypeFor(entry.Name())
+			fileInfo.ThumbnailURL = thumbnailURL(share, relativePath)
+		}
+		files = append(files, fileInfo)
+	}
+	return files, nil
 }
 
-// handleFileDownload serves files for download
+// handleFileDownload serves files for download from the shared folder or the
+// share named by ?share=. Range, If-Range and conditional requests are
+// handled by http.ServeContent, so browsers can resume interrupted downloads
+// and seek inside media files.
 func (sc *ServerController) handleFileDownload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
 		return
 	}
 
 	// Check if downloads are enabled
-	if !sc.prefs.EnableDownloads {
-		http.Error(w, "Downloads not enabled", http.StatusForbidden)
+	share := r.URL.Query().Get("share")
+	if !sc.canDownload(share) {
+		writeError(w, r, http.StatusForbidden, api.CodeDownloadsDisabled, "Downloads not enabled")
 		return
 	}
 
 	// Get the requested file path
 	filePath := r.URL.Query().Get("file")
 	if filePath == "" {
-		http.Error(w, "File parameter required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "File parameter required")
 		return
 	}
+	sc.serveFile(w, r, share, filePath)
+}
 
-	// Construct the full path and validate it's within shared directory
-	fullPath := filepath.Join(sc.prefs.SharedDir, filePath)
-	sharedDirAbs, err := filepath.Abs(sc.prefs.SharedDir)
+// serveFile sends a file from the named share and records the download
+func (sc *ServerController) serveFile(w http.ResponseWriter, r *http.Request, share, filePath string) {
+	root, err := sc.readRoot(share)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePathError(w, r, err, "Share not found", "Internal server error")
 		return
 	}
+	defer root.Close()
 
-	fullPathAbs, err := filepath.Abs(fullPath)
+	// Open the file; the root keeps it within the shared directory
+	file, err := root.Open(filePath)
 	if err != nil {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
-		return
-	}
-
-	// Security check: ensure we're within the shared directory
-	if !strings.HasPrefix(fullPathAbs, sharedDirAbs) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		writePathError(w, r, err, "File not found", "Cannot access file")
 		return
 	}
+	defer file.Close()
 
-	// Check if file exists and is not a directory
-	stat, err := os.Stat(fullPath)
+	// Check the file is not a directory
+	stat, err := file.Stat()
 	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Cannot access file", http.StatusInternalServerError)
-		}
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Cannot access file")
 		return
 	}
 
 	if stat.IsDir() {
-		http.Error(w, "Cannot download directory", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Cannot download directory")
 		return
 	}
 
-	// Open the file
-	file, err := os.Open(fullPath)
-	if err != nil {
-		http.Error(w, "Cannot open file", http.StatusInternalServerError)
-		return
+	fullPath, _ := root.FullPath(filePath)
+	name := filepath.Base(fullPath)
+
+	// inline lets the browser play or display shared media directly
+	disposition := "attachment"
+	if inline, _ := strconv.ParseBool(r.URL.Query().Get("inline")); inline {
+		disposition = "inline"
 	}
-	defer file.Close()
 
-	// Set appropriate headers
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(fullPath)))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
+	// Set appropriate headers. Content-Type is left to ServeContent, which
+	// derives it from the extension or sniffs the first bytes of the file.
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	w.Header().Set("ETag", fileETag(stat))
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Only whole-file GETs count as downloads; range requests from media
+	// players and cache revalidations would otherwise flood the status
+	// label and the history.
+	reportTransfer := r.Method == http.MethodGet && r.Header.Get("Range") == "" &&
+		r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == ""
 
 	// Report status
-	if sc.OnStatus != nil {
-		sc.OnStatus(fmt.Sprintf("Downloading: %s", filepath.Base(fullPath)))
+	if reportTransfer {
+		events.Publish(events.DownloadStarted{Name: name})
 	}
 
-	// Stream the file
-	io.Copy(w, file)
+	// Stream the file, hashing it on the way out
+	start := time.Now()
+	content := newHashingReadSeeker(file)
+	http.ServeContent(w, r, name, stat.ModTime(), content)
+
+	if !reportTransfer {
+		return
+	}
+
+	entry := history.Entry{
+		Direction:  history.Sent,
+		Method:     "download",
+		Files:      []history.File{{Name: name, Path: fullPath, Size: stat.Size()}},
+		DurationMS: sinceMS(start),
+		Result:     history.Completed,
+	}
+	if sum, ok := content.Sum(stat.Size()); ok {
+		entry.Files[0].SHA256 = sum
+	} else {
+		entry.Result, entry.Error = history.Failed, "Download interrupted"
+	}
+	sc.recordTransfer(r, entry)
 
 	// Report completion
-	if sc.OnStatus != nil {
-		sc.OnStatus(fmt.Sprintf("Downloaded: %s", filepath.Base(fullPath)))
+	if entry.Result == history.Completed {
+		events.Publish(events.DownloadServed{Name: name, Size: stat.Size(), PeerAddr: remoteIP(r)})
+	} else {
+		events.Publish(events.DownloadFailed{Name: name})
 	}
 }
+
+// readRoot opens the folder a request reads from: the named share, or the
+// shared folder, creating it if needed. Paths opened through it can't lead
+// outside of it.
+func (sc *ServerController) readRoot(share string) (*sandbox.Root, error) {
+	dir, err := sc.prefs.ReadDir(share)
+	if err != nil {
+		return nil, err
+	}
+	os.MkdirAll(dir, os.ModePerm)
+	return sandbox.Open(dir, sc.prefs.FollowSymlinks)
+}
+
+// writeDir returns the folder a request writes to: the named share, or the
+// upload folder, creating it if needed
+func (sc *ServerController) writeDir(share string) (string, error) {
+	if share == "" {
+		return sc.folder, nil
+	}
+	dir, err := sc.prefs.WriteDir(share)
+	if err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, os.ModePerm)
+}
+
+// canDownload reports whether a request may read from the named share. The
+// shared folder needs downloads enabled; named shares have their own access.
+func (sc *ServerController) canDownload(share string) bool {
+	return share != "" || sc.prefs.EnableDownloads
+}
+
+// fileETag builds a strong validator from a file's size and modification time
+func fileETag(stat os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", stat.Size(), stat.ModTime().UnixNano())
+}
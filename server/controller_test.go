@@ -6,29 +6,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"lan-drop/api"
 	"lan-drop/config"
+	"lan-drop/events"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Create an empty embedded filesystem for testing
 var testEmbeddedFiles embed.FS
 
-// Mock status reporter for testing
-type mockStatusReporter struct {
-	messages []string
-}
-
-func (m *mockStatusReporter) recordMessage(msg string) {
-	m.messages = append(m.messages, msg)
-}
-
-func (m *mockStatusReporter) getMessages() []string {
-	return m.messages
+// drainStatuses returns the status lines of the events waiting on a subscription
+func drainStatuses(received <-chan events.Event) []string {
+	var statuses []string
+	for len(received) > 0 {
+		if status := (<-received).Status(); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
 }
 
 func TestNewServerController(t *testing.T) {
@@ -64,30 +68,66 @@ func TestNewServerController(t *testing.T) {
 	}
 }
 
-func TestServerControllerReportStatus(t *testing.T) {
+func TestServerControllerPublishesStatus(t *testing.T) {
 	tempDir := t.TempDir()
-	prefs := &config.Preferences{
-		UploadDir:         tempDir,
-		Port:              8080,
-		ShowNotifications: true,
-		AutoUpdateCheck:   true,
-		AutoOpenFiles:     true,
+	prefs := &config.Preferences{UploadDir: tempDir}
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
+
+	// Port 0 lets the system pick a free port
+	controller := NewServerController(0, tempDir, prefs, testEmbeddedFiles, "test-version")
+	controller.Start()
+	defer controller.Stop()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-received:
+			if started, ok := e.(events.ServerStarted); ok {
+				if started.Port == 0 || started.Scheme != "HTTP" ||
+					e.Status() != fmt.Sprintf("Server listening on port %d (HTTP)", started.Port) {
+					t.Errorf("Unexpected start event: %#v", e)
+				}
+				// The published port is the one actually listening
+				conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", started.Port))
+				if err != nil {
+					t.Fatalf("Expected the server on port %d: %v", started.Port, err)
+				}
+				conn.Close()
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the server to start")
+		}
 	}
+}
 
-	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+func TestDiscoveryInstance(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "1.2.3")
 
-	// Test with no callback set
-	controller.ReportStatus("test message")
+	instance := controller.discoveryInstance()
+	if instance.ID == "" || instance.ID != controller.DiscoveryID() || instance.Port != 8080 || instance.Version != "1.2.3" {
+		t.Errorf("Unexpected instance: %+v", instance)
+	}
+	if instance.Fingerprint != "" {
+		t.Errorf("Expected no fingerprint over plain HTTP, got %s", instance.Fingerprint)
+	}
 
-	// Test with callback set
-	var receivedMessage string
-	controller.OnStatus = func(msg string) {
-		receivedMessage = msg
+	cert, err := loadOrCreateCertificate(t.TempDir(), []net.IP{net.ParseIP("192.168.1.20")}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	controller.certificate = cert.Leaf
+	if instance := controller.discoveryInstance(); instance.Fingerprint != certificateFingerprint(cert.Leaf) {
+		t.Errorf("Expected the certificate fingerprint, got %q", instance.Fingerprint)
 	}
 
-	controller.ReportStatus("test message 2")
-	if receivedMessage != "test message 2" {
-		t.Errorf("Expected 'test message 2', got '%s'", receivedMessage)
+	// Every controller is told apart from the others
+	other := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "1.2.3")
+	if other.DiscoveryID() == controller.DiscoveryID() {
+		t.Error("Expected each controller to get its own ID")
 	}
 }
 
@@ -123,70 +163,6 @@ func TestServerControllerUpdate(t *testing.T) {
 	}
 }
 
-func TestSafeSavePath(t *testing.T) {
-	tempDir := t.TempDir()
-	prefs := &config.Preferences{
-		UploadDir:         tempDir,
-		Port:              8080,
-		ShowNotifications: true,
-		AutoUpdateCheck:   true,
-		AutoOpenFiles:     true,
-	}
-
-	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
-
-	// Test with non-existing file
-	path1 := controller.safeSavePath("test.txt")
-	expected1 := filepath.Join(tempDir, "test.txt")
-	if path1 != expected1 {
-		t.Errorf("Expected %s, got %s", expected1, path1)
-	}
-
-	// Create the file to test conflict resolution
-	os.WriteFile(expected1, []byte("test"), 0644)
-
-	// Test with existing file (should add _1)
-	path2 := controller.safeSavePath("test.txt")
-	expected2 := filepath.Join(tempDir, "test_1.txt")
-	if path2 != expected2 {
-		t.Errorf("Expected %s, got %s", expected2, path2)
-	}
-
-	// Create the _1 file too
-	os.WriteFile(expected2, []byte("test"), 0644)
-
-	// Test with both existing (should add _2)
-	path3 := controller.safeSavePath("test.txt")
-	expected3 := filepath.Join(tempDir, "test_2.txt")
-	if path3 != expected3 {
-		t.Errorf("Expected %s, got %s", expected3, path3)
-	}
-}
-
-func TestSafeSavePathWithExtension(t *testing.T) {
-	tempDir := t.TempDir()
-	prefs := &config.Preferences{
-		UploadDir:         tempDir,
-		Port:              8080,
-		ShowNotifications: true,
-		AutoUpdateCheck:   true,
-		AutoOpenFiles:     true,
-	}
-
-	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
-
-	// Create original file
-	originalPath := filepath.Join(tempDir, "document.pdf")
-	os.WriteFile(originalPath, []byte("test"), 0644)
-
-	// Test with existing file with extension
-	path := controller.safeSavePath("document.pdf")
-	expected := filepath.Join(tempDir, "document_1.pdf")
-	if path != expected {
-		t.Errorf("Expected %s, got %s", expected, path)
-	}
-}
-
 func TestHandleVersion(t *testing.T) {
 	tempDir := t.TempDir()
 	prefs := &config.Preferences{
@@ -234,10 +210,8 @@ func TestHandleUploadPOST(t *testing.T) {
 	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
 
 	// Capture status messages
-	var statusMessages []string
-	controller.OnStatus = func(msg string) {
-		statusMessages = append(statusMessages, msg)
-	}
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
 
 	// Create a multipart form with a file
 	var body bytes.Buffer
@@ -262,9 +236,14 @@ func TestHandleUploadPOST(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	responseBody := w.Body.String()
-	if responseBody != "Upload successful" {
-		t.Errorf("Expected 'Upload successful', got '%s'", responseBody)
+	var response struct {
+		Files []api.UploadResult `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response, got '%s'", w.Body.String())
+	}
+	if len(response.Files) != 1 || response.Files[0].Status != "saved" || response.Files[0].SavedAs != "test.txt" || response.Files[0].Size != 13 {
+		t.Errorf("Unexpected upload result: %+v", response.Files)
 	}
 
 	// Check if file was saved
@@ -283,6 +262,7 @@ func TestHandleUploadPOST(t *testing.T) {
 	}
 
 	// Check status messages
+	statusMessages := drainStatuses(received)
 	if len(statusMessages) < 2 {
 		t.Errorf("Expected at least 2 status messages, got %d", len(statusMessages))
 	}
@@ -432,26 +412,404 @@ func TestCopyFileToSharedDir(t *testing.T) {
 	}
 }
 
-func TestStatusReporting(t *testing.T) {
-	mock := &mockStatusReporter{}
-	messages := []string{
-		"File upload started",
-		"File upload in progress",
-		"File upload completed",
+func newDownloadTestController(t *testing.T) (*ServerController, string) {
+	t.Helper()
+	sharedDir := t.TempDir()
+	prefs := &config.Preferences{
+		UploadDir:       t.TempDir(),
+		SharedDir:       sharedDir,
+		Port:            8080,
+		EnableDownloads: true,
 	}
+	return NewServerController(8080, prefs.UploadDir, prefs, testEmbeddedFiles, "test-version"), sharedDir
+}
 
-	for _, msg := range messages {
-		mock.recordMessage(msg)
+func TestHandleFileDownloadFull(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "notes.txt"), []byte("0123456789"), 0644)
+
+	req := httptest.NewRequest("GET", "/download?file=notes.txt", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != "0123456789" {
+		t.Errorf("Unexpected body: %s", w.Body.String())
 	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Expected text/plain content type, got %s", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=notes.txt` {
+		t.Errorf("Unexpected Content-Disposition: %s", cd)
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("Expected Accept-Ranges: bytes")
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+		t.Error("Expected ETag and Last-Modified validators")
+	}
+}
 
-	receivedMessages := mock.getMessages()
-	if len(receivedMessages) != len(messages) {
-		t.Errorf("Expected %d messages, got %d", len(messages), len(receivedMessages))
+func TestHandleFileDownloadRange(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "clip.mp4"), []byte("0123456789"), 0644)
+
+	req := httptest.NewRequest("GET", "/download?file=clip.mp4", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", w.Code)
+	}
+	if w.Body.String() != "2345" {
+		t.Errorf("Expected '2345', got '%s'", w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 2-5/10" {
+		t.Errorf("Unexpected Content-Range: %s", cr)
 	}
+	if ct := w.Header().Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Expected video/mp4, got %s", ct)
+	}
+}
+
+func TestHandleFileDownloadMultiRange(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "data.bin"), []byte("0123456789"), 0644)
+
+	req := httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("Range", "bytes=0-1,8-9")
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
 
-	for i, msg := range messages {
-		if receivedMessages[i] != msg {
-			t.Errorf("Message %d: expected '%s', got '%s'", i, msg, receivedMessages[i])
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+		t.Errorf("Expected multipart/byteranges, got %s", ct)
+	}
+}
+
+func TestHandleFileDownloadConditional(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "photo.jpg"), []byte("not really a jpeg"), 0644)
+
+	first := httptest.NewRecorder()
+	controller.handleFileDownload(first, httptest.NewRequest("GET", "/download?file=photo.jpg", nil))
+	etag := first.Header().Get("ETag")
+
+	req := httptest.NewRequest("GET", "/download?file=photo.jpg", nil)
+	req.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %d", w.Code)
+	}
+
+	// A stale If-Range validator falls back to the full file
+	req = httptest.NewRequest("GET", "/download?file=photo.jpg", nil)
+	req.Header.Set("Range", "bytes=0-2")
+	req.Header.Set("If-Range", `"stale"`)
+	w = httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for stale If-Range, got %d", w.Code)
+	}
+}
+
+func TestHandleFileDownloadInlineAndSniffing(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "page"), []byte("<!DOCTYPE html><html></html>"), 0644)
+
+	req := httptest.NewRequest("GET", "/download?file=page&inline=1", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if cd := w.Header().Get("Content-Disposition"); cd != "inline; filename=page" {
+		t.Errorf("Expected inline disposition, got %s", cd)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected sniffed text/html, got %s", ct)
+	}
+}
+
+func TestHandleFileDownloadOutsideSharedDir(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+
+	req := httptest.NewRequest("GET", "/download?file=../../etc/passwd", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestHandlersStayInsideTheirFolder(t *testing.T) {
+	base := t.TempDir()
+	sharedDir := filepath.Join(base, "shared")
+	uploadDir := filepath.Join(base, "uploads")
+	for _, dir := range []string{sharedDir, uploadDir, filepath.Join(base, "shared-private"), filepath.Join(base, "uploads-private")} {
+		os.MkdirAll(dir, os.ModePerm)
+	}
+	os.WriteFile(filepath.Join(base, "shared-private", "secret.txt"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(base, "uploads-private", "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(filepath.Join(base, "shared-private"), filepath.Join(sharedDir, "link")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	os.Symlink(filepath.Join(base, "uploads-private"), filepath.Join(uploadDir, "link"))
+
+	prefs := &config.Preferences{UploadDir: uploadDir, SharedDir: sharedDir, Port: 8080, EnableDownloads: true}
+	controller := NewServerController(8080, uploadDir, prefs, testEmbeddedFiles, "test-version")
+
+	testCases := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request)
+		method  string
+		target  string
+	}{
+		{"download prefix sibling", controller.handleFileDownload, "GET", "/download?file=../shared-private/secret.txt"},
+		{"download through link", controller.handleFileDownload, "GET", "/download?file=link/secret.txt"},
+		{"download absolute", controller.handleFileDownload, "GET", "/download?file=" + url.QueryEscape(filepath.Join(base, "shared-private", "secret.txt"))},
+		{"browse prefix sibling", controller.handleFileBrowse, "GET", "/browse?path=../shared-private"},
+		{"browse through link", controller.handleFileBrowse, "GET", "/browse?path=link"},
+		{"browse parent", controller.handleFileBrowse, "GET", "/browse?path=.."},
+		{"thumbnail through link", controller.handleThumbnail, "GET", "/thumbnail?file=link/secret.jpg"},
+		{"archive through link", controller.handleArchiveDownload, "GET", "/archive?path=link"},
+		{"delete prefix sibling", controller.handleDelete, "POST", "/delete?filename=../uploads-private/secret.txt"},
+		{"delete through link", controller.handleDelete, "POST", "/delete?filename=link/secret.txt"},
+		{"delete absolute", controller.handleDelete, "POST", "/delete?filename=" + url.QueryEscape(filepath.Join(base, "uploads-private", "secret.txt"))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			w := httptest.NewRecorder()
+			tc.handler(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), "secret") {
+				t.Error("Expected nothing from outside the folder in the response")
+			}
+		})
+	}
+
+	for _, dir := range []string{"shared-private", "uploads-private"} {
+		if _, err := os.Stat(filepath.Join(base, dir, "secret.txt")); err != nil {
+			t.Errorf("Expected %s/secret.txt to be untouched: %v", dir, err)
 		}
 	}
 }
+
+func TestHandleFileDownloadFollowsLinksWhenAllowed(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "movie.txt"), []byte("linked"), 0644)
+	if err := os.Symlink(outside, filepath.Join(sharedDir, "movies")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	controller.prefs.FollowSymlinks = true
+
+	req := httptest.NewRequest("GET", "/download?file=movies/movie.txt", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "linked" {
+		t.Errorf("Expected the linked file, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// newSharesTestController configures a read-only, a write-only and a
+// read-write share next to the shared folder
+func newSharesTestController(t *testing.T) (*ServerController, map[string]string) {
+	t.Helper()
+	controller, sharedDir := newDownloadTestController(t)
+	dirs := map[string]string{
+		"":              sharedDir,
+		"Design assets": t.TempDir(),
+		"Inbox":         t.TempDir(),
+		"Scratch":       t.TempDir(),
+	}
+	controller.prefs.Shares = []config.Share{
+		{Name: "Design assets", Dir: dirs["Design assets"], Access: config.ShareReadOnly},
+		{Name: "Inbox", Dir: dirs["Inbox"], Access: config.ShareWriteOnly},
+		{Name: "Scratch", Dir: dirs["Scratch"], Access: config.ShareReadWrite},
+	}
+	for share, dir := range dirs {
+		os.WriteFile(filepath.Join(dir, "logo.txt"), []byte("logo of "+share), 0644)
+	}
+	return controller, dirs
+}
+
+func TestFileBrowseListsShares(t *testing.T) {
+	controller, _ := newSharesTestController(t)
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileBrowse(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Files []FileInfo `json:"files"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	access := map[string]string{}
+	for _, f := range response.Files {
+		if f.Share != "" {
+			if !f.IsDirectory {
+				t.Errorf("Expected share %s to be listed as a directory", f.Share)
+			}
+			access[f.Share] = f.Access
+		}
+	}
+	expected := map[string]string{"Design assets": "read", "Inbox": "write", "Scratch": "read_write"}
+	for name, a := range expected {
+		if access[name] != a {
+			t.Errorf("Expected share %s with access %s, got %q", name, a, access[name])
+		}
+	}
+	if len(response.Files) != 4 {
+		t.Errorf("Expected logo.txt and 3 shares, got %d entries", len(response.Files))
+	}
+
+	// Inside a share only its own files are listed
+	req = httptest.NewRequest("GET", "/files?share=Design+assets", nil)
+	w = httptest.NewRecorder()
+	controller.handleFileBrowse(w, req)
+	response.Files = nil
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || len(response.Files) != 1 || response.Files[0].Name != "logo.txt" {
+		t.Errorf("Expected only logo.txt in the share, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFileBrowseListsSharesWithDownloadsDisabled(t *testing.T) {
+	controller, _ := newSharesTestController(t)
+	controller.prefs.EnableDownloads = false
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	w := httptest.NewRecorder()
+	controller.handleFileBrowse(w, req)
+
+	var response struct {
+		Files []FileInfo `json:"files"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || len(response.Files) != 3 {
+		t.Fatalf("Expected only the 3 shares, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/download?file=logo.txt", nil)
+	w = httptest.NewRecorder()
+	controller.handleFileDownload(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected the shared folder to stay closed, got %d", w.Code)
+	}
+}
+
+func TestShareAccess(t *testing.T) {
+	controller, dirs := newSharesTestController(t)
+
+	testCases := []struct {
+		name     string
+		handler  func(http.ResponseWriter, *http.Request)
+		method   string
+		target   string
+		expected int
+		body     string
+	}{
+		{"download read-only", controller.handleFileDownload, "GET", "/download?share=Design+assets&file=logo.txt", http.StatusOK, "logo of Design assets"},
+		{"download read-write", controller.handleFileDownload, "GET", "/download?share=Scratch&file=logo.txt", http.StatusOK, "logo of Scratch"},
+		{"download write-only", controller.handleFileDownload, "GET", "/download?share=Inbox&file=logo.txt", http.StatusForbidden, "Share is write-only\n"},
+		{"download unknown share", controller.handleFileDownload, "GET", "/download?share=Nope&file=logo.txt", http.StatusNotFound, "Share not found\n"},
+		{"browse write-only", controller.handleFileBrowse, "GET", "/files?share=Inbox", http.StatusForbidden, "Share is write-only\n"},
+		{"archive write-only", controller.handleArchiveDownload, "GET", "/archive?share=Inbox", http.StatusForbidden, "Share is write-only\n"},
+		{"escape from share", controller.handleFileDownload, "GET", "/download?share=Scratch&file=../logo.txt", http.StatusForbidden, "Access denied\n"},
+		{"delete read-only", controller.handleDelete, "POST", "/delete?share=Design+assets&filename=logo.txt", http.StatusForbidden, "Share is read-only\n"},
+		{"delete read-write", controller.handleDelete, "POST", "/delete?share=Scratch&filename=logo.txt", http.StatusOK, "File deleted successfully"},
+		{"delete write-only", controller.handleDelete, "POST", "/delete?share=Inbox&filename=logo.txt", http.StatusForbidden, "Share is write-only\n"},
+		{"delete missing from write-only", controller.handleDelete, "POST", "/delete?share=Inbox&filename=missing.txt", http.StatusForbidden, "Share is write-only\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			w := httptest.NewRecorder()
+			tc.handler(w, req)
+
+			if w.Code != tc.expected || w.Body.String() != tc.body {
+				t.Errorf("Expected %d %q, got %d %q", tc.expected, tc.body, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dirs["Design assets"], "logo.txt")); err != nil {
+		t.Errorf("Expected the read-only share to be untouched: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs["Inbox"], "logo.txt")); err != nil {
+		t.Errorf("Expected the drop box to be untouched: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs["Scratch"], "logo.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected logo.txt to be deleted from the read-write share, got %v", err)
+	}
+}
+
+func TestHandleUploadVerifiesSHA256(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir, Port: 8080}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	// hex SHA-256 of "hello"
+	const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	upload := func(files map[string]string, digests []string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for _, name := range []string{"a.txt", "b.txt"} {
+			content, ok := files[name]
+			if !ok {
+				continue
+			}
+			fileWriter, _ := writer.CreateFormFile("file", name)
+			fileWriter.Write([]byte(content))
+		}
+		for _, digest := range digests {
+			writer.WriteField("sha256", digest)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		controller.handleUpload(w, req)
+		return w
+	}
+
+	if w := upload(map[string]string{"a.txt": "hello"}, []string{"xyz"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid digest, got %d", w.Code)
+	}
+
+	// The first file matches its digest, the second doesn't
+	w := upload(map[string]string{"a.txt": "hello", "b.txt": "jello"}, []string{helloSHA256, helloSHA256})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "b.txt") {
+		t.Errorf("Expected the corrupt file to be named, got %q", w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "a.txt")); err != nil {
+		t.Errorf("Expected the verified file to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupt file to be deleted, got err=%v", err)
+	}
+}
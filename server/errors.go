@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"lan-drop/api"
+	"lan-drop/config"
+	"lan-drop/sandbox"
+)
+
+// isAPIRequest reports whether a request came in through the versioned API
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, api.Prefix)
+}
+
+// writeError answers a request that failed. Requests to the versioned API
+// get an api.ErrorResponse with a machine-readable code; the older routes
+// keep answering with the plain text message the web page shows.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code api.ErrorCode, message string) {
+	if !isAPIRequest(r) {
+		http.Error(w, message, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.ErrorResponse{Error: api.Error{Code: code, Message: message}})
+}
+
+// writeMethodNotAllowed answers a request with a method the route doesn't
+// handle
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, message)
+}
+
+// writeJSON answers a request with a JSON document
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writePathError answers a request for a share or path that couldn't be
+// accessed
+func writePathError(w http.ResponseWriter, r *http.Request, err error, notFound, failed string) {
+	switch {
+	case errors.Is(err, config.ErrShareNotFound):
+		writeError(w, r, http.StatusNotFound, api.CodeShareNotFound, "Share not found")
+	case errors.Is(err, config.ErrShareReadOnly):
+		writeError(w, r, http.StatusForbidden, api.CodeShareReadOnly, "Share is read-only")
+	case errors.Is(err, config.ErrShareWriteOnly):
+		writeError(w, r, http.StatusForbidden, api.CodeShareWriteOnly, "Share is write-only")
+	case errors.Is(err, sandbox.ErrOutside):
+		writeError(w, r, http.StatusForbidden, api.CodeAccessDenied, "Access denied")
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, notFound)
+	default:
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, failed)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/history"
+)
+
+// requestDeviceName returns the name a client gave itself, falling back to
+// its user agent
+func requestDeviceName(r *http.Request) string {
+	if name := r.Header.Get(deviceNameHeader); name != "" {
+		return name
+	}
+	return shortDeviceName(r.UserAgent())
+}
+
+// recordTransfer adds an entry to the transfer history. The peer is taken
+// from r unless the entry already names one.
+func (sc *ServerController) recordTransfer(r *http.Request, entry history.Entry) {
+	if sc.History == nil {
+		return
+	}
+	if entry.PeerAddr == "" {
+		entry.PeerAddr = remoteIP(r)
+	}
+	if entry.Device == "" {
+		entry.Device = requestDeviceName(r)
+	}
+	if err := sc.History.Add(entry); err != nil {
+		log.Printf("Failed to record transfer history: %v", err)
+	}
+}
+
+// sinceMS returns the milliseconds elapsed since start
+func sinceMS(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
+
+// hashingReadSeeker hashes the content http.ServeContent reads from a file.
+// ServeContent seeks around to sniff the content type and serve ranges, so
+// only bytes that continue the hashed prefix are added; Sum succeeds only
+// when the whole file went through in order.
+type hashingReadSeeker struct {
+	io.ReadSeeker
+	hash   hash.Hash
+	pos    int64 // current offset of the underlying reader
+	hashed int64 // length of the prefix that has been hashed
+}
+
+func newHashingReadSeeker(rs io.ReadSeeker) *hashingReadSeeker {
+	return &hashingReadSeeker{ReadSeeker: rs, hash: sha256.New()}
+}
+
+func (h *hashingReadSeeker) Read(p []byte) (int, error) {
+	n, err := h.ReadSeeker.Read(p)
+	if end := h.pos + int64(n); h.pos <= h.hashed && h.hashed < end {
+		h.hash.Write(p[h.hashed-h.pos : n])
+		h.hashed = end
+	}
+	h.pos += int64(n)
+	return n, err
+}
+
+func (h *hashingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := h.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		h.pos = pos
+	}
+	return pos, err
+}
+
+// Sum returns the hex encoded SHA-256 of the file if all size bytes were hashed
+func (h *hashingReadSeeker) Sum(size int64) (string, bool) {
+	if h.hashed != size {
+		return "", false
+	}
+	return hex.EncodeToString(h.hash.Sum(nil)), true
+}
+
+// handleHistory returns the transfer history as JSON, or as CSV with
+// ?format=csv. ?q= filters by file name, device or address and ?limit=
+// caps the number of entries.
+func (sc *ServerController) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	if sc.History == nil {
+		writeError(w, r, http.StatusServiceUnavailable, api.CodeUnavailable, "History is not available")
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	entries := sc.History.Search(r.URL.Query().Get("q"), limit)
+	if entries == nil {
+		entries = []history.Entry{}
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="landrop-history.csv"`)
+		if err := history.WriteCSV(w, entries); err != nil {
+			log.Printf("Failed to write history CSV: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lan-drop/history"
+)
+
+func withTestHistory(t *testing.T, sc *ServerController) *history.Store {
+	t.Helper()
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	sc.History = store
+	return store
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHandleUploadRecordsHistory(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	store := withTestHistory(t, controller)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, _ := writer.CreateFormFile("file", "report.txt")
+	fileWriter.Write([]byte("quarterly numbers"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(deviceNameHeader, "Pixel")
+	req.RemoteAddr = "192.168.1.50:40000"
+	controller.handleUpload(httptest.NewRecorder(), req)
+
+	entries := store.Search("", 0)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 history entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Direction != history.Received || entry.Result != history.Completed {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.PeerAddr != "192.168.1.50" || entry.Device != "Pixel" {
+		t.Errorf("Unexpected peer: %s / %s", entry.PeerAddr, entry.Device)
+	}
+	if len(entry.Files) != 1 || entry.Files[0].SHA256 != sha256Hex("quarterly numbers") || entry.Files[0].Size != 17 {
+		t.Errorf("Unexpected files: %+v", entry.Files)
+	}
+}
+
+func TestHandleFileDownloadRecordsHistory(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	store := withTestHistory(t, controller)
+	os.WriteFile(filepath.Join(sharedDir, "notes.txt"), []byte("0123456789"), 0644)
+
+	// Range requests are not whole downloads and are not recorded
+	req := httptest.NewRequest("GET", "/download?file=notes.txt", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	controller.handleFileDownload(httptest.NewRecorder(), req)
+	if entries := store.Search("", 0); len(entries) != 0 {
+		t.Fatalf("Expected range request not to be recorded, got %d entries", len(entries))
+	}
+
+	req = httptest.NewRequest("GET", "/download?file=notes.txt", nil)
+	controller.handleFileDownload(httptest.NewRecorder(), req)
+
+	entries := store.Search("", 0)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 history entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Direction != history.Sent || entry.Result != history.Completed {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.Files[0].SHA256 != sha256Hex("0123456789") {
+		t.Errorf("Unexpected hash: %s", entry.Files[0].SHA256)
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	store := withTestHistory(t, controller)
+	store.Add(history.Entry{Direction: history.Received, Files: []history.File{{Name: "cat.jpg", Size: 10}}, Result: history.Completed})
+	store.Add(history.Entry{Direction: history.Sent, Files: []history.File{{Name: "dog.jpg", Size: 20}}, Result: history.Completed})
+
+	w := httptest.NewRecorder()
+	controller.handleHistory(w, httptest.NewRequest("GET", "/history?q=cat", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Entries []history.Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(response.Entries) != 1 || response.Entries[0].Files[0].Name != "cat.jpg" {
+		t.Errorf("Unexpected entries: %+v", response.Entries)
+	}
+
+	w = httptest.NewRecorder()
+	controller.handleHistory(w, httptest.NewRequest("GET", "/history?format=csv", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Expected CSV content type, got %s", ct)
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 3 {
+		t.Errorf("Expected header and 2 rows, got %d lines", len(lines))
+	}
+
+	w = httptest.NewRecorder()
+	controller.handleHistory(w, httptest.NewRequest("GET", "/history?limit=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid limit, got %d", w.Code)
+	}
+}
+
+func TestHashingReadSeekerRequiresWholeFile(t *testing.T) {
+	content := strings.NewReader("abcdefghij")
+	h := newHashingReadSeeker(content)
+
+	// Sniff the start, seek back and read everything, like ServeContent
+	buf := make([]byte, 4)
+	h.Read(buf)
+	h.Seek(0, 0)
+	all := make([]byte, 10)
+	h.Read(all)
+
+	sum, ok := h.Sum(10)
+	if !ok || sum != sha256Hex("abcdefghij") {
+		t.Errorf("Expected full hash, got %q (ok=%v)", sum, ok)
+	}
+
+	partial := newHashingReadSeeker(strings.NewReader("abcdefghij"))
+	partial.Seek(5, 0)
+	partial.Read(make([]byte, 5))
+	if _, ok := partial.Sum(10); ok {
+		t.Error("Expected no hash for a partial read")
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/progress"
+)
+
+// progressKeepAlive is how often an idle progress stream sends a comment, so
+// proxies and browsers don't drop it
+const progressKeepAlive = 15 * time.Second
+
+// handleProgress streams transfer progress as server-sent events. Each
+// "progress" event carries a progress.Event as JSON; the files already being
+// transferred are sent first.
+func (sc *ServerController) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Streaming not supported")
+		return
+	}
+
+	events, cancel := progress.Default.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range progress.Default.Active() {
+		writeProgressEvent(w, e)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if writeProgressEvent(w, e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeProgressEvent(w http.ResponseWriter, e progress.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-drop/progress"
+)
+
+func TestHandleProgressStreamsEvents(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	server := httptest.NewServer(http.HandlerFunc(controller.handleProgress))
+	defer server.Close()
+
+	// A file already on its way is sent as soon as the stream opens
+	running := progress.Default.Start(progress.Info{Name: "running.bin", Method: "webrtc", Size: 10})
+	defer running.Finish(progress.Failed)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open the stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %s", ct)
+	}
+
+	events := make(chan progress.Event)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var e progress.Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					events <- e
+				}
+			}
+		}
+		close(events)
+	}()
+
+	next := func() progress.Event {
+		t.Helper()
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("The stream ended")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an event")
+		}
+		return progress.Event{}
+	}
+
+	// Other tests may have left files running, so skip to the ones of this test
+	for e := next(); e.Name != "running.bin"; e = next() {
+	}
+	running.Add(10)
+	running.Finish(progress.Completed)
+	for {
+		e := next()
+		if e.Name == "running.bin" && e.Done {
+			if e.Result != progress.Completed || e.Bytes != 10 {
+				t.Errorf("Expected 10 bytes completed, got %+v", e)
+			}
+			break
+		}
+	}
+}
+
+func TestHandleUploadReportsProgress(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	events, cancel := progress.Default.Subscribe()
+	defer cancel()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, _ := writer.CreateFormFile("file", "report.txt")
+	fileWriter.Write([]byte("quarterly numbers"))
+	writer.WriteField("sessionId", "upload-session")
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed: %d %s", w.Code, w.Body.String())
+	}
+
+	var last progress.Event
+	for len(events) > 0 {
+		if e := <-events; e.Name == "report.txt" {
+			last = e
+		}
+	}
+	if !last.Done || last.Result != progress.Completed || last.Bytes != 17 || last.Method != "upload" {
+		t.Errorf("Expected the upload to complete with 17 bytes, got %+v", last)
+	}
+	if last.Session == nil || last.Session.Size != req.ContentLength || last.Session.DoneFiles != 1 {
+		t.Errorf("Expected the request length as the session size, got %+v", last.Session)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"lan-drop/api"
+	"lan-drop/events"
+	"lan-drop/p2p"
+	"lan-drop/transfer"
+)
+
+// handleText exchanges text with devices that have no data channel open.
+// POST sends text to the desktop, either as a "text" form field or as a
+// text/plain body. GET returns the last text the desktop sent; with
+// ?since=<id> it answers 204 until a newer one is sent.
+func (sc *ServerController) handleText(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		last, ok := p2p.LastText()
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if !ok || last.ID <= since {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(last)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, transfer.MaxTextSize+4096)
+		text, err := requestText(r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Text too long")
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Cannot read text")
+			return
+		}
+
+		err = transfer.ReceiveText(transfer.TextMessage{
+			Text:       text,
+			DeviceName: requestDeviceName(r),
+			RemoteAddr: remoteIP(r),
+		})
+		switch {
+		case errors.Is(err, transfer.ErrTextTooLong):
+			writeError(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Text too long")
+			return
+		case errors.Is(err, transfer.ErrNoTextReceiver):
+			writeError(w, r, http.StatusServiceUnavailable, api.CodeUnavailable, "Nobody is available to receive the text")
+			return
+		case err != nil:
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid text: "+err.Error())
+			return
+		}
+
+		events.Publish(events.TextReceived{Device: requestDeviceName(r)})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "received"})
+	default:
+		writeMethodNotAllowed(w, r, "Only GET and POST allowed")
+	}
+}
+
+// requestText reads the text of a POST /text request
+func requestText(r *http.Request) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/plain" {
+		data, err := io.ReadAll(r.Body)
+		return string(data), err
+	}
+	if err := r.ParseMultipartForm(transfer.MaxTextSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return "", err
+	}
+	return r.FormValue("text"), nil
+}
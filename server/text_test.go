@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lan-drop/config"
+	"lan-drop/p2p"
+	"lan-drop/transfer"
+)
+
+func TestHandleTextPOST(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := NewServerController(8080, tempDir, &config.Preferences{UploadDir: tempDir}, testEmbeddedFiles, "1.0.0")
+
+	var got []transfer.TextMessage
+	transfer.SetTextReceiver(func(msg transfer.TextMessage) {
+		got = append(got, msg)
+	})
+	defer transfer.SetTextReceiver(nil)
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		expected    string // text delivered, "" if none
+	}{
+		{"form field", "application/x-www-form-urlencoded", url.Values{"text": {"123 456"}}.Encode(), http.StatusOK, "123 456"},
+		{"plain body", "text/plain; charset=utf-8", "first\nsecond", http.StatusOK, "first\nsecond"},
+		{"empty", "text/plain", "  ", http.StatusBadRequest, ""},
+		{"too long", "text/plain", strings.Repeat("a", transfer.MaxTextSize+1), http.StatusRequestEntityTooLarge, ""},
+		{"far too long", "text/plain", strings.Repeat("a", 2*transfer.MaxTextSize), http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest("POST", "/text", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set(deviceNameHeader, "Phone")
+			w := httptest.NewRecorder()
+			controller.handleText(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.expected == "" {
+				if len(got) != 0 {
+					t.Errorf("Expected no text to be delivered, got %+v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Text != tc.expected || got[0].DeviceName != "Phone" {
+				t.Errorf("Expected %q from Phone, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleTextWithoutReceiver(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := NewServerController(8080, tempDir, &config.Preferences{UploadDir: tempDir}, testEmbeddedFiles, "1.0.0")
+	transfer.SetTextReceiver(nil)
+
+	req := httptest.NewRequest("POST", "/text", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	controller.handleText(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
+
+func TestHandleTextGET(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := NewServerController(8080, tempDir, &config.Preferences{UploadDir: tempDir}, testEmbeddedFiles, "1.0.0")
+
+	if _, err := p2p.SendText("https://example.com"); err != nil {
+		t.Fatalf("SendText failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	controller.handleText(w, httptest.NewRequest("GET", "/text", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var sent p2p.SentText
+	if err := json.Unmarshal(w.Body.Bytes(), &sent); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if sent.Text != "https://example.com" {
+		t.Errorf("Expected the last sent text, got %+v", sent)
+	}
+
+	// Nothing newer than what the device already has
+	w = httptest.NewRecorder()
+	controller.handleText(w, httptest.NewRequest("GET", "/text?since="+strconv.FormatInt(sent.ID, 10), nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	controller.handleText(w, httptest.NewRequest("DELETE", "/text", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
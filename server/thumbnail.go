@@ -0,0 +1,286 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lan-drop/api"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+const (
+	defaultThumbnailSize = 256
+	minThumbnailSize     = 32
+	maxThumbnailSize     = 1024
+
+	// Source images above these limits are not thumbnailed, to keep a
+	// single request from exhausting memory.
+	maxThumbnailSourceBytes  = 64 << 20
+	maxThumbnailSourcePixels = 64_000_000
+)
+
+// thumbnailDecoders maps supported image extensions to their decoder
+var thumbnailDecoders = map[string]func(io.Reader) (image.Image, error){
+	".jpg":  jpeg.Decode,
+	".jpeg": jpeg.Decode,
+	".png":  png.Decode,
+	".gif":  gif.Decode,
+	".bmp":  bmp.Decode,
+	".tif":  tiff.Decode,
+	".tiff": tiff.Decode,
+	".webp": webp.Decode,
+}
+
+var thumbnailConfigDecoders = map[string]func(io.Reader) (image.Config, error){
+	".jpg":  jpeg.DecodeConfig,
+	".jpeg": jpeg.DecodeConfig,
+	".png":  png.DecodeConfig,
+	".gif":  gif.DecodeConfig,
+	".bmp":  bmp.DecodeConfig,
+	".tif":  tiff.DecodeConfig,
+	".tiff": tiff.DecodeConfig,
+	".webp": webp.DecodeConfig,
+}
+
+// canThumbnail reports whether a thumbnail can be generated for the file
+func canThumbnail(name string) bool {
+	_, ok := thumbnailDecoders[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// thumbnailURL returns the URL of the thumbnail for a file in the shared
+// folder or a named share, or an empty string if the file type isn't
+// supported.
+func thumbnailURL(share, relativePath string) string {
+	if !canThumbnail(relativePath) {
+		return ""
+	}
+	u := "/thumbnail?file=" + url.QueryEscape(filepath.ToSlash(relativePath))
+	if share != "" {
+		u += "&share=" + url.QueryEscape(share)
+	}
+	return u
+}
+
+// mimeTypeFor returns the MIME type for a file name without parameters
+func mimeTypeFor(name string) string {
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// defaultThumbnailCacheDir returns the per-user cache directory for thumbnails
+func defaultThumbnailCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "landrop", "thumbnails")
+}
+
+// handleThumbnail serves a cached, size-bounded thumbnail of an image in the
+// shared folder. ?size= sets the longest edge in pixels.
+func (sc *ServerController) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+
+	// Check if downloads are enabled
+	share := r.URL.Query().Get("share")
+	if !sc.canDownload(share) {
+		writeError(w, r, http.StatusForbidden, api.CodeDownloadsDisabled, "Downloads not enabled")
+		return
+	}
+
+	filePath := r.URL.Query().Get("file")
+	if filePath == "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "File parameter required")
+		return
+	}
+
+	size := defaultThumbnailSize
+	if raw := r.URL.Query().Get("size"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid size")
+			return
+		}
+		size = min(max(parsed, minThumbnailSize), maxThumbnailSize)
+	}
+
+	if !canThumbnail(filePath) {
+		writeError(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedType, "Unsupported image type")
+		return
+	}
+
+	root, err := sc.readRoot(share)
+	if err != nil {
+		writePathError(w, r, err, "Share not found", "Internal server error")
+		return
+	}
+	defer root.Close()
+
+	file, err := root.Open(filePath)
+	if err != nil {
+		writePathError(w, r, err, "File not found", "Cannot access file")
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "File not found")
+		return
+	}
+	fullPath, _ := root.FullPath(filePath)
+
+	if stat.Size() > maxThumbnailSourceBytes {
+		writeError(w, r, http.StatusUnprocessableEntity, api.CodeTooLarge, "Image too large")
+		return
+	}
+
+	thumbPath, err := sc.cachedThumbnail(fullPath, file, stat, size)
+	if err != nil {
+		log.Printf("Failed to generate thumbnail for %s: %v", fullPath, err)
+		writeError(w, r, http.StatusUnprocessableEntity, api.CodeUnsupportedType, "Cannot generate thumbnail")
+		return
+	}
+
+	thumb, err := os.Open(thumbPath)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Cannot open thumbnail")
+		return
+	}
+	defer thumb.Close()
+
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", strings.TrimSuffix(filepath.Base(thumbPath), filepath.Ext(thumbPath))))
+	http.ServeContent(w, r, filepath.Base(thumbPath), stat.ModTime(), thumb)
+}
+
+// cachedThumbnail returns the path of the thumbnail for the file opened from
+// fullPath, generating it if needed. Cache entries are keyed by path, size and
+// modification time, so an edited image gets a fresh thumbnail.
+func (sc *ServerController) cachedThumbnail(fullPath string, file io.ReadSeeker, stat os.FileInfo, size int) (string, error) {
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d", fullPath, stat.Size(), stat.ModTime().UnixNano(), size)))
+	base := filepath.Join(sc.thumbnailCacheDir, hex.EncodeToString(key[:16]))
+
+	for _, ext := range []string{".jpg", ".png"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+
+	img, err := generateThumbnail(file, strings.ToLower(filepath.Ext(fullPath)), size)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(sc.thumbnailCacheDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// Opaque images become JPEGs; anything with transparency stays PNG
+	thumbPath := base + ".jpg"
+	encode := func(w io.Writer) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: 80}) }
+	if !img.Opaque() {
+		thumbPath = base + ".png"
+		encode = func(w io.Writer) error { return png.Encode(w, img) }
+	}
+
+	// Write to a temporary file first so concurrent requests never see a
+	// half-written thumbnail
+	tmp, err := os.CreateTemp(sc.thumbnailCacheDir, "thumb-*")
+	if err != nil {
+		return "", err
+	}
+	if err := encode(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), thumbPath); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// generateThumbnail decodes an image, scales it so its longest edge is at
+// most size pixels and rotates it according to its EXIF orientation. ext is
+// the lower case extension that selects the decoder.
+func generateThumbnail(file io.ReadSeeker, ext string, size int) (*image.NRGBA, error) {
+	decode := thumbnailDecoders[ext]
+
+	// Check the dimensions before decoding the whole image
+	cfg, err := thumbnailConfigDecoders[ext](file)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	orientation := 1
+	if ext == ".jpg" || ext == ".jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		orientation = jpegOrientation(file)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, err := decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never upscale small images
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Src, nil)
+
+	oriented := applyOrientation(scaled, orientation)
+	if nrgba, ok := oriented.(*image.NRGBA); ok {
+		return nrgba, nil
+	}
+	return scaled, nil
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeJPEGWithOrientation encodes img as a JPEG with an EXIF APP1 segment
+// carrying the given orientation.
+func writeJPEGWithOrientation(t *testing.T, path string, img image.Image, orientation uint16) {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	// Minimal big-endian TIFF header with a single IFD entry
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(exifOrientationTag))
+	binary.Write(&tiff, binary.BigEndian, uint16(3)) // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, orientation)
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(encoded.Bytes()[2:])
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write JPEG: %v", err)
+	}
+}
+
+func solidImage(width, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func newThumbnailTestController(t *testing.T) (*ServerController, string) {
+	t.Helper()
+	controller, sharedDir := newDownloadTestController(t)
+	controller.thumbnailCacheDir = t.TempDir()
+	return controller, sharedDir
+}
+
+func TestThumbnailScalesAndRespectsOrientation(t *testing.T) {
+	controller, sharedDir := newThumbnailTestController(t)
+
+	// 400x200 landscape pixels, tagged "rotate 90° clockwise"
+	writeJPEGWithOrientation(t, filepath.Join(sharedDir, "photo.jpg"), solidImage(400, 200, color.White), 6)
+
+	req := httptest.NewRequest("GET", "/thumbnail?file=photo.jpg&size=100", nil)
+	w := httptest.NewRecorder()
+	controller.handleThumbnail(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", ct)
+	}
+
+	cfg, err := jpeg.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("Thumbnail is not a valid JPEG: %v", err)
+	}
+	if cfg.Width != 50 || cfg.Height != 100 {
+		t.Errorf("Expected upright 50x100 thumbnail, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestThumbnailKeepsTransparencyAsPNG(t *testing.T) {
+	controller, sharedDir := newThumbnailTestController(t)
+
+	f, _ := os.Create(filepath.Join(sharedDir, "icon.png"))
+	png.Encode(f, solidImage(64, 64, color.NRGBA{R: 255, A: 100}))
+	f.Close()
+
+	req := httptest.NewRequest("GET", "/thumbnail?file=icon.png", nil)
+	w := httptest.NewRecorder()
+	controller.handleThumbnail(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected image/png, got %s", ct)
+	}
+
+	// Small images are never upscaled
+	cfg, err := png.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("Thumbnail is not a valid PNG: %v", err)
+	}
+	if cfg.Width != 64 || cfg.Height != 64 {
+		t.Errorf("Expected 64x64 thumbnail, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestThumbnailIsCached(t *testing.T) {
+	controller, sharedDir := newThumbnailTestController(t)
+	writeJPEGWithOrientation(t, filepath.Join(sharedDir, "photo.jpg"), solidImage(300, 300, color.Black), 1)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		controller.handleThumbnail(w, httptest.NewRequest("GET", "/thumbnail?file=photo.jpg", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i, w.Code)
+		}
+	}
+
+	entries, _ := os.ReadDir(controller.thumbnailCacheDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected a single cached thumbnail, found %d", len(entries))
+	}
+}
+
+func TestThumbnailRejectsUnsupportedAndEscapes(t *testing.T) {
+	controller, sharedDir := newThumbnailTestController(t)
+	os.WriteFile(filepath.Join(sharedDir, "notes.txt"), []byte("text"), 0644)
+
+	w := httptest.NewRecorder()
+	controller.handleThumbnail(w, httptest.NewRequest("GET", "/thumbnail?file=notes.txt", nil))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for text file, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	controller.handleThumbnail(w, httptest.NewRequest("GET", "/thumbnail?file=../../outside.jpg", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for path outside shared folder, got %d", w.Code)
+	}
+}
+
+func TestFileBrowseIncludesThumbnailAndMimeType(t *testing.T) {
+	controller, sharedDir := newThumbnailTestController(t)
+	writeJPEGWithOrientation(t, filepath.Join(sharedDir, "photo.jpg"), solidImage(10, 10, color.White), 1)
+	os.WriteFile(filepath.Join(sharedDir, "notes.txt"), []byte("text"), 0644)
+
+	w := httptest.NewRecorder()
+	controller.handleFileBrowse(w, httptest.NewRequest("GET", "/files", nil))
+
+	var response struct {
+		Files []FileInfo `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	found := make(map[string]FileInfo)
+	for _, f := range response.Files {
+		found[f.Name] = f
+	}
+
+	if found["photo.jpg"].ThumbnailURL != "/thumbnail?file=photo.jpg" || found["photo.jpg"].MimeType != "image/jpeg" {
+		t.Errorf("Unexpected image entry: %+v", found["photo.jpg"])
+	}
+	if found["notes.txt"].ThumbnailURL != "" || found["notes.txt"].MimeType != "text/plain" {
+		t.Errorf("Unexpected text entry: %+v", found["notes.txt"])
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1 image: red on the left, blue on the right
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	img.SetNRGBA(0, 0, red)
+	img.SetNRGBA(1, 0, blue)
+
+	testCases := []struct {
+		orientation int
+		width       int
+		height      int
+		topLeft     color.NRGBA
+	}{
+		{1, 2, 1, red},
+		{2, 2, 1, blue},
+		{3, 2, 1, blue},
+		{6, 1, 2, red},
+		{8, 1, 2, blue},
+	}
+
+	for _, tc := range testCases {
+		out := applyOrientation(img, tc.orientation)
+		b := out.Bounds()
+		if b.Dx() != tc.width || b.Dy() != tc.height {
+			t.Errorf("Orientation %d: expected %dx%d, got %dx%d", tc.orientation, tc.width, tc.height, b.Dx(), b.Dy())
+			continue
+		}
+		if got := color.NRGBAModel.Convert(out.At(b.Min.X, b.Min.Y)).(color.NRGBA); got != tc.topLeft {
+			t.Errorf("Orientation %d: unexpected top-left pixel %v", tc.orientation, got)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certFileName = "cert.pem"
+	keyFileName  = "key.pem"
+
+	// certValidity is how long a generated certificate is valid for
+	certValidity = 2 * 365 * 24 * time.Hour
+	// certRenewBefore regenerates certificates this close to expiry
+	certRenewBefore = 30 * 24 * time.Hour
+	// sniffTimeout bounds how long a new connection may take to send its
+	// first byte before it is dropped
+	sniffTimeout = 10 * time.Second
+)
+
+// defaultCertDir returns where the self-signed certificate is persisted
+func defaultCertDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "landrop", "tls")
+}
+
+// loadOrCreateCertificate returns the certificate stored in dir, generating a
+// new self-signed one if there is none, it is about to expire, or it doesn't
+// cover every address in ips.
+func loadOrCreateCertificate(dir string, ips []net.IP, now time.Time) (tls.Certificate, error) {
+	certPath := filepath.Join(dir, certFileName)
+	keyPath := filepath.Join(dir, keyFileName)
+
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && now.Before(leaf.NotAfter.Add(-certRenewBefore)) && certCoversIPs(leaf, ips) {
+			cert.Leaf = leaf
+			return cert, nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateCertificate(ips, now)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot create certificate directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot write private key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot write certificate: %w", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// generateCertificate creates a self-signed ECDSA P-256 certificate for
+// localhost and the given addresses, returned PEM encoded.
+func generateCertificate(ips []net.IP, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "LANDrop", Organization: []string{"LANDrop"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           append([]net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, ips...),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// certCoversIPs reports whether every address in ips is listed in cert
+func certCoversIPs(cert *x509.Certificate, ips []net.IP) bool {
+	for _, ip := range ips {
+		found := false
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of cert as colon
+// separated hex, the way browsers display it.
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		parts = append(parts, hexSum[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS. The
+// redirect is temporary so browsers don't remember it if HTTPS is turned off.
+func redirectToHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sniffListener serves TLS and plain HTTP on the same port. It peeks at the
+// first byte of every connection: a TLS handshake starts with a handshake
+// record (0x16), anything else is treated as plain HTTP.
+type sniffListener struct {
+	net.Listener
+	config *tls.Config
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSniffListener(inner net.Listener, config *tls.Config) *sniffListener {
+	l := &sniffListener{
+		Listener: inner,
+		config:   config,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop hands every accepted connection to classify until the listener
+// is closed. Other errors, such as running out of file descriptors, are
+// retried with a growing delay the way http.Server.Serve does.
+func (l *sniffListener) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := l.Listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			l.errs <- err
+			return
+		}
+		if err != nil {
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			log.Printf("Accept error: %v; retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-l.done:
+				l.errs <- net.ErrClosed
+				return
+			}
+		}
+		delay = 0
+		// Sniff concurrently so a slow client doesn't hold up the others
+		go l.classify(conn)
+	}
+}
+
+func (l *sniffListener) classify(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var result net.Conn = &peekedConn{Conn: conn, reader: reader}
+	if first[0] == 0x16 {
+		result = tls.Server(result, l.config)
+	}
+
+	select {
+	case l.conns <- result:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next sniffed connection
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *sniffListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// peekedConn replays the bytes buffered while sniffing
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
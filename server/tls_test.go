@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateCertificatePersists(t *testing.T) {
+	dir := t.TempDir()
+	ips := []net.IP{net.ParseIP("192.168.1.20")}
+	now := time.Now()
+
+	first, err := loadOrCreateCertificate(dir, ips, now)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if !certCoversIPs(first.Leaf, append(ips, net.IPv4(127, 0, 0, 1))) {
+		t.Errorf("Certificate does not cover the LAN and loopback addresses: %v", first.Leaf.IPAddresses)
+	}
+
+	second, err := loadOrCreateCertificate(dir, ips, now)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	if certificateFingerprint(first.Leaf) != certificateFingerprint(second.Leaf) {
+		t.Error("Expected the persisted certificate to be reused")
+	}
+}
+
+func TestLoadOrCreateCertificateRegenerates(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	original, err := loadOrCreateCertificate(dir, []net.IP{net.ParseIP("192.168.1.20")}, now)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	fingerprint := certificateFingerprint(original.Leaf)
+
+	// A new LAN address needs a certificate that covers it
+	moved, err := loadOrCreateCertificate(dir, []net.IP{net.ParseIP("10.0.0.5")}, now)
+	if err != nil {
+		t.Fatalf("Failed to regenerate certificate: %v", err)
+	}
+	if certificateFingerprint(moved.Leaf) == fingerprint {
+		t.Error("Expected a new certificate after the address changed")
+	}
+
+	// So does a certificate that is about to expire
+	later := now.Add(certValidity - certRenewBefore/2)
+	renewed, err := loadOrCreateCertificate(dir, []net.IP{net.ParseIP("10.0.0.5")}, later)
+	if err != nil {
+		t.Fatalf("Failed to renew certificate: %v", err)
+	}
+	if certificateFingerprint(renewed.Leaf) == certificateFingerprint(moved.Leaf) {
+		t.Error("Expected a new certificate close to expiry")
+	}
+}
+
+func TestCertificateFingerprintFormat(t *testing.T) {
+	cert, err := loadOrCreateCertificate(t.TempDir(), nil, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	fingerprint := certificateFingerprint(cert.Leaf)
+	if len(fingerprint) != 32*3-1 || strings.Count(fingerprint, ":") != 31 {
+		t.Errorf("Unexpected fingerprint format: %s", fingerprint)
+	}
+}
+
+func TestSniffListenerServesTLSAndRedirects(t *testing.T) {
+	cert, err := loadOrCreateCertificate(t.TempDir(), nil, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ln := newSniffListener(inner, config)
+
+	handler := redirectToHTTPS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	defer server.Close()
+
+	addr := inner.Addr().String()
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Plain HTTP is redirected to the same address over HTTPS
+	resp, err := client.Get("http://" + addr + "/files?path=docs")
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "https://"+addr+"/files?path=docs" {
+		t.Errorf("Unexpected redirect location: %s", location)
+	}
+
+	// HTTPS on the same port is served normally
+	resp, err = client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "secure" {
+		t.Errorf("Expected 'secure', got '%s'", string(body))
+	}
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		t.Fatal("Expected a TLS connection")
+	}
+	if certificateFingerprint(resp.TLS.PeerCertificates[0]) != certificateFingerprint(cert.Leaf) {
+		t.Error("Server presented an unexpected certificate")
+	}
+}
+
+// flakyListener fails its first Accept calls, then hands out conns
+type flakyListener struct {
+	net.Listener
+	failures int
+	conns    chan net.Conn
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, syscall.EMFILE
+	}
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func (l *flakyListener) Close() error { return nil }
+
+func TestSniffListenerRetriesAcceptErrors(t *testing.T) {
+	inner := &flakyListener{failures: 3, conns: make(chan net.Conn, 1)}
+	ln := newSniffListener(inner, &tls.Config{})
+	defer ln.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	inner.conns <- server
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the listener to keep accepting after temporary errors")
+	}
+
+	// Only closing the listener ends the loop
+	close(inner.conns)
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected net.ErrClosed once the listener is closed, got %v", err)
+	}
+}
@@ -0,0 +1,643 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/progress"
+	"lan-drop/sandbox"
+	"lan-drop/transfer"
+)
+
+// tus 1.0 resumable upload protocol (https://tus.io/protocols/resumable-upload).
+// Supported extensions: creation, expiration and termination.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	tusStateDir   = ".landrop-tus"
+	tusBasePath   = "/tus/"
+
+	// tusExpiry is how long an upload may sit without receiving data before
+	// it is abandoned and its partial file removed
+	tusExpiry = 24 * time.Hour
+	// tusSweepInterval is how often expired uploads are looked for
+	tusSweepInterval = time.Hour
+
+	// tusStatusChecksumMismatch is defined by the checksum extension. The
+	// whole-file digest in the "sha256" metadata entry reuses it.
+	tusStatusChecksumMismatch = 460
+)
+
+var tusIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var errTusExpired = errors.New("upload expired")
+
+// tusUpload is the persisted state of a partial upload. The current offset is
+// not stored: it is the size of the .part file, which keeps the two in sync
+// even if the app is killed in the middle of a PATCH. Likewise the upload
+// expires tusExpiry after the .part file was last written.
+type tusUpload struct {
+	ID        string            `json:"id"`
+	Filename  string            `json:"filename"` // relative path with forward slashes
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// tusLocks serializes requests touching the same upload
+var tusLocks sync.Map
+
+func tusLock(id string) *sync.Mutex {
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// tusTransfers follows the progress of uploads across their PATCH requests,
+// keyed by upload ID. Each entry is guarded by its upload's lock.
+var tusTransfers sync.Map
+
+// tusTransfer returns the progress of an upload at offset, following it
+// from there if it isn't followed yet. The caller must hold the upload's lock.
+func tusTransfer(r *http.Request, upload tusUpload, offset int64) *progress.Transfer {
+	if tracked, ok := tusTransfers.Load(upload.ID); ok {
+		return tracked.(*progress.Transfer)
+	}
+	tracked := progress.Default.Start(progress.Info{
+		SessionID: upload.Metadata["sessionId"],
+		Name:      upload.Filename,
+		Method:    "tus",
+		Device:    requestDeviceName(r),
+		Size:      upload.Length,
+		Offset:    offset,
+	})
+	tusTransfers.Store(upload.ID, tracked)
+	return tracked
+}
+
+// finishTusTransfer stops following the progress of an upload, if it was.
+// The caller must hold the upload's lock.
+func finishTusTransfer(id string, result progress.Result) {
+	if tracked, ok := tusTransfers.LoadAndDelete(id); ok {
+		tracked.(*progress.Transfer).Finish(result)
+	}
+}
+
+func (sc *ServerController) tusDir() string {
+	return filepath.Join(sc.folder, tusStateDir)
+}
+
+func (sc *ServerController) tusInfoPath(id string) string {
+	return filepath.Join(sc.folder, tusInfoName(id))
+}
+
+func (sc *ServerController) tusPartPath(id string) string {
+	return filepath.Join(sc.folder, tusPartName(id))
+}
+
+// tusInfoName and tusPartName name the files of an upload under the upload
+// folder
+func tusInfoName(id string) string {
+	return filepath.Join(tusStateDir, id+".json")
+}
+
+func tusPartName(id string) string {
+	return filepath.Join(tusStateDir, id+".part")
+}
+
+// openTusRoot opens the upload folder, through which the state of uploads is
+// written so that links can't lead it out of the folder
+func (sc *ServerController) openTusRoot() (*sandbox.Root, error) {
+	if err := os.MkdirAll(sc.folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return sandbox.Open(sc.folder, false)
+}
+
+// tusBase returns the path uploads are created under, the one the request
+// came in through
+func tusBase(r *http.Request) string {
+	if isAPIRequest(r) {
+		return api.Prefix + "tus/"
+	}
+	return tusBasePath
+}
+
+// handleTus dispatches requests for the tus endpoint
+func (sc *ServerController) handleTus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeError(w, r, http.StatusPreconditionFailed, api.CodeBadRequest, "Unsupported tus version")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(tusBase(r), "/")), "/")
+	if id == "" {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, r, "Method not allowed")
+			return
+		}
+		sc.handleTusCreate(w, r)
+		return
+	}
+
+	if !tusIDPattern.MatchString(id) {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Upload not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		sc.handleTusHead(w, r, id)
+	case http.MethodPatch:
+		sc.handleTusPatch(w, r, id)
+	case http.MethodDelete:
+		sc.handleTusDelete(w, r, id)
+	default:
+		writeMethodNotAllowed(w, r, "Method not allowed")
+	}
+}
+
+// handleTusCreate implements the creation extension
+func (sc *ServerController) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid Upload-Length")
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	if metadata["filename"] == "" && metadata["relativePath"] == "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Filename required in Upload-Metadata")
+		return
+	}
+
+	// An optional relativePath entry places the file in a folder tree
+	rel, err := transfer.ReceivedPath(metadata["filename"], metadata["relativePath"])
+	if err != nil || strings.SplitN(filepath.ToSlash(rel), "/", 2)[0] == tusStateDir {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid relativePath in Upload-Metadata")
+		return
+	}
+	filename := filepath.ToSlash(rel)
+
+	// An optional share entry uploads into a writable named share
+	if _, err := sc.writeDir(metadata["share"]); err != nil {
+		writePathError(w, r, err, "Share not found", "Failed to create upload")
+		return
+	}
+
+	// An optional sha256 entry is checked once the upload is complete
+	if _, err := transfer.ParseDigest(metadata["sha256"]); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid sha256 in Upload-Metadata")
+		return
+	}
+
+	if decision := sc.requestConsent(r, []transfer.FileSummary{{Name: filename, Size: length}}); !decision.Accepted {
+		writeError(w, r, http.StatusForbidden, api.CodeDeclined, decision.Message)
+		return
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Internal server error")
+		return
+	}
+
+	upload := tusUpload{
+		ID:        id,
+		Filename:  filename,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	}
+
+	if err := sc.createTusUpload(upload); err != nil {
+		log.Printf("Failed to create tus upload: %v", err)
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to create upload")
+		return
+	}
+
+	// Zero-length uploads are complete as soon as they exist
+	if length == 0 {
+		if _, err := sc.finishTusUpload(r, upload); err != nil {
+			writeTusFinishError(w, r, err)
+			return
+		}
+	}
+
+	if length > 0 {
+		setTusExpires(w, time.Now())
+	}
+	w.Header().Set("Location", tusBase(r)+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleTusHead reports the current offset of an upload
+func (sc *ServerController) handleTusHead(w http.ResponseWriter, r *http.Request, id string) {
+	lock := tusLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, offset, err := sc.loadTusUpload(id)
+	if err != nil {
+		writeTusNotFound(w, r, id, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTusPatch appends the request body to an upload
+func (sc *ServerController) handleTusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedType, "Invalid Content-Type")
+		return
+	}
+
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid Upload-Offset")
+		return
+	}
+
+	lock := tusLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, offset, err := sc.loadTusUpload(id)
+	if err != nil {
+		writeTusNotFound(w, r, id, err)
+		return
+	}
+
+	if clientOffset != offset {
+		writeError(w, r, http.StatusConflict, api.CodeOffsetMismatch, "Upload-Offset does not match")
+		return
+	}
+
+	root, err := sc.openTusRoot()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to open upload")
+		return
+	}
+	defer root.Close()
+	out, err := root.OpenFile(tusPartName(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to open upload")
+		return
+	}
+
+	// Never accept more than the declared length. Whatever made it to disk
+	// before the client dropped is kept so the upload can be resumed.
+	tracked := tusTransfer(r, upload, offset)
+	written, copyErr := io.Copy(out, tracked.Reader(io.LimitReader(r.Body, upload.Length-offset)))
+	closeErr := out.Close()
+	offset += written
+
+	if copyErr != nil || closeErr != nil {
+		log.Printf("tus upload %s interrupted at %d/%d bytes", id, offset, upload.Length)
+		// Resuming follows the upload afresh from where it stopped
+		finishTusTransfer(id, progress.Failed)
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Upload interrupted")
+		return
+	}
+
+	if offset == upload.Length {
+		if _, err := sc.finishTusUpload(r, upload); err != nil {
+			log.Printf("Failed to finalize tus upload %s: %v", id, err)
+			writeTusFinishError(w, r, err)
+			return
+		}
+	} else {
+		setTusExpires(w, time.Now())
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTusDelete implements the termination extension
+func (sc *ServerController) handleTusDelete(w http.ResponseWriter, r *http.Request, id string) {
+	lock := tusLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, _, err := sc.loadTusUpload(id); err != nil {
+		writeTusNotFound(w, r, id, err)
+		return
+	}
+
+	sc.removeTusUpload(id)
+	finishTusTransfer(id, progress.Failed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createTusUpload persists the state of a new upload and its empty data file
+func (sc *ServerController) createTusUpload(upload tusUpload) error {
+	root, err := sc.openTusRoot()
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := root.MkdirAll(tusStateDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	part, err := root.OpenFile(tusPartName(upload.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	part.Close()
+
+	if err := root.WriteFile(tusInfoName(upload.ID), data, 0644); err != nil {
+		root.Remove(tusPartName(upload.ID))
+		return err
+	}
+	return nil
+}
+
+// loadTusUpload reads the persisted state of an upload and its current offset
+func (sc *ServerController) loadTusUpload(id string) (tusUpload, int64, error) {
+	var upload tusUpload
+
+	data, err := os.ReadFile(sc.tusInfoPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+
+	stat, err := os.Stat(sc.tusPartPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	if stat.Size() > upload.Length {
+		return upload, 0, errors.New("upload data exceeds declared length")
+	}
+	if tusExpired(stat.ModTime(), time.Now()) {
+		return upload, 0, errTusExpired
+	}
+
+	return upload, stat.Size(), nil
+}
+
+// finishTusUpload moves a completed upload into the upload directory or its
+// share, applying the conflict policy, and reports it like any other
+// received file.
+func (sc *ServerController) finishTusUpload(r *http.Request, upload tusUpload) (string, error) {
+	result := progress.Failed
+	defer func() { finishTusTransfer(upload.ID, result) }()
+
+	// Uploads span several requests, so the file is hashed once complete
+	partPath := sc.tusPartPath(upload.ID)
+	digest, err := transfer.FileSHA256(partPath)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(digest)
+
+	// The digest was validated when the upload was created
+	if expected, _ := transfer.ParseDigest(upload.Metadata["sha256"]); expected != nil && !bytes.Equal(expected, digest) {
+		sc.removeTusUpload(upload.ID)
+		events.Publish(events.TransferFailed{Name: upload.Filename, Method: "tus", Reason: events.Corrupt})
+		sc.recordTransfer(r, history.Entry{
+			Direction:  history.Received,
+			Method:     "tus",
+			Files:      []history.File{{Name: upload.Filename, Size: upload.Length, SHA256: sum}},
+			DurationMS: sinceMS(upload.CreatedAt),
+			Result:     history.Failed,
+			Error:      transfer.ErrChecksumMismatch.Error(),
+		})
+		return "", transfer.ErrChecksumMismatch
+	}
+
+	rel, err := transfer.CleanRelativePath(upload.Filename)
+	if err != nil {
+		return "", err
+	}
+	// The share may have been removed or made read-only since the upload began
+	dir, err := sc.writeDir(upload.Metadata["share"])
+	if err != nil {
+		return "", err
+	}
+	// Metadata entries named like tus-js-client's, plus lastModified and sessionId
+	modTime, err := transfer.ParseLastModified(upload.Metadata["lastModified"])
+	if err != nil {
+		log.Printf("Ignoring lastModified for %s: %v", upload.Filename, err)
+	}
+	res, err := transfer.SaveFile(r.Context(), sc.prefs, dir, rel, partPath, transfer.IncomingFile{
+		Size:      upload.Length,
+		SHA256:    digest,
+		ModTime:   modTime,
+		MIMEType:  upload.Metadata["filetype"],
+		Device:    requestDeviceName(r),
+		PeerAddr:  remoteIP(r),
+		SessionID: upload.Metadata["sessionId"],
+	})
+	if err != nil {
+		return "", err
+	}
+	sc.removeTusUpload(upload.ID)
+	savePath := res.Path
+
+	// The duration runs from creation
+	sc.recordTransfer(r, history.Entry{
+		Direction:  history.Received,
+		Method:     "tus",
+		Files:      []history.File{{Name: upload.Filename, Path: savePath, Size: upload.Length, SHA256: sum}},
+		DurationMS: sinceMS(upload.CreatedAt),
+		Result:     history.Completed,
+	})
+
+	events.Publish(events.FileReceived{
+		Name:    upload.Filename,
+		Path:    savePath,
+		Method:  "tus",
+		Device:  requestDeviceName(r),
+		Size:    upload.Length,
+		Skipped: res.Skip,
+	})
+	if res.Skip {
+		result = progress.Skipped
+		return savePath, nil
+	}
+	result = progress.Completed
+
+	sc.notifyReceived(dir, []string{savePath})
+
+	return savePath, nil
+}
+
+// writeTusNotFound reports an upload that couldn't be loaded. The lock taken
+// for an ID that has no upload is dropped again, so requests for made up IDs
+// don't pile up locks. The caller must hold it.
+func writeTusNotFound(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		tusLocks.Delete(id)
+	}
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Upload not found")
+}
+
+// writeTusFinishError reports a failure to finalize an upload. A checksum
+// mismatch uses the status code of the tus checksum extension.
+func writeTusFinishError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, transfer.ErrChecksumMismatch) {
+		writeError(w, r, tusStatusChecksumMismatch, api.CodeChecksumMismatch, "Checksum mismatch, please upload again")
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+}
+
+// removeTusUpload removes the state and data of an upload, and its lock
+// along with them. The caller must hold the lock; requests already waiting
+// for it find the upload gone.
+func (sc *ServerController) removeTusUpload(id string) {
+	defer tusLocks.Delete(id)
+	root, err := sc.openTusRoot()
+	if err != nil {
+		return
+	}
+	defer root.Close()
+	root.Remove(tusInfoName(id))
+	root.Remove(tusPartName(id))
+}
+
+// setTusExpires reports when an upload last written at lastWrite expires
+func setTusExpires(w http.ResponseWriter, lastWrite time.Time) {
+	w.Header().Set("Upload-Expires", lastWrite.Add(tusExpiry).UTC().Format(http.TimeFormat))
+}
+
+func tusExpired(lastWrite, now time.Time) bool {
+	return now.Sub(lastWrite) > tusExpiry
+}
+
+// sweepTusUploads removes the state and partial data of uploads that have
+// expired, including leftovers whose state or data file is missing, and
+// returns how many it removed.
+func (sc *ServerController) sweepTusUploads(now time.Time) int {
+	entries, err := os.ReadDir(sc.tusDir())
+	if err != nil {
+		return 0
+	}
+
+	seen := make(map[string]bool)
+	removed := 0
+	for _, entry := range entries {
+		id := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".json"), ".part")
+		if seen[id] || !tusIDPattern.MatchString(id) {
+			continue
+		}
+		seen[id] = true
+
+		lock := tusLock(id)
+		lock.Lock()
+		// The newest of the two files tells when the upload was last touched
+		var lastWrite time.Time
+		for _, path := range []string{sc.tusInfoPath(id), sc.tusPartPath(id)} {
+			if stat, err := os.Stat(path); err == nil && stat.ModTime().After(lastWrite) {
+				lastWrite = stat.ModTime()
+			}
+		}
+		expired := tusExpired(lastWrite, now)
+		if expired {
+			sc.removeTusUpload(id)
+			finishTusTransfer(id, progress.Failed)
+			removed++
+		}
+		lock.Unlock()
+	}
+	return removed
+}
+
+// sweepTusUploadsUntil sweeps expired uploads now and then every
+// tusSweepInterval until ctx is done
+func (sc *ServerController) sweepTusUploadsUntil(ctx context.Context) {
+	ticker := time.NewTicker(tusSweepInterval)
+	defer ticker.Stop()
+	for {
+		if n := sc.sweepTusUploads(time.Now()); n > 0 {
+			log.Printf("Removed %d abandoned resumable upload(s)", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair: %q", pair)
+		}
+	}
+	return metadata, nil
+}
+
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
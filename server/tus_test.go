@@ -0,0 +1,488 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"lan-drop/api"
+	"lan-drop/config"
+	"lan-drop/events"
+	"lan-drop/progress"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func newTusTestController(t *testing.T, dir string) *ServerController {
+	t.Helper()
+	prefs := &config.Preferences{
+		UploadDir:         dir,
+		Port:              8080,
+		ShowNotifications: false,
+	}
+	return NewServerController(8080, dir, prefs, testEmbeddedFiles, "test-version")
+}
+
+func tusRequest(method, target string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+func createTusUpload(t *testing.T, controller *ServerController, filename string, length string) string {
+	t.Helper()
+	req := tusRequest(http.MethodPost, "/tus/", "")
+	req.Header.Set("Upload-Length", length)
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename)))
+	w := httptest.NewRecorder()
+
+	controller.handleTus(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, tusBasePath) {
+		t.Fatalf("Unexpected Location header: %s", location)
+	}
+	return location
+}
+
+func patchTusUpload(controller *ServerController, location, offset, body string) *httptest.ResponseRecorder {
+	req := tusRequest(http.MethodPatch, location, body)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", offset)
+	w := httptest.NewRecorder()
+	controller.handleTus(w, req)
+	return w
+}
+
+func TestTusOptions(t *testing.T) {
+	controller := newTusTestController(t, t.TempDir())
+
+	req := httptest.NewRequest(http.MethodOptions, "/tus/", nil)
+	w := httptest.NewRecorder()
+	controller.handleTus(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	if w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("Expected Tus-Version %s, got %s", tusVersion, w.Header().Get("Tus-Version"))
+	}
+	if !strings.Contains(w.Header().Get("Tus-Extension"), "creation") {
+		t.Errorf("Expected creation extension, got %s", w.Header().Get("Tus-Extension"))
+	}
+}
+
+func TestTusRequiresVersionHeader(t *testing.T) {
+	controller := newTusTestController(t, t.TempDir())
+
+	req := httptest.NewRequest(http.MethodPost, "/tus/", nil)
+	req.Header.Set("Upload-Length", "5")
+	w := httptest.NewRecorder()
+	controller.handleTus(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412, got %d", w.Code)
+	}
+}
+
+func TestTusUploadInChunks(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
+
+	location := createTusUpload(t, controller, "video.mp4", "11")
+
+	w := patchTusUpload(controller, location, "0", "hello ")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+	if w.Header().Get("Upload-Offset") != "6" {
+		t.Errorf("Expected offset 6, got %s", w.Header().Get("Upload-Offset"))
+	}
+
+	// HEAD reports the offset to resume from
+	head := httptest.NewRecorder()
+	controller.handleTus(head, tusRequest(http.MethodHead, location, ""))
+	if head.Header().Get("Upload-Offset") != "6" || head.Header().Get("Upload-Length") != "11" {
+		t.Errorf("Unexpected HEAD headers: offset=%s length=%s", head.Header().Get("Upload-Offset"), head.Header().Get("Upload-Length"))
+	}
+
+	w = patchTusUpload(controller, location, "6", "world")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "video.mp4"))
+	if err != nil {
+		t.Fatalf("Completed upload was not saved: %v", err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("Expected 'hello world', got '%s'", string(content))
+	}
+
+	statusMessages := drainStatuses(received)
+	if len(statusMessages) == 0 || statusMessages[len(statusMessages)-1] != "Received: video.mp4" {
+		t.Errorf("Expected completion status, got %v", statusMessages)
+	}
+
+	// State is cleaned up once the upload is done
+	entries, _ := os.ReadDir(filepath.Join(tempDir, tusStateDir))
+	if len(entries) != 0 {
+		t.Errorf("Expected no leftover state, found %d entries", len(entries))
+	}
+}
+
+func TestTusProgress(t *testing.T) {
+	controller := newTusTestController(t, t.TempDir())
+	updates, cancel := progress.Default.Subscribe()
+	defer cancel()
+
+	tusProgress := func(name string) *progress.Event {
+		for _, e := range progress.Default.Active() {
+			if e.Method == "tus" && e.Name == name {
+				return &e
+			}
+		}
+		return nil
+	}
+	lastResult := func(name string) progress.Result {
+		var result progress.Result
+		for len(updates) > 0 {
+			if e := <-updates; e.Method == "tus" && e.Name == name && e.Done {
+				result = e.Result
+			}
+		}
+		return result
+	}
+
+	// The upload is followed across its requests
+	location := createTusUpload(t, controller, "progress.bin", "11")
+	patchTusUpload(controller, location, "0", "hello ")
+	if e := tusProgress("progress.bin"); e == nil || e.Bytes != 6 || e.Size != 11 {
+		t.Fatalf("Expected 6 of 11 bytes in progress, got %+v", e)
+	}
+	patchTusUpload(controller, location, "6", "world")
+	if e := tusProgress("progress.bin"); e != nil {
+		t.Errorf("Expected the finished upload to stop being followed, got %+v", e)
+	}
+	if result := lastResult("progress.bin"); result != progress.Completed {
+		t.Errorf("Expected the upload to complete, got %q", result)
+	}
+
+	// An interrupted request fails the transfer, and resuming starts a new
+	// one from the offset
+	location = createTusUpload(t, controller, "resumed.bin", "10")
+	req := httptest.NewRequest(http.MethodPatch, location, io.MultiReader(strings.NewReader("abcd"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	controller.handleTus(httptest.NewRecorder(), req)
+	if result := lastResult("resumed.bin"); result != progress.Failed {
+		t.Errorf("Expected the interrupted transfer to fail, got %q", result)
+	}
+	patchTusUpload(controller, location, "4", "ef")
+	if e := tusProgress("resumed.bin"); e == nil || e.Bytes != 6 {
+		t.Errorf("Expected 6 bytes in progress, got %+v", e)
+	}
+
+	// Terminating the upload ends it
+	w := httptest.NewRecorder()
+	controller.handleTus(w, tusRequest(http.MethodDelete, location, ""))
+	if e := tusProgress("resumed.bin"); e != nil {
+		t.Errorf("Expected the terminated upload to stop being followed, got %+v", e)
+	}
+	if result := lastResult("resumed.bin"); result != progress.Failed {
+		t.Errorf("Expected the upload to fail, got %q", result)
+	}
+}
+
+func TestTusForgetsLocks(t *testing.T) {
+	controller := newTusTestController(t, t.TempDir())
+	locked := func(location string) bool {
+		_, ok := tusLocks.Load(strings.TrimPrefix(location, tusBasePath))
+		return ok
+	}
+
+	finished := createTusUpload(t, controller, "done.txt", "4")
+	patchTusUpload(controller, finished, "0", "done")
+	terminated := createTusUpload(t, controller, "gone.txt", "4")
+	patchTusUpload(controller, terminated, "0", "go")
+	controller.handleTus(httptest.NewRecorder(), tusRequest(http.MethodDelete, terminated, ""))
+	unknown := tusBasePath + strings.Repeat("0", 32)
+	controller.handleTus(httptest.NewRecorder(), tusRequest(http.MethodHead, unknown, ""))
+
+	for name, location := range map[string]string{"finished": finished, "terminated": terminated, "unknown": unknown} {
+		if locked(location) {
+			t.Errorf("Expected the lock of the %s upload to be dropped", name)
+		}
+	}
+}
+
+func TestTusOffsetMismatch(t *testing.T) {
+	controller := newTusTestController(t, t.TempDir())
+	location := createTusUpload(t, controller, "file.bin", "10")
+
+	w := patchTusUpload(controller, location, "4", "data")
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+}
+
+func TestTusUnderAPIPrefix(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+
+	req := tusRequest(http.MethodPost, api.Prefix+"tus/", "")
+	req.Header.Set("Upload-Length", "4")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("v1.txt")))
+	w := httptest.NewRecorder()
+	controller.handleTus(w, req)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(location, api.Prefix+"tus/") {
+		t.Fatalf("Expected an upload under the API prefix, got %d at %q", w.Code, location)
+	}
+
+	// Errors are JSON objects, as everywhere under the prefix
+	w = patchTusUpload(controller, location, "2", "data")
+	var resp api.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusConflict || resp.Error.Code != api.CodeOffsetMismatch {
+		t.Errorf("Expected a JSON offset_mismatch error, got %d %q", w.Code, w.Body.String())
+	}
+
+	if w := patchTusUpload(controller, location, "0", "data"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(tempDir, "v1.txt")); err != nil || string(data) != "data" {
+		t.Errorf("Expected the file to be saved, got %q, %v", data, err)
+	}
+}
+
+func TestTusResumeAfterRestart(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+	location := createTusUpload(t, controller, "notes.txt", "8")
+
+	if w := patchTusUpload(controller, location, "0", "resu"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	// A fresh controller on the same folder picks the upload up again
+	restarted := newTusTestController(t, tempDir)
+
+	head := httptest.NewRecorder()
+	restarted.handleTus(head, tusRequest(http.MethodHead, location, ""))
+	if head.Code != http.StatusOK || head.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("Expected offset 4 after restart, got status %d offset %s", head.Code, head.Header().Get("Upload-Offset"))
+	}
+
+	if w := patchTusUpload(restarted, location, "4", "med!"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "notes.txt"))
+	if err != nil {
+		t.Fatalf("Completed upload was not saved: %v", err)
+	}
+	if string(content) != "resumed!" {
+		t.Errorf("Expected 'resumed!', got '%s'", string(content))
+	}
+}
+
+func TestTusTermination(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+	location := createTusUpload(t, controller, "cancel.txt", "10")
+
+	w := httptest.NewRecorder()
+	controller.handleTus(w, tusRequest(http.MethodDelete, location, ""))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	head := httptest.NewRecorder()
+	controller.handleTus(head, tusRequest(http.MethodHead, location, ""))
+	if head.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after termination, got %d", head.Code)
+	}
+}
+
+func TestTusExpiry(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+
+	req := tusRequest(http.MethodPost, "/tus/", "")
+	req.Header.Set("Upload-Length", "10")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("stale.bin")))
+	w := httptest.NewRecorder()
+	controller.handleTus(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	expires, err := http.ParseTime(w.Header().Get("Upload-Expires"))
+	if err != nil || time.Until(expires) < tusExpiry-time.Minute {
+		t.Errorf("Expected Upload-Expires about %v from now, got %q", tusExpiry, w.Header().Get("Upload-Expires"))
+	}
+	stale := w.Header().Get("Location")
+	staleID := strings.TrimPrefix(stale, tusBasePath)
+	if w := patchTusUpload(controller, stale, "0", "part"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("Expected status 204 with Upload-Expires, got %d %q", w.Code, w.Header().Get("Upload-Expires"))
+	}
+	fresh := createTusUpload(t, controller, "fresh.bin", "10")
+
+	// The stale upload was last written longer ago than the expiry
+	old := time.Now().Add(-tusExpiry - time.Hour)
+	for _, path := range []string{controller.tusInfoPath(staleID), controller.tusPartPath(staleID)} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Failed to age %s: %v", path, err)
+		}
+	}
+	// A leftover data file without its state is old too
+	orphan := strings.Repeat("ab", 16)
+	os.WriteFile(controller.tusPartPath(orphan), []byte("x"), 0644)
+	os.Chtimes(controller.tusPartPath(orphan), old, old)
+
+	head := httptest.NewRecorder()
+	controller.handleTus(head, tusRequest(http.MethodHead, stale, ""))
+	if head.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an expired upload, got %d", head.Code)
+	}
+
+	if removed := controller.sweepTusUploads(time.Now()); removed != 2 {
+		t.Errorf("Expected 2 uploads removed, got %d", removed)
+	}
+	for _, path := range []string{controller.tusInfoPath(staleID), controller.tusPartPath(staleID), controller.tusPartPath(orphan)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", filepath.Base(path))
+		}
+	}
+
+	if w := patchTusUpload(controller, fresh, "0", "still here"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the fresh upload to survive the sweep, got status %d", w.Code)
+	}
+}
+
+func TestTusConflictingFilename(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, tempDir)
+	os.WriteFile(filepath.Join(tempDir, "photo.jpg"), []byte("old"), 0644)
+
+	location := createTusUpload(t, controller, "photo.jpg", "3")
+	if w := patchTusUpload(controller, location, "0", "new"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "photo_1.jpg")); err != nil {
+		t.Errorf("Expected upload to be saved as photo_1.jpg: %v", err)
+	}
+}
+
+func TestTusSanitizesFilename(t *testing.T) {
+	tempDir := t.TempDir()
+	controller := newTusTestController(t, filepath.Join(tempDir, "uploads"))
+
+	location := createTusUpload(t, controller, "../../photo.jpg", "3")
+	if w := patchTusUpload(controller, location, "0", "new"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "uploads", ".._.._photo.jpg")); err != nil {
+		t.Errorf("Expected upload to be saved as .._.._photo.jpg: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the upload not to leave the upload folder")
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := parseTusMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if metadata["filename"] != "world_domination_plan.pdf" {
+		t.Errorf("Unexpected filename: %s", metadata["filename"])
+	}
+	if _, ok := metadata["is_confidential"]; !ok {
+		t.Error("Expected key without value to be present")
+	}
+
+	if _, err := parseTusMetadata("filename not-base64!"); err == nil {
+		t.Error("Expected error for invalid base64")
+	}
+}
+
+func TestTusVerifiesSHA256(t *testing.T) {
+	dir := t.TempDir()
+	controller := newTusTestController(t, dir)
+
+	create := func(filename, digest string) *httptest.ResponseRecorder {
+		req := tusRequest(http.MethodPost, "/tus/", "")
+		req.Header.Set("Upload-Length", "5")
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename))+
+			",sha256 "+base64.StdEncoding.EncodeToString([]byte(digest)))
+		w := httptest.NewRecorder()
+		controller.handleTus(w, req)
+		return w
+	}
+
+	// hex SHA-256 of "hello"
+	const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if w := create("bad.txt", "not-a-digest"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an invalid digest, got %d", w.Code)
+	}
+
+	w := create("good.txt", helloSHA256)
+	if w := patchTusUpload(controller, w.Header().Get("Location"), "0", "hello"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "good.txt")); err != nil || string(content) != "hello" {
+		t.Errorf("Expected verified upload to be saved, got %q (err=%v)", content, err)
+	}
+
+	w = create("corrupt.txt", helloSHA256)
+	if w := patchTusUpload(controller, w.Header().Get("Location"), "0", "jello"); w.Code != tusStatusChecksumMismatch {
+		t.Fatalf("Expected status 460, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected corrupt upload not to be saved, got err=%v", err)
+	}
+}
+
+func TestTusUploadIntoShare(t *testing.T) {
+	controller, dirs := newSharesTestController(t)
+
+	create := func(share string) *httptest.ResponseRecorder {
+		req := tusRequest(http.MethodPost, "/tus/", "")
+		req.Header.Set("Upload-Length", "3")
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("new.txt"))+
+			",share "+base64.StdEncoding.EncodeToString([]byte(share)))
+		w := httptest.NewRecorder()
+		controller.handleTus(w, req)
+		return w
+	}
+
+	if w := create("Design assets"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read-only share to refuse uploads, got %d", w.Code)
+	}
+
+	w := create("Scratch")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := patchTusUpload(controller, w.Header().Get("Location"), "0", "new"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+	if content, err := os.ReadFile(filepath.Join(dirs["Scratch"], "new.txt")); err != nil || string(content) != "new" {
+		t.Errorf("Expected the upload in the Scratch share, got '%s' (%v)", content, err)
+	}
+}
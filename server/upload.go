@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/progress"
+	"lan-drop/transfer"
+)
+
+const (
+	// maxUploadFieldSize bounds the non-file fields of an upload form
+	maxUploadFieldSize = 4 << 10
+	// maxManifestSize bounds the manifest field, which lists every file
+	maxManifestSize = 256 << 10
+)
+
+// isHiddenEntry reports whether a directory entry is LANDrop bookkeeping or
+// OS clutter that must not be listed or downloaded
+func isHiddenEntry(name string) bool {
+	return name == ".DS_Store" || name == tusStateDir || strings.HasPrefix(name, transfer.PartFilePrefix)
+}
+
+// savedName returns where a received file was saved, relative to the folder
+// it was uploaded to and with forward slashes
+func savedName(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// pendingUpload is a file streamed into a .part file, not yet in place
+type pendingUpload struct {
+	name     string // relative path with forward slashes, as sent
+	rel      string // cleaned relative path under the upload folder
+	partPath string
+	size     int64
+	sum      []byte
+	mimeType string
+	progress *progress.Transfer
+}
+
+// handleUpload receives a multipart form with one or more "file" parts. The
+// parts are streamed straight into hidden .part files in the upload folder
+// and renamed into place once the whole request has been read, so nothing is
+// buffered in the temp dir and an aborted upload leaves no files behind.
+//
+// Optional "sha256" fields, one per file in the same order, are checked
+// against the received data. Optional "relativePath" fields, also one per
+// file, recreate a dropped folder tree under the upload folder, and
+// "lastModified" fields (milliseconds since the epoch or RFC 3339) give the
+// files their original modification time. An optional "sessionId" field is
+// recorded in sidecars. The response lists the outcome for each file.
+//
+// When the desktop user is asked before receiving, the question comes with
+// the first file part, before any of it is written. An optional "manifest"
+// field sent ahead of the files, a JSON array of {"name", "size"} objects,
+// is what the user sees; the request may then not carry more files or bytes
+// than it announces. Without one, the user is asked for each file part in
+// turn, seeing its name and the size of the rest of the request.
+//
+// ?share= uploads into a writable named share instead of the upload folder.
+// It's a query parameter so the folder is known before the files arrive.
+func (sc *ServerController) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r, "Only POST allowed")
+		return
+	}
+
+	dir, err := sc.writeDir(r.URL.Query().Get("share"))
+	if err != nil {
+		writePathError(w, r, err, "Share not found", "Failed to save file")
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Failed to parse form")
+		return
+	}
+
+	start := time.Now()
+	entry := history.Entry{Direction: history.Received, Method: "upload"}
+
+	var pending []pendingUpload
+	var digestFields, pathFields, modTimeFields []string
+	var sessionID, progressSession string
+	var manifest []transfer.FileSummary
+	var manifestSize, received int64 // bytes announced by the manifest, and written so far
+	consented := false
+
+	// Remove every .part file that didn't make it into place
+	defer func() {
+		for _, p := range pending {
+			p.progress.Finish(progress.Failed)
+			if err := os.Remove(p.partPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove partial upload %s: %v", p.partPath, err)
+			}
+		}
+	}()
+
+	fail := func(status int, code api.ErrorCode, message string) {
+		entry.DurationMS = sinceMS(start)
+		entry.Result, entry.Error = history.Failed, message
+		sc.recordTransfer(r, entry)
+		writeError(w, r, status, code, message)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+			return
+		}
+
+		switch {
+		case part.FormName() == "sha256":
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			if err != nil {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+				return
+			}
+			digestFields = append(digestFields, string(value))
+
+		case part.FormName() == "relativePath":
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			if err != nil {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+				return
+			}
+			pathFields = append(pathFields, string(value))
+
+		case part.FormName() == "lastModified" || part.FormName() == "sessionId":
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			if err != nil {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+				return
+			}
+			if part.FormName() == "sessionId" {
+				sessionID = string(value)
+			} else {
+				modTimeFields = append(modTimeFields, string(value))
+			}
+
+		case part.FormName() == "manifest":
+			value, err := io.ReadAll(io.LimitReader(part, maxManifestSize))
+			if err != nil {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+				return
+			}
+			if len(pending) > 0 || json.Unmarshal(value, &manifest) != nil || len(manifest) == 0 {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Invalid manifest, it must be a list of files sent before them")
+				return
+			}
+			for _, f := range manifest {
+				if f.Size < 0 {
+					fail(http.StatusBadRequest, api.CodeBadRequest, "Invalid manifest, it must be a list of files sent before them")
+					return
+				}
+				manifestSize += f.Size
+			}
+
+		case part.FormName() == "file" && part.FileName() != "":
+			// Nothing is written unless the desktop user accepts the transfer.
+			// Without a manifest the user only saw one file, so each file part
+			// is asked for on its own.
+			if !consented {
+				summaries := manifest
+				if summaries == nil {
+					summaries = []transfer.FileSummary{{Name: part.FileName(), Size: max(r.ContentLength-received, 0)}}
+				}
+				if decision := sc.requestConsent(r, summaries); !decision.Accepted {
+					for _, f := range summaries {
+						entry.Files = append(entry.Files, history.File{Name: f.Name, Size: f.Size})
+					}
+					entry.DurationMS = sinceMS(start)
+					entry.Result, entry.Error = history.Declined, decision.Message
+					sc.recordTransfer(r, entry)
+					writeError(w, r, http.StatusForbidden, api.CodeDeclined, decision.Message)
+					return
+				}
+				consented = manifest != nil
+			}
+			if manifest != nil && len(pending) >= len(manifest) {
+				fail(http.StatusBadRequest, api.CodeBadRequest, "More files than the manifest announced")
+				return
+			}
+
+			out, err := transfer.CreatePartFile(dir)
+			if err != nil {
+				fail(http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+				return
+			}
+			// Part sizes aren't known in advance, so the request's length
+			// stands in for the session's
+			if progressSession == "" {
+				progressSession = sessionID
+				if progressSession == "" {
+					progressSession = progress.Default.NewSessionID()
+				}
+			}
+			p := pendingUpload{name: part.FileName(), partPath: out.Name(), mimeType: part.Header.Get("Content-Type")}
+			p.progress = progress.Default.Start(progress.Info{
+				SessionID:   progressSession,
+				Name:        p.name,
+				Method:      "upload",
+				Device:      requestDeviceName(r),
+				SessionSize: r.ContentLength,
+			})
+			pending = append(pending, p)
+
+			// Hash while writing so the file is only read once
+			var body io.Reader = part
+			if manifest != nil {
+				// One byte more than announced is enough to tell it's too much
+				body = io.LimitReader(part, manifestSize-received+1)
+			}
+			hash := sha256.New()
+			size, copyErr := io.Copy(io.MultiWriter(out, hash), p.progress.Reader(body))
+			closeErr := out.Close()
+			received += size
+			if manifest != nil && received > manifestSize {
+				fail(http.StatusRequestEntityTooLarge, api.CodeTooLarge, "The files are larger than the manifest announced")
+				return
+			}
+			if copyErr != nil {
+				log.Printf("Upload of %s interrupted after %d bytes: %v", p.name, size, copyErr)
+				fail(http.StatusBadRequest, api.CodeBadRequest, "Upload interrupted")
+				return
+			}
+			if closeErr != nil {
+				fail(http.StatusInternalServerError, api.CodeInternal, "Failed to save file")
+				return
+			}
+			pending[len(pending)-1].size = size
+			pending[len(pending)-1].sum = hash.Sum(nil)
+		}
+		part.Close()
+	}
+
+	if len(pending) == 0 {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "No files uploaded")
+		return
+	}
+
+	digests := make([][]byte, len(pending))
+	for i, value := range digestFields {
+		if i >= len(pending) {
+			break
+		}
+		digest, err := transfer.ParseDigest(value)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid sha256 for "+pending[i].name)
+			return
+		}
+		digests[i] = digest
+	}
+
+	// A bad modification time only costs the original date, so it's ignored
+	modTimes := make([]time.Time, len(pending))
+	for i, value := range modTimeFields {
+		if i >= len(pending) {
+			break
+		}
+		modTime, err := transfer.ParseLastModified(value)
+		if err != nil {
+			log.Printf("Ignoring lastModified %q for %s: %v", value, pending[i].name, err)
+		}
+		modTimes[i] = modTime
+	}
+
+	for i := range pending {
+		p := &pending[i]
+		relativePath := ""
+		if i < len(pathFields) {
+			relativePath = pathFields[i]
+		}
+		rel, err := transfer.ReceivedPath(p.name, relativePath)
+		// The resumable upload state lives in the upload folder too
+		if err != nil || strings.SplitN(filepath.ToSlash(rel), "/", 2)[0] == tusStateDir {
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid relativePath for "+p.name)
+			return
+		}
+		p.rel, p.name = rel, filepath.ToSlash(rel)
+	}
+
+	status := http.StatusOK
+	results := make([]api.UploadResult, 0, len(pending))
+	var savedFiles, problems []string
+
+	for i, p := range pending {
+		result := api.UploadResult{Name: p.name, Size: p.size, SHA256: hex.EncodeToString(p.sum)}
+		file := history.File{Name: p.name, Size: p.size, SHA256: result.SHA256}
+
+		if digests[i] != nil && !bytes.Equal(p.sum, digests[i]) {
+			// Never announce a corrupt file as received
+			result.Status, result.Error = api.UploadCorrupt, transfer.ErrChecksumMismatch.Error()
+			events.Publish(events.TransferFailed{Name: p.name, Method: "upload", Reason: events.Corrupt})
+			if status == http.StatusOK {
+				status = http.StatusUnprocessableEntity
+			}
+		} else if res, err := transfer.SaveFile(r.Context(), sc.prefs, dir, p.rel, p.partPath, transfer.IncomingFile{
+			Size:      p.size,
+			SHA256:    p.sum,
+			ModTime:   modTimes[i],
+			MIMEType:  p.mimeType,
+			Device:    requestDeviceName(r),
+			PeerAddr:  remoteIP(r),
+			SessionID: sessionID,
+		}); err != nil {
+			log.Printf("Failed to save %s: %v", p.name, err)
+			result.Status, result.Error = api.UploadFailed, "Failed to save file"
+			events.Publish(events.TransferFailed{Name: p.name, Method: "upload", Reason: events.SaveFailed})
+			status = http.StatusInternalServerError
+		} else if res.Skip {
+			// The conflict policy kept the existing file
+			result.Status, result.SavedAs = api.UploadSkipped, savedName(dir, res.Path)
+			file.Path = res.Path
+		} else {
+			result.Status, result.SavedAs = api.UploadSaved, savedName(dir, res.Path)
+			file.Path = res.Path
+			savedFiles = append(savedFiles, res.Path)
+		}
+		if file.Path != "" {
+			events.Publish(events.FileReceived{
+				Name:    p.name,
+				Path:    file.Path,
+				Method:  "upload",
+				Device:  requestDeviceName(r),
+				Size:    p.size,
+				Skipped: result.Status == api.UploadSkipped,
+			})
+		}
+
+		switch result.Status {
+		case api.UploadSaved:
+			p.progress.Finish(progress.Completed)
+		case api.UploadSkipped:
+			p.progress.Finish(progress.Skipped)
+		default:
+			p.progress.Finish(progress.Failed)
+			problems = append(problems, fmt.Sprintf("%s (%s)", p.name, result.Error))
+		}
+		results = append(results, result)
+		entry.Files = append(entry.Files, file)
+	}
+	if len(savedFiles) > 0 {
+		events.Publish(events.FilesReceived{Paths: savedFiles, Dir: dir, Method: "upload"})
+	}
+
+	entry.DurationMS = sinceMS(start)
+	entry.Result = history.Completed
+	if len(problems) > 0 {
+		entry.Result, entry.Error = history.Failed, strings.Join(problems, ", ")
+	}
+	sc.recordTransfer(r, entry)
+
+	if len(savedFiles) > 0 {
+		sc.notifyReceived(dir, savedFiles)
+	}
+
+	writeJSON(w, status, api.UploadResponse{Files: results})
+}
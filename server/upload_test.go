@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"lan-drop/api"
+	"lan-drop/config"
+	"lan-drop/transfer"
+)
+
+// failingReader returns data and then an error, like a client that goes away
+type failingReader struct {
+	data []byte
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestHandleUploadStreamsMultipleFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+	os.WriteFile(tempDir+"/a.txt", []byte("existing"), 0644)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range map[string]string{"a.txt": "first", "b.txt": "second"} {
+		fileWriter, _ := writer.CreateFormFile("file", name)
+		fileWriter.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Files []api.UploadResult `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	saved := map[string]string{}
+	for _, f := range response.Files {
+		saved[f.Name] = f.SavedAs
+	}
+	if saved["a.txt"] != "a_1.txt" || saved["b.txt"] != "b.txt" {
+		t.Errorf("Unexpected saved names: %v", saved)
+	}
+
+	// Only the final files remain, no .part leftovers
+	entries, _ := os.ReadDir(tempDir)
+	for _, entry := range entries {
+		if isHiddenEntry(entry.Name()) {
+			t.Errorf("Leftover partial upload: %s", entry.Name())
+		}
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 files in the upload folder, got %d", len(entries))
+	}
+}
+
+func TestHandleUploadCleansUpOnAbort(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, _ := writer.CreateFormFile("file", "big.bin")
+	fileWriter.Write(bytes.Repeat([]byte("x"), 64*1024))
+	writer.Close()
+
+	// Cut the body off in the middle of the file part
+	truncated := body.Bytes()[:body.Len()/2]
+	req := httptest.NewRequest("POST", "/upload", io.NopCloser(&failingReader{data: truncated}))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after an aborted upload, found %d", len(entries))
+	}
+}
+
+func TestIsHiddenEntry(t *testing.T) {
+	for name, hidden := range map[string]bool{
+		".DS_Store":                          true,
+		tusStateDir:                          true,
+		transfer.PartFilePrefix + "123.part": true,
+		"photo.jpg":                          false,
+		"report.part":                        false,
+	} {
+		if got := isHiddenEntry(name); got != hidden {
+			t.Errorf("isHiddenEntry(%q) = %v, want %v", name, got, hidden)
+		}
+	}
+}
+
+func TestHandleUploadAppliesConflictPolicy(t *testing.T) {
+	testCases := []struct {
+		policy   string
+		content  string
+		status   string
+		savedAs  string
+		existing string // content of dup.txt afterwards
+	}{
+		{"rename", "new", "saved", "dup_1.txt", "old"},
+		{"overwrite", "new", "saved", "dup.txt", "new"},
+		{"skip_identical", "old", "skipped", "dup.txt", "old"},
+		{"skip_identical", "new", "saved", "dup_1.txt", "old"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.policy+"/"+tc.content, func(t *testing.T) {
+			tempDir := t.TempDir()
+			prefs := &config.Preferences{UploadDir: tempDir, ConflictPolicy: tc.policy}
+			controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+			os.WriteFile(tempDir+"/dup.txt", []byte("old"), 0644)
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			fileWriter, _ := writer.CreateFormFile("file", "dup.txt")
+			fileWriter.Write([]byte(tc.content))
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/upload", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+			controller.handleUpload(w, req)
+
+			var response struct {
+				Files []api.UploadResult `json:"files"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Files) != 1 {
+				t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+			}
+			result := response.Files[0]
+			if result.Status != tc.status || result.SavedAs != tc.savedAs {
+				t.Errorf("Expected %s as %s, got %s as %s", tc.status, tc.savedAs, result.Status, result.SavedAs)
+			}
+			if content, _ := os.ReadFile(tempDir + "/dup.txt"); string(content) != tc.existing {
+				t.Errorf("Expected dup.txt to contain '%s', got '%s'", tc.existing, content)
+			}
+
+			entries, _ := os.ReadDir(tempDir)
+			for _, entry := range entries {
+				if isHiddenEntry(entry.Name()) {
+					t.Errorf("Leftover partial upload: %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestHandleUploadRecreatesFolders(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, path := range []string{"Photos/a.jpg", "Photos/2024/b.jpg"} {
+		writer.WriteField("relativePath", path)
+	}
+	for _, content := range []string{"first", "second"} {
+		fileWriter, _ := writer.CreateFormFile("file", "ignored.jpg")
+		fileWriter.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for path, expected := range map[string]string{"Photos/a.jpg": "first", "Photos/2024/b.jpg": "second"} {
+		content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(path)))
+		if err != nil || string(content) != expected {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", path, expected, content, err)
+		}
+	}
+}
+
+func TestHandleUploadRejectsEscapingPath(t *testing.T) {
+	for _, path := range []string{"../escape.txt", "/etc/passwd", "Photos/../../escape.txt", tusStateDir + "/x"} {
+		t.Run(path, func(t *testing.T) {
+			parent := t.TempDir()
+			tempDir := filepath.Join(parent, "uploads")
+			os.Mkdir(tempDir, 0755)
+			prefs := &config.Preferences{UploadDir: tempDir}
+			controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			writer.WriteField("relativePath", path)
+			fileWriter, _ := writer.CreateFormFile("file", "escape.txt")
+			fileWriter.Write([]byte("data"))
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/upload", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+			controller.handleUpload(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+			if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+				t.Error("Expected nothing to be written outside the upload folder")
+			}
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Errorf("Expected an empty upload folder, found %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestHandleUploadKeepsModificationTime(t *testing.T) {
+	tempDir := t.TempDir()
+	prefs := &config.Preferences{UploadDir: tempDir}
+	controller := NewServerController(8080, tempDir, prefs, testEmbeddedFiles, "test-version")
+
+	modTime := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("lastModified", strconv.FormatInt(modTime.UnixMilli(), 10))
+	fileWriter, _ := writer.CreateFormFile("file", "photo.jpg")
+	fileWriter.Write([]byte("jpeg"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	controller.handleUpload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	stat, err := os.Stat(filepath.Join(tempDir, "photo.jpg"))
+	if err != nil {
+		t.Fatalf("Uploaded file missing: %v", err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, stat.ModTime())
+	}
+}
+
+func TestHandleUploadIntoShare(t *testing.T) {
+	controller, dirs := newSharesTestController(t)
+
+	upload := func(target string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		fileWriter, _ := writer.CreateFormFile("file", "report.txt")
+		fileWriter.Write([]byte("report"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", target, &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		controller.handleUpload(w, req)
+		return w
+	}
+
+	if w := upload("/upload?share=Inbox"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(dirs["Inbox"], "report.txt")); err != nil || string(content) != "report" {
+		t.Errorf("Expected the file in the Inbox share, got '%s' (%v)", content, err)
+	}
+
+	if w := upload("/upload?share=Design+assets"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read-only share to refuse uploads, got %d", w.Code)
+	}
+	if w := upload("/upload?share=Nope"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown share to be reported, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dirs["Design assets"], "report.txt")); !os.IsNotExist(err) {
+		t.Error("Expected nothing written to the read-only share")
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"lan-drop/api"
+	"lan-drop/transfer"
+)
+
+// apiRoutes returns the handlers of the versioned API by their path under
+// api.Prefix. Most of them are the handlers of the older routes, which stay
+// as they were; under api.Prefix they answer errors with JSON objects. API
+// keys are only accepted by the upload routes.
+func (sc *ServerController) apiRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"openapi.json": sc.handleOpenAPI,
+		"info":         sc.handleInfo,
+		"capabilities": sc.handleCapabilities,
+		"pair":         sc.handlePair,
+		"shares":       sc.requireAuth(sc.handleShares),
+		"files":        sc.requireAPIAuth(sc.handleAPIFiles),
+		"files/":       sc.requireAPIAuth(sc.handleAPIFiles),
+		"uploads":      sc.requireAPIAuth(sc.handleUpload),
+		"tus":          sc.requireAuth(sc.handleTus),
+		"tus/":         sc.requireAuth(sc.handleTus),
+		"archive":      sc.requireAuth(sc.handleArchiveDownload),
+		"thumbnail":    sc.requireAuth(sc.handleThumbnail),
+		"text":         sc.requireAuth(sc.handleText),
+		"progress":     sc.requireAuth(sc.handleProgress),
+		"history":      sc.requireAuth(sc.handleHistory),
+	}
+}
+
+// registerAPI adds the versioned API to mux
+func (sc *ServerController) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc(api.Prefix, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "No such API route")
+	})
+	for path, handler := range sc.apiRoutes() {
+		mux.HandleFunc(api.Prefix+path, handler)
+	}
+}
+
+// handleOpenAPI serves the OpenAPI description of the versioned API
+func (sc *ServerController) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(api.OpenAPI)
+}
+
+// handleInfo identifies the server. It's open to everyone, so clients can
+// find out whether they have to pair first.
+func (sc *ServerController) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	name, _ := os.Hostname()
+	fingerprint := sc.Fingerprint()
+	_, hasKey := sc.prefs.FindAPIKey(requestToken(r))
+	writeJSON(w, http.StatusOK, api.ServerInfo{
+		Name:            name,
+		Version:         sc.version,
+		APIVersion:      api.Version,
+		HTTPS:           fingerprint != "",
+		Fingerprint:     fingerprint,
+		PairingRequired: sc.prefs.RequirePairing,
+		Authenticated:   hasKey || sc.isAuthenticated(r),
+	})
+}
+
+// handleCapabilities tells clients which features are turned on
+func (sc *ServerController) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	caps := api.Capabilities{
+		APIVersion:       api.Version,
+		Downloads:        sc.prefs.EnableDownloads,
+		Shares:           len(sc.prefs.Shares) > 0,
+		ArchiveFormats:   archiveFormats,
+		ResumableUploads: api.Prefix + "tus/",
+		WebDAVUploads:    sc.prefs.EnableWebDAV && sc.prefs.WebDAVUploads,
+		History:          sc.History != nil,
+		MaxTextSize:      transfer.MaxTextSize,
+		ApprovalRequired: sc.prefs.AskBeforeReceiving,
+		ConflictPolicy:   sc.prefs.ConflictPolicy,
+		PairingRequired:  sc.prefs.RequirePairing,
+	}
+	if sc.prefs.EnableWebDAV {
+		caps.WebDAV = davPrefix
+	}
+	writeJSON(w, http.StatusOK, caps)
+}
+
+// handleShares lists the named shares and what devices may do with them
+func (sc *ServerController) handleShares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, "Only GET allowed")
+		return
+	}
+	list := api.ShareList{Shares: []api.Share{}}
+	for _, s := range sc.prefs.Shares {
+		list.Shares = append(list.Shares, api.Share{
+			Name:     s.Name,
+			Access:   string(s.Access),
+			Readable: s.Access.CanRead(),
+			Writable: s.Access.CanWrite(),
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAPIFiles serves the files of the shared folder or the share named
+// by ?share=:
+//
+//	GET /api/v1/files?path={folder}  lists a folder
+//	GET /api/v1/files/{path}         downloads a file, or lists a folder
+//	PUT and POST                     upload, see handleAPIUpload
+//	DELETE /api/v1/files/{path}      deletes an uploaded file
+func (sc *ServerController) handleAPIFiles(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, apiFilesPath), "/")
+	share := r.URL.Query().Get("share")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if name == "" {
+			sc.browse(w, r, share, r.URL.Query().Get("path"))
+			return
+		}
+		if !sc.canDownload(share) {
+			writeError(w, r, http.StatusForbidden, api.CodeDownloadsDisabled, "Downloads not enabled")
+			return
+		}
+		root, err := sc.readRoot(share)
+		if err != nil {
+			writePathError(w, r, err, "Share not found", "Internal server error")
+			return
+		}
+		stat, err := root.Stat(name)
+		root.Close()
+		if err != nil {
+			writePathError(w, r, err, "File not found", "Cannot access file")
+			return
+		}
+		if stat.IsDir() {
+			sc.browse(w, r, share, name)
+			return
+		}
+		sc.serveFile(w, r, share, name)
+	case http.MethodPut, http.MethodPost:
+		sc.handleAPIUpload(w, r)
+	case http.MethodDelete:
+		if name == "" {
+			writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "File name required")
+			return
+		}
+		sc.deleteFile(w, r, share, name)
+	default:
+		writeMethodNotAllowed(w, r, "Only GET, PUT, POST and DELETE allowed")
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lan-drop/api"
+	"lan-drop/config"
+)
+
+// serveAPI sends a request through the versioned API's routes
+func serveAPI(controller *ServerController, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	controller.registerAPI(mux)
+	mux.HandleFunc("/files", controller.requireAuth(controller.handleFileBrowse))
+
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIRoutesAreDocumented(t *testing.T) {
+	var doc struct {
+		Info    struct{ Version string }
+		Servers []struct{ URL string }
+		Paths   map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatalf("The OpenAPI document is not valid JSON: %v", err)
+	}
+	if doc.Info.Version != api.Version || len(doc.Servers) != 1 || doc.Servers[0].URL+"/" != api.Prefix {
+		t.Errorf("The OpenAPI document describes another version: %+v %+v", doc.Info, doc.Servers)
+	}
+
+	// Routes ending in a slash take the rest of the path as a parameter
+	params := map[string]string{"files/": "{path}", "tus/": "{id}"}
+	controller, _ := newDownloadTestController(t)
+	routes := controller.apiRoutes()
+	for route := range routes {
+		documented := "/" + route + params[route]
+		if _, ok := doc.Paths[documented]; !ok {
+			t.Errorf("Route %s is not in the OpenAPI document", route)
+		}
+	}
+	for path := range doc.Paths {
+		route := strings.TrimPrefix(strings.NewReplacer("{path}", "", "{id}", "").Replace(path), "/")
+		if _, ok := routes[route]; !ok {
+			t.Errorf("Documented path %s has no route", path)
+		}
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	controller.prefs.RequirePairing = true
+	token, err := controller.Auth.Pair("", controller.Auth.QRToken(), "script", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("Pair failed: %v", err)
+	}
+	paired := map[string]string{"Authorization": "Bearer " + token}
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		status int
+		code   api.ErrorCode
+	}{
+		{"unpaired", http.MethodGet, "/api/v1/files", nil, http.StatusUnauthorized, api.CodeUnauthorized},
+		{"unknown route", http.MethodGet, "/api/v1/nothing", paired, http.StatusNotFound, api.CodeNotFound},
+		{"wrong method", http.MethodPost, "/api/v1/info", nil, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed},
+		{"missing file", http.MethodGet, "/api/v1/files/missing.txt", paired, http.StatusNotFound, api.CodeNotFound},
+		{"unknown share", http.MethodGet, "/api/v1/files?share=nope", paired, http.StatusNotFound, api.CodeShareNotFound},
+		{"outside the folder", http.MethodGet, "/api/v1/files?path=../secret", paired, http.StatusForbidden, api.CodeAccessDenied},
+		{"bad archive format", http.MethodGet, "/api/v1/archive?format=rar", paired, http.StatusBadRequest, api.CodeBadRequest},
+		{"history unavailable", http.MethodGet, "/api/v1/history", paired, http.StatusServiceUnavailable, api.CodeUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serveAPI(controller, tc.method, tc.target, tc.header)
+			if w.Code != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			var resp api.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("Expected a JSON error, got %q", w.Body.String())
+			}
+			if resp.Error.Code != tc.code || resp.Error.Message == "" {
+				t.Errorf("Expected code %s, got %+v", tc.code, resp.Error)
+			}
+		})
+	}
+
+	// The older routes still answer with plain text
+	w := serveAPI(controller, http.MethodGet, "/files", nil)
+	if w.Code != http.StatusUnauthorized || strings.TrimSpace(w.Body.String()) != "Pairing required" {
+		t.Errorf("Expected a plain text error from /files, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestAPIDownloadsDisabled(t *testing.T) {
+	controller, _ := newDownloadTestController(t)
+	controller.prefs.EnableDownloads = false
+
+	for _, target := range []string{"/api/v1/files", "/api/v1/files/notes.txt", "/api/v1/archive"} {
+		w := serveAPI(controller, http.MethodGet, target, nil)
+		var resp api.ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusForbidden || resp.Error.Code != api.CodeDownloadsDisabled {
+			t.Errorf("Expected %s to be refused, got %d %s", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAPIFiles(t *testing.T) {
+	controller, sharedDir := newDownloadTestController(t)
+	os.MkdirAll(filepath.Join(sharedDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(sharedDir, "docs", "notes.txt"), []byte("0123456789"), 0644)
+
+	// A folder is listed, both by ?path= and by its path
+	for _, target := range []string{"/api/v1/files?path=docs", "/api/v1/files/docs"} {
+		w := serveAPI(controller, http.MethodGet, target, nil)
+		var list api.FileList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Expected a listing from %s, got %d %q", target, w.Code, w.Body.String())
+		}
+		if list.Path != "docs" || len(list.Files) != 1 || list.Files[0].RelativePath != filepath.Join("docs", "notes.txt") {
+			t.Errorf("Unexpected listing from %s: %+v", target, list)
+		}
+	}
+
+	// The versioned listing uses snake_case, the older route keeps its names
+	for target, key := range map[string]string{"/api/v1/files?path=docs": `"relative_path"`, "/files?path=docs": `"relativePath"`} {
+		if w := serveAPI(controller, http.MethodGet, target, nil); !strings.Contains(w.Body.String(), key) {
+			t.Errorf("Expected %s in the listing from %s, got %q", key, target, w.Body.String())
+		}
+	}
+
+	// A file is downloaded, ranges included
+	w := serveAPI(controller, http.MethodGet, "/api/v1/files/docs/notes.txt", map[string]string{"Range": "bytes=2-4"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("Expected a partial download, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Uploaded files can be deleted from the upload folder
+	os.WriteFile(filepath.Join(controller.folder, "upload.txt"), []byte("x"), 0644)
+	w = serveAPI(controller, http.MethodDelete, "/api/v1/files/upload.txt", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d %q", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(controller.folder, "upload.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be deleted, got %v", err)
+	}
+}
+
+func TestAPIInfoAndCapabilities(t *testing.T) {
+	controller, _ := newSharesTestController(t)
+	controller.prefs.RequirePairing = true
+	controller.prefs.EnableWebDAV = true
+	key, secret, err := config.NewAPIKey("CI runner")
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	controller.prefs.APIKeys = []config.APIKey{key}
+
+	var info api.ServerInfo
+	w := serveAPI(controller, http.MethodGet, "/api/v1/info", nil)
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if w.Code != http.StatusOK || info.Version != "test-version" || info.APIVersion != api.Version || !info.PairingRequired || info.Authenticated {
+		t.Errorf("Unexpected info for an unpaired client: %d %+v", w.Code, info)
+	}
+	w = serveAPI(controller, http.MethodGet, "/api/v1/info", map[string]string{"Authorization": "Bearer " + secret})
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if !info.Authenticated {
+		t.Errorf("Expected an API key to authenticate, got %+v", info)
+	}
+
+	var caps api.Capabilities
+	w = serveAPI(controller, http.MethodGet, "/api/v1/capabilities", nil)
+	json.Unmarshal(w.Body.Bytes(), &caps)
+	if !caps.Downloads || !caps.Shares || caps.WebDAV != davPrefix || caps.WebDAVUploads || len(caps.ArchiveFormats) != 2 {
+		t.Errorf("Unexpected capabilities: %+v", caps)
+	}
+
+	token, err := controller.Auth.Pair("", controller.Auth.QRToken(), "laptop", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("Pair failed: %v", err)
+	}
+	var shares api.ShareList
+	w = serveAPI(controller, http.MethodGet, "/api/v1/shares", map[string]string{"Authorization": "Bearer " + token})
+	json.Unmarshal(w.Body.Bytes(), &shares)
+	expected := []api.Share{
+		{Name: "Design assets", Access: "read", Readable: true},
+		{Name: "Inbox", Access: "write", Writable: true},
+		{Name: "Scratch", Access: "read_write", Readable: true, Writable: true},
+	}
+	if w.Code != http.StatusOK || len(shares.Shares) != len(expected) {
+		t.Fatalf("Unexpected shares: %d %s", w.Code, w.Body.String())
+	}
+	for i := range expected {
+		if shares.Shares[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], shares.Shares[i])
+		}
+	}
+}
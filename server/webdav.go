@@ -0,0 +1,437 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/sandbox"
+	"lan-drop/transfer"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is where WebDAV clients find the folders
+const davPrefix = "/dav/"
+
+// Folders served under davPrefix
+const (
+	davShared  = "shared"  // the shared folder, read-only
+	davUploads = "uploads" // the upload folder, read and write
+)
+
+// davReadMethods are the WebDAV methods that don't change anything
+var davReadMethods = map[string]bool{
+	http.MethodOptions: true,
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	"PROPFIND":         true,
+}
+
+// handleDAV serves the shared folder, and the upload folder if enabled, to
+// WebDAV clients such as file managers and editors
+func (sc *ServerController) handleDAV(w http.ResponseWriter, r *http.Request) {
+	if !sc.prefs.EnableWebDAV {
+		http.Error(w, "WebDAV is disabled", http.StatusForbidden)
+		return
+	}
+
+	mount, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, davPrefix), "/")
+	var dir string
+	var readOnly, followLinks bool
+	switch mount {
+	case "":
+		sc.serveDAVMounts(w, r)
+		return
+	case davShared:
+		if !sc.prefs.EnableDownloads {
+			http.Error(w, "Downloads are disabled", http.StatusForbidden)
+			return
+		}
+		dir, readOnly, followLinks = sc.prefs.SharedDir, true, sc.prefs.FollowSymlinks
+	case davUploads:
+		if !sc.prefs.WebDAVUploads {
+			http.Error(w, "WebDAV access to the upload folder is disabled", http.StatusForbidden)
+			return
+		}
+		dir = sc.folder
+	default:
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	if readOnly && !davReadMethods[r.Method] {
+		http.Error(w, "The shared folder is read-only", http.StatusForbidden)
+		return
+	}
+
+	os.MkdirAll(dir, os.ModePerm)
+	root, err := sandbox.Open(dir, followLinks)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer root.Close()
+
+	// Nothing can be locked in a read-only folder, so it gets a lock system
+	// of its own that stays empty
+	locks := sc.davLocks
+	if readOnly {
+		locks = webdav.NewMemLS()
+	}
+	fsys := &davFS{root: root, readOnly: readOnly}
+	handler := &webdav.Handler{
+		Prefix:     davPrefix + mount,
+		FileSystem: fsys,
+		LockSystem: locks,
+		Logger:     logDAVError,
+	}
+
+	// Moves and copies can't pick another name for the file, so they only
+	// replace one when the conflict policy is to overwrite
+	if (r.Method == "MOVE" || r.Method == "COPY") && transfer.ConflictPolicy(sc.prefs.ConflictPolicy) != transfer.ConflictOverwrite {
+		r.Header.Set("Overwrite", "F")
+	}
+
+	if r.Method != "COPY" && r.Method != http.MethodPut {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	// Files written by COPY and PUT are saved like any other received file,
+	// unless the client went away before sending all of it, and recorded
+	// once saved. A COPY of a folder saves each of its files.
+	start := time.Now()
+	var saved []davSavedFile
+	body := &bodyReader{ReadCloser: r.Body}
+	r.Body = body
+	fsys.save = func(name, partPath string) error {
+		if body.err != nil {
+			return body.err
+		}
+		f, err := sc.saveDAVFile(r, root, name, partPath)
+		if err == nil {
+			saved = append(saved, f)
+		}
+		return err
+	}
+
+	// Copies are of files already on this computer, so only PUT asks first
+	if r.Method == http.MethodPut {
+		name := strings.TrimPrefix(r.URL.Path, handler.Prefix)
+		summary := transfer.FileSummary{Name: path.Base(name), Size: max(r.ContentLength, 0)}
+		if decision := sc.requestConsent(r, []transfer.FileSummary{summary}); !decision.Accepted {
+			sc.recordTransfer(r, history.Entry{
+				Direction:  history.Received,
+				Method:     "webdav",
+				Files:      []history.File{{Name: summary.Name, Size: summary.Size}},
+				DurationMS: sinceMS(start),
+				Result:     history.Declined,
+				Error:      decision.Message,
+			})
+			http.Error(w, decision.Message, http.StatusForbidden)
+			return
+		}
+	}
+
+	handler.ServeHTTP(w, r)
+	for _, f := range saved {
+		sc.reportDAVUpload(r, f, start)
+	}
+}
+
+// serveDAVMounts lists the folders available under davPrefix
+func (sc *ServerController) serveDAVMounts(w http.ResponseWriter, r *http.Request) {
+	if !davReadMethods[r.Method] {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := context.Background()
+	mounts := webdav.NewMemFS()
+	if sc.prefs.EnableDownloads {
+		mounts.Mkdir(ctx, davShared, 0755)
+	}
+	if sc.prefs.WebDAVUploads {
+		mounts.Mkdir(ctx, davUploads, 0755)
+	}
+	handler := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(davPrefix, "/"),
+		FileSystem: mounts,
+		LockSystem: webdav.NewMemLS(),
+		Logger:     logDAVError,
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// davSavedFile is a file a WebDAV client wrote, once saved
+type davSavedFile struct {
+	name   string
+	size   int64
+	sha256 []byte
+	res    transfer.Resolution
+}
+
+// saveDAVFile puts the data a WebDAV client wrote to name, held in partPath,
+// in place through transfer.SaveFile, so the conflict policy applies as for
+// any other upload. An empty file is simply replaced: clients create one
+// with LOCK before they PUT the content.
+func (sc *ServerController) saveDAVFile(r *http.Request, root *sandbox.Root, name, partPath string) (davSavedFile, error) {
+	f := davSavedFile{name: path.Base(name)}
+	rel, err := sandbox.Clean(name)
+	if err != nil {
+		return f, err
+	}
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return f, err
+	}
+	f.size = info.Size()
+	if f.sha256, err = transfer.FileSHA256(partPath); err != nil {
+		return f, err
+	}
+
+	if existing, err := root.Stat(rel); err == nil && existing.Mode().IsRegular() && existing.Size() == 0 {
+		if err := root.Remove(rel); err != nil {
+			return f, err
+		}
+	}
+	f.res, err = transfer.SaveFile(r.Context(), sc.prefs, root.Dir(), rel, partPath, transfer.IncomingFile{
+		Size:     f.size,
+		SHA256:   f.sha256,
+		MIMEType: r.Header.Get("Content-Type"),
+		Device:   requestDeviceName(r),
+		PeerAddr: remoteIP(r),
+	})
+	return f, err
+}
+
+// reportDAVUpload records a file a WebDAV client has written and announces it
+func (sc *ServerController) reportDAVUpload(r *http.Request, f davSavedFile, start time.Time) {
+	sc.recordTransfer(r, history.Entry{
+		Direction:  history.Received,
+		Method:     "webdav",
+		Files:      []history.File{{Name: f.name, Path: f.res.Path, Size: f.size, SHA256: hex.EncodeToString(f.sha256)}},
+		DurationMS: sinceMS(start),
+		Result:     history.Completed,
+	})
+	events.Publish(events.FileReceived{
+		Name:    f.name,
+		Path:    f.res.Path,
+		Method:  "webdav",
+		Device:  requestDeviceName(r),
+		Size:    f.size,
+		Skipped: f.res.Skip,
+	})
+}
+
+// requireDAVAuth is requireAuth for WebDAV clients, which can't pair through
+// the web page. They send the pairing PIN or a session token as the password
+// of HTTP Basic authentication instead.
+func (sc *ServerController) requireDAVAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sc.isAuthenticated(r) {
+			next(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); ok {
+			device := requestDeviceName(r)
+			paired, err := sc.Auth.ValidateBasic(user, password, device, remoteIP(r))
+			if err == nil {
+				if paired {
+					events.Publish(events.DevicePaired{Device: shortDeviceName(device)})
+				}
+				next(w, r)
+				return
+			}
+			if writeLockout(w, r, err) {
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="LANDrop", charset="UTF-8"`)
+		http.Error(w, "Pairing required", http.StatusUnauthorized)
+	}
+}
+
+// logDAVError logs what went wrong with a WebDAV request. File managers
+// probe for lots of files that don't exist, so those aren't logged.
+func logDAVError(r *http.Request, err error) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// davFS gives WebDAV access to a folder through a sandbox.Root, so the same
+// rules as for the web page keep clients inside it
+type davFS struct {
+	root     *sandbox.Root
+	readOnly bool
+	// save, when set, is given the files clients write, in a part file,
+	// to put them in place
+	save func(name, partPath string) error
+}
+
+// davName turns a WebDAV path into a name for the root. Partial uploads
+// are never exposed.
+func davName(op, name string) (string, error) {
+	name = strings.TrimPrefix(name, "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == tusStateDir || strings.HasPrefix(part, transfer.PartFilePrefix) {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	if name == "" {
+		return ".", nil
+	}
+	return name, nil
+}
+
+// davError reports a path outside the folder as a permission error, which
+// the WebDAV handler skips in listings instead of aborting them
+func davError(op, name string, err error) error {
+	if errors.Is(err, sandbox.ErrOutside) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return err
+}
+
+func (d *davFS) refuseWrite(op, name string) error {
+	if d.readOnly {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (d *davFS) Mkdir(_ context.Context, name string, perm os.FileMode) error {
+	if err := d.refuseWrite("mkdir", name); err != nil {
+		return err
+	}
+	name, err := davName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return davError("mkdir", name, d.root.Mkdir(name, perm))
+}
+
+func (d *davFS) OpenFile(_ context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if err := d.refuseWrite("open", name); err != nil {
+			return nil, err
+		}
+	}
+	name, err := davName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if d.save != nil && flag&os.O_TRUNC != 0 {
+		return d.openUpload(name)
+	}
+	f, err := d.root.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, davError("open", name, err)
+	}
+	return davFile{f}, nil
+}
+
+// openUpload receives a file into a part file, which is saved on Close
+func (d *davFS) openUpload(name string) (webdav.File, error) {
+	// Writing into a folder that doesn't exist fails as it would on disk
+	if _, err := d.root.Stat(path.Dir(name)); err != nil {
+		return nil, davError("open", name, err)
+	}
+	part, err := transfer.CreatePartFile(d.root.Dir())
+	if err != nil {
+		return nil, err
+	}
+	return &davUpload{davFile: davFile{part}, name: name, save: d.save}, nil
+}
+
+func (d *davFS) RemoveAll(_ context.Context, name string) error {
+	if err := d.refuseWrite("remove", name); err != nil {
+		return err
+	}
+	name, err := davName("remove", name)
+	if err != nil {
+		return err
+	}
+	return davError("remove", name, d.root.RemoveAll(name))
+}
+
+func (d *davFS) Rename(_ context.Context, oldName, newName string) error {
+	if err := d.refuseWrite("rename", oldName); err != nil {
+		return err
+	}
+	oldName, err := davName("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = davName("rename", newName)
+	if err != nil {
+		return err
+	}
+	return davError("rename", newName, d.root.Rename(oldName, newName))
+}
+
+func (d *davFS) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	name, err := davName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.root.Stat(name)
+	return info, davError("stat", name, err)
+}
+
+// davFile hides the same entries from listings as the web page does
+type davFile struct {
+	*os.File
+}
+
+func (f davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !isHiddenEntry(info.Name()) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// davUpload is a file a client is writing
+type davUpload struct {
+	davFile
+	name string
+	save func(name, partPath string) error
+}
+
+func (u *davUpload) Close() error {
+	err := u.File.Close()
+	if err == nil {
+		err = u.save(u.name, u.Name())
+	}
+	// Once saved the part file is gone already
+	os.Remove(u.Name())
+	return err
+}
+
+// bodyReader remembers why reading a request body failed
+type bodyReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
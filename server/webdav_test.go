@@ -0,0 +1,348 @@
+package server
+
+import (
+	"io"
+	"lan-drop/config"
+	"lan-drop/events"
+	"lan-drop/history"
+	"lan-drop/transfer"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func newDAVTestServer(t *testing.T, prefs *config.Preferences) (*ServerController, *httptest.Server) {
+	t.Helper()
+	if prefs.UploadDir == "" {
+		prefs.UploadDir = t.TempDir()
+	}
+	if prefs.SharedDir == "" {
+		prefs.SharedDir = t.TempDir()
+	}
+	controller := NewServerController(8080, prefs.UploadDir, prefs, testEmbeddedFiles, "test-version")
+	mux := http.NewServeMux()
+	mux.HandleFunc(davPrefix, controller.requireDAVAuth(controller.handleDAV))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return controller, server
+}
+
+func davRequest(t *testing.T, method, url, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(resp.Body)
+	return resp, string(content)
+}
+
+func TestDAVSharedFolderIsReadOnly(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, EnableDownloads: true}
+	_, server := newDAVTestServer(t, prefs)
+	os.WriteFile(filepath.Join(prefs.SharedDir, "notes.txt"), []byte("shared notes"), 0644)
+	os.WriteFile(filepath.Join(prefs.SharedDir, ".landrop-upload-123.part"), []byte("partial"), 0644)
+
+	resp, body := davRequest(t, http.MethodGet, server.URL+"/dav/shared/notes.txt", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "shared notes" {
+		t.Errorf("Expected the file, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = davRequest(t, "PROPFIND", server.URL+"/dav/shared/", "", map[string]string{"Depth": "1"})
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("Expected 207, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(body, "notes.txt") || strings.Contains(body, ".landrop-upload-") {
+		t.Errorf("Expected the listing to show notes.txt only, got %s", body)
+	}
+
+	resp, _ = davRequest(t, http.MethodGet, server.URL+"/dav/shared/.landrop-upload-123.part", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected partial uploads to be hidden, got %d", resp.StatusCode)
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "LOCK"} {
+		resp, _ = davRequest(t, method, server.URL+"/dav/shared/notes.txt", "changed", nil)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", method, resp.StatusCode)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(prefs.SharedDir, "notes.txt")); string(content) != "shared notes" {
+		t.Errorf("Expected the shared file to be untouched, got %q", content)
+	}
+}
+
+func TestDAVRespectsSettings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		prefs    config.Preferences
+		path     string
+		expected int
+	}{
+		{"WebDAV disabled", config.Preferences{EnableDownloads: true}, "/dav/shared/", http.StatusForbidden},
+		{"downloads disabled", config.Preferences{EnableWebDAV: true}, "/dav/shared/", http.StatusForbidden},
+		{"uploads not enabled", config.Preferences{EnableWebDAV: true, EnableDownloads: true}, "/dav/uploads/", http.StatusForbidden},
+		{"unknown folder", config.Preferences{EnableWebDAV: true, EnableDownloads: true}, "/dav/other/", http.StatusNotFound},
+		{"folder list", config.Preferences{EnableWebDAV: true, EnableDownloads: true}, "/dav/", http.StatusMultiStatus},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, server := newDAVTestServer(t, &tc.prefs)
+			resp, body := davRequest(t, "PROPFIND", server.URL+tc.path, "", map[string]string{"Depth": "1"})
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected %d, got %d: %s", tc.expected, resp.StatusCode, body)
+			}
+			if tc.path == "/dav/" && (!strings.Contains(body, "/dav/shared/") || strings.Contains(body, "/dav/uploads/")) {
+				t.Errorf("Expected only the shared folder to be listed, got %s", body)
+			}
+		})
+	}
+}
+
+func TestDAVWritesUploadFolder(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true}
+	_, server := newDAVTestServer(t, prefs)
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
+
+	resp, _ := davRequest(t, "MKCOL", server.URL+"/dav/uploads/project", "", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("MKCOL: expected 201, got %d", resp.StatusCode)
+	}
+	resp, _ = davRequest(t, http.MethodPut, server.URL+"/dav/uploads/project/draft.txt", "first draft", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: expected 201, got %d", resp.StatusCode)
+	}
+	resp, _ = davRequest(t, "MOVE", server.URL+"/dav/uploads/project/draft.txt", "", map[string]string{"Destination": server.URL + "/dav/uploads/project/final.txt"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("MOVE: expected 201, got %d", resp.StatusCode)
+	}
+	if content, err := os.ReadFile(filepath.Join(prefs.UploadDir, "project", "final.txt")); err != nil || string(content) != "first draft" {
+		t.Errorf("Expected the moved file, got %q (%v)", content, err)
+	}
+
+	var got *events.FileReceived
+	for len(received) > 0 {
+		if e, ok := (<-received).(events.FileReceived); ok && e.Method == "webdav" {
+			got = &e
+		}
+	}
+	if got == nil || got.Path != filepath.Join(prefs.UploadDir, "project", "draft.txt") || got.Size != 11 {
+		t.Errorf("Expected the upload to be announced, got %+v", got)
+	}
+
+	// Partial uploads can't be written or overwritten
+	resp, _ = davRequest(t, http.MethodPut, server.URL+"/dav/uploads/.landrop-upload-1.part", "x", nil)
+	if resp.StatusCode < 400 {
+		t.Errorf("Expected writing a partial upload to fail, got %d", resp.StatusCode)
+	}
+}
+
+func TestDAVBasicAuth(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, EnableDownloads: true, RequirePairing: true}
+	controller, server := newDAVTestServer(t, prefs)
+	pin, _ := controller.Auth.PIN()
+
+	resp, _ := davRequest(t, "PROPFIND", server.URL+"/dav/shared/", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("Expected a Basic challenge, got %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	req, _ := http.NewRequest("PROPFIND", server.URL+"/dav/shared/", nil)
+	req.SetBasicAuth("laptop", pin)
+	for i := 0; i < 2; i++ {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PROPFIND failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMultiStatus {
+			t.Errorf("Request %d: expected the PIN to be accepted, got %d", i+1, resp.StatusCode)
+		}
+	}
+
+	// Wrong passwords lock the address out without rotating the PIN
+	pin, _ = controller.Auth.PIN()
+	req.SetBasicAuth("other", "wrong")
+	for i := 0; i <= maxPINAttempts; i++ {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PROPFIND failed: %v", err)
+		}
+		resp.Body.Close()
+		expected := http.StatusUnauthorized
+		if i == maxPINAttempts {
+			expected = http.StatusTooManyRequests
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("Attempt %d: expected %d, got %d", i+1, expected, resp.StatusCode)
+		}
+	}
+	if current, _ := controller.Auth.PIN(); current != pin {
+		t.Error("Expected wrong WebDAV passwords to leave the PIN alone")
+	}
+}
+
+func TestDAVAppliesConflictPolicy(t *testing.T) {
+	testCases := []struct {
+		policy    transfer.ConflictPolicy
+		saved     string // where the PUT lands
+		moveCode  int
+		finalText string // content of notes.txt once done
+	}{
+		{transfer.ConflictRename, "notes_1.txt", http.StatusPreconditionFailed, "old"},
+		{transfer.ConflictSkipIdentical, "notes_1.txt", http.StatusPreconditionFailed, "old"},
+		{transfer.ConflictOverwrite, "notes.txt", http.StatusNoContent, "draft"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true, ConflictPolicy: string(tc.policy)}
+			controller, server := newDAVTestServer(t, prefs)
+			store := withTestHistory(t, controller)
+			os.WriteFile(filepath.Join(prefs.UploadDir, "notes.txt"), []byte("old"), 0644)
+
+			resp, _ := davRequest(t, http.MethodPut, server.URL+"/dav/uploads/notes.txt", "new", nil)
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("PUT: expected 201, got %d", resp.StatusCode)
+			}
+			if content, err := os.ReadFile(filepath.Join(prefs.UploadDir, tc.saved)); err != nil || string(content) != "new" {
+				t.Errorf("Expected the upload in %s, got %q (%v)", tc.saved, content, err)
+			}
+			entries := store.Search("", 0)
+			if len(entries) != 1 || entries[0].Method != "webdav" || entries[0].Files[0].Path != filepath.Join(prefs.UploadDir, tc.saved) {
+				t.Errorf("Expected the upload in the history, got %+v", entries)
+			}
+
+			davRequest(t, http.MethodPut, server.URL+"/dav/uploads/draft.txt", "draft", nil)
+			resp, _ = davRequest(t, "MOVE", server.URL+"/dav/uploads/draft.txt", "", map[string]string{"Destination": server.URL + "/dav/uploads/notes.txt", "Overwrite": "T"})
+			if resp.StatusCode != tc.moveCode {
+				t.Errorf("MOVE: expected %d, got %d", tc.moveCode, resp.StatusCode)
+			}
+			if content, _ := os.ReadFile(filepath.Join(prefs.UploadDir, "notes.txt")); string(content) != tc.finalText {
+				t.Errorf("Expected notes.txt to hold %q, got %q", tc.finalText, content)
+			}
+		})
+	}
+}
+
+func TestDAVCopyIsRecorded(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true}
+	controller, server := newDAVTestServer(t, prefs)
+	store := withTestHistory(t, controller)
+	received, cancel := events.Default.Subscribe()
+	defer cancel()
+
+	davRequest(t, "MKCOL", server.URL+"/dav/uploads/album", "", nil)
+	davRequest(t, http.MethodPut, server.URL+"/dav/uploads/album/one.jpg", "one", nil)
+	davRequest(t, http.MethodPut, server.URL+"/dav/uploads/album/two.jpg", "two", nil)
+
+	resp, _ := davRequest(t, "COPY", server.URL+"/dav/uploads/album/one.jpg", "", map[string]string{"Destination": server.URL + "/dav/uploads/cover.jpg"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("COPY: expected 201, got %d", resp.StatusCode)
+	}
+	// A copied folder records each of its files
+	resp, _ = davRequest(t, "COPY", server.URL+"/dav/uploads/album", "", map[string]string{"Destination": server.URL + "/dav/uploads/backup"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("COPY: expected 201, got %d", resp.StatusCode)
+	}
+
+	copied := map[string]bool{}
+	for _, e := range store.Search("", 0) {
+		if e.Method == "webdav" && e.Result == history.Completed {
+			copied[e.Files[0].Path] = true
+		}
+	}
+	for _, name := range []string{"cover.jpg", "backup/one.jpg", "backup/two.jpg"} {
+		if !copied[filepath.Join(prefs.UploadDir, filepath.FromSlash(name))] {
+			t.Errorf("Expected the copy to %s in the history, got %v", name, copied)
+		}
+	}
+
+	announced := 0
+	for len(received) > 0 {
+		if e, ok := (<-received).(events.FileReceived); ok && e.Method == "webdav" {
+			announced++
+		}
+	}
+	if announced != 5 {
+		t.Errorf("Expected the uploads and copies to be announced, got %d events", announced)
+	}
+}
+
+func TestDAVLockThenPut(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true}
+	_, server := newDAVTestServer(t, prefs)
+
+	// File managers lock a new file, which creates it empty, then write it
+	lockInfo := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	resp, _ := davRequest(t, "LOCK", server.URL+"/dav/uploads/photo.jpg", lockInfo, map[string]string{"Timeout": "Second-60"})
+	token := resp.Header.Get("Lock-Token")
+	if resp.StatusCode != http.StatusCreated || token == "" {
+		t.Fatalf("LOCK: expected 201 with a token, got %d", resp.StatusCode)
+	}
+	resp, _ = davRequest(t, http.MethodPut, server.URL+"/dav/uploads/photo.jpg", "jpeg", map[string]string{"If": "(" + token + ")"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: expected 201, got %d", resp.StatusCode)
+	}
+
+	entries, _ := os.ReadDir(prefs.UploadDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only photo.jpg, found %d entries", len(entries))
+	}
+	if content, err := os.ReadFile(filepath.Join(prefs.UploadDir, "photo.jpg")); err != nil || string(content) != "jpeg" {
+		t.Errorf("Expected the locked file to be written, got %q (%v)", content, err)
+	}
+}
+
+func TestDAVPutIsNotSavedWhenCutShort(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true, ConflictPolicy: string(transfer.ConflictOverwrite)}
+	controller, _ := newDAVTestServer(t, prefs)
+	os.WriteFile(filepath.Join(prefs.UploadDir, "notes.txt"), []byte("old"), 0644)
+
+	// The client goes away halfway through the body
+	body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	req := httptest.NewRequest(http.MethodPut, "/dav/uploads/notes.txt", body)
+	w := httptest.NewRecorder()
+	controller.handleDAV(w, req)
+
+	if w.Code == http.StatusCreated {
+		t.Errorf("Expected the cut short upload to fail, got %d", w.Code)
+	}
+	if content, _ := os.ReadFile(filepath.Join(prefs.UploadDir, "notes.txt")); string(content) != "old" {
+		t.Errorf("Expected the file to be kept, got %q", content)
+	}
+	if entries, _ := os.ReadDir(prefs.UploadDir); len(entries) != 1 {
+		t.Errorf("Expected the part file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestDAVPutAsksFirst(t *testing.T) {
+	prefs := &config.Preferences{EnableWebDAV: true, WebDAVUploads: true, AskBeforeReceiving: true}
+	controller, server := newDAVTestServer(t, prefs)
+	store := withTestHistory(t, controller)
+
+	resp, _ := davRequest(t, http.MethodPut, server.URL+"/dav/uploads/notes.txt", "new", nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the unanswered upload to be declined, got %d", resp.StatusCode)
+	}
+	if entries, _ := os.ReadDir(prefs.UploadDir); len(entries) != 0 {
+		t.Errorf("Expected nothing written, found %d entries", len(entries))
+	}
+	if entries := store.Search("", 0); len(entries) != 1 || entries[0].Result != history.Declined {
+		t.Errorf("Expected a declined history entry, got %+v", entries)
+	}
+}
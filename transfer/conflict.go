@@ -0,0 +1,432 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/sandbox"
+)
+
+// ConflictPolicy decides what happens when a received file has the same name
+// as a file already in the destination folder
+type ConflictPolicy string
+
+const (
+	// ConflictRename keeps both files, saving the new one under a new name
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkipIdentical drops the new file if it has the same content as
+	// the existing one and renames it otherwise
+	ConflictSkipIdentical ConflictPolicy = "skip_identical"
+	// ConflictKeepNewest keeps whichever file was modified last
+	ConflictKeepNewest ConflictPolicy = "keep_newest"
+	// ConflictAsk lets the desktop user choose
+	ConflictAsk ConflictPolicy = "ask"
+)
+
+// ConflictPolicies lists every policy in the order the settings show them
+var ConflictPolicies = []ConflictPolicy{
+	ConflictRename,
+	ConflictOverwrite,
+	ConflictSkipIdentical,
+	ConflictKeepNewest,
+	ConflictAsk,
+}
+
+// Label returns the description shown in the settings window
+func (p ConflictPolicy) Label() string {
+	switch p {
+	case ConflictOverwrite:
+		return "Overwrite the existing file"
+	case ConflictSkipIdentical:
+		return "Skip if identical, otherwise keep both"
+	case ConflictKeepNewest:
+		return "Keep the newest file"
+	case ConflictAsk:
+		return "Ask me"
+	default:
+		return "Keep both (rename the new file)"
+	}
+}
+
+// DefaultRenamePattern names the copies "photo_1.jpg", "photo_2.jpg", ...
+const DefaultRenamePattern = "{name}_{n}{ext}"
+
+// PartFilePrefix marks files still being received. They are hidden from
+// listings and put in place by PlaceFile once complete.
+const PartFilePrefix = ".landrop-upload-"
+
+// CreatePartFile creates a hidden file in dir to receive data into
+func CreatePartFile(dir string) (*os.File, error) {
+	root, err := sandbox.Open(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	f, _, err := createPartFile(root, ".")
+	return f, err
+}
+
+// createPartFile is CreatePartFile for the named folder under root. It also
+// returns the name of the part file under root.
+func createPartFile(root *sandbox.Root, dir string) (*os.File, string, error) {
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return nil, "", err
+		}
+		name := filepath.Join(dir, PartFilePrefix+hex.EncodeToString(b)+".part")
+		f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, name, err
+	}
+}
+
+// IncomingFile describes a received file waiting to be put in place
+type IncomingFile struct {
+	Name     string    // name given by the sender
+	Size     int64     // size of the received data
+	SHA256   []byte    // digest of the received data
+	ModTime  time.Time // modification time on the sender, zero if unknown
+	MIMEType string    // content type given by the sender, if any
+
+	// Where the file came from, recorded in the sidecar
+	Device    string
+	PeerAddr  string
+	SessionID string
+}
+
+// ConflictChoice is the desktop user's answer to a conflict
+type ConflictChoice int
+
+const (
+	ChoiceRename ConflictChoice = iota
+	ChoiceOverwrite
+	ChoiceSkip
+)
+
+// ConflictPrompter asks the desktop user what to do with a file whose name
+// is taken by existing. It must return once ctx is done.
+type ConflictPrompter func(ctx context.Context, existing string, in IncomingFile) ConflictChoice
+
+var (
+	conflictPromptMu sync.Mutex
+	conflictPrompter ConflictPrompter
+)
+
+// SetConflictPrompter sets the global prompter used by the ask policy
+func SetConflictPrompter(p ConflictPrompter) {
+	conflictPromptMu.Lock()
+	defer conflictPromptMu.Unlock()
+	conflictPrompter = p
+}
+
+// Resolution tells the caller where an incoming file goes
+type Resolution struct {
+	Path string // where the file is saved, or the existing file when skipped
+	Skip bool   // the incoming file is dropped and Path left untouched
+	// Replace lets the file replace the one at Path. Otherwise a file saved
+	// there since the conflict was resolved is kept, and the incoming file
+	// gets the next free name.
+	Replace bool
+
+	name    string // name the file was sent with
+	pattern string // rename pattern for the next free name
+}
+
+// ResolveConflict decides where an incoming file is saved in dir according to
+// the configured policy. Without a conflict the file keeps its name.
+func ResolveConflict(ctx context.Context, prefs *config.Preferences, dir string, in IncomingFile) (Resolution, error) {
+	target := filepath.Join(dir, in.Name)
+	keep := Resolution{Path: target, name: in.Name, pattern: prefs.RenamePattern}
+	replace := keep
+	replace.Replace = true
+	skip := Resolution{Path: target, Skip: true}
+
+	existing, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return keep, nil
+	}
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	rename := func() (Resolution, error) {
+		res := keep
+		res.Path = UniquePath(dir, in.Name, prefs.RenamePattern)
+		return res, nil
+	}
+
+	// Only regular files can be replaced or compared
+	if !existing.Mode().IsRegular() {
+		return rename()
+	}
+
+	switch ConflictPolicy(prefs.ConflictPolicy) {
+	case ConflictOverwrite:
+		return replace, nil
+
+	case ConflictSkipIdentical:
+		same, err := sameContent(target, existing, in)
+		if err != nil {
+			return Resolution{}, err
+		}
+		if same {
+			return skip, nil
+		}
+		return rename()
+
+	case ConflictKeepNewest:
+		// A file without a known modification time was just created
+		modTime := in.ModTime
+		if modTime.IsZero() {
+			modTime = time.Now()
+		}
+		if modTime.After(existing.ModTime()) {
+			return replace, nil
+		}
+		return skip, nil
+
+	case ConflictAsk:
+		switch askConflict(ctx, prefs, target, in) {
+		case ChoiceOverwrite:
+			return replace, nil
+		case ChoiceSkip:
+			return skip, nil
+		}
+		return rename()
+
+	default:
+		return rename()
+	}
+}
+
+// askConflict asks the desktop user, keeping both files if nobody answers
+func askConflict(ctx context.Context, prefs *config.Preferences, existing string, in IncomingFile) ConflictChoice {
+	conflictPromptMu.Lock()
+	p := conflictPrompter
+	conflictPromptMu.Unlock()
+
+	if p == nil {
+		return ChoiceRename
+	}
+
+	timeout := time.Duration(prefs.ConsentTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultConsentTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	choice := p(ctx, existing, in)
+	if ctx.Err() != nil {
+		return ChoiceRename
+	}
+	return choice
+}
+
+// sameContent reports whether the file at path holds exactly the incoming data
+func sameContent(path string, info os.FileInfo, in IncomingFile) (bool, error) {
+	if in.SHA256 == nil || info.Size() != in.Size {
+		return false, nil
+	}
+	sum, err := FileSHA256(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sum, in.SHA256), nil
+}
+
+// PlaceFile moves a received part file to where res says, or removes it if
+// the incoming file is skipped. A part file on another disk than the
+// destination is copied. It returns res with the path the file was saved
+// at, which differs when the name was taken since res was resolved.
+func PlaceFile(partPath string, res Resolution) (Resolution, error) {
+	if res.Skip {
+		return res, os.Remove(partPath)
+	}
+	root, err := sandbox.Open(filepath.Dir(res.Path), false)
+	if err != nil {
+		return res, err
+	}
+	defer root.Close()
+	return placeFile(root, partPath, res)
+}
+
+// placeFile is PlaceFile for a destination under root, which the file is
+// moved into without following links out of it. A part file outside root,
+// such as a resumable upload's, is copied.
+func placeFile(root *sandbox.Root, partPath string, res Resolution) (Resolution, error) {
+	if res.Skip {
+		return res, os.Remove(partPath)
+	}
+	name, err := root.Name(res.Path)
+	if err != nil {
+		return res, err
+	}
+	if !res.Replace {
+		if name, err = claimName(root, name, res); err != nil {
+			return res, err
+		}
+		res.Path = filepath.Join(filepath.Dir(res.Path), filepath.Base(name))
+	}
+
+	if err := moveIntoPlace(root, partPath, name); err != nil {
+		if !res.Replace {
+			root.Remove(name)
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// claimName creates an empty file at name under root, or at the next free
+// name if it's taken, and returns the name it created. Two files saved
+// under the same name at the same time then each get their own, where
+// checking for a free name and moving the file there would let the second
+// replace the first.
+func claimName(root *sandbox.Root, name string, res Resolution) (string, error) {
+	pattern := res.pattern
+	if ValidateRenamePattern(pattern) != nil {
+		pattern = DefaultRenamePattern
+	}
+	sent := res.name
+	if sent == "" {
+		sent = filepath.Base(name)
+	}
+	base, ext := splitExt(sent)
+
+	dir := filepath.Dir(name)
+	for i := 1; ; i++ {
+		f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return name, f.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		name = filepath.Join(dir, expandRenamePattern(pattern, base, i, ext))
+	}
+}
+
+// moveIntoPlace moves a part file to the named file under root, replacing
+// it, or copies it if it's outside root or on another disk
+func moveIntoPlace(root *sandbox.Root, partPath, name string) error {
+	if part, err := root.Name(partPath); err == nil {
+		err := root.Rename(part, name)
+		var linkErr *os.LinkError
+		if err == nil || !errors.As(err, &linkErr) {
+			return err
+		}
+	}
+	if err := copyPartFile(root, partPath, name); err != nil {
+		return err
+	}
+	return os.Remove(partPath)
+}
+
+// copyPartFile copies a part file to the named file under root through
+// another part file next to it, so the file never holds a partial copy
+func copyPartFile(root *sandbox.Root, partPath, name string) error {
+	in, err := os.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, outName, err := createPartFile(root, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(out, in)
+	closeErr := out.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil {
+		copyErr = root.Rename(outName, name)
+	}
+	if copyErr != nil {
+		root.Remove(outName)
+	}
+	return copyErr
+}
+
+// UniquePath returns a path in dir for name that doesn't exist yet, naming
+// copies after pattern. The pattern may use {name}, {n} and {ext}, e.g.
+// "{name} ({n}){ext}"; an invalid pattern falls back to DefaultRenamePattern.
+func UniquePath(dir, name, pattern string) string {
+	if ValidateRenamePattern(pattern) != nil {
+		pattern = DefaultRenamePattern
+	}
+
+	savePath := filepath.Join(dir, name)
+	base, ext := splitExt(name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(savePath); os.IsNotExist(err) {
+			return savePath
+		}
+		savePath = filepath.Join(dir, expandRenamePattern(pattern, base, i, ext))
+	}
+}
+
+// splitExt splits a file name into its base and extension
+func splitExt(name string) (base, ext string) {
+	ext = filepath.Ext(name)
+	if ext == name {
+		// ".bashrc" is a name, not an extension
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+func expandRenamePattern(pattern, base string, n int, ext string) string {
+	return strings.NewReplacer("{name}", base, "{n}", strconv.Itoa(n), "{ext}", ext).Replace(pattern)
+}
+
+// ValidateRenamePattern checks that pattern numbers its copies and can't
+// produce a name outside the destination folder
+func ValidateRenamePattern(pattern string) error {
+	if !strings.Contains(pattern, "{n}") {
+		return errors.New("the pattern must contain {n}")
+	}
+	if strings.ContainsAny(pattern, `/\`) {
+		return errors.New("the pattern must not contain path separators")
+	}
+	if name := expandRenamePattern(pattern, "", 1, ""); strings.Trim(name, ".") == "" {
+		return fmt.Errorf("the pattern gives the invalid name %q", name)
+	}
+	return nil
+}
+
+// FileSHA256 returns the SHA-256 digest of the file at path
+func FileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,302 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/sandbox"
+)
+
+func TestUniquePath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing []string
+		filename string
+		pattern  string
+		expected string
+	}{
+		{"no conflict", nil, "test.txt", "", "test.txt"},
+		{"first copy", []string{"test.txt"}, "test.txt", "", "test_1.txt"},
+		{"second copy", []string{"test.txt", "test_1.txt"}, "test.txt", "", "test_2.txt"},
+		{"no extension", []string{"test"}, "test", "", "test_1"},
+		{"multiple dots", []string{"test.backup.txt"}, "test.backup.txt", "", "test.backup_1.txt"},
+		{"hidden file", []string{".hidden"}, ".hidden", "", ".hidden_1"},
+		{"custom pattern", []string{"photo.jpg"}, "photo.jpg", "{name} ({n}){ext}", "photo (1).jpg"},
+		{"pattern without number", []string{"photo.jpg"}, "photo.jpg", "{name}{ext}", "photo_1.jpg"},
+		{"pattern with separator", []string{"photo.jpg"}, "photo.jpg", "../{name}_{n}{ext}", "photo_1.jpg"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tc.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+					t.Fatalf("Failed to create %s: %v", name, err)
+				}
+			}
+
+			pattern := tc.pattern
+			if pattern == "" {
+				pattern = DefaultRenamePattern
+			}
+			if got := UniquePath(dir, tc.filename, pattern); got != filepath.Join(dir, tc.expected) {
+				t.Errorf("Expected %s, got %s", filepath.Join(dir, tc.expected), got)
+			}
+		})
+	}
+}
+
+func TestUniquePathNoDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if got := UniquePath(dir, "file.txt", DefaultRenamePattern); got != filepath.Join(dir, "file.txt") {
+		t.Errorf("Expected %s, got %s", filepath.Join(dir, "file.txt"), got)
+	}
+}
+
+func TestValidateRenamePattern(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"{name}_{n}{ext}":     true,
+		"{name} ({n}){ext}":   true,
+		"{n}-{name}{ext}":     true,
+		"{name}{ext}":         false,
+		"{name}/{n}{ext}":     false,
+		`{name}\{n}{ext}`:     false,
+		"..{n}":               true,
+		"":                    false,
+		"copy of {name}{ext}": false,
+	} {
+		if err := ValidateRenamePattern(pattern); (err == nil) != valid {
+			t.Errorf("ValidateRenamePattern(%q) = %v, want valid=%v", pattern, err, valid)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	oldTime := time.Now().Add(-time.Hour)
+	oldSum := sha256.Sum256([]byte("old"))
+	newSum := sha256.Sum256([]byte("new"))
+
+	testCases := []struct {
+		name     string
+		policy   ConflictPolicy
+		incoming IncomingFile
+		choice   ConflictChoice
+		expected string
+		skip     bool
+	}{
+		{"free name", ConflictOverwrite, IncomingFile{Name: "other.txt"}, 0, "other.txt", false},
+		{"rename", ConflictRename, IncomingFile{Name: "dup.txt"}, 0, "dup_1.txt", false},
+		{"unknown policy renames", "bogus", IncomingFile{Name: "dup.txt"}, 0, "dup_1.txt", false},
+		{"overwrite", ConflictOverwrite, IncomingFile{Name: "dup.txt"}, 0, "dup.txt", false},
+		{"identical is skipped", ConflictSkipIdentical, IncomingFile{Name: "dup.txt", Size: 3, SHA256: oldSum[:]}, 0, "dup.txt", true},
+		{"different is renamed", ConflictSkipIdentical, IncomingFile{Name: "dup.txt", Size: 3, SHA256: newSum[:]}, 0, "dup_1.txt", false},
+		{"unknown digest is renamed", ConflictSkipIdentical, IncomingFile{Name: "dup.txt", Size: 3}, 0, "dup_1.txt", false},
+		{"newer replaces", ConflictKeepNewest, IncomingFile{Name: "dup.txt", ModTime: time.Now()}, 0, "dup.txt", false},
+		{"older is skipped", ConflictKeepNewest, IncomingFile{Name: "dup.txt", ModTime: oldTime.Add(-time.Hour)}, 0, "dup.txt", true},
+		{"unknown time is newest", ConflictKeepNewest, IncomingFile{Name: "dup.txt"}, 0, "dup.txt", false},
+		{"ask rename", ConflictAsk, IncomingFile{Name: "dup.txt"}, ChoiceRename, "dup_1.txt", false},
+		{"ask overwrite", ConflictAsk, IncomingFile{Name: "dup.txt"}, ChoiceOverwrite, "dup.txt", false},
+		{"ask skip", ConflictAsk, IncomingFile{Name: "dup.txt"}, ChoiceSkip, "dup.txt", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			existing := filepath.Join(dir, "dup.txt")
+			if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+				t.Fatalf("Failed to create existing file: %v", err)
+			}
+			os.Chtimes(existing, oldTime, oldTime)
+
+			choice := tc.choice
+			SetConflictPrompter(func(ctx context.Context, path string, in IncomingFile) ConflictChoice {
+				if path != existing {
+					t.Errorf("Expected prompt for %s, got %s", existing, path)
+				}
+				return choice
+			})
+			defer SetConflictPrompter(nil)
+
+			prefs := &config.Preferences{ConflictPolicy: string(tc.policy), RenamePattern: DefaultRenamePattern}
+			res, err := ResolveConflict(context.Background(), prefs, dir, tc.incoming)
+			if err != nil {
+				t.Fatalf("ResolveConflict failed: %v", err)
+			}
+			if res.Path != filepath.Join(dir, tc.expected) || res.Skip != tc.skip {
+				t.Errorf("Expected %s (skip=%v), got %s (skip=%v)", tc.expected, tc.skip, filepath.Base(res.Path), res.Skip)
+			}
+			// Only a file meant to replace the existing one may do so
+			if replace := tc.expected == "dup.txt" && !tc.skip; res.Replace != replace {
+				t.Errorf("Expected replace=%v, got %v", replace, res.Replace)
+			}
+		})
+	}
+}
+
+func TestResolveConflictAskTimesOut(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "dup.txt"), []byte("old"), 0644)
+
+	SetConflictPrompter(func(ctx context.Context, path string, in IncomingFile) ConflictChoice {
+		<-ctx.Done()
+		return ChoiceOverwrite
+	})
+	defer SetConflictPrompter(nil)
+
+	prefs := &config.Preferences{ConflictPolicy: string(ConflictAsk), ConsentTimeout: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Nobody answered, so both files are kept
+	res, err := ResolveConflict(ctx, prefs, dir, IncomingFile{Name: "dup.txt"})
+	if err != nil {
+		t.Fatalf("ResolveConflict failed: %v", err)
+	}
+	if res.Skip || res.Path != filepath.Join(dir, "dup_1.txt") {
+		t.Errorf("Expected a renamed copy, got %+v", res)
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dup.txt")
+	os.WriteFile(target, []byte("old"), 0644)
+
+	part, err := CreatePartFile(dir)
+	if err != nil {
+		t.Fatalf("CreatePartFile failed: %v", err)
+	}
+	part.WriteString("new")
+	part.Close()
+
+	if _, err := PlaceFile(part.Name(), Resolution{Path: target, Skip: true}); err != nil {
+		t.Fatalf("PlaceFile failed: %v", err)
+	}
+	if _, err := os.Stat(part.Name()); !os.IsNotExist(err) {
+		t.Error("Expected the skipped part file to be removed")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "old" {
+		t.Errorf("Expected the existing file to be kept, got '%s'", content)
+	}
+
+	part, _ = CreatePartFile(dir)
+	part.WriteString("new")
+	part.Close()
+	if _, err := PlaceFile(part.Name(), Resolution{Path: target, Replace: true}); err != nil {
+		t.Fatalf("PlaceFile failed: %v", err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "new" {
+		t.Errorf("Expected the file to be replaced, got '%s'", content)
+	}
+
+	// A file that isn't meant to replace one gets the next free name
+	part, _ = CreatePartFile(dir)
+	part.WriteString("newer")
+	part.Close()
+	res, err := PlaceFile(part.Name(), Resolution{Path: target})
+	if err != nil {
+		t.Fatalf("PlaceFile failed: %v", err)
+	}
+	if res.Path != filepath.Join(dir, "dup_1.txt") {
+		t.Errorf("Expected dup_1.txt, got %s", res.Path)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "new" {
+		t.Errorf("Expected the existing file to be kept, got '%s'", content)
+	}
+	if content, _ := os.ReadFile(res.Path); string(content) != "newer" {
+		t.Errorf("Expected the new file at %s, got '%s'", res.Path, content)
+	}
+}
+
+func TestPlaceFileConcurrently(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictRename, ConflictSkipIdentical, ConflictKeepNewest} {
+		t.Run(string(policy), func(t *testing.T) {
+			dir := t.TempDir()
+			prefs := &config.Preferences{ConflictPolicy: string(policy), RenamePattern: DefaultRenamePattern}
+
+			// Both files are resolved before either is placed, as when two
+			// devices send IMG_0001.jpg at the same time
+			const files = 2
+			var resolved, placed sync.WaitGroup
+			resolved.Add(files)
+			paths := make([]string, files)
+			for i := range files {
+				placed.Add(1)
+				go func() {
+					defer placed.Done()
+					content := fmt.Sprintf("photo %d", i)
+					part, err := CreatePartFile(dir)
+					if err != nil {
+						t.Errorf("CreatePartFile failed: %v", err)
+						resolved.Done()
+						return
+					}
+					part.WriteString(content)
+					part.Close()
+					sum := sha256.Sum256([]byte(content))
+
+					res, err := ResolveConflict(context.Background(), prefs, dir, IncomingFile{
+						Name:   "IMG_0001.jpg",
+						Size:   int64(len(content)),
+						SHA256: sum[:],
+					})
+					resolved.Done()
+					resolved.Wait()
+					if err != nil {
+						t.Errorf("ResolveConflict failed: %v", err)
+						return
+					}
+					if res, err = PlaceFile(part.Name(), res); err != nil {
+						t.Errorf("PlaceFile failed: %v", err)
+					}
+					paths[i] = res.Path
+				}()
+			}
+			placed.Wait()
+
+			for i, path := range paths {
+				if content, err := os.ReadFile(path); err != nil || string(content) != fmt.Sprintf("photo %d", i) {
+					t.Errorf("Expected photo %d at %s, got '%s' (%v)", i, path, content, err)
+				}
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != files {
+				t.Errorf("Expected both files to be kept, found %v", entries)
+			}
+		})
+	}
+}
+
+func TestCopyPartFile(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	part := filepath.Join(src, PartFilePrefix+"1.part")
+	os.WriteFile(part, []byte("moved"), 0644)
+	target := filepath.Join(dst, "file.txt")
+	os.WriteFile(target, []byte("old"), 0644)
+
+	root, err := sandbox.Open(dst, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer root.Close()
+
+	if err := copyPartFile(root, part, "file.txt"); err != nil {
+		t.Fatalf("copyPartFile failed: %v", err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "moved" {
+		t.Errorf("Expected the copy to replace the file, got '%s' (%v)", content, err)
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 1 {
+		t.Errorf("Expected no part file left next to the copy, found %d entries", len(entries))
+	}
+
+	if err := copyPartFile(root, filepath.Join(src, "missing.part"), "file.txt"); err == nil {
+		t.Error("Expected copying a missing part file to fail")
+	}
+}
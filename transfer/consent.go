@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"lan-drop/config"
+)
+
+// FileSummary describes one file of an incoming transfer
+type FileSummary struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Request describes an incoming transfer waiting for the desktop user's consent
+type Request struct {
+	// DeviceID is what "Always allow this device" remembers: an identity
+	// the server issued and authenticated the sender with, like its paired
+	// session or API key. It's empty when the sender has none, and such a
+	// sender can't be trusted.
+	DeviceID   string
+	DeviceName string
+	RemoteAddr string
+	Files      []FileSummary
+}
+
+// TotalSize returns the combined size of all files in the request
+func (r Request) TotalSize() int64 {
+	var total int64
+	for _, f := range r.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Decision is the desktop user's answer to a Request
+type Decision struct {
+	Accepted    bool
+	AlwaysAllow bool   // remember the device and skip the prompt next time
+	Message     string // reason sent back to the browser when declined
+}
+
+// Prompter asks the desktop user whether to accept a transfer. It must
+// return once ctx is done.
+type Prompter func(ctx context.Context, req Request) Decision
+
+const defaultConsentTimeout = 60 * time.Second
+
+var (
+	promptMu sync.Mutex
+	prompter Prompter
+
+	// trustedMu guards Preferences.TrustedDevices, which is read by the
+	// server while the GUI may be adding to it
+	trustedMu sync.Mutex
+)
+
+// SetPrompter sets the global prompter used by RequestConsent
+func SetPrompter(p Prompter) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	prompter = p
+}
+
+// RequestConsent decides whether an incoming transfer may be written. When
+// "ask before receiving" is off, or the device is trusted, it accepts
+// immediately; otherwise it blocks until the prompter answers or the
+// configured timeout expires.
+func RequestConsent(ctx context.Context, prefs *config.Preferences, req Request) Decision {
+	if !prefs.AskBeforeReceiving || IsTrusted(prefs, req.DeviceID) {
+		return Decision{Accepted: true}
+	}
+
+	promptMu.Lock()
+	p := prompter
+	promptMu.Unlock()
+
+	if p == nil {
+		return Decision{Message: "Nobody is available to approve the transfer"}
+	}
+
+	timeout := time.Duration(prefs.ConsentTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultConsentTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	decision := p(ctx, req)
+	if ctx.Err() != nil && !decision.Accepted {
+		return Decision{Message: "The transfer was not approved in time"}
+	}
+
+	if decision.Accepted && decision.AlwaysAllow {
+		Trust(prefs, req.DeviceID)
+	}
+	if !decision.Accepted && decision.Message == "" {
+		decision.Message = "The transfer was declined"
+	}
+	return decision
+}
+
+// IsTrusted reports whether the device may send files without a prompt
+func IsTrusted(prefs *config.Preferences, deviceID string) bool {
+	if deviceID == "" {
+		return false
+	}
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	return slices.Contains(prefs.TrustedDevices, deviceID)
+}
+
+// Trust adds the device to the list of devices that are never prompted for.
+// The caller is responsible for persisting the preferences.
+func Trust(prefs *config.Preferences, deviceID string) {
+	if deviceID == "" {
+		return
+	}
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	if !slices.Contains(prefs.TrustedDevices, deviceID) {
+		prefs.TrustedDevices = append(prefs.TrustedDevices, deviceID)
+	}
+}
+
+// ForgetTrustedDevices clears the list of trusted devices
+func ForgetTrustedDevices(prefs *config.Preferences) {
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	prefs.TrustedDevices = nil
+}
+
+// ForgetTrustedDevicesFunc removes the trusted devices for which forget
+// returns true
+func ForgetTrustedDevicesFunc(prefs *config.Preferences, forget func(deviceID string) bool) {
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	prefs.TrustedDevices = slices.DeleteFunc(prefs.TrustedDevices, forget)
+}
+
+// PreferencesSnapshot returns a copy of prefs to save. The trusted devices
+// are copied under the lock the server takes to add to them.
+func PreferencesSnapshot(prefs *config.Preferences) config.Preferences {
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	snapshot := *prefs
+	snapshot.TrustedDevices = slices.Clone(prefs.TrustedDevices)
+	return snapshot
+}
@@ -0,0 +1,122 @@
+package transfer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"lan-drop/config"
+)
+
+func TestRequestConsentAcceptsWhenAskModeIsOff(t *testing.T) {
+	SetPrompter(nil)
+	prefs := &config.Preferences{AskBeforeReceiving: false}
+
+	decision := RequestConsent(context.Background(), prefs, Request{DeviceID: "phone"})
+	if !decision.Accepted {
+		t.Errorf("Expected transfer to be accepted, got %+v", decision)
+	}
+}
+
+func TestRequestConsentDeclinesWithoutPrompter(t *testing.T) {
+	SetPrompter(nil)
+	prefs := &config.Preferences{AskBeforeReceiving: true}
+
+	decision := RequestConsent(context.Background(), prefs, Request{DeviceID: "phone"})
+	if decision.Accepted || decision.Message == "" {
+		t.Errorf("Expected a declined transfer with a message, got %+v", decision)
+	}
+}
+
+func TestRequestConsentAlwaysAllowTrustsDevice(t *testing.T) {
+	calls := 0
+	SetPrompter(func(ctx context.Context, req Request) Decision {
+		calls++
+		return Decision{Accepted: true, AlwaysAllow: true}
+	})
+	defer SetPrompter(nil)
+
+	prefs := &config.Preferences{AskBeforeReceiving: true}
+	req := Request{DeviceID: "phone", Files: []FileSummary{{Name: "a.txt", Size: 1}}}
+
+	if decision := RequestConsent(context.Background(), prefs, req); !decision.Accepted {
+		t.Fatalf("Expected first transfer to be accepted, got %+v", decision)
+	}
+	if !IsTrusted(prefs, "phone") {
+		t.Fatal("Expected device to be trusted after Always allow")
+	}
+
+	// A trusted device is not prompted again
+	if decision := RequestConsent(context.Background(), prefs, req); !decision.Accepted {
+		t.Fatalf("Expected second transfer to be accepted, got %+v", decision)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the prompter to be called once, got %d", calls)
+	}
+
+	ForgetTrustedDevices(prefs)
+	if IsTrusted(prefs, "phone") {
+		t.Error("Expected device to be forgotten")
+	}
+}
+
+func TestRequestConsentTimesOut(t *testing.T) {
+	SetPrompter(func(ctx context.Context, req Request) Decision {
+		<-ctx.Done()
+		return Decision{}
+	})
+	defer SetPrompter(nil)
+
+	prefs := &config.Preferences{AskBeforeReceiving: true, ConsentTimeout: 1}
+
+	start := time.Now()
+	decision := RequestConsent(context.Background(), prefs, Request{DeviceID: "phone"})
+	if decision.Accepted {
+		t.Fatal("Expected timed out transfer to be declined")
+	}
+	if decision.Message != "The transfer was not approved in time" {
+		t.Errorf("Unexpected message: %q", decision.Message)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Timeout took too long: %v", elapsed)
+	}
+}
+
+func TestRequestConsentDeclineMessage(t *testing.T) {
+	SetPrompter(func(ctx context.Context, req Request) Decision {
+		return Decision{}
+	})
+	defer SetPrompter(nil)
+
+	prefs := &config.Preferences{AskBeforeReceiving: true}
+	decision := RequestConsent(context.Background(), prefs, Request{DeviceID: "phone"})
+	if decision.Message != "The transfer was declined" {
+		t.Errorf("Unexpected message: %q", decision.Message)
+	}
+}
+
+func TestRequestConsentNeverTrustsAnonymousSenders(t *testing.T) {
+	SetPrompter(func(ctx context.Context, req Request) Decision {
+		return Decision{Accepted: true, AlwaysAllow: true}
+	})
+	defer SetPrompter(nil)
+
+	prefs := &config.Preferences{AskBeforeReceiving: true}
+	RequestConsent(context.Background(), prefs, Request{})
+	if len(prefs.TrustedDevices) != 0 || IsTrusted(prefs, "") {
+		t.Errorf("Expected a sender without an identity not to be trusted, got %v", prefs.TrustedDevices)
+	}
+}
+
+func TestPreferencesSnapshot(t *testing.T) {
+	prefs := &config.Preferences{TrustedDevices: []string{"session:a", "key:b"}}
+	snapshot := PreferencesSnapshot(prefs)
+
+	ForgetTrustedDevicesFunc(prefs, func(deviceID string) bool { return deviceID == "session:a" })
+	if len(prefs.TrustedDevices) != 1 || prefs.TrustedDevices[0] != "key:b" {
+		t.Errorf("Expected only key:b to stay trusted, got %v", prefs.TrustedDevices)
+	}
+	if len(snapshot.TrustedDevices) != 2 || snapshot.TrustedDevices[0] != "session:a" {
+		t.Errorf("Expected the snapshot to keep its own list, got %v", snapshot.TrustedDevices)
+	}
+}
@@ -0,0 +1,39 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when received data doesn't match the
+// SHA-256 digest the sender announced
+var ErrChecksumMismatch = errors.New("SHA-256 checksum mismatch")
+
+// ParseDigest decodes a SHA-256 digest sent by a client, either as hex or
+// as standard or URL-safe base64. An empty string means no digest was sent
+// and returns nil.
+func ParseDigest(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	// Accept the "sha-256=" prefix of HTTP Digest-style headers
+	if i := strings.IndexByte(s, '='); i > 0 && strings.EqualFold(s[:i], "sha-256") {
+		s = s[i+1:]
+	}
+
+	if len(s) == hex.EncodedLen(sha256.Size) {
+		if digest, err := hex.DecodeString(s); err == nil {
+			return digest, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if digest, err := enc.DecodeString(s); err == nil && len(digest) == sha256.Size {
+			return digest, nil
+		}
+	}
+	return nil, errors.New("invalid SHA-256 digest")
+}
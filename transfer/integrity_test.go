@@ -0,0 +1,42 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"hex", hex.EncodeToString(sum[:]), sum[:], false},
+		{"padded uppercase hex", "  " + strings.ToUpper(hex.EncodeToString(sum[:])) + "\n", sum[:], false},
+		{"base64", base64.StdEncoding.EncodeToString(sum[:]), sum[:], false},
+		{"raw url base64", base64.RawURLEncoding.EncodeToString(sum[:]), sum[:], false},
+		{"digest header", "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]), sum[:], false},
+		{"too short", "abcd", nil, true},
+		{"not hex", "zz" + hex.EncodeToString(sum[:])[2:], nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDigest(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDigest(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseDigest(%q) = %x, want %x", tt.input, got, tt.want)
+			}
+		})
+	}
+}
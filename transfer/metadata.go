@@ -0,0 +1,66 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"lan-drop/sandbox"
+)
+
+// SidecarSuffix is appended to a received file's name to name its sidecar
+const SidecarSuffix = ".landrop.json"
+
+// maxClockSkew is how far in the future a sender's modification time may be
+// before it is considered bogus
+const maxClockSkew = 24 * time.Hour
+
+// ErrInvalidTime is returned for a modification time that can't be parsed or
+// is out of range
+var ErrInvalidTime = errors.New("invalid modification time")
+
+// Sidecar is written next to a received file when sidecars are enabled. It
+// records where the file came from.
+type Sidecar struct {
+	OriginalName string     `json:"original_name"`
+	SavedAs      string     `json:"saved_as"`
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256,omitempty"`
+	MIMEType     string     `json:"mime_type,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Device       string     `json:"device,omitempty"`
+	PeerAddr     string     `json:"peer_addr,omitempty"`
+	SessionID    string     `json:"session_id,omitempty"`
+	ReceivedAt   time.Time  `json:"received_at"`
+}
+
+// ParseLastModified parses a modification time sent with a file: either
+// milliseconds since the Unix epoch, like the browser's File.lastModified,
+// or an RFC 3339 timestamp. An empty value is the zero time.
+func ParseLastModified(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var t time.Time
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t = time.UnixMilli(ms)
+	} else if t, err = time.Parse(time.RFC3339, value); err != nil {
+		return time.Time{}, ErrInvalidTime
+	}
+
+	if t.Unix() <= 0 || t.After(time.Now().Add(maxClockSkew)) {
+		return time.Time{}, ErrInvalidTime
+	}
+	return t, nil
+}
+
+// WriteSidecar writes the sidecar of the named file under root
+func WriteSidecar(root *sandbox.Root, name string, sidecar Sidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return root.WriteFile(name+SidecarSuffix, append(data, '\n'), 0644)
+}
@@ -0,0 +1,109 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"lan-drop/config"
+)
+
+func TestParseLastModified(t *testing.T) {
+	photoTime := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	future := time.Now().Add(48 * time.Hour)
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected time.Time
+		valid    bool
+	}{
+		{"empty", "", time.Time{}, true},
+		{"milliseconds", strconv.FormatInt(photoTime.UnixMilli(), 10), photoTime, true},
+		{"RFC 3339", "2024-07-14T11:30:00+02:00", photoTime, true},
+		{"zero", "0", time.Time{}, false},
+		{"negative", "-1000", time.Time{}, false},
+		{"far future", strconv.FormatInt(future.UnixMilli(), 10), time.Time{}, false},
+		{"garbage", "yesterday", time.Time{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLastModified(tc.input)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidTime) {
+					t.Errorf("Expected %q to be rejected, got %v", tc.input, got)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tc.expected) {
+				t.Errorf("Expected %v, got %v (%v)", tc.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestSaveFileAppliesMetadata(t *testing.T) {
+	root := t.TempDir()
+	part, err := CreatePartFile(root)
+	if err != nil {
+		t.Fatalf("CreatePartFile failed: %v", err)
+	}
+	part.WriteString("jpeg")
+	part.Close()
+
+	modTime := time.Date(2024, 7, 14, 9, 30, 0, 0, time.UTC)
+	prefs := &config.Preferences{WriteSidecar: true}
+	in := IncomingFile{
+		Size:      4,
+		ModTime:   modTime,
+		MIMEType:  "image/jpeg",
+		Device:    "Pixel 8",
+		PeerAddr:  "192.168.1.20",
+		SessionID: "42",
+	}
+	res, err := SaveFile(context.Background(), prefs, root, filepath.Join("Camera", "IMG_1.jpg"), part.Name(), in)
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	stat, err := os.Stat(res.Path)
+	if err != nil {
+		t.Fatalf("Saved file missing: %v", err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, stat.ModTime())
+	}
+
+	data, err := os.ReadFile(res.Path + SidecarSuffix)
+	if err != nil {
+		t.Fatalf("Sidecar missing: %v", err)
+	}
+	var sidecar Sidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatalf("Invalid sidecar: %v", err)
+	}
+	if sidecar.OriginalName != "Camera/IMG_1.jpg" || sidecar.SavedAs != "IMG_1.jpg" || sidecar.Device != "Pixel 8" ||
+		sidecar.SessionID != "42" || sidecar.MIMEType != "image/jpeg" || sidecar.LastModified == nil || !sidecar.LastModified.Equal(modTime) {
+		t.Errorf("Unexpected sidecar: %+v", sidecar)
+	}
+}
+
+func TestSaveFileWithoutSidecar(t *testing.T) {
+	root := t.TempDir()
+	part, _ := CreatePartFile(root)
+	part.Close()
+
+	res, err := SaveFile(context.Background(), &config.Preferences{}, root, "empty.txt", part.Name(), IncomingFile{})
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if _, err := os.Stat(res.Path + SidecarSuffix); !os.IsNotExist(err) {
+		t.Error("Expected no sidecar when sidecars are disabled")
+	}
+}
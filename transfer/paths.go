@@ -0,0 +1,168 @@
+package transfer
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lan-drop/config"
+	"lan-drop/sandbox"
+	"lan-drop/utils"
+)
+
+// maxPathDepth bounds how deep a received folder tree may be
+const maxPathDepth = 32
+
+// ErrInvalidPath is returned for a relative path that could leave the
+// destination folder or isn't a valid file path
+var ErrInvalidPath = errors.New("invalid relative path")
+
+// CleanRelativePath validates a path sent along with a file, such as the
+// browser's webkitRelativePath "Photos/2024/beach.jpg", and returns it with
+// the OS separator. Absolute paths and "." , ".." or empty elements are
+// rejected rather than cleaned up; each element is then sanitized with
+// utils.SanitizeFilename.
+func CleanRelativePath(rel string) (string, error) {
+	rel = strings.ReplaceAll(rel, `\`, "/")
+	if rel == "" || strings.HasPrefix(rel, "/") || filepath.VolumeName(filepath.FromSlash(rel)) != "" {
+		return "", ErrInvalidPath
+	}
+	// "C:" only means a drive on Windows, but nobody means to send one
+	if len(rel) >= 2 && rel[1] == ':' {
+		return "", ErrInvalidPath
+	}
+
+	elements := strings.Split(rel, "/")
+	if len(elements) > maxPathDepth {
+		return "", ErrInvalidPath
+	}
+	for i, element := range elements {
+		cleaned, err := cleanElement(element)
+		if err != nil {
+			return "", err
+		}
+		elements[i] = cleaned
+	}
+	return filepath.Join(elements...), nil
+}
+
+// ReceivedPath returns where a received file goes under the destination
+// folder: the relative path the sender gave, or else its name made safe as a
+// single file name, so a name like "../x" can't pick a folder.
+func ReceivedPath(name, relativePath string) (string, error) {
+	if relativePath != "" {
+		return CleanRelativePath(relativePath)
+	}
+	return cleanElement(utils.SanitizeFilename(name))
+}
+
+// cleanElement validates and sanitizes one element of a relative path
+func cleanElement(element string) (string, error) {
+	if element == "" || element == "." || element == ".." {
+		return "", ErrInvalidPath
+	}
+	element = utils.SanitizeFilename(element)
+	if strings.HasPrefix(element, PartFilePrefix) {
+		return "", ErrInvalidPath
+	}
+	return element, nil
+}
+
+// PrepareDir creates the folders of rel, a path returned by
+// CleanRelativePath, under root and returns the folder the file goes in.
+// It fails if an existing folder on the way is a link that leads out of root.
+func PrepareDir(root *sandbox.Root, rel string) (string, error) {
+	dir := filepath.Dir(rel)
+	if err := root.MkdirAll(dir, 0755); err != nil {
+		if errors.Is(err, sandbox.ErrOutside) {
+			return "", fmt.Errorf("%w: %s leaves the destination folder", ErrInvalidPath, rel)
+		}
+		return "", err
+	}
+	return root.FullPath(dir)
+}
+
+// RootFolder returns the top-level folder under root that holds every one of
+// paths, or "" if they aren't all inside the same folder
+func RootFolder(root string, paths []string) string {
+	var top string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return ""
+		}
+		first, _, found := strings.Cut(rel, string(filepath.Separator))
+		if !found || first == ".." || (top != "" && first != top) {
+			return ""
+		}
+		top = first
+	}
+	if top == "" {
+		return ""
+	}
+	return filepath.Join(root, top)
+}
+
+// SaveFile puts a received part file at rel, a path returned by
+// CleanRelativePath, under root. The folders of rel are created and a name
+// clash is settled by the conflict policy. The saved file gets the sender's
+// modification time and, if enabled, a sidecar describing its origin.
+//
+// Everything is written through a sandbox.Root, so a link swapped in while
+// the file is saved can't send it out of root.
+func SaveFile(ctx context.Context, prefs *config.Preferences, root, rel, partPath string, in IncomingFile) (Resolution, error) {
+	sb, err := sandbox.Open(root, false)
+	if err != nil {
+		return Resolution{}, err
+	}
+	defer sb.Close()
+
+	dir, err := PrepareDir(sb, rel)
+	if err != nil {
+		return Resolution{}, err
+	}
+	in.Name = filepath.Base(rel)
+	res, err := ResolveConflict(ctx, prefs, dir, in)
+	if err != nil {
+		return res, err
+	}
+	if res, err = placeFile(sb, partPath, res); err != nil || res.Skip {
+		return res, err
+	}
+	name, err := sb.Name(res.Path)
+	if err != nil {
+		return res, err
+	}
+
+	// The file is in place, so failing to add its metadata isn't fatal
+	if !in.ModTime.IsZero() {
+		if err := sb.Chtimes(name, time.Now(), in.ModTime); err != nil {
+			log.Printf("Failed to set the modification time of %s: %v", res.Path, err)
+		}
+	}
+	if prefs.WriteSidecar {
+		sidecar := Sidecar{
+			OriginalName: filepath.ToSlash(rel),
+			SavedAs:      filepath.Base(res.Path),
+			Size:         in.Size,
+			SHA256:       hex.EncodeToString(in.SHA256),
+			MIMEType:     in.MIMEType,
+			Device:       in.Device,
+			PeerAddr:     in.PeerAddr,
+			SessionID:    in.SessionID,
+			ReceivedAt:   time.Now(),
+		}
+		if !in.ModTime.IsZero() {
+			sidecar.LastModified = &in.ModTime
+		}
+		if err := WriteSidecar(sb, name, sidecar); err != nil {
+			log.Printf("Failed to write the sidecar of %s: %v", res.Path, err)
+		}
+	}
+	return res, nil
+}
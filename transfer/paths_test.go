@@ -0,0 +1,203 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lan-drop/config"
+	"lan-drop/sandbox"
+)
+
+func TestCleanRelativePath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string // slash separated, "" if the path is rejected
+	}{
+		{"plain name", "photo.jpg", "photo.jpg"},
+		{"nested", "Photos/2024/beach.jpg", "Photos/2024/beach.jpg"},
+		{"backslashes", `Photos\beach.jpg`, "Photos/beach.jpg"},
+		{"hidden file", "Photos/.DS_Store", "Photos/.DS_Store"},
+		{"empty", "", ""},
+		{"absolute", "/etc/passwd", ""},
+		{"absolute backslash", `\Windows\win.ini`, ""},
+		{"drive letter", "C:/Windows/win.ini", ""},
+		{"parent", "../secret.txt", ""},
+		{"parent inside", "Photos/../../secret.txt", ""},
+		{"parent only", "..", ""},
+		{"current dir", "./photo.jpg", ""},
+		{"empty element", "Photos//beach.jpg", ""},
+		{"trailing slash", "Photos/", ""},
+		{"control character", "Photos/be\nach.jpg", "Photos/beach.jpg"},
+		{"sanitized element", "Photos/bad\u202egpj.exe", "Photos/badgpj.exe"},
+		{"part file", "Photos/" + PartFilePrefix + "1.part", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CleanRelativePath(tc.input)
+			if tc.expected == "" {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected %q to be rejected, got %q", tc.input, got)
+				}
+				return
+			}
+			if err != nil || got != filepath.FromSlash(tc.expected) {
+				t.Errorf("Expected %q, got %q (%v)", tc.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestReceivedPath(t *testing.T) {
+	testCases := []struct {
+		name         string
+		filename     string
+		relativePath string
+		expected     string // slash separated, "" if the file is rejected
+	}{
+		{"plain name", "photo.jpg", "", "photo.jpg"},
+		{"name with traversal", "../../etc/passwd", "", ".._.._etc_passwd"},
+		{"name is parent", "..", "", "_.."},
+		{"relative path wins", "beach.jpg", "Photos/beach.jpg", "Photos/beach.jpg"},
+		{"relative path with traversal", "beach.jpg", "../beach.jpg", ""},
+		{"part file name", PartFilePrefix + "1.part", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReceivedPath(tc.filename, tc.relativePath)
+			if tc.expected == "" {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected the file to be rejected, got %q", got)
+				}
+				return
+			}
+			if err != nil || got != filepath.FromSlash(tc.expected) {
+				t.Errorf("Expected %q, got %q (%v)", tc.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestPrepareDirRejectsLinkOutOfRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	sb, err := sandbox.Open(root, false)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer sb.Close()
+
+	if _, err := PrepareDir(sb, filepath.Join("escape", "sub", "file.txt")); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected a link out of the upload folder to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "sub")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be created outside the upload folder, got %v", err)
+	}
+
+	dir, err := PrepareDir(sb, filepath.Join("Photos", "2024", "beach.jpg"))
+	if err != nil {
+		t.Fatalf("PrepareDir failed: %v", err)
+	}
+	if dir != filepath.Join(root, "Photos", "2024") {
+		t.Errorf("Expected %s, got %s", filepath.Join(root, "Photos", "2024"), dir)
+	}
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+		t.Errorf("Expected %s to be created", dir)
+	}
+}
+
+func TestRootFolder(t *testing.T) {
+	root := filepath.Join("uploads")
+	testCases := []struct {
+		name     string
+		paths    []string
+		expected string
+	}{
+		{"one folder", []string{"Photos/a.jpg", "Photos/2024/b.jpg"}, "Photos"},
+		{"loose files", []string{"a.jpg", "b.jpg"}, ""},
+		{"mixed", []string{"Photos/a.jpg", "b.jpg"}, ""},
+		{"two folders", []string{"Photos/a.jpg", "Music/b.mp3"}, ""},
+		{"outside", []string{"../Photos/a.jpg"}, ""},
+		{"nothing", nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			paths := make([]string, 0, len(tc.paths))
+			for _, p := range tc.paths {
+				paths = append(paths, filepath.Join(root, filepath.FromSlash(p)))
+			}
+			expected := ""
+			if tc.expected != "" {
+				expected = filepath.Join(root, tc.expected)
+			}
+			if got := RootFolder(root, paths); got != expected {
+				t.Errorf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestSaveFileRecreatesFolders(t *testing.T) {
+	root := t.TempDir()
+	part, err := CreatePartFile(root)
+	if err != nil {
+		t.Fatalf("CreatePartFile failed: %v", err)
+	}
+	part.WriteString("sand")
+	part.Close()
+
+	prefs := &config.Preferences{}
+	rel := filepath.Join("Photos", "2024", "beach.jpg")
+	res, err := SaveFile(context.Background(), prefs, root, rel, part.Name(), IncomingFile{Size: 4})
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if res.Path != filepath.Join(root, rel) {
+		t.Errorf("Expected %s, got %s", filepath.Join(root, rel), res.Path)
+	}
+	if content, err := os.ReadFile(res.Path); err != nil || string(content) != "sand" {
+		t.Errorf("Expected the file in its folder, got '%s' (%v)", content, err)
+	}
+}
+
+func TestSaveFileStaysInRoot(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "Photos")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	prefs := &config.Preferences{}
+
+	part, _ := CreatePartFile(root)
+	part.WriteString("sand")
+	part.Close()
+	if _, err := SaveFile(context.Background(), prefs, root, filepath.Join("Photos", "beach.jpg"), part.Name(), IncomingFile{Size: 4}); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected a folder linking out of the upload folder to be refused, got %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written outside the upload folder, found %d entries", len(entries))
+	}
+
+	// A part file kept elsewhere, like a resumable upload's, is copied in
+	elsewhere := filepath.Join(t.TempDir(), "upload.part")
+	os.WriteFile(elsewhere, []byte("resumed"), 0644)
+	res, err := SaveFile(context.Background(), prefs, root, "notes.txt", elsewhere, IncomingFile{Size: 7})
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if content, err := os.ReadFile(res.Path); err != nil || string(content) != "resumed" {
+		t.Errorf("Expected the copied file, got '%s' (%v)", content, err)
+	}
+	if _, err := os.Stat(elsewhere); !os.IsNotExist(err) {
+		t.Error("Expected the part file to be removed once copied")
+	}
+}
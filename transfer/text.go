@@ -0,0 +1,89 @@
+package transfer
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxTextSize is the largest text message accepted, in bytes
+const MaxTextSize = 64 << 10
+
+var (
+	// ErrEmptyText is returned for a text message with nothing in it
+	ErrEmptyText = errors.New("the text is empty")
+	// ErrTextTooLong is returned for a text message over MaxTextSize
+	ErrTextTooLong = errors.New("the text is too long")
+	// ErrNoTextReceiver is returned when nobody is there to take the text
+	ErrNoTextReceiver = errors.New("nobody is available to receive the text")
+)
+
+// TextMessage is a piece of text sent by another device
+type TextMessage struct {
+	Text       string
+	DeviceName string
+	RemoteAddr string
+}
+
+// TextReceiver delivers a text message to the desktop user
+type TextReceiver func(msg TextMessage)
+
+var (
+	textMu       sync.Mutex
+	textReceiver TextReceiver
+)
+
+// SetTextReceiver sets the global receiver used by ReceiveText
+func SetTextReceiver(r TextReceiver) {
+	textMu.Lock()
+	defer textMu.Unlock()
+	textReceiver = r
+}
+
+// CheckText validates the text of a message
+func CheckText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrEmptyText
+	}
+	if len(text) > MaxTextSize {
+		return ErrTextTooLong
+	}
+	if !utf8.ValidString(text) {
+		return errors.New("the text is not valid UTF-8")
+	}
+	return nil
+}
+
+// ReceiveText checks a text message and hands it to the text receiver
+func ReceiveText(msg TextMessage) error {
+	if err := CheckText(msg.Text); err != nil {
+		return err
+	}
+
+	textMu.Lock()
+	r := textReceiver
+	textMu.Unlock()
+
+	if r == nil {
+		return ErrNoTextReceiver
+	}
+	r(msg)
+	return nil
+}
+
+// WebURL returns text as a URL if it is a single http or https link, and
+// false otherwise. Other schemes are never treated as links, so a received
+// text can't start a local program.
+func WebURL(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, " \t\r\n") {
+		return "", false
+	}
+	u, err := url.Parse(text)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
@@ -0,0 +1,88 @@
+package transfer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected error
+	}{
+		{"plain", "123 456", nil},
+		{"multiline", "first line\nsecond line", nil},
+		{"empty", "", ErrEmptyText},
+		{"only spaces", " \n\t", ErrEmptyText},
+		{"too long", strings.Repeat("a", MaxTextSize+1), ErrTextTooLong},
+		{"longest", strings.Repeat("a", MaxTextSize), nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := CheckText(tc.text); !errors.Is(err, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	if err := CheckText("bad \xff"); err == nil {
+		t.Error("Expected invalid UTF-8 to be rejected")
+	}
+}
+
+func TestReceiveText(t *testing.T) {
+	defer SetTextReceiver(nil)
+
+	SetTextReceiver(nil)
+	if err := ReceiveText(TextMessage{Text: "hello"}); !errors.Is(err, ErrNoTextReceiver) {
+		t.Errorf("Expected ErrNoTextReceiver, got %v", err)
+	}
+
+	var got []TextMessage
+	SetTextReceiver(func(msg TextMessage) {
+		got = append(got, msg)
+	})
+	if err := ReceiveText(TextMessage{Text: "hello", DeviceName: "Phone"}); err != nil {
+		t.Fatalf("ReceiveText failed: %v", err)
+	}
+	if err := ReceiveText(TextMessage{Text: "  "}); !errors.Is(err, ErrEmptyText) {
+		t.Errorf("Expected ErrEmptyText, got %v", err)
+	}
+	if len(got) != 1 || got[0].Text != "hello" || got[0].DeviceName != "Phone" {
+		t.Errorf("Expected the message to be delivered once, got %+v", got)
+	}
+}
+
+func TestWebURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string // "" if the text is not a link
+	}{
+		{"https", "https://example.com/path?q=1", "https://example.com/path?q=1"},
+		{"http with spaces around", "  http://192.168.1.10:8080/\n", "http://192.168.1.10:8080/"},
+		{"sentence", "look at https://example.com", ""},
+		{"file scheme", "file:///etc/passwd", ""},
+		{"javascript", "javascript:alert(1)", ""},
+		{"no host", "https:///path", ""},
+		{"plain text", "123456", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := WebURL(tc.text)
+			if tc.expected == "" {
+				if ok {
+					t.Errorf("Expected %q not to be a link, got %q", tc.text, got)
+				}
+				return
+			}
+			if !ok || got != tc.expected {
+				t.Errorf("Expected %q, got %q (%v)", tc.expected, got, ok)
+			}
+		})
+	}
+}
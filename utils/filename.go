@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// maxFilenameBytes is the name length limit of ext4 and APFS
+	maxFilenameBytes = 255
+	// maxExtensionBytes is the longest extension kept when truncating
+	maxExtensionBytes = 16
+)
+
+// windowsReservedNames can't be used as a file name on Windows, with or
+// without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// SanitizeFilename turns a file name sent by another device into one that
+// can be created on this OS without leaving the destination folder
+func SanitizeFilename(name string) string {
+	return sanitizeFilename(name, runtime.GOOS)
+}
+
+// sanitizeFilename sanitizes name for the given GOOS:
+//   - invalid UTF-8 is replaced and the name is normalized to NFC, so the
+//     same name typed on macOS and Windows gives the same file
+//   - path separators and characters the OS forbids become "_"
+//   - control and invisible formatting characters (e.g. right-to-left
+//     overrides that disguise an extension) are removed
+//   - "." and ".." and, on Windows, reserved device names are neutralized
+//   - names over 255 bytes are cut at a character boundary, keeping the
+//     extension
+func sanitizeFilename(name, goos string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, "_"))
+
+	illegal := "/\x00"
+	switch goos {
+	case "windows":
+		illegal = `/\<>:"|?*` + "\x00"
+	case "darwin":
+		// Finder shows ":" as "/"
+		illegal = "/:\x00"
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(illegal, r):
+			return '_'
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, name)
+
+	name = strings.TrimSpace(name)
+	if goos == "windows" {
+		// Windows silently drops trailing dots and spaces
+		name = strings.TrimRight(name, ". ")
+	}
+
+	if strings.Trim(name, ".") == "" {
+		return "_" + name
+	}
+
+	if goos == "windows" {
+		base, _, _ := strings.Cut(name, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+			name = "_" + name
+		}
+	}
+
+	return truncateFilename(name, maxFilenameBytes)
+}
+
+// truncateFilename shortens name to at most limit bytes without splitting a
+// character, keeping a short extension
+func truncateFilename(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > maxExtensionBytes || ext == name {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+
+	cut := limit - len(ext)
+	for cut > 0 && !utf8.RuneStart(base[cut]) {
+		cut--
+	}
+	// Don't keep a character whose combining marks were cut off
+	for cut > 0 {
+		if r, _ := utf8.DecodeRuneInString(base[cut:]); !unicode.Is(unicode.Mn, r) {
+			break
+		}
+		_, size := utf8.DecodeLastRuneInString(base[:cut])
+		cut -= size
+	}
+	return strings.TrimSpace(base[:cut]) + ext
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		goos     string
+		expected string
+	}{
+		{"plain", "photo.jpg", "linux", "photo.jpg"},
+		{"spaces kept", "My Document (1).pdf", "linux", "My Document (1).pdf"},
+		{"traversal", "../../etc/passwd", "linux", ".._.._etc_passwd"},
+		{"dot dot", "..", "linux", "_.."},
+		{"dot", ".", "linux", "_."},
+		{"empty", "", "linux", "_"},
+		{"only spaces", "   ", "linux", "_"},
+		{"hidden file", ".bashrc", "linux", ".bashrc"},
+		{"backslash on linux", `a\b.txt`, "linux", `a\b.txt`},
+		{"backslash on windows", `..\..\win.ini`, "windows", ".._.._win.ini"},
+		{"colon on linux", "12:30.txt", "linux", "12:30.txt"},
+		{"colon on darwin", "12:30.txt", "darwin", "12_30.txt"},
+		{"windows illegal", `a<b>c:d"e|f?g*h.txt`, "windows", "a_b_c_d_e_f_g_h.txt"},
+		{"control characters", "bad\x00na\nme\x7f.txt", "linux", "bad_name.txt"},
+		{"right-to-left override", "invoice\u202efdp.exe", "linux", "invoicefdp.exe"},
+		{"invalid UTF-8", "caf\xe9.txt", "linux", "caf_.txt"},
+		{"NFD to NFC", "cafe\u0301.txt", "linux", "caf\u00e9.txt"},
+		{"reserved name", "CON", "windows", "_CON"},
+		{"reserved name with extension", "nul.txt", "windows", "_nul.txt"},
+		{"reserved name lower case", "com1.log", "windows", "_com1.log"},
+		{"reserved name on linux", "CON", "linux", "CON"},
+		{"not reserved", "CONSOLE.txt", "windows", "CONSOLE.txt"},
+		{"trailing dots on windows", "report. . .", "windows", "report"},
+		{"trailing dots on linux", "report...", "linux", "report..."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sanitizeFilename(tc.input, tc.goos); got != tc.expected {
+				t.Errorf("sanitizeFilename(%q, %s) = %q, want %q", tc.input, tc.goos, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameTruncates(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		ext   string
+	}{
+		{"ASCII", strings.Repeat("a", 300) + ".jpg", ".jpg"},
+		{"multi-byte", strings.Repeat("é", 200) + ".jpeg", ".jpeg"},
+		{"emoji", strings.Repeat("😀", 100) + ".png", ".png"},
+		{"combining marks", strings.Repeat("q\u0301", 150) + ".txt", ".txt"},
+		{"long extension dropped", strings.Repeat("a", 200) + "." + strings.Repeat("b", 100), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := sanitizeFilename(tc.input, "linux")
+			if len(got) > maxFilenameBytes {
+				t.Errorf("Expected at most %d bytes, got %d", maxFilenameBytes, len(got))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Truncation split a character: %q", got)
+			}
+			if tc.ext != "" && !strings.HasSuffix(got, tc.ext) {
+				t.Errorf("Expected the extension %s to be kept, got %q", tc.ext, got)
+			}
+			if strings.HasSuffix(strings.TrimSuffix(got, tc.ext), "q") {
+				t.Errorf("A character lost its combining mark: %q", got)
+			}
+		})
+	}
+}
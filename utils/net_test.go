@@ -37,3 +37,15 @@ func TestGetLocalIPReturnsConsistentResult(t *testing.T) {
 		t.Errorf("GetLocalIP returned inconsistent results: %s, %s, %s", ip1, ip2, ip3)
 	}
 }
+
+func TestGetLocalIPs(t *testing.T) {
+	ips := GetLocalIPs()
+	if len(ips) == 0 {
+		t.Fatal("GetLocalIPs returned no addresses")
+	}
+	for _, ip := range ips {
+		if ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			t.Errorf("GetLocalIPs returned unexpected address: %s", ip)
+		}
+	}
+}